@@ -0,0 +1,296 @@
+// Command hetzner-machine manages the lifecycle of Hetzner Cloud servers using the driver directly, without requiring
+// docker-machine. Machines are kept in docker-machine's storage layout, so the driver's maintenance commands work on
+// them as well, but docker is not provisioned.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/JonasProgrammer/docker-machine-driver-hetzner/driver"
+	"github.com/docker/machine/commands/mcndirs"
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+// Version will be added once we start the build process by goreleaser
+var version string
+
+// validMachineName is the pattern docker-machine accepts machine names by
+var validMachineName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\-.]*$`)
+
+// command operates on a single machine given by name as the last argument
+type command struct {
+	usage string
+	setup func(fs *flag.FlagSet) func(storePath, name string) error
+}
+
+var commands = map[string]command{
+	"create": {
+		usage: "create a server, taking the driver's --hetzner-* flags",
+		setup: setupCreate,
+	},
+	"rm": {
+		usage: "remove the server and all resources the driver created for it",
+		setup: func(fs *flag.FlagSet) func(storePath, name string) error {
+			yes := fs.Bool("y", false, "remove without asking for confirmation")
+			return func(storePath, name string) error {
+				d, err := driver.LoadMachine(version, storePath, name)
+				if err != nil {
+					return err
+				}
+				if !*yes && !confirm(fmt.Sprintf("Remove machine %v?", name)) {
+					return nil
+				}
+				if err = d.Remove(); err != nil {
+					return err
+				}
+				return d.RemoveMachine()
+			}
+		},
+	},
+	"ssh": {
+		usage: "log into the server or run the remaining arguments as command",
+		setup: func(fs *flag.FlagSet) func(storePath, name string) error {
+			return func(storePath, name string) error {
+				d, err := driver.LoadMachine(version, storePath, name)
+				if err != nil {
+					return err
+				}
+				if err = d.VerifyHostKey(); err != nil {
+					return err
+				}
+				client, err := drivers.GetSSHClientFromDriver(d)
+				if err != nil {
+					return err
+				}
+				return client.Shell(fs.Args()[1:]...)
+			}
+		},
+	},
+	"ip": {
+		usage: "print the server's IP address",
+		setup: func(fs *flag.FlagSet) func(storePath, name string) error {
+			return func(storePath, name string) error {
+				d, err := driver.LoadMachine(version, storePath, name)
+				if err != nil {
+					return err
+				}
+				ip, err := d.GetIP()
+				if err != nil {
+					return err
+				}
+				fmt.Println(ip)
+				return nil
+			}
+		},
+	},
+	"status": {
+		usage: "print the server's state",
+		setup: func(fs *flag.FlagSet) func(storePath, name string) error {
+			return func(storePath, name string) error {
+				d, err := driver.LoadMachine(version, storePath, name)
+				if err != nil {
+					return err
+				}
+				st, err := d.GetState()
+				if err != nil {
+					return err
+				}
+				fmt.Println(st)
+				return nil
+			}
+		},
+	},
+}
+
+func main() {
+	storePath := flag.String("storage-path", mcndirs.GetBaseDir(), "docker-machine storage path")
+	versionFlag := flag.Bool("v", false, "prints current hetzner-machine version")
+	flag.Usage = printUsage
+	flag.Parse()
+	if *versionFlag {
+		fmt.Printf("Version: %s\n", version)
+		os.Exit(0)
+	}
+	if flag.NArg() == 0 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	if err := runCommand(*storePath, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runCommand(storePath, name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage()
+		return fmt.Errorf("unknown command: %v", name)
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	run := cmd.setup(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("machine name is required")
+	}
+	return run(storePath, fs.Arg(0))
+}
+
+func setupCreate(fs *flag.FlagSet) func(storePath, name string) error {
+	d := driver.NewDriver(version)
+	opts, err := registerCreateFlags(fs, d.GetCreateFlags())
+	return func(storePath, name string) error {
+		if err != nil {
+			return err
+		}
+		if !validMachineName.MatchString(name) {
+			return fmt.Errorf("invalid machine name %v, only letters, digits, dashes and dots are allowed", name)
+		}
+
+		d.MachineName = name
+		d.StorePath = storePath
+		if err := d.SetConfigFromFlags(opts); err != nil {
+			return err
+		}
+		if err := d.PreCreateCheck(); err != nil {
+			return err
+		}
+
+		// like docker-machine, save before creating, so the machine can be removed if creating fails
+		if err := d.InitMachine(); err != nil {
+			return err
+		}
+		if err := d.Create(); err != nil {
+			_ = d.SaveMachine()
+			return err
+		}
+		return d.SaveMachine()
+	}
+}
+
+// flagOptions provides the values of the driver's create flags, after parsing them from the command line
+type flagOptions map[string]func() interface{}
+
+func (o flagOptions) get(key string) interface{} {
+	if value, ok := o[key]; ok {
+		return value()
+	}
+	return nil
+}
+
+func (o flagOptions) String(key string) string {
+	value, _ := o.get(key).(string)
+	return value
+}
+
+func (o flagOptions) StringSlice(key string) []string {
+	value, _ := o.get(key).([]string)
+	return value
+}
+
+func (o flagOptions) Int(key string) int {
+	value, _ := o.get(key).(int)
+	return value
+}
+
+func (o flagOptions) Bool(key string) bool {
+	value, _ := o.get(key).(bool)
+	return value
+}
+
+// stringSlice is a repeatable flag, replacing its default once given
+type stringSlice struct {
+	values []string
+	set    bool
+}
+
+func (s *stringSlice) String() string {
+	return strings.Join(s.values, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	if !s.set {
+		s.values, s.set = nil, true
+	}
+	s.values = append(s.values, value)
+	return nil
+}
+
+// registerCreateFlags registers the driver's create flags, defaulting to their environment variables like
+// docker-machine does
+func registerCreateFlags(fs *flag.FlagSet, flags []mcnflag.Flag) (flagOptions, error) {
+	opts := make(flagOptions)
+	for _, f := range flags {
+		switch f := f.(type) {
+		case mcnflag.StringFlag:
+			value := f.Value
+			if env, ok := os.LookupEnv(f.EnvVar); ok && f.EnvVar != "" {
+				value = env
+			}
+			p := fs.String(f.Name, value, f.Usage)
+			opts[f.Name] = func() interface{} { return *p }
+		case mcnflag.IntFlag:
+			value := f.Value
+			if env, ok := os.LookupEnv(f.EnvVar); ok && f.EnvVar != "" {
+				var err error
+				if value, err = strconv.Atoi(env); err != nil {
+					return nil, fmt.Errorf("invalid value of %v: %w", f.EnvVar, err)
+				}
+			}
+			p := fs.Int(f.Name, value, f.Usage)
+			opts[f.Name] = func() interface{} { return *p }
+		case mcnflag.BoolFlag:
+			value := false
+			if env, ok := os.LookupEnv(f.EnvVar); ok && f.EnvVar != "" {
+				var err error
+				if value, err = strconv.ParseBool(env); err != nil {
+					return nil, fmt.Errorf("invalid value of %v: %w", f.EnvVar, err)
+				}
+			}
+			p := fs.Bool(f.Name, value, f.Usage)
+			opts[f.Name] = func() interface{} { return *p }
+		case mcnflag.StringSliceFlag:
+			value := &stringSlice{values: f.Value}
+			if env, ok := os.LookupEnv(f.EnvVar); ok && f.EnvVar != "" {
+				value.values = strings.Split(env, ",")
+			}
+			fs.Var(value, f.Name, f.Usage)
+			opts[f.Name] = func() interface{} { return value.values }
+		}
+	}
+	return opts, nil
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: hetzner-machine [-storage-path <path>] <command> [flags] <machine>\n\n")
+	fmt.Fprintf(os.Stderr, "Available commands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, commands[name].usage)
+	}
+}
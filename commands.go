@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/JonasProgrammer/docker-machine-driver-hetzner/driver"
+	"github.com/docker/machine/commands/mcndirs"
+)
+
+// command is a maintenance operation run directly against an existing machine, outside the plugin lifecycle
+type command struct {
+	usage string
+	// project commands operate on a whole project given by its API token, rather than on a single machine
+	project bool
+	// static commands need neither a machine nor a project
+	static bool
+	setup  func(fs *flag.FlagSet) func(d *driver.Driver) error
+}
+
+var commands = map[string]command{
+	"autoscaler-config": {
+		usage: "print the machine's node group as nodeConfigs entry of cluster-autoscaler's HCLOUD_CLUSTER_CONFIG",
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			return func(d *driver.Driver) error {
+				config, err := d.AutoscalerNodeConfig()
+				if err != nil {
+					return err
+				}
+				out, err := json.MarshalIndent(config, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(out))
+				return nil
+			}
+		},
+	},
+	"cloud-init-logs": {
+		usage: "save cloud-init's status and output log of the server to the machine's store directory",
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			return func(d *driver.Driver) error {
+				paths, err := d.FetchCloudInitLogs()
+				for _, path := range paths {
+					fmt.Println(path)
+				}
+				return err
+			}
+		},
+	},
+	"cost-report": {
+		usage:   "print the monthly price of resources created by the driver, grouped by machine or another label",
+		project: true,
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			groupBy := fs.String("group-by", "docker-machine/machine", "label to group resources by, e.g. hcloud/node-group")
+			allStores := fs.Bool("all-stores", false, "include resources created from other docker-machine stores")
+			resources := fs.Bool("resources", false, "list every resource below its group")
+			return func(d *driver.Driver) error {
+				report, err := d.CostReport(*groupBy, *allStores)
+				if err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintf(w, "%s\tRESOURCES\tMONTHLY (%s)\n", strings.ToUpper(*groupBy), report.Currency)
+				for _, group := range report.Groups() {
+					fmt.Fprintf(w, "%s\t%d\t%.2f\n", group.Name, group.Count, group.Monthly)
+					if !*resources {
+						continue
+					}
+					for _, item := range report.Items {
+						if item.Group == group.Name {
+							fmt.Fprintf(w, "  %s %s[%d]\t\t%.2f\n", item.Kind, item.Name, item.ID, item.Monthly)
+						}
+					}
+				}
+				fmt.Fprintf(w, "TOTAL\t%d\t%.2f\n", len(report.Items), report.Total())
+				return w.Flush()
+			}
+		},
+	},
+	"gc": {
+		usage:   "delete resources labeled for machines which do not exist locally anymore",
+		project: true,
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			yes := fs.Bool("yes", false, "delete without asking for confirmation")
+			selector := fs.String("selector", "", "label selector narrowing down the resources considered")
+			unlabeled := fs.Bool("include-unlabeled", false, "also consider resources without store label, e.g. created by older versions")
+			return func(d *driver.Driver) error {
+				machines, err := driver.ListMachines(d.StorePath)
+				if err != nil {
+					return err
+				}
+
+				orphans, err := d.FindOrphans(machines, *selector, *unlabeled)
+				if err != nil {
+					return err
+				}
+				if len(orphans) == 0 {
+					fmt.Println("No orphaned resources found")
+					return nil
+				}
+
+				for _, orphan := range orphans {
+					fmt.Println(orphan)
+				}
+				if !*yes && !confirm(fmt.Sprintf("Delete these %d resources?", len(orphans))) {
+					return nil
+				}
+				return d.DeleteOrphans(orphans)
+			}
+		},
+	},
+	"golden-snapshot": {
+		usage: "power the server down briefly and snapshot it as golden image, e.g. for --hetzner-create-golden-snapshot",
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			label := fs.String("label", "", "golden image name to label the snapshot with (default: that of --hetzner-create-golden-snapshot)")
+			return func(d *driver.Driver) error {
+				if *label == "" {
+					*label = d.GoldenSnapshot
+				}
+				if *label == "" {
+					return fmt.Errorf("-label is required, as the machine was not created with --hetzner-create-golden-snapshot")
+				}
+				image, err := d.CreateGoldenSnapshot(*label)
+				if image != nil {
+					fmt.Println(image.ID)
+				}
+				return err
+			}
+		},
+	},
+	"prune-snapshots": {
+		usage:   "delete snapshots created by the driver beyond a retention count or age",
+		project: true,
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			selector := fs.String("selector", "", "label selector the snapshots must match additionally, e.g. docker-machine/golden=runner")
+			keep := fs.Int("keep", 0, "number of newest snapshots to keep per golden image")
+			maxAge := fs.Duration("max-age", 0, "keep snapshots younger than this, e.g. 720h")
+			yes := fs.Bool("yes", false, "delete without asking for confirmation")
+			return func(d *driver.Driver) error {
+				images, err := d.FindPrunableSnapshots(*selector, *keep, *maxAge)
+				if err != nil {
+					return err
+				}
+				if len(images) == 0 {
+					fmt.Println("No snapshots to prune")
+					return nil
+				}
+
+				for _, image := range images {
+					fmt.Printf("snapshot %s[%d], created %s, %.2f GB\n", image.Description, image.ID,
+						image.Created.Format(time.RFC3339), image.ImageSize)
+				}
+				if !*yes && !confirm(fmt.Sprintf("Delete these %d snapshots?", len(images))) {
+					return nil
+				}
+				return d.DeleteSnapshots(images)
+			}
+		},
+	},
+	"rebuild": {
+		usage: "reimage the server, keeping its ID and IP addresses",
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			image := fs.String("image", "", "ID or name of the image to use instead of the original one")
+			skipProvision := fs.Bool("skip-provision", false, "leave provisioning docker to 'docker-machine provision'")
+			return func(d *driver.Driver) error {
+				if err := d.Rebuild(*image); err != nil {
+					return err
+				}
+				if *skipProvision {
+					return nil
+				}
+				// the rebuilt server is recorded first, as provisioning may fail on its own
+				if err := d.SaveMachine(); err != nil {
+					return err
+				}
+				return d.Reprovision()
+			}
+		},
+	},
+	"resume": {
+		usage: "resume a creation which failed after the server had been created",
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			return func(d *driver.Driver) error {
+				return d.ResumeCreate()
+			}
+		},
+	},
+	"rotate-ssh-key": {
+		usage: "replace the machine's generated SSH key by a new one, revoking the old key",
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			keyType := fs.String("type", "", "type of the new key, rsa or ed25519 (default: type of the current key)")
+			return func(d *driver.Driver) error {
+				return d.RotateSSHKey(*keyType)
+			}
+		},
+	},
+	"schema": {
+		usage:  "print a JSON schema of the machine config, describing all create flags, e.g. for Rancher UIs",
+		static: true,
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			return func(d *driver.Driver) error {
+				out, err := json.MarshalIndent(d.ConfigSchema(), "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(out))
+				return nil
+			}
+		},
+	},
+	"server-type": {
+		usage: "change the server type of a machine, powering it off if needed",
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			serverType := fs.String("type", "", "new server type")
+			keepDisk := fs.Bool("keep-disk", false, "keep the current disk size (default from --hetzner-resize-keep-disk)")
+			return func(d *driver.Driver) error {
+				// an explicit -keep-disk=false overrides the machine's default
+				keep := d.ResizeKeepDisk
+				fs.Visit(func(f *flag.Flag) {
+					if f.Name == "keep-disk" {
+						keep = *keepDisk
+					}
+				})
+				return d.ChangeServerType(*serverType, keep)
+			}
+		},
+	},
+	"version": {
+		usage:  "print the driver's version, build info, capabilities and create flags, e.g. for bug reports",
+		static: true,
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			asJSON := fs.Bool("json", false, "print the report as JSON")
+			return func(d *driver.Driver) error {
+				report := d.VersionReport()
+				if *asJSON {
+					out, err := json.MarshalIndent(report, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				}
+				printVersionReport(report)
+				return nil
+			}
+		},
+	},
+	"volume-resize": {
+		usage: "grow an attached volume and its filesystem",
+		setup: func(fs *flag.FlagSet) func(d *driver.Driver) error {
+			volume := fs.String("volume", "", "ID or name of the attached volume (optional if only one is attached)")
+			size := fs.Int("size", 0, "new volume size in GB")
+			return func(d *driver.Driver) error {
+				return d.ResizeVolume(*volume, *size)
+			}
+		},
+	},
+}
+
+func runCommand(name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		printCommands()
+		return fmt.Errorf("unknown command: %v", name)
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	storePath := fs.String("storage-path", mcndirs.GetBaseDir(), "docker-machine storage path")
+	var machine, token *string
+	switch {
+	case cmd.static:
+	case cmd.project:
+		token = fs.String("token", os.Getenv("HETZNER_API_TOKEN"), "Hetzner API token of the project")
+	default:
+		machine = fs.String("machine", "", "name of the docker-machine host")
+	}
+	run := cmd.setup(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cmd.static {
+		return run(driver.NewDriver(version))
+	}
+
+	if cmd.project {
+		if *token == "" {
+			return fmt.Errorf("-token is required")
+		}
+
+		d := driver.NewDriver(version)
+		d.AccessToken = *token
+		d.StorePath = *storePath
+		return run(d)
+	}
+
+	if *machine == "" {
+		return fmt.Errorf("-machine is required")
+	}
+
+	d, err := driver.LoadMachine(version, *storePath, *machine)
+	if err != nil {
+		return err
+	}
+
+	if err := run(d); err != nil {
+		return err
+	}
+
+	return d.SaveMachine()
+}
+
+func printVersionReport(report driver.VersionReport) {
+	fmt.Printf("Version: %s\n", report.Version)
+	if report.Revision != "" {
+		modified := ""
+		if report.Modified {
+			modified = " (modified)"
+		}
+		fmt.Printf("Revision: %s%s, %s\n", report.Revision, modified, report.BuildTime)
+	}
+	fmt.Printf("Go: %s %s\n", report.GoVersion, report.Platform)
+	fmt.Printf("hcloud-go: %s\n", report.HcloudGo)
+	if report.Libmachine != "" {
+		fmt.Printf("libmachine: %s\n", report.Libmachine)
+	}
+
+	fmt.Println("\nCapabilities:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, capability := range report.Capabilities {
+		fmt.Fprintf(w, "  %s\t--%s\n", capability.Name, strings.Join(capability.Flags, ", --"))
+	}
+	_ = w.Flush()
+
+	fmt.Println("\nFlags:")
+	for _, flag := range report.Flags {
+		fmt.Printf("  --%s\n", flag)
+	}
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Available commands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, commands[name].usage)
+	}
+}
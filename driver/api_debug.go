@@ -0,0 +1,92 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const redacted = "[REDACTED]"
+
+// debugTransport logs a summary of every API request, leaving out headers and bodies, which may contain secrets
+type debugTransport struct {
+	next  http.RoundTripper
+	token string
+}
+
+type debugAction struct {
+	ID      int64  `json:"id"`
+	Command string `json:"command"`
+}
+
+type debugResponse struct {
+	Action      *debugAction  `json:"action"`
+	Actions     []debugAction `json:"actions"`
+	NextActions []debugAction `json:"next_actions"`
+	Error       *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	if err != nil {
+		log.Infof("[http] %s %s: %s (%v)", req.Method, req.URL.Path, t.redact(err.Error()), elapsed)
+		return resp, err
+	}
+
+	summary := []string{resp.Status}
+	if remaining := resp.Header.Get("RateLimit-Remaining"); remaining != "" {
+		summary = append(summary, fmt.Sprintf("rate limit %s/%s", remaining, resp.Header.Get("RateLimit-Limit")))
+	}
+
+	body, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr == nil {
+		summary = append(summary, summarizeResponse(body)...)
+	}
+
+	log.Infof("[http] %s %s: %s (%v)", req.Method, req.URL.Path, t.redact(strings.Join(summary, ", ")), elapsed)
+	return resp, nil
+}
+
+// redact removes the token from log output, in case an error or message contains it
+func (t *debugTransport) redact(s string) string {
+	if t.token == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, t.token, redacted)
+}
+
+func summarizeResponse(body []byte) []string {
+	var parsed debugResponse
+	if json.Unmarshal(body, &parsed) != nil {
+		return nil
+	}
+
+	var summary []string
+	actions := parsed.Actions
+	if parsed.Action != nil {
+		actions = append(actions, *parsed.Action)
+	}
+	actions = append(actions, parsed.NextActions...)
+	for _, a := range actions {
+		summary = append(summary, fmt.Sprintf("action %s[%d]", a.Command, a.ID))
+	}
+
+	if parsed.Error != nil {
+		summary = append(summary, fmt.Sprintf("error %s: %s", parsed.Error.Code, parsed.Error.Message))
+	}
+	return summary
+}
@@ -0,0 +1,82 @@
+package driver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// loadCAPool returns the system certificate pool extended by the certificates in the given PEM file
+func loadCAPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %v", path)
+	}
+	return pool, nil
+}
+
+// getBaseTransport returns the transport for API requests, trusting the custom CA and using the explicit proxy if
+// configured; otherwise, the standard proxy environment variables apply
+func (d *Driver) getBaseTransport() http.RoundTripper {
+	if d.APICAFile == "" && d.APIProxy == "" {
+		return http.DefaultTransport
+	}
+	if d.cachedTransport != nil {
+		return d.cachedTransport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if d.APICAFile != "" {
+		pool, err := loadCAPool(d.APICAFile)
+		if err != nil {
+			// requests will fail with a certificate error, so this is merely a hint at the cause
+			log.Errorf("could not load API CA: %v", err)
+		} else {
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		}
+	}
+
+	if d.APIProxy != "" {
+		proxy, err := parseProxyURL(d.APIProxy)
+		if err != nil {
+			log.Errorf("could not use API proxy: %v", err)
+		} else {
+			transport.Proxy = http.ProxyURL(proxy)
+		}
+	}
+
+	d.cachedTransport = transport
+	return transport
+}
+
+// parseProxyURL parses a proxy URL with any of the schemes supported by [http.Transport]
+func parseProxyURL(raw string) (*url.URL, error) {
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme '%s', use http, https, socks5 or socks5h", proxy.Scheme)
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("proxy URL %v lacks a host", raw)
+	}
+	return proxy, nil
+}
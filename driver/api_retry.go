@@ -0,0 +1,134 @@
+package driver
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const (
+	retryBaseDelay = 1 * time.Second
+	retryMaxDelay  = 30 * time.Second
+
+	// defaultLockTimeout bounds waiting for locked resources if no action timeout was configured
+	defaultLockTimeout = 5 * time.Minute
+)
+
+// retryTransport retries requests answered by a rate limit (429) with exponential backoff and jitter, so single
+// transient errors do not fail whole operations. Server errors (5xx) and network errors are only retried for idempotent
+// requests, as a failed POST may well have created a resource, which a retry would create a second time.
+type retryTransport struct {
+	next        http.RoundTripper
+	maxAttempts int
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.maxAttempts || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		var delay time.Duration
+		if err != nil {
+			delay = retryDelay(attempt, "")
+			log.Debugf("%s %s: %v, retrying in %v (attempt %d of %d)", req.Method, req.URL.Path, err,
+				delay, attempt+1, t.maxAttempts)
+		} else {
+			delay = retryDelay(attempt, resp.Header.Get("Retry-After"))
+			log.Debugf("%s %s: got %s, retrying in %v (attempt %d of %d)", req.Method, req.URL.Path, resp.Status,
+				delay, attempt+1, t.maxAttempts)
+
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// shouldRetry tells whether a request may be sent once more: requests rejected by the rate limit were not processed,
+// others only if repeating them does no harm
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil && isIdempotent(req.Method)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return isRetryableStatus(resp.StatusCode) && isIdempotent(req.Method)
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500 && status <= 599
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryDelay doubles the delay with every attempt, up to a maximum, picking a random delay within the upper half to
+// spread out parallel clients; a Retry-After by the server takes precedence
+func retryDelay(attempt int, retryAfter string) time.Duration {
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	delay := retryBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryLocked issues the request until the resource is no longer locked by another action, such as a backup or a
+// Hetzner-side maintenance operation, backing off in between; waiting is bounded by the action timeout
+func retryLocked[T any](d *Driver, request func() (T, *hcloud.Response, error)) (T, *hcloud.Response, error) {
+	timeout := defaultLockTimeout
+	if d.ActionTimeout > 0 {
+		timeout = time.Duration(d.ActionTimeout) * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+
+	for attempt := 1; ; attempt++ {
+		res, resp, err := request()
+		if !isAPIError(err, hcloud.ErrorCodeLocked) {
+			return res, resp, err
+		}
+
+		delay := retryDelay(attempt, "")
+		if time.Now().Add(delay).After(deadline) {
+			return res, resp, fmt.Errorf("resource still locked after %v: %w", timeout, err)
+		}
+		log.Infof(" -> Resource is locked by another action, retrying in %v...", delay)
+
+		select {
+		case <-d.getContext().Done():
+			return res, resp, d.getContext().Err()
+		case <-time.After(delay):
+		}
+	}
+}
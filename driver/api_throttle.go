@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// rateLimitReserveRatio is the share of the rate limit budget below which requests are throttled
+const rateLimitReserveRatio = 10
+
+// rateLimitState tracks the rate limit budget reported by the API, which is shared by all clients of a project
+type rateLimitState struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// delay returns how long to wait before the next request, so the budget is spread until it has been restored
+func (s *rateLimitState) delay(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.limit == 0 || s.remaining*rateLimitReserveRatio >= s.limit || !now.Before(s.reset) {
+		return 0
+	}
+
+	// the budget refills gradually until reset, so wait for roughly one request's worth
+	return s.reset.Sub(now) / time.Duration(s.limit-s.remaining)
+}
+
+func (s *rateLimitState) update(header http.Header) {
+	limit, err1 := strconv.Atoi(header.Get("RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(header.Get("RateLimit-Remaining"))
+	reset, err3 := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit, s.remaining, s.reset = limit, remaining, time.Unix(reset, 0)
+}
+
+// throttleTransport delays requests while the rate limit budget is nearly exhausted, so parallel creates do not run
+// into rate limit errors
+type throttleTransport struct {
+	next  http.RoundTripper
+	state *rateLimitState
+}
+
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if delay := t.state.delay(time.Now()); delay > 0 {
+		log.Debugf("%s %s: rate limit budget nearly exhausted, waiting %v", req.Method, req.URL.Path, delay)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.state.update(resp.Header)
+	}
+	return resp, err
+}
@@ -0,0 +1,96 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// setupTokenSource ensures exactly one way of passing the token was chosen and that it yields a token right away
+func (d *Driver) setupTokenSource() error {
+	sources := 0
+	for _, source := range []string{d.AccessToken, d.APITokenFile, d.APITokenCmd} {
+		if source != "" {
+			sources++
+		}
+	}
+
+	if sources == 0 {
+		return d.flagFailure("hetzner requires --%v, --%v or --%v to be set", flagAPIToken, flagAPITokenFile, flagAPITokenCmd)
+	} else if sources > 1 {
+		return d.flagFailure("--%v, --%v and --%v are mutually exclusive", flagAPIToken, flagAPITokenFile, flagAPITokenCmd)
+	} else if d.AccessToken != "" {
+		return nil
+	}
+
+	token, err := d.resolveAccessToken()
+	if err != nil {
+		return d.flagFailure("could not get API token: %v", err)
+	}
+	d.cachedToken = token
+
+	if d.APITokenFile != "" {
+		// later operations may run from another working directory
+		if d.APITokenFile, err = filepath.Abs(d.APITokenFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAccessToken returns the API token; when it is read from a file or command, this happens on first use in each
+// process, so the token itself never ends up in the machine config. A failure is remembered as well, so a failing
+// credential helper runs and reports once per process rather than for each API client.
+func (d *Driver) getAccessToken() string {
+	if d.AccessToken != "" {
+		return d.AccessToken
+	}
+
+	if d.cachedToken == "" && d.tokenErr == nil {
+		d.cachedToken, d.tokenErr = d.resolveAccessToken()
+		if d.tokenErr != nil {
+			log.Errorf("could not resolve API token, API requests will fail: %v", d.tokenErr)
+		}
+	}
+	return d.cachedToken
+}
+
+func (d *Driver) resolveAccessToken() (string, error) {
+	var token string
+	if d.APITokenFile != "" {
+		buf, err := os.ReadFile(d.APITokenFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read token file: %w", err)
+		}
+		token = string(buf)
+	} else if d.APITokenCmd != "" {
+		out, err := tokenCommand(d.APITokenCmd).Output()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) != 0 {
+			return "", fmt.Errorf("token command failed: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		} else if err != nil {
+			return "", fmt.Errorf("token command failed: %w", err)
+		}
+		token = string(out)
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("no API token was returned")
+	}
+	return token, nil
+}
+
+// tokenCommand runs the credential helper through the platform's shell, so it may contain arguments and pipes
+func tokenCommand(cmd string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", cmd)
+	}
+	return exec.Command("sh", "-c", cmd)
+}
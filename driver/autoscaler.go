@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const (
+	// labelNodeGroup is how cluster-autoscaler's Hetzner provider tells which node group a server belongs to
+	labelNodeGroup = "hcloud/node-group"
+	labelCores     = "cores"
+	labelMemory    = "memory-gb"
+	labelDisk      = "disk-gb"
+)
+
+var taintEffects = []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+
+// autoscalerTaint is a Kubernetes taint in the format of cluster-autoscaler's Hetzner cluster config
+type autoscalerTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+// parseTaint accepts taints in kubectl's key[=value]:effect format
+func parseTaint(raw string) (autoscalerTaint, error) {
+	rest, effect, found := strings.Cut(raw, ":")
+	if !found || !slices.Contains(taintEffects, effect) {
+		return autoscalerTaint{}, fmt.Errorf("taint %v is not in key[=value]:effect format, with effect one of %v", raw,
+			strings.Join(taintEffects, ", "))
+	}
+	key, value, _ := strings.Cut(rest, "=")
+	if key == "" {
+		return autoscalerTaint{}, fmt.Errorf("taint %v has no key", raw)
+	}
+	return autoscalerTaint{Key: key, Value: value, Effect: effect}, nil
+}
+
+// setAutoscalerFlags labels the server for cluster-autoscaler; node labels are Hetzner labels as well, so scaling from
+// an existing node of the group can derive them
+func (d *Driver) setAutoscalerFlags(opts drivers.DriverOptions) error {
+	d.NodeGroup = opts.String(flagNodeGroup)
+	d.NodeLabels = make(map[string]string)
+	for _, label := range opts.StringSlice(flagNodeLabel) {
+		split := strings.SplitN(label, "=", 2)
+		if len(split) != 2 {
+			return d.flagFailure("node label %v is not in key=value format", label)
+		}
+		if err := validateLabel(split[0], split[1]); err != nil {
+			return d.flagFailure("invalid --%v: %v", flagNodeLabel, err)
+		}
+		d.NodeLabels[split[0]] = split[1]
+	}
+	d.NodeTaints = nil
+	for _, raw := range opts.StringSlice(flagNodeTaint) {
+		if _, err := parseTaint(raw); err != nil {
+			return d.flagFailure("invalid --%v: %v", flagNodeTaint, err)
+		}
+		d.NodeTaints = append(d.NodeTaints, raw)
+	}
+
+	if d.NodeGroup == "" {
+		if len(d.NodeLabels) != 0 || len(d.NodeTaints) != 0 {
+			return d.flagFailure("--%v and --%v require --%v", flagNodeLabel, flagNodeTaint, flagNodeGroup)
+		}
+		return nil
+	}
+
+	d.ServerLabels[labelNodeGroup] = d.NodeGroup
+	for k, v := range d.NodeLabels {
+		d.ServerLabels[k] = v
+	}
+	return nil
+}
+
+// autoscalerCapacityLabels hints the node's capacity, as known to cluster-autoscaler from the server type, to tools
+// that only see the server's labels
+func (d *Driver) autoscalerCapacityLabels(labels map[string]string, serverType *hcloud.ServerType) {
+	if d.NodeGroup == "" || serverType == nil {
+		return
+	}
+	labels[d.labelName(labelCores)] = strconv.Itoa(serverType.Cores)
+	labels[d.labelName(labelMemory)] = strconv.FormatFloat(float64(serverType.Memory), 'f', -1, 32)
+	labels[d.labelName(labelDisk)] = strconv.Itoa(serverType.Disk)
+}
+
+// AutoscalerNodeConfig returns the machine's node group as entry of the nodeConfigs in cluster-autoscaler's
+// HCLOUD_CLUSTER_CONFIG, from which it builds template nodes when scaling the group up from zero
+func (d *Driver) AutoscalerNodeConfig() (map[string]interface{}, error) {
+	if d.NodeGroup == "" {
+		return nil, fmt.Errorf("machine %v was not created with --%v", d.GetMachineName(), flagNodeGroup)
+	}
+
+	taints := make([]autoscalerTaint, 0, len(d.NodeTaints))
+	for _, raw := range d.NodeTaints {
+		taint, err := parseTaint(raw)
+		if err != nil {
+			return nil, err
+		}
+		taints = append(taints, taint)
+	}
+	labels := d.NodeLabels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	return map[string]interface{}{
+		"nodeConfigs": map[string]interface{}{
+			d.NodeGroup: map[string]interface{}{
+				"labels": labels,
+				"taints": taints,
+			},
+		},
+	}, nil
+}
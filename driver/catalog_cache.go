@@ -0,0 +1,69 @@
+package driver
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// catalogTTL limits how long catalog lookups are reused, so long-running processes still notice changes
+const catalogTTL = 30 * time.Second
+
+const (
+	catalogServerType  = "server-type"
+	catalogLocation    = "location"
+	catalogImage       = "image"
+	catalogSSHKey      = "ssh-key"
+	catalogDatacenters = "datacenters"
+)
+
+type catalogEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// catalog caches lookups of rarely changing API resources for the lifetime of the process, keyed by API token, so
+// repeated validation and lookups do not issue redundant requests
+var catalog = struct {
+	sync.Mutex
+	entries map[string]catalogEntry
+}{entries: make(map[string]catalogEntry)}
+
+func catalogKey(token, kind, key string) string {
+	return token + "\x00" + kind + "\x00" + key
+}
+
+// cachedLookup returns the cached result of a lookup, or performs it and caches successful results
+func cachedLookup[T any](d *Driver, kind, key string, lookup func() (T, error)) (T, error) {
+	k := catalogKey(d.getAccessToken(), kind, key)
+
+	catalog.Lock()
+	entry, ok := catalog.entries[k]
+	catalog.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value.(T), nil
+	}
+
+	value, err := lookup()
+	if err != nil {
+		return value, err
+	}
+
+	catalog.Lock()
+	catalog.entries[k] = catalogEntry{value: value, expires: time.Now().Add(catalogTTL)}
+	catalog.Unlock()
+	return value, nil
+}
+
+// invalidateCatalog drops all cached lookups of the given kind, e.g. after creating a resource of that kind
+func (d *Driver) invalidateCatalog(kind string) {
+	prefix := catalogKey(d.getAccessToken(), kind, "")
+
+	catalog.Lock()
+	defer catalog.Unlock()
+	for k := range catalog.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(catalog.entries, k)
+		}
+	}
+}
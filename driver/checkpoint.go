@@ -0,0 +1,51 @@
+package driver
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// checkpoint persists the state of a partially created machine, so resources kept after a failed creation can either
+// be cleaned up by removing the machine or be picked up again by resuming
+func (d *Driver) checkpoint() {
+	// failure to store a checkpoint is not a hard error, the creation itself may still succeed
+	if err := d.SaveMachine(); err != nil {
+		log.Warnf(" -> could not store checkpoint: %v", err)
+	}
+}
+
+// getResumableServer returns the server recorded by a previous, failed creation, if it still exists
+func (d *Driver) getResumableServer() (*hcloud.ServerCreateResult, error) {
+	if d.ServerID == 0 {
+		return nil, nil
+	}
+
+	srv, err := d.getServerHandleNullable()
+	if err != nil {
+		return nil, fmt.Errorf("could not get server handle: %w", err)
+	}
+	if srv == nil {
+		log.Warnf(" -> Server %d from previous attempt does not exist anymore, creating a new one", d.ServerID)
+		d.ServerID = 0
+		return nil, nil
+	}
+
+	log.Infof("Resuming creation of server %s[%d]...", srv.Name, srv.ID)
+	return &hcloud.ServerCreateResult{Server: srv}, nil
+}
+
+// ResumeCreate continues the creation of a machine whose creation failed after the server had been created
+func (d *Driver) ResumeCreate() error {
+	if d.ServerID == 0 {
+		return fmt.Errorf("machine %v has no server to resume the creation of", d.GetMachineName())
+	}
+
+	if err := d.Create(); err != nil {
+		return err
+	}
+
+	log.Infof("Creation resumed; run 'docker-machine provision %s' to set up docker", d.GetMachineName())
+	return nil
+}
@@ -1,17 +1,58 @@
 package driver
 
 import (
-	"context"
 	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/docker/machine/libmachine/log"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 )
 
 func (d *Driver) destroyDangling() {
-	for _, destructor := range d.dangling {
-		destructor()
+	if len(d.dangling) == 0 {
+		return
 	}
+
+	// the operation may have been canceled, but cleaning up must still be possible
+	d.endOperation()
+
+	if d.KeepOnFailure {
+		log.Warnf("Keeping %d partially created resources; remove the machine or resume its creation", len(d.dangling))
+		d.checkpoint()
+		return
+	}
+
+	if d.keepOnError > 0 && d.ServerID != 0 {
+		// failure to hold the server is not a hard error, it merely falls back to the regular rollback
+		if err := d.holdFailedServer(); err != nil {
+			log.Warnf(" -> could not hold failed server: %v", err)
+		} else {
+			log.Infof("Rolling back %d partially created resources not needed by the failed server...",
+				len(d.dangling)-len(d.serverDangling))
+			for i := len(d.dangling) - 1; i >= 0; i-- {
+				if !slices.Contains(d.serverDangling, i) {
+					d.dangling[i]()
+				}
+			}
+			d.checkpoint()
+			return
+		}
+	}
+
+	// destroy in reverse order, as later resources may depend on earlier ones, e.g. servers on placement groups
+	log.Infof("Rolling back %d partially created resources...", len(d.dangling))
+	for i := len(d.dangling) - 1; i >= 0; i-- {
+		d.dangling[i]()
+	}
+}
+
+// keepWithServer marks the last dangling resource as part of the server, i.e. it is kept along with the server by
+// --hetzner-keep-on-error, as it cannot be removed while the server exists
+func (d *Driver) keepWithServer() {
+	d.serverDangling = append(d.serverDangling, len(d.dangling)-1)
 }
 
 func (d *Driver) removeEmptyServerPlacementGroup(srv *hcloud.Server) error {
@@ -26,7 +67,7 @@ func (d *Driver) removeEmptyServerPlacementGroup(srv *hcloud.Server) error {
 	}
 
 	if auto, exists := pg.Labels[d.labelName(labelAutoCreated)]; exists && auto == "true" {
-		_, err := d.getClient().PlacementGroup.Delete(context.Background(), pg)
+		_, err := d.getClient().PlacementGroup.Delete(d.getContext(), pg)
 		if err != nil {
 			return fmt.Errorf("could not remove placement group: %w", err)
 		}
@@ -37,6 +78,80 @@ func (d *Driver) removeEmptyServerPlacementGroup(srv *hcloud.Server) error {
 	}
 }
 
+// envForceRemove allows removing servers failing the ownership verification, as remove takes no driver flags
+const envForceRemove = "HETZNER_FORCE_REMOVE"
+
+// verifyServerOwnership ensures the server resolved by ID still is the machine's server, guarding against ID reuse
+// and manual changes before deleting anything
+func (d *Driver) verifyServerOwnership() error {
+	if d.ServerID == 0 {
+		return nil
+	}
+
+	srv, err := d.getServerHandleNullable()
+	if err != nil {
+		return fmt.Errorf("could not get server handle: %w", err)
+	}
+	if srv == nil {
+		return nil
+	}
+
+	expectedName := d.getExpectedServerName()
+
+	var mismatch string
+	if srv.Name != expectedName {
+		mismatch = fmt.Sprintf("is named %v instead of %v", srv.Name, expectedName)
+	} else if machine, ok := srv.Labels[d.labelName(labelMachine)]; ok && machine != d.shortName() {
+		mismatch = fmt.Sprintf("is labeled for machine %v", machine)
+	} else if store, ok := srv.Labels[d.labelName(labelStore)]; ok && d.storeID() != "" && store != d.storeID() {
+		mismatch = fmt.Sprintf("is labeled for store %v", store)
+	}
+
+	if mismatch == "" {
+		return nil
+	}
+
+	if force, _ := strconv.ParseBool(os.Getenv(envForceRemove)); force {
+		log.Warnf(" -> Server %s[%d] %s, removing anyway as %s is set", srv.Name, srv.ID, mismatch, envForceRemove)
+		return nil
+	}
+	return fmt.Errorf("server %s[%d] %s and may not belong to this machine; set %s=true to remove it anyway",
+		srv.Name, srv.ID, mismatch, envForceRemove)
+}
+
+func (d *Driver) getExpectedServerName() string {
+	if d.ServerName == "" { // machines created before the server name was recorded
+		return d.shortName()
+	}
+	return d.ServerName
+}
+
+// verifyServerGone ensures no other server carries the machine's name and label once its server does not exist
+// anymore, as could happen if it was recreated by hand; only then, the machine is purged without deleting a server
+func (d *Driver) verifyServerGone() error {
+	servers, err := d.getClient().Server.AllWithOpts(d.getContext(), hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: d.labelName(labelMachine) + "=" + d.shortName()},
+		Name:     d.getExpectedServerName(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not look for servers of the machine: %w", err)
+	}
+
+	if len(servers) == 0 {
+		log.Infof(" -> Server %d does not exist anymore", d.ServerID)
+		return nil
+	}
+
+	srv := servers[0]
+	if force, _ := strconv.ParseBool(os.Getenv(envForceRemove)); force {
+		log.Warnf(" -> Server %d does not exist anymore, leaving %s[%d] carrying the machine's name and label as %s is set",
+			d.ServerID, srv.Name, srv.ID, envForceRemove)
+		return nil
+	}
+	return fmt.Errorf("server %d does not exist anymore, but %s[%d] carries the machine's name and label; "+
+		"delete it by hand or set %s=true to remove the machine anyway", d.ServerID, srv.Name, srv.ID, envForceRemove)
+}
+
 func (d *Driver) destroyServer() error {
 	if d.ServerID == 0 {
 		return nil
@@ -48,25 +163,80 @@ func (d *Driver) destroyServer() error {
 	}
 
 	if srv == nil {
-		log.Infof(" -> Server does not exist anymore")
-	} else {
-		log.Infof(" -> Destroying server %s[%d] in...", srv.Name, srv.ID)
+		return d.verifyServerGone()
+	}
 
-		res, _, err := d.getClient().Server.DeleteWithResult(context.Background(), srv)
-		if err != nil {
-			return fmt.Errorf("could not delete server: %w", err)
+	// the snapshot is the point of archiving, so failure to create it is a hard error
+	if d.SnapshotOnRemove {
+		if err = d.snapshotServer(srv); err != nil {
+			return err
 		}
+	}
 
-		// failure to remove a placement group is not a hard error
-		if softErr := d.removeEmptyServerPlacementGroup(srv); softErr != nil {
-			log.Error(softErr)
-		}
+	d.deregisterLoadBalancerTargets(srv)
 
-		// wait for the server to actually be deleted
-		if err = d.waitForAction(res.Action); err != nil {
-			return fmt.Errorf("could not wait for deletion: %w", err)
-		}
+	log.Infof(" -> Destroying server %s[%d] in...", srv.Name, srv.ID)
+
+	res, _, err := retryLocked(d, func() (*hcloud.ServerDeleteResult, *hcloud.Response, error) {
+		return d.getClient().Server.DeleteWithResult(d.getContext(), srv)
+	})
+	if isAPIError(err, hcloud.ErrorCodeNotFound) {
+		log.Infof(" -> Server %s[%d] was deleted in the meantime", srv.Name, srv.ID)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not delete server: %w", err)
+	}
+
+	// failure to remove a placement group is not a hard error
+	if softErr := d.removeEmptyServerPlacementGroup(srv); softErr != nil {
+		log.Error(softErr)
+	}
+
+	// wait for the server to actually be deleted
+	if err = d.waitForAction(res.Action); err != nil {
+		return fmt.Errorf("could not wait for deletion: %w", err)
 	}
 
 	return nil
 }
+
+// releaseServer strips the driver's labels from the server, leaving it running for management by another tool
+func (d *Driver) releaseServer() error {
+	if d.ServerID == 0 {
+		return nil
+	}
+
+	srv, err := d.getServerHandleNullable()
+	if err != nil {
+		return fmt.Errorf("could not get server handle: %w", err)
+	}
+
+	if srv == nil {
+		return d.verifyServerGone()
+	}
+
+	log.Infof(" -> Releasing server %s[%d], leaving it running...", srv.Name, srv.ID)
+
+	if _, _, err = retryLocked(d, func() (*hcloud.Server, *hcloud.Response, error) {
+		return d.getClient().Server.Update(d.getContext(), srv, hcloud.ServerUpdateOpts{
+			Labels: d.withoutDriverLabels(srv.Labels),
+		})
+	}); err != nil {
+		return fmt.Errorf("could not remove server labels: %w", err)
+	}
+	return nil
+}
+
+// withoutDriverLabels returns the labels set by the user, i.e. all but the driver's own and the --hetzner-server-label
+// ones
+func (d *Driver) withoutDriverLabels(labels map[string]string) map[string]string {
+	res := make(map[string]string)
+	for k, v := range labels {
+		if _, own := d.ServerLabels[k]; own || strings.HasPrefix(k, labelNamespace+"/") ||
+			strings.HasPrefix(k, labelKeyNamespace+"/") {
+			continue
+		}
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,184 @@
+package driver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"gopkg.in/yaml.v3"
+)
+
+// userDataMaxSize is the maximum size of user data accepted by the API
+const userDataMaxSize = 32 * 1024
+
+// cloudConfigValue describes the YAML shapes accepted for a cloud-config key
+type cloudConfigValue []string
+
+const (
+	ccString = "string"
+	ccBool   = "bool"
+	ccList   = "list"
+	ccMap    = "map"
+)
+
+// cloudConfigSchema lists the commonly used cloud-config modules; keys not listed are merely warned about, as
+// cloud-init has many more modules, varying by version
+var cloudConfigSchema = map[string]cloudConfigValue{
+	"apt":                        {ccMap},
+	"bootcmd":                    {ccList},
+	"ca_certs":                   {ccMap},
+	"ca-certs":                   {ccMap},
+	"chpasswd":                   {ccMap},
+	"disable_root":               {ccBool},
+	"disk_setup":                 {ccMap},
+	"final_message":              {ccString},
+	"fqdn":                       {ccString},
+	"fs_setup":                   {ccList},
+	"groups":                     {ccList, ccMap, ccString},
+	"growpart":                   {ccMap},
+	"hostname":                   {ccString},
+	"keyboard":                   {ccMap},
+	"locale":                     {ccString, ccBool},
+	"manage_etc_hosts":           {ccBool, ccString},
+	"merge_how":                  {ccList, ccString},
+	"merge_type":                 {ccList, ccString},
+	"mounts":                     {ccList},
+	"ntp":                        {ccMap},
+	"package_reboot_if_required": {ccBool},
+	"package_update":             {ccBool},
+	"package_upgrade":            {ccBool},
+	"packages":                   {ccList},
+	"phone_home":                 {ccMap},
+	"power_state":                {ccMap},
+	"preserve_hostname":          {ccBool},
+	"resize_rootfs":              {ccBool, ccString},
+	"rsyslog":                    {ccMap, ccList},
+	"runcmd":                     {ccList},
+	"snap":                       {ccMap},
+	"ssh_authorized_keys":        {ccList},
+	"ssh_deletekeys":             {ccBool},
+	"ssh_genkeytypes":            {ccList},
+	"ssh_keys":                   {ccMap},
+	"ssh_pwauth":                 {ccBool, ccString},
+	"swap":                       {ccMap},
+	"timezone":                   {ccString},
+	"users":                      {ccList, ccMap, ccString},
+	"write_files":                {ccList},
+	"yum_repos":                  {ccMap},
+}
+
+func nodeShape(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return ccList
+	case yaml.MappingNode:
+		return ccMap
+	case yaml.ScalarNode:
+		switch node.Tag {
+		case "!!bool":
+			return ccBool
+		case "!!null":
+			return "null"
+		}
+		return ccString
+	}
+	return "unknown"
+}
+
+// fitUserData compresses user data exceeding the API's size limit. As the API only accepts text, it is gzipped and
+// base64 encoded, both of which cloud-init's Hetzner datasource decodes transparently.
+func fitUserData(userData string) (string, error) {
+	if len(userData) <= userDataMaxSize {
+		return userData, nil
+	}
+
+	var buf bytes.Buffer
+	enc := base64.NewEncoder(base64.StdEncoding, &buf)
+	gz := gzip.NewWriter(enc)
+	if _, err := gz.Write([]byte(userData)); err != nil {
+		return "", fmt.Errorf("could not compress user data: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("could not compress user data: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", fmt.Errorf("could not encode user data: %w", err)
+	}
+
+	if buf.Len() > userDataMaxSize {
+		return "", fmt.Errorf("user data has %d bytes, %d bytes compressed, exceeding the limit of %d bytes",
+			len(userData), buf.Len(), userDataMaxSize)
+	}
+
+	log.Infof("Compressed user data from %d to %d bytes to fit the limit of %d bytes", len(userData), buf.Len(), userDataMaxSize)
+	return buf.String(), nil
+}
+
+// validateUserData checks that cloud-configs parse and the common modules are given values of the right shape,
+// reporting the lines at fault
+func validateUserData(userData string) error {
+	if !isCloudConfig(userData) {
+		return nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(userData), &doc); err != nil {
+		return fmt.Errorf("invalid cloud-config: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("invalid cloud-config: line %d: expected a mapping of modules, got %s", root.Line, nodeShape(root))
+	}
+
+	var errs []error
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		shapes, known := cloudConfigSchema[key.Value]
+		if !known {
+			log.Warnf("cloud-config line %d: unknown key %v", key.Line, key.Value)
+			continue
+		}
+
+		if shape := nodeShape(value); !slices.Contains(shapes, shape) {
+			errs = append(errs, fmt.Errorf("line %d: %v must be a %s, got %s", value.Line, key.Value,
+				strings.Join(shapes, " or "), shape))
+		} else if key.Value == "write_files" {
+			errs = append(errs, validateWriteFiles(value)...)
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("invalid cloud-config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// validateWriteFiles ensures every file has a path, as cloud-init otherwise skips the whole module
+func validateWriteFiles(files *yaml.Node) []error {
+	var errs []error
+	for _, file := range files.Content {
+		if file.Kind != yaml.MappingNode {
+			errs = append(errs, fmt.Errorf("line %d: write_files entries must be a map, got %s", file.Line, nodeShape(file)))
+			continue
+		}
+
+		keys := make([]string, 0, len(file.Content)/2)
+		for i := 0; i+1 < len(file.Content); i += 2 {
+			keys = append(keys, file.Content[i].Value)
+		}
+		if !slices.Contains(keys, "path") {
+			errs = append(errs, fmt.Errorf("line %d: write_files entry lacks a path", file.Line))
+		}
+	}
+	return errs
+}
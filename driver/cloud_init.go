@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// cloudInitWaitCommand echoes the exit code, as the SSH client does not tell these apart from connection errors
+const cloudInitWaitCommand = "if command -v cloud-init >/dev/null; then " +
+	"timeout %d cloud-init status --wait >/dev/null; echo $?; else echo missing; fi"
+
+// cloudInitLogs maps the files written to the machine's store directory to the commands retrieving their contents
+var cloudInitLogs = [][2]string{
+	{"cloud-init-status.log", "cloud-init status --long || true"},
+	{"cloud-init-output.log", "cat /var/log/cloud-init-output.log"},
+}
+
+// FetchCloudInitLogs retrieves cloud-init's status and output log via SSH and writes them to the machine's store
+// directory, returning the paths written
+func (d *Driver) FetchCloudInitLogs() ([]string, error) {
+	var paths []string
+	for _, spec := range cloudInitLogs {
+		out, err := d.runRemote(spec[1])
+		if err != nil {
+			return paths, fmt.Errorf("could not fetch %v: %w", spec[0], err)
+		}
+
+		path := d.ResolveStorePath(spec[0])
+		if err = os.WriteFile(path, []byte(out), 0600); err != nil {
+			return paths, fmt.Errorf("could not write %v: %w", spec[0], err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// saveCloudInitLogs keeps the logs of a failed cloud-init run or provisioning step, as a rollback deletes the server
+// right after
+func (d *Driver) saveCloudInitLogs() {
+	d.savedInitLogs = true
+	paths, err := d.FetchCloudInitLogs()
+	for _, path := range paths {
+		log.Infof(" -> Saved %v", path)
+	}
+	if err != nil {
+		log.Warnf(" -> Could not save cloud-init logs: %v", err)
+	}
+}
+
+// waitForCloudInit blocks until cloud-init finished on the server, so user data reconfiguring networking, users or
+// the package manager cannot race Docker's installation. Servers without cloud-init are not waited for.
+func (d *Driver) waitForCloudInit() error {
+	log.Infof(" -> Waiting for cloud-init to finish...")
+
+	out, err := d.runRemote(fmt.Sprintf(cloudInitWaitCommand, d.waitCloudInitTimeout))
+	if err != nil {
+		return err
+	}
+
+	switch status := strings.TrimSpace(out); status {
+	case "0":
+		return nil
+	case "2": // finished with recoverable errors, as reported by recent versions
+		log.Warnf(" -> cloud-init finished with recoverable errors, see /var/log/cloud-init.log on the server")
+		d.saveCloudInitLogs()
+		return nil
+	case "missing":
+		log.Warnf(" -> cloud-init is not installed, not waiting for it")
+		return nil
+	case "124":
+		d.saveCloudInitLogs()
+		return fmt.Errorf("cloud-init did not finish within %d seconds", d.waitCloudInitTimeout)
+	default:
+		d.saveCloudInitLogs()
+		return fmt.Errorf("cloud-init failed with status %s, see the saved logs or /var/log/cloud-init-output.log on the server", status)
+	}
+}
@@ -0,0 +1,104 @@
+package driver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// combustionNetwork is the marker that makes Combustion bring up networking before running the script
+const combustionNetwork = "# combustion: network"
+
+var combustionNetworkMarker = regexp.MustCompile(`(?m)^# combustion:.*\bnetwork\b`)
+
+// combustionSetup runs within Combustion's transaction, where the otherwise read-only root filesystem is writable,
+// so Docker is installed already when the SUSE provisioner would use zypper
+const combustionSetup = `# added by docker-machine-driver-hetzner
+mkdir -pm700 /root/.ssh
+cat >>/root/.ssh/authorized_keys <<'DOCKER_MACHINE_KEYS'
+%s
+DOCKER_MACHINE_KEYS
+zypper --non-interactive install docker
+systemctl enable docker
+`
+
+// combustionRemountCommand makes the running snapshot writable, as docker-machine's SUSE provisioner links binaries
+// in /usr/sbin; the root filesystem is mounted read-only again on the next boot. This bypasses transactional-update,
+// which only changes the next snapshot, so it is only done given --hetzner-combustion-writable-root.
+const combustionRemountCommand = "btrfs property set -ts / ro false && mount -o remount,rw /"
+
+// setCombustionFlags passes a Combustion script as user data, as used by openSUSE MicroOS
+func (d *Driver) setCombustionFlags(opts drivers.DriverOptions) error {
+	if d.ignition && (opts.String(flagCombustion) != "" || opts.String(flagCombustionFile) != "") {
+		return d.flagFailure("--%v and --%v are mutually exclusive", flagIgnition, flagCombustion)
+	}
+
+	d.writableRoot = opts.Bool(flagWritableRoot)
+	set, err := d.setBootConfigFlags(opts, flagCombustion, flagCombustionFile)
+	if err != nil {
+		return err
+	} else if !set {
+		if d.writableRoot {
+			return d.flagFailure("--%v requires --%v or --%v", flagWritableRoot, flagCombustion, flagCombustionFile)
+		}
+		return nil
+	}
+
+	d.combustion = true
+	return nil
+}
+
+// composeCombustion inserts the driver's setup right after the script's header, so it runs regardless of how the
+// user's part exits, and enables networking for it
+func (d *Driver) composeCombustion(userData string) (string, error) {
+	if !strings.HasPrefix(userData, "#!") {
+		return "", fmt.Errorf("invalid Combustion script: must start with an interpreter line like #!/bin/bash")
+	}
+
+	keys, err := d.getBootConfigKeys()
+	if err != nil {
+		return "", err
+	}
+
+	var header, body []string
+	lines := strings.SplitAfter(userData, "\n")
+	for i, line := range lines {
+		if i != 0 && !strings.HasPrefix(line, "#") {
+			header, body = lines[:i], lines[i:]
+			break
+		}
+	}
+	if header == nil {
+		header = lines
+	}
+
+	var out strings.Builder
+	for _, line := range header {
+		out.WriteString(line)
+	}
+	if !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString("\n")
+	}
+	if !combustionNetworkMarker.MatchString(out.String()) {
+		out.WriteString(combustionNetwork + "\n")
+	}
+	out.WriteString(fmt.Sprintf(combustionSetup, strings.Join(keys, "\n")))
+	for _, line := range body {
+		out.WriteString(line)
+	}
+	return out.String(), nil
+}
+
+// prepareTransactionalProvisioning allows docker-machine to provision the server, as its SUSE provisioner is not aware
+// of transactional updates
+func (d *Driver) prepareTransactionalProvisioning() error {
+	log.Infof(" -> Making the root filesystem writable for provisioning...")
+
+	if _, err := d.runRemote(combustionRemountCommand); err != nil {
+		return fmt.Errorf("could not make root filesystem writable: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileOptions supplies the values of a config file for flags left at their defaults, i.e. neither given on the
+// command line nor via environment variables
+type configFileOptions struct {
+	drivers.DriverOptions
+	values   map[string]interface{}
+	defaults map[string]interface{}
+}
+
+func (o *configFileOptions) String(key string) string {
+	value := o.DriverOptions.String(key)
+	if config, ok := o.values[key].(string); ok && value == o.defaults[key] {
+		return config
+	}
+	return value
+}
+
+func (o *configFileOptions) StringSlice(key string) []string {
+	value := o.DriverOptions.StringSlice(key)
+	if config, ok := o.values[key].([]string); ok {
+		if defaults, _ := o.defaults[key].([]string); slices.Equal(value, defaults) {
+			return config
+		}
+	}
+	return value
+}
+
+func (o *configFileOptions) Int(key string) int {
+	value := o.DriverOptions.Int(key)
+	if config, ok := o.values[key].(int); ok && value == o.defaults[key] {
+		return config
+	}
+	return value
+}
+
+func (o *configFileOptions) Bool(key string) bool {
+	if config, ok := o.values[key].(bool); ok && config {
+		return true
+	}
+	return o.DriverOptions.Bool(key)
+}
+
+// withConfigFile reads a YAML or JSON document of flag values, keyed by flag name with or without the driver's prefix,
+// or by the camel-cased field name of the config schema
+func (d *Driver) withConfigFile(opts drivers.DriverOptions, path string) (drivers.DriverOptions, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err = yaml.Unmarshal(buf, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse config file %v: %w", path, err)
+	}
+
+	flags := make(map[string]mcnflag.Flag)
+	for _, flag := range d.GetCreateFlags() {
+		for _, name := range []string{flag.String(), strings.TrimPrefix(flag.String(), "hetzner-"), configFieldName(flag.String())} {
+			flags[name] = flag
+		}
+	}
+
+	res := &configFileOptions{DriverOptions: opts, values: make(map[string]interface{}), defaults: make(map[string]interface{})}
+	for key, value := range raw {
+		flag, ok := flags[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown option %v in config file %v", key, path)
+		}
+		if flag.String() == flagConfigFile {
+			return nil, fmt.Errorf("config file %v must not refer to another config file", path)
+		}
+
+		converted, err := convertConfigValue(flag, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid option %v in config file %v: %w", key, path, err)
+		}
+		res.values[flag.String()] = converted
+		res.defaults[flag.String()] = flag.Default()
+	}
+	return res, nil
+}
+
+// convertConfigValue converts YAML scalars to the flag's type; lists may be given as single value, and maps are
+// converted to key=value pairs, e.g. for labels
+func convertConfigValue(flag mcnflag.Flag, value interface{}) (interface{}, error) {
+	switch flag.(type) {
+	case mcnflag.StringFlag:
+		switch value := value.(type) {
+		case string:
+			return value, nil
+		case int, float64, bool:
+			return fmt.Sprint(value), nil
+		}
+	case mcnflag.IntFlag:
+		switch value := value.(type) {
+		case int:
+			return value, nil
+		case string:
+			return strconv.Atoi(value)
+		}
+	case mcnflag.BoolFlag:
+		if value, ok := value.(bool); ok {
+			return value, nil
+		}
+	case mcnflag.StringSliceFlag:
+		switch value := value.(type) {
+		case string:
+			return []string{value}, nil
+		case []interface{}:
+			list := make([]string, 0, len(value))
+			for _, item := range value {
+				list = append(list, fmt.Sprint(item))
+			}
+			return list, nil
+		case map[string]interface{}:
+			list := make([]string, 0, len(value))
+			for k, v := range value {
+				list = append(list, fmt.Sprintf("%v=%v", k, v))
+			}
+			sort.Strings(list)
+			return list, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected value %v", value)
+}
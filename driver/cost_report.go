@@ -0,0 +1,179 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// noGroup is reported for resources without the label grouped by, like those shared by machines
+const noGroup = "(none)"
+
+// CostItem is the current gross monthly price of a resource created by the driver
+type CostItem struct {
+	Kind    string
+	ID      int64
+	Name    string
+	Group   string
+	Monthly float64
+}
+
+// CostGroup sums up the resources sharing a value of the label grouped by
+type CostGroup struct {
+	Name    string
+	Count   int
+	Monthly float64
+}
+
+// CostReport lists the resources created by the driver, along with the currency of their prices
+type CostReport struct {
+	Currency string
+	Items    []CostItem
+}
+
+// Groups sums up the items by group, most expensive first
+func (r CostReport) Groups() []CostGroup {
+	byName := make(map[string]*CostGroup)
+	for _, item := range r.Items {
+		group, ok := byName[item.Group]
+		if !ok {
+			group = &CostGroup{Name: item.Group}
+			byName[item.Group] = group
+		}
+		group.Count++
+		group.Monthly += item.Monthly
+	}
+
+	groups := make([]CostGroup, 0, len(byName))
+	for _, group := range byName {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Monthly != groups[j].Monthly {
+			return groups[i].Monthly > groups[j].Monthly
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// Total sums up all items
+func (r CostReport) Total() float64 {
+	var sum float64
+	for _, item := range r.Items {
+		sum += item.Monthly
+	}
+	return sum
+}
+
+// CostReport prices the servers, primary IPs, volumes, load balancers and snapshots labeled as created by the driver,
+// grouped by the value of the given label, e.g. docker-machine/machine or hcloud/node-group. Unless allStores is set,
+// only resources of this store are included. Traffic beyond the included amount is not known in advance and left out.
+func (d *Driver) CostReport(groupBy string, allStores bool) (CostReport, error) {
+	ctx := d.getContext()
+	client := d.getClient()
+	opts := hcloud.ListOpts{LabelSelector: d.labelName(labelManagedBy) + "=" + managedByDriver}
+
+	pricing, _, err := client.Pricing.Get(ctx)
+	if err != nil {
+		return CostReport{}, fmt.Errorf("could not get pricing: %w", err)
+	}
+	report := CostReport{Currency: pricing.Volume.PerGBMonthly.Currency}
+
+	var priceErr error
+	add := func(kind string, id int64, name string, labels map[string]string, monthly string, factor float64) {
+		if !allStores && !d.ownStore(labels) {
+			return
+		}
+		price, err := parsePrice(monthly)
+		if err != nil && priceErr == nil {
+			priceErr = fmt.Errorf("could not parse price of %s %s[%d]: %w", kind, name, id, err)
+		}
+		group := labels[groupBy]
+		if group == "" {
+			group = noGroup
+		}
+		report.Items = append(report.Items, CostItem{Kind: kind, ID: id, Name: name, Group: group, Monthly: price * factor})
+	}
+
+	servers, err := client.Server.AllWithOpts(ctx, hcloud.ServerListOpts{ListOpts: opts})
+	if err != nil {
+		return CostReport{}, fmt.Errorf("could not list servers: %w", err)
+	}
+	backups, err := parsePrice(pricing.ServerBackup.Percentage)
+	if err != nil {
+		return CostReport{}, fmt.Errorf("could not parse backup price: %w", err)
+	}
+	for _, srv := range servers {
+		monthly := ""
+		if srv.ServerType != nil && srv.Datacenter != nil && srv.Datacenter.Location != nil {
+			for _, p := range srv.ServerType.Pricings {
+				if p.Location != nil && p.Location.Name == srv.Datacenter.Location.Name {
+					monthly = p.Monthly.Gross
+				}
+			}
+		}
+		factor := 1.0
+		if srv.BackupWindow != "" {
+			factor += backups / 100
+		}
+		add("server", srv.ID, srv.Name, srv.Labels, monthly, factor)
+	}
+
+	ips, err := client.PrimaryIP.AllWithOpts(ctx, hcloud.PrimaryIPListOpts{ListOpts: opts})
+	if err != nil {
+		return CostReport{}, fmt.Errorf("could not list primary IPs: %w", err)
+	}
+	for _, ip := range ips {
+		monthly := ""
+		for _, p := range pricing.PrimaryIPs {
+			if p.Type != string(ip.Type) || ip.Datacenter == nil || ip.Datacenter.Location == nil {
+				continue
+			}
+			for _, lp := range p.Pricings {
+				if lp.Location == ip.Datacenter.Location.Name {
+					monthly = lp.Monthly.Gross
+				}
+			}
+		}
+		add("primary IP", ip.ID, ip.Name, ip.Labels, monthly, 1)
+	}
+
+	volumes, err := client.Volume.AllWithOpts(ctx, hcloud.VolumeListOpts{ListOpts: opts})
+	if err != nil {
+		return CostReport{}, fmt.Errorf("could not list volumes: %w", err)
+	}
+	for _, volume := range volumes {
+		add("volume", volume.ID, volume.Name, volume.Labels, pricing.Volume.PerGBMonthly.Gross, float64(volume.Size))
+	}
+
+	balancers, err := client.LoadBalancer.AllWithOpts(ctx, hcloud.LoadBalancerListOpts{ListOpts: opts})
+	if err != nil {
+		return CostReport{}, fmt.Errorf("could not list load balancers: %w", err)
+	}
+	for _, lb := range balancers {
+		monthly := ""
+		if lb.LoadBalancerType != nil && lb.Location != nil {
+			for _, p := range lb.LoadBalancerType.Pricings {
+				if p.Location != nil && p.Location.Name == lb.Location.Name {
+					monthly = p.Monthly.Gross
+				}
+			}
+		}
+		add("load balancer", lb.ID, lb.Name, lb.Labels, monthly, 1)
+	}
+
+	images, err := client.Image.AllWithOpts(ctx, hcloud.ImageListOpts{
+		ListOpts: opts,
+		Type:     []hcloud.ImageType{hcloud.ImageTypeSnapshot},
+	})
+	if err != nil {
+		return CostReport{}, fmt.Errorf("could not list snapshots: %w", err)
+	}
+	for _, image := range images {
+		add("snapshot", image.ID, image.Description, image.Labels, pricing.Image.PerGBMonth.Gross, float64(image.ImageSize))
+	}
+
+	return report, priceErr
+}
@@ -0,0 +1,60 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// acquireCreateSlot blocks until one of the project's creation slots is free, so no more than the configured number
+// of creations run against the same project at once, across all driver processes on this host. Slots are file locks,
+// which the OS releases even if the process dies.
+func (d *Driver) acquireCreateSlot() (func(), error) {
+	noop := func() {}
+	if d.maxParallelCreates <= 0 {
+		return noop, nil
+	}
+
+	dir := filepath.Join(os.TempDir(), "docker-machine-driver-hetzner")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return noop, fmt.Errorf("could not create lock directory: %w", err)
+	}
+
+	// keyed by project, without revealing the token
+	sum := sha256.Sum256([]byte(d.getAccessToken()))
+	project := hex.EncodeToString(sum[:8])
+
+	waiting := false
+	for {
+		for i := 0; i < d.maxParallelCreates; i++ {
+			file, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%s-%d.lock", project, i)), os.O_CREATE|os.O_RDWR, 0600)
+			if err != nil {
+				return noop, fmt.Errorf("could not open lock file: %w", err)
+			}
+
+			if locked, err := tryLockFile(file); err != nil {
+				file.Close()
+				return noop, fmt.Errorf("could not lock %v: %w", file.Name(), err)
+			} else if locked {
+				log.Debugf(" -> acquired creation slot %d of %d", i+1, d.maxParallelCreates)
+				return func() {
+					unlockFile(file)
+					file.Close()
+				}, nil
+			}
+			file.Close()
+		}
+
+		if !waiting {
+			log.Infof("Waiting for one of %d parallel creations in the project to finish...", d.maxParallelCreates)
+			waiting = true
+		}
+		if err := d.sleep(); err != nil {
+			return noop, fmt.Errorf("stopped waiting for a creation slot: %w", err)
+		}
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/machine/libmachine/drivers"
@@ -20,9 +23,20 @@ type Driver struct {
 	*drivers.BaseDriver
 
 	AccessToken       string
+	APITokenFile      string
+	APITokenCmd       string
+	cachedToken       string
+	tokenErr          error
+	APIEndpoint       string
+	APICAFile         string
+	APIProxy          string
+	cachedTransport   http.RoundTripper
+	DebugHTTP         bool
+	APIApplication    string
 	Image             string
 	ImageID           int64
 	ImageArch         hcloud.Architecture
+	FromGolden        string
 	cachedImage       *hcloud.Image
 	Type              string
 	cachedType        *hcloud.ServerType
@@ -32,13 +46,57 @@ type Driver struct {
 	cachedKey         *hcloud.SSHKey
 	IsExistingKey     bool
 	originalKey       string
+	existingKeyFP     string
+	sshKeyType        string
+	UseSSHAgent       bool
+	hostKeyPinning    bool
+	SSHRetries        int
+	DockerPort        int
+	HostKey           string
+	existingServer    string
+	deleteAdopted     bool
+	KeepServer        bool
+	onNameConflict    string
+	newServerName     string
+	dryRun            bool
+	fastCreate        bool
+	maxHourlyPrice    float64
+	autoCheapest      bool
 	dangling          []func()
+	serverDangling    []int
+	cachedStoreID     string
 	ServerID          int64
+	ServerName        string
+	Datacenter        string
+	ServerImage       *ResourceRef
+	PrimaryIPv4ID     int64
+	PrimaryIPv6ID     int64
+	AttachedNetworks  []NetworkAttachment
+	AppliedFirewalls  []ResourceRef
 	cachedServer      *hcloud.Server
 	userData          string
 	userDataFile      string
+	userDataDir       string
+	userDataTemplate  bool
+	userDataDedupe    bool
+	userDataChecksum  string
+	cachedUserData    *string
+	writeFiles        []writeFile
+	packages          []string
+	machineMetadata   bool
+	preset            string
+	presetRegistries  []byte
+	ignition          bool
+	savedInitLogs     bool
+	combustion        bool
+	writableRoot      bool
 	Volumes           []string
+	AttachedVolumes   []AttachedVolume
+	DataVolumeID      int64
+	DeleteVolumes     bool
+	attachVolumes     []volumeRequest
 	Networks          []string
+	cachedNetworks    []*hcloud.Network
 	UsePrivateNetwork bool
 	DisablePublic4    bool
 	DisablePublic6    bool
@@ -48,17 +106,61 @@ type Driver struct {
 	cachedPrimaryIPv6 *hcloud.PrimaryIP
 	Firewalls         []string
 	ServerLabels      map[string]string
+	NodeGroup         string
+	NodeLabels        map[string]string
+	NodeTaints        []string
 	keyLabels         map[string]string
+	keySelector       string
 	placementGroup    string
 	cachedPGrp        *hcloud.PlacementGroup
 
+	volumeSelector       string
+	cachedAttachVolumes  []*hcloud.Volume
+	dockerDataVolumeSize int
+	cachedDataVolume     *hcloud.Volume
+
+	LoadBalancer       string
+	LoadBalancerIDs    []int64
+	cachedLoadBalancer *hcloud.LoadBalancer
+	lbDefinition       *lbDefinition
+	lbUsePrivateIP     bool
+	lbSelector         string
+
 	AdditionalKeys       []string
+	additionalKeyRefs    []string
+	authorizedKeys       []string
 	AdditionalKeyIDs     []int64
+	SharedKeyIDs         []int64
 	cachedAdditionalKeys []*hcloud.SSHKey
 
 	WaitOnError           int
 	WaitOnPolling         int
+	WaitInterval          time.Duration
 	WaitForRunningTimeout int
+	SSHWaitTimeout        int
+	SSHWaitInterval       time.Duration
+	SSHWaitAttempts       int
+	ShutdownTimeout       int
+	CreateTimeout         int
+	phases                []phaseTiming
+	provisionStart        time.Time
+	operation             context.Context
+	endOperationFunc      context.CancelFunc
+	ActionTimeout         int
+	KeepOnFailure         bool
+	APIMaxAttempts        int
+	maxParallelCreates    int
+	waitCloudInit         bool
+	waitCloudInitTimeout  int
+	waitMetadata          bool
+	waitMetadataTimeout   int
+	rateLimit             rateLimitState
+	keepOnError           time.Duration
+	RestartReset          bool
+	ResizeKeepDisk        bool
+	SnapshotOnRemove      bool
+	RemoveDetachOnly      bool
+	GoldenSnapshot        string
 
 	// internal housekeeping
 	version string
@@ -70,16 +172,42 @@ const (
 	defaultType  = "cx11"
 
 	flagAPIToken           = "hetzner-api-token"
+	flagConfigFile         = "hetzner-config-file"
+	flagAPITokenFile       = "hetzner-api-token-file"
+	flagAPITokenCmd        = "hetzner-api-token-cmd"
 	flagImage              = "hetzner-image"
 	flagImageID            = "hetzner-image-id"
 	flagImageArch          = "hetzner-image-arch"
 	flagType               = "hetzner-server-type"
 	flagLocation           = "hetzner-server-location"
+	flagDefaultLocation    = "hetzner-default-location"
+	flagAutoCheapest       = "hetzner-location-auto-cheapest"
 	flagExKeyID            = "hetzner-existing-key-id"
 	flagExKeyPath          = "hetzner-existing-key-path"
+	flagExKeyFP            = "hetzner-existing-key-fingerprint"
+	flagExistingServer     = "hetzner-existing-server"
+	flagDeleteAdopted      = "hetzner-existing-server-delete-on-remove"
+	flagOnNameConflict     = "hetzner-on-name-conflict"
+	flagDryRun             = "hetzner-dry-run"
+	flagFastCreate         = "hetzner-fast-create"
+	flagMaxHourlyPrice     = "hetzner-max-hourly-price"
 	flagUserData           = "hetzner-user-data"
 	flagAdditionalUserData = "hetzner-additional-user-data"
 	flagUserDataFile       = "hetzner-user-data-file"
+	flagUserDataDir        = "hetzner-user-data-dir"
+	flagUserDataTemplate   = "hetzner-user-data-template"
+	flagUserDataDedupe     = "hetzner-user-data-dedupe"
+	flagUserDataChecksum   = "hetzner-user-data-checksum"
+	flagWriteFile          = "hetzner-write-file"
+	flagPackages           = "hetzner-packages"
+	flagMachineMetadata    = "hetzner-machine-metadata"
+	flagPreset             = "hetzner-preset"
+	flagPresetRegistries   = "hetzner-preset-registries"
+	flagIgnition           = "hetzner-ignition"
+	flagIgnitionFile       = "hetzner-ignition-file"
+	flagCombustion         = "hetzner-combustion"
+	flagCombustionFile     = "hetzner-combustion-file"
+	flagWritableRoot       = "hetzner-combustion-writable-root"
 	flagVolumes            = "hetzner-volumes"
 	flagNetworks           = "hetzner-networks"
 	flagUsePrivateNetwork  = "hetzner-use-private-network"
@@ -90,23 +218,79 @@ const (
 	flagDisablePublic      = "hetzner-disable-public"
 	flagFirewalls          = "hetzner-firewalls"
 	flagAdditionalKeys     = "hetzner-additional-key"
+	flagAuthorizedKeys     = "hetzner-ssh-authorized-key"
 	flagServerLabel        = "hetzner-server-label"
+	flagServerLabelsFile   = "hetzner-server-labels-file"
 	flagKeyLabel           = "hetzner-key-label"
+	flagKeySelector        = "hetzner-key-selector"
+	flagNodeGroup          = "hetzner-node-group"
+	flagNodeLabel          = "hetzner-node-label"
+	flagNodeTaint          = "hetzner-node-taint"
 	flagPlacementGroup     = "hetzner-placement-group"
 	flagAutoSpread         = "hetzner-auto-spread"
 
-	flagSshUser = "hetzner-ssh-user"
-	flagSshPort = "hetzner-ssh-port"
-
-	defaultSSHPort = 22
-	defaultSSHUser = "root"
+	flagAttachVolume         = "hetzner-attach-volume"
+	flagDeleteVolumes        = "hetzner-volume-delete-on-remove"
+	flagVolumeSelector       = "hetzner-volume-selector"
+	flagDockerDataVolumeSize = "hetzner-docker-data-volume-size"
+	flagDockerDaemonJSON     = "hetzner-docker-daemon-json"
+
+	flagLBTarget         = "hetzner-lb-target"
+	flagLBCreateFromFile = "hetzner-lb-create-from-file"
+	flagLBUsePrivateIP   = "hetzner-lb-use-private-ip"
+	flagLBSelector       = "hetzner-lb-selector"
+
+	flagSshUser    = "hetzner-ssh-user"
+	flagSshPort    = "hetzner-ssh-port"
+	flagSshKeyType = "hetzner-ssh-key-type"
+	flagSSHAgent   = "hetzner-ssh-agent"
+	flagPinHostKey = "hetzner-pin-host-key"
+	flagSSHRetries = "hetzner-ssh-retries"
+	flagDockerPort = "hetzner-docker-port"
+
+	defaultSSHPort    = 22
+	defaultDockerPort = 2376
+	defaultSSHUser    = "root"
+	defaultSSHKeyType = sshKeyTypeRSA
+	defaultSSHRetries = 3
 
 	flagWaitOnError              = "hetzner-wait-on-error"
 	defaultWaitOnError           = 0
 	flagWaitOnPolling            = "hetzner-wait-on-polling"
 	defaultWaitOnPolling         = 1
+	flagWaitInterval             = "hetzner-wait-interval"
 	flagWaitForRunningTimeout    = "hetzner-wait-for-running-timeout"
 	defaultWaitForRunningTimeout = 0
+	flagWaitCloudInit            = "hetzner-wait-cloud-init"
+	flagWaitCloudInitTimeout     = "hetzner-wait-cloud-init-timeout"
+	defaultWaitCloudInitTimeout  = 600
+	flagWaitMetadata             = "hetzner-wait-metadata"
+	flagWaitMetadataTimeout      = "hetzner-wait-metadata-timeout"
+	flagSSHWaitTimeout           = "hetzner-ssh-wait-timeout"
+	flagSSHWaitInterval          = "hetzner-ssh-wait-interval"
+	flagSSHWaitAttempts          = "hetzner-ssh-wait-attempts"
+	flagShutdownTimeout          = "hetzner-shutdown-timeout"
+	flagCreateTimeout            = "hetzner-create-timeout"
+	flagActionTimeout            = "hetzner-action-timeout"
+	flagKeepOnFailure            = "hetzner-keep-on-failure"
+	flagKeepOnError              = "hetzner-keep-on-error"
+	flagAPIMaxAttempts           = "hetzner-api-max-attempts"
+	defaultAPIMaxAttempts        = 5
+	flagMaxParallelCreates       = "hetzner-max-parallel-creates"
+	flagAPIEndpoint              = "hetzner-api-endpoint"
+	flagDefaultNetworks          = "hetzner-default-networks"
+	flagAPICAFile                = "hetzner-api-ca-file"
+	flagAPIProxy                 = "hetzner-api-proxy"
+	flagDebugHTTP                = "hetzner-debug-http"
+	flagAPIApplication           = "hetzner-api-application"
+	defaultAPIApplication        = "docker-machine-driver"
+	defaultShutdownTimeout       = 60
+	flagRestartReset             = "hetzner-restart-reset"
+	flagResizeKeepDisk           = "hetzner-resize-keep-disk"
+	flagSnapshotOnRemove         = "hetzner-snapshot-on-remove"
+	flagRemoveDetachOnly         = "hetzner-remove-detach-only"
+	flagGoldenSnapshot           = "hetzner-create-golden-snapshot"
+	flagFromGolden               = "hetzner-from-golden"
 
 	legacyFlagUserDataFromFile = "hetzner-user-data-from-file"
 	legacyFlagDisablePublic4   = "hetzner-disable-public-4"
@@ -136,12 +320,59 @@ func (d *Driver) DriverName() string {
 // GetCreateFlags retrieves additional driver-specific arguments; see [drivers.Driver.GetCreateFlags]
 func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_CONFIG_FILE",
+			Name:   flagConfigFile,
+			Usage:  "YAML or JSON file providing defaults for all other flags, which take precedence if given",
+			Value:  "",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "HETZNER_API_TOKEN",
 			Name:   flagAPIToken,
 			Usage:  "Project-specific Hetzner API token",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_API_TOKEN_FILE",
+			Name:   flagAPITokenFile,
+			Usage:  "File to read the Hetzner API token from, e.g. a mounted secret",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_API_TOKEN_CMD",
+			Name:   flagAPITokenCmd,
+			Usage:  "Command printing the Hetzner API token, e.g. a credential helper; run whenever the driver starts",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_API_ENDPOINT",
+			Name:   flagAPIEndpoint,
+			Usage:  "Alternative Hetzner Cloud API endpoint, e.g. for an hcloud-compatible proxy or mock",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_API_CA_FILE",
+			Name:   flagAPICAFile,
+			Usage:  "PEM file with additional CA certificates to trust for the API endpoint",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_API_PROXY",
+			Name:   flagAPIProxy,
+			Usage:  "HTTP(S) or SOCKS5 proxy URL for all API requests, taking precedence over the standard proxy variables",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_DEBUG_HTTP",
+			Name:   flagDebugHTTP,
+			Usage:  "Log a summary of every API request, without the token or other secrets",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_API_APPLICATION",
+			Name:   flagAPIApplication,
+			Usage:  "Application name and optional version (name/version) reported to the API in the user agent",
+			Value:  "",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "HETZNER_IMAGE",
 			Name:   flagImage,
@@ -170,6 +401,17 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Location to create machine at",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_DEFAULT_LOCATION",
+			Name:   flagDefaultLocation,
+			Usage:  "Location to use if --hetzner-server-location is not given, e.g. per Rancher cloud credential",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_LOCATION_AUTO_CHEAPEST",
+			Name:   flagAutoCheapest,
+			Usage:  "Create the server in the location where it is available and cheapest, within the constraints of volumes, primary IPs and networks",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "HETZNER_EXISTING_KEY_ID",
 			Name:   flagExKeyID,
@@ -182,6 +424,45 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Path to existing key (new public key will be created unless --hetzner-existing-key-id is specified)",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_EXISTING_KEY_FINGERPRINT",
+			Name:   flagExKeyFP,
+			Usage:  "MD5 or SHA256 fingerprint of an existing key to use for server; requires --hetzner-existing-key-path",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_EXISTING_SERVER",
+			Name:   flagExistingServer,
+			Usage:  "ID or name of an existing server to adopt instead of creating one; requires --hetzner-existing-key-path",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_EXISTING_SERVER_DELETE_ON_REMOVE",
+			Name:   flagDeleteAdopted,
+			Usage:  "Delete an adopted server when removing the machine, instead of leaving it running",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_ON_NAME_CONFLICT",
+			Name:   flagOnNameConflict,
+			Usage:  "What to do if a server with the machine's name exists: fail, adopt it (requires --hetzner-existing-key-path) or suffix the name",
+			Value:  nameConflictFail,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_DRY_RUN",
+			Name:   flagDryRun,
+			Usage:  "Only print the resources that would be created, without creating anything",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_FAST_CREATE",
+			Name:   flagFastCreate,
+			Usage:  "Tune creation for time-to-ready, e.g. for autoscaled CI machines: poll faster and skip optional checks",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_MAX_HOURLY_PRICE",
+			Name:   flagMaxHourlyPrice,
+			Usage:  "Abort the creation if the estimated gross price of the server, primary IPs and volumes per hour exceeds this",
+			Value:  "",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "HETZNER_USER_DATA",
 			Name:   flagUserData,
@@ -194,6 +475,11 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Additional Cloud-init based user data (inline).",
 			Value:  "",
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_USER_DATA_DEDUPE",
+			Name:   flagUserDataDedupe,
+			Usage:  "Drop duplicate list entries, e.g. packages or runcmd, when merging user data documents",
+		},
 		mcnflag.BoolFlag{
 			EnvVar: "HETZNER_USER_DATA_FROM_FILE",
 			Name:   legacyFlagUserDataFromFile,
@@ -202,21 +488,154 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			EnvVar: "HETZNER_USER_DATA_FILE",
 			Name:   flagUserDataFile,
-			Usage:  "Cloud-init based user data (read from file)",
+			Usage:  "Cloud-init based user data (read from file or http(s) URL)",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_USER_DATA_DIR",
+			Name:   flagUserDataDir,
+			Usage:  "Directory of cloud-config snippets merged in lexical order, before the other user data",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_USER_DATA_CHECKSUM",
+			Name:   flagUserDataChecksum,
+			Usage:  "Checksum to verify user data fetched from a URL against, as [sha256:|sha512:]<hex>",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_USER_DATA_TEMPLATE",
+			Name:   flagUserDataTemplate,
+			Usage:  "Render user data as Go template before passing it to the server",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HETZNER_WRITE_FILES",
+			Name:   flagWriteFile,
+			Usage:  "Local file to write to the server via cloud-init, given as source=<local>,path=<remote>[,mode=<octal>]",
+			Value:  []string{},
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HETZNER_PACKAGES",
+			Name:   flagPackages,
+			Usage:  "Comma-separated packages to install via cloud-init",
+			Value:  []string{},
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_MACHINE_METADATA",
+			Name:   flagMachineMetadata,
+			Usage:  "Write the machine's name, server ID, location, private IPs and labels to " + machineMetadataDir + " on boot",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_PRESET",
+			Name:   flagPreset,
+			Usage:  "Prepare the host for a Kubernetes distribution via cloud-init, one of " + strings.Join(presetNames(), ", "),
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_PRESET_REGISTRIES",
+			Name:   flagPresetRegistries,
+			Usage:  "Local registries.yaml to write to where the distribution of --hetzner-preset reads it from",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_IGNITION",
+			Name:   flagIgnition,
+			Usage:  "Ignition config for Flatcar or Fedora CoreOS images (inline JSON), instead of cloud-init user data",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_IGNITION_FILE",
+			Name:   flagIgnitionFile,
+			Usage:  "Ignition config for Flatcar or Fedora CoreOS images (read from file), instead of cloud-init user data",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_COMBUSTION",
+			Name:   flagCombustion,
+			Usage:  "Combustion script for openSUSE MicroOS images (inline), instead of cloud-init user data",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_COMBUSTION_FILE",
+			Name:   flagCombustionFile,
+			Usage:  "Combustion script for openSUSE MicroOS images (read from file), instead of cloud-init user data",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_COMBUSTION_WRITABLE_ROOT",
+			Name:   flagWritableRoot,
+			Usage:  "Make the running snapshot of a Combustion server writable for docker-machine's provisioning",
+		},
 		mcnflag.StringSliceFlag{
 			EnvVar: "HETZNER_VOLUMES",
 			Name:   flagVolumes,
 			Usage:  "Volume IDs or names which should be attached to the server",
 			Value:  []string{},
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HETZNER_ATTACH_VOLUME",
+			Name:   flagAttachVolume,
+			Usage:  "Existing volume ID or name to attach to the server, optionally suffixed by :<mount-point>; will be detached on removal",
+			Value:  []string{},
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_VOLUME_SELECTOR",
+			Name:   flagVolumeSelector,
+			Usage:  "Label selector to claim the first unattached matching volume in the server's location; will be detached on removal",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_VOLUME_DELETE_ON_REMOVE",
+			Name:   flagDeleteVolumes,
+			Usage:  "Delete volumes created by the driver when removing the machine",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_DOCKER_DATA_VOLUME_SIZE",
+			Name:   flagDockerDataVolumeSize,
+			Usage:  "Size (in GB) of a volume to create and mount as /var/lib/docker; requires cloud-config user data, if any",
+			Value:  0,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_DOCKER_DAEMON_JSON",
+			Name:   flagDockerDaemonJSON,
+			Usage:  "Docker daemon.json to write via cloud-init before Docker is installed (inline JSON or file path)",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_LB_TARGET",
+			Name:   flagLBTarget,
+			Usage:  "Load balancer ID or name to register the server at as target; will be deregistered on removal",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_LB_CREATE_FROM_FILE",
+			Name:   flagLBCreateFromFile,
+			Usage:  "YAML definition to create the load balancer from, if it does not exist",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_LB_SELECTOR",
+			Name:   flagLBSelector,
+			Usage:  "Label selector of load balancers to register the server at as target; will be deregistered on removal",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_LB_USE_PRIVATE_IP",
+			Name:   flagLBUsePrivateIP,
+			Usage:  "Register the server at the load balancer by its private IP; requires a shared network",
+		},
 		mcnflag.StringSliceFlag{
 			EnvVar: "HETZNER_NETWORKS",
 			Name:   flagNetworks,
 			Usage:  "Network IDs or names which should be attached to the server private network interface",
 			Value:  []string{},
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HETZNER_DEFAULT_NETWORKS",
+			Name:   flagDefaultNetworks,
+			Usage:  "Networks to attach if --hetzner-networks is not given, e.g. per Rancher cloud credential",
+			Value:  []string{},
+		},
 		mcnflag.BoolFlag{
 			EnvVar: "HETZNER_USE_PRIVATE_NETWORK",
 			Name:   flagUsePrivateNetwork,
@@ -268,7 +687,13 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringSliceFlag{
 			EnvVar: "HETZNER_ADDITIONAL_KEYS",
 			Name:   flagAdditionalKeys,
-			Usage:  "Additional public keys to be attached to the server",
+			Usage:  "Additional public keys, or names, IDs or fingerprints of existing SSH keys, to be attached to the server",
+			Value:  []string{},
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HETZNER_SSH_AUTHORIZED_KEYS",
+			Name:   flagAuthorizedKeys,
+			Usage:  "Public keys to authorize for the SSH user via user data, without uploading them to the project",
 			Value:  []string{},
 		},
 		mcnflag.StringSliceFlag{
@@ -277,12 +702,42 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Key value pairs of additional labels to assign to the server",
 			Value:  []string{},
 		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_SERVER_LABELS_FILE",
+			Name:   flagServerLabelsFile,
+			Usage:  "YAML or JSON map of additional labels to assign to the server, overridden by --hetzner-server-label",
+			Value:  "",
+		},
 		mcnflag.StringSliceFlag{
 			EnvVar: "HETZNER_KEY_LABELS",
 			Name:   flagKeyLabel,
 			Usage:  "Key value pairs of additional labels to assign to the SSH key",
 			Value:  []string{},
 		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_NODE_GROUP",
+			Name:   flagNodeGroup,
+			Usage:  "Node group of cluster-autoscaler's Hetzner provider the server belongs to",
+			Value:  "",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HETZNER_NODE_LABELS",
+			Name:   flagNodeLabel,
+			Usage:  "Kubernetes node labels of the node group in key=value format, also assigned to the server",
+			Value:  []string{},
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HETZNER_NODE_TAINTS",
+			Name:   flagNodeTaint,
+			Usage:  "Kubernetes node taints of the node group in key[=value]:effect format",
+			Value:  []string{},
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_KEY_SELECTOR",
+			Name:   flagKeySelector,
+			Usage:  "Label selector of existing SSH keys to attach to the server",
+			Value:  "",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "HETZNER_PLACEMENT_GROUP",
 			Name:   flagPlacementGroup,
@@ -297,8 +752,8 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			EnvVar: "HETZNER_SSH_USER",
 			Name:   flagSshUser,
-			Usage:  "SSH username",
-			Value:  defaultSSHUser,
+			Usage:  "SSH username (default: the user of the image's family, mostly root)",
+			Value:  "",
 		},
 		mcnflag.IntFlag{
 			EnvVar: "HETZNER_SSH_PORT",
@@ -306,6 +761,34 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "SSH port",
 			Value:  defaultSSHPort,
 		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_DOCKER_PORT",
+			Name:   flagDockerPort,
+			Usage:  "Port the Docker daemon listens on, instead of 2376",
+			Value:  defaultDockerPort,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_SSH_KEY_TYPE",
+			Name:   flagSshKeyType,
+			Usage:  "Type of the SSH key generated for the machine: rsa or ed25519",
+			Value:  defaultSSHKeyType,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_SSH_AGENT",
+			Name:   flagSSHAgent,
+			Usage:  "Authenticate via the local ssh-agent, uploading its first key, instead of storing a key pair for the machine",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_PIN_HOST_KEY",
+			Name:   flagPinHostKey,
+			Usage:  "Pin the SSH host key presented on the first connection, and verify it on later connections of the driver",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_SSH_RETRIES",
+			Name:   flagSSHRetries,
+			Usage:  "Number of retries for SSH commands of the driver failing for the connection, e.g. as sshd restarts",
+			Value:  defaultSSHRetries,
+		},
 		mcnflag.IntFlag{
 			EnvVar: "HETZNER_WAIT_ON_ERROR",
 			Name:   flagWaitOnError,
@@ -318,12 +801,131 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Period for waiting between requests when waiting for some state to change",
 			Value:  defaultWaitOnPolling,
 		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_WAIT_INTERVAL",
+			Name:   flagWaitInterval,
+			Usage:  "Interval for polling actions and server state as a duration, e.g. 500ms; overrides --hetzner-wait-on-polling",
+			Value:  "",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "HETZNER_WAIT_FOR_RUNNING_TIMEOUT",
 			Name:   flagWaitForRunningTimeout,
 			Usage:  "Period for waiting for a machine to be running before failing",
 			Value:  defaultWaitForRunningTimeout,
 		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_SSH_WAIT_TIMEOUT",
+			Name:   flagSSHWaitTimeout,
+			Usage:  "Max amount of seconds to wait for the server to accept SSH logins",
+			Value:  defaultSSHWaitTimeout,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_SSH_WAIT_INTERVAL",
+			Name:   flagSSHWaitInterval,
+			Usage:  "Interval between SSH readiness probes as a duration, e.g. 500ms",
+			Value:  "",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_SSH_WAIT_ATTEMPTS",
+			Name:   flagSSHWaitAttempts,
+			Usage:  "Max number of SSH readiness probes, 0 for no limit besides the timeout",
+			Value:  0,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_WAIT_CLOUD_INIT",
+			Name:   flagWaitCloudInit,
+			Usage:  "Wait for cloud-init to finish before Docker is provisioned",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_WAIT_CLOUD_INIT_TIMEOUT",
+			Name:   flagWaitCloudInitTimeout,
+			Usage:  "Period for waiting for cloud-init to finish before failing",
+			Value:  defaultWaitCloudInitTimeout,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_WAIT_METADATA",
+			Name:   flagWaitMetadata,
+			Usage:  "Wait for the metadata endpoint to report the server initialized before SSH provisioning",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_WAIT_METADATA_TIMEOUT",
+			Name:   flagWaitMetadataTimeout,
+			Usage:  "Period for waiting for the metadata endpoint to report the server initialized before failing",
+			Value:  defaultWaitMetadataTimeout,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_CREATE_TIMEOUT",
+			Name:   flagCreateTimeout,
+			Usage:  "Period for the whole server creation before failing (0 for no limit)",
+			Value:  0,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_ACTION_TIMEOUT",
+			Name:   flagActionTimeout,
+			Usage:  "Period for waiting for a single API action before failing (0 for no limit)",
+			Value:  0,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_KEEP_ON_FAILURE",
+			Name:   flagKeepOnFailure,
+			Usage:  "Keep partially created resources if the creation fails, instead of rolling them back",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_KEEP_ON_ERROR",
+			Name:   flagKeepOnError,
+			Usage:  "Duration to keep the server of a failed creation for debugging, labeled as failed, before gc deletes it",
+			Value:  "",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_API_MAX_ATTEMPTS",
+			Name:   flagAPIMaxAttempts,
+			Usage:  "Maximum number of attempts for API requests failing due to rate limits or server errors",
+			Value:  defaultAPIMaxAttempts,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_MAX_PARALLEL_CREATES",
+			Name:   flagMaxParallelCreates,
+			Usage:  "Maximum number of machines created in parallel in the same project from this host; 0 for no limit",
+			Value:  0,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HETZNER_SHUTDOWN_TIMEOUT",
+			Name:   flagShutdownTimeout,
+			Usage:  "Period for waiting for a graceful shutdown on stop before powering off",
+			Value:  defaultShutdownTimeout,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_RESTART_RESET",
+			Name:   flagRestartReset,
+			Usage:  "Use a hard reset instead of an ACPI reboot on restart",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_RESIZE_KEEP_DISK",
+			Name:   flagResizeKeepDisk,
+			Usage:  "Keep the disk size when changing the server type, so the machine can be downscaled again later",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_SNAPSHOT_ON_REMOVE",
+			Name:   flagSnapshotOnRemove,
+			Usage:  "Create a snapshot of the server before removing it",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HETZNER_REMOVE_DETACH_ONLY",
+			Name:   flagRemoveDetachOnly,
+			Usage:  "Only remove SSH keys and driver labels on remove, leaving the server running",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_CREATE_GOLDEN_SNAPSHOT",
+			Name:   flagGoldenSnapshot,
+			Usage:  "Golden image name for snapshots taken by the golden-snapshot command once the machine is set up",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HETZNER_FROM_GOLDEN",
+			Name:   flagFromGolden,
+			Usage:  "Create the server from the newest golden snapshot of the given name, or matching the given label selector",
+			Value:  "",
+		},
 	}
 }
 
@@ -350,7 +952,37 @@ func (d *Driver) SetConfigFromFlags(opts drivers.DriverOptions) error {
 func (d *Driver) setConfigFromFlagsImpl(opts drivers.DriverOptions) error {
 	var err error
 
+	if path := opts.String(flagConfigFile); path != "" {
+		if opts, err = d.withConfigFile(opts, path); err != nil {
+			return d.flagFailure("could not use --%v: %v", flagConfigFile, err)
+		}
+	}
+
 	d.AccessToken = opts.String(flagAPIToken)
+	d.APITokenFile = opts.String(flagAPITokenFile)
+	d.APITokenCmd = opts.String(flagAPITokenCmd)
+	d.APIEndpoint = opts.String(flagAPIEndpoint)
+	d.APICAFile = opts.String(flagAPICAFile)
+	d.APIProxy = opts.String(flagAPIProxy)
+	if d.APIProxy != "" {
+		if _, err := parseProxyURL(d.APIProxy); err != nil {
+			return d.flagFailure("could not use --%v: %v", flagAPIProxy, err)
+		}
+	}
+	d.DebugHTTP = opts.Bool(flagDebugHTTP)
+	d.APIApplication = opts.String(flagAPIApplication)
+	if name, _, _ := strings.Cut(d.APIApplication, "/"); d.APIApplication != "" && name == "" {
+		return d.flagFailure("--%v requires an application name, got %v", flagAPIApplication, d.APIApplication)
+	}
+	if d.APICAFile != "" {
+		if _, err := loadCAPool(d.APICAFile); err != nil {
+			return d.flagFailure("could not use --%v: %v", flagAPICAFile, err)
+		}
+		// later operations may run from another working directory
+		if d.APICAFile, err = filepath.Abs(d.APICAFile); err != nil {
+			return err
+		}
+	}
 	d.Image = opts.String(flagImage)
 	d.ImageID, err = flagI64(opts, flagImageID)
 	if err != nil {
@@ -361,6 +993,13 @@ func (d *Driver) setConfigFromFlagsImpl(opts drivers.DriverOptions) error {
 		return err
 	}
 	d.Location = opts.String(flagLocation)
+	d.autoCheapest = opts.Bool(flagAutoCheapest)
+	if d.autoCheapest && d.Location != "" {
+		return d.flagFailure("--%v and --%v are mutually exclusive", flagAutoCheapest, flagLocation)
+	}
+	if d.Location == "" && !d.autoCheapest {
+		d.Location = opts.String(flagDefaultLocation)
+	}
 	d.Type = opts.String(flagType)
 	d.KeyID, err = flagI64(opts, flagExKeyID)
 	if err != nil {
@@ -368,12 +1007,61 @@ func (d *Driver) setConfigFromFlagsImpl(opts drivers.DriverOptions) error {
 	}
 	d.IsExistingKey = d.KeyID != 0
 	d.originalKey = opts.String(flagExKeyPath)
+	if err = d.setExistingKeyFingerprintFromFlags(opts.String(flagExKeyFP)); err != nil {
+		return err
+	}
+	d.existingServer = opts.String(flagExistingServer)
+	d.deleteAdopted = opts.Bool(flagDeleteAdopted)
+	if d.existingServer != "" && d.originalKey == "" {
+		return d.flagFailure("--%v requires --%v, as no key can be added to an existing server", flagExistingServer, flagExKeyPath)
+	}
+	if err = d.setNameConflictFlags(opts); err != nil {
+		return err
+	}
+	d.dryRun = opts.Bool(flagDryRun)
+	d.fastCreate = opts.Bool(flagFastCreate)
+	d.maxHourlyPrice = 0
+	if raw := opts.String(flagMaxHourlyPrice); raw != "" {
+		if d.maxHourlyPrice, err = strconv.ParseFloat(raw, 64); err != nil || d.maxHourlyPrice <= 0 {
+			return d.flagFailure("--%v must be a positive price, got %v", flagMaxHourlyPrice, raw)
+		}
+	}
+	if d.dryRun && d.existingServer != "" {
+		return d.flagFailure("--%v and --%v are mutually exclusive", flagDryRun, flagExistingServer)
+	}
 	err = d.setUserDataFlags(opts)
 	if err != nil {
 		return err
 	}
+	d.userDataTemplate = opts.Bool(flagUserDataTemplate)
+	if err = d.setWriteFilesFromFlags(opts.StringSlice(flagWriteFile)); err != nil {
+		return err
+	}
+	d.packages = splitPackages(opts.StringSlice(flagPackages))
+	d.machineMetadata = opts.Bool(flagMachineMetadata)
+	if err = d.setPresetFlags(opts); err != nil {
+		return err
+	}
+	if err = d.setDockerDaemonJSONFromFlags(opts.String(flagDockerDaemonJSON)); err != nil {
+		return err
+	}
 	d.Volumes = opts.StringSlice(flagVolumes)
+	if err = d.setAttachVolumesFromFlags(opts.StringSlice(flagAttachVolume)); err != nil {
+		return err
+	}
+	d.volumeSelector = opts.String(flagVolumeSelector)
+	d.DeleteVolumes = opts.Bool(flagDeleteVolumes)
+	if err = d.setDockerDataVolumeFromFlags(opts.Int(flagDockerDataVolumeSize)); err != nil {
+		return err
+	}
 	d.Networks = opts.StringSlice(flagNetworks)
+	if len(d.Networks) == 0 {
+		d.Networks = opts.StringSlice(flagDefaultNetworks)
+	}
+	err = d.setLoadBalancerFlags(opts)
+	if err != nil {
+		return err
+	}
 	disablePublic := opts.Bool(flagDisablePublic)
 	d.UsePrivateNetwork = opts.Bool(flagUsePrivateNetwork) || disablePublic
 	d.DisablePublic4 = d.deprecatedBooleanFlag(opts, flagDisablePublic4, legacyFlagDisablePublic4) || disablePublic
@@ -381,14 +1069,79 @@ func (d *Driver) setConfigFromFlagsImpl(opts drivers.DriverOptions) error {
 	d.PrimaryIPv4 = opts.String(flagPrimary4)
 	d.PrimaryIPv6 = opts.String(flagPrimary6)
 	d.Firewalls = opts.StringSlice(flagFirewalls)
-	d.AdditionalKeys = opts.StringSlice(flagAdditionalKeys)
+	d.setAdditionalKeysFromFlags(opts.StringSlice(flagAdditionalKeys))
+	d.keySelector = opts.String(flagKeySelector)
+	if err = d.setAuthorizedKeysFromFlags(opts.StringSlice(flagAuthorizedKeys)); err != nil {
+		return err
+	}
 
 	d.SSHUser = opts.String(flagSshUser)
 	d.SSHPort = opts.Int(flagSshPort)
+	d.DockerPort = opts.Int(flagDockerPort)
+	if d.DockerPort == 0 {
+		d.DockerPort = defaultDockerPort
+	}
+	if d.DockerPort < 1 || d.DockerPort > 65535 || d.DockerPort == d.SSHPort {
+		return d.flagFailure("--%v must be a port between 1 and 65535 other than the SSH port, got %d", flagDockerPort, d.DockerPort)
+	}
+	if err = d.setSSHKeyTypeFromFlags(opts.String(flagSshKeyType)); err != nil {
+		return err
+	}
+	d.UseSSHAgent = opts.Bool(flagSSHAgent)
+	d.hostKeyPinning = opts.Bool(flagPinHostKey)
+	d.SSHRetries = opts.Int(flagSSHRetries)
+	if d.SSHRetries < 0 {
+		return d.flagFailure("--%v must not be negative, got %d", flagSSHRetries, d.SSHRetries)
+	}
+	if d.UseSSHAgent && d.originalKey != "" {
+		return d.flagFailure("--%v and --%v are mutually exclusive", flagSSHAgent, flagExKeyPath)
+	}
 
 	d.WaitOnError = opts.Int(flagWaitOnError)
 	d.WaitOnPolling = opts.Int(flagWaitOnPolling)
+	if raw := opts.String(flagWaitInterval); raw != "" {
+		if d.WaitInterval, err = time.ParseDuration(raw); err != nil || d.WaitInterval <= 0 {
+			return d.flagFailure("--%v must be a positive duration, got %v", flagWaitInterval, raw)
+		}
+	}
 	d.WaitForRunningTimeout = opts.Int(flagWaitForRunningTimeout)
+	if err = d.setSSHWaitFlags(opts); err != nil {
+		return err
+	}
+	d.waitCloudInit = opts.Bool(flagWaitCloudInit)
+	d.waitCloudInitTimeout = opts.Int(flagWaitCloudInitTimeout)
+	d.waitMetadata = opts.Bool(flagWaitMetadata)
+	d.waitMetadataTimeout = opts.Int(flagWaitMetadataTimeout)
+	if err = d.setIgnitionFlags(opts); err != nil {
+		return err
+	}
+	if err = d.setCombustionFlags(opts); err != nil {
+		return err
+	}
+	d.ShutdownTimeout = opts.Int(flagShutdownTimeout)
+	d.CreateTimeout = opts.Int(flagCreateTimeout)
+	d.ActionTimeout = opts.Int(flagActionTimeout)
+	d.KeepOnFailure = opts.Bool(flagKeepOnFailure)
+	d.APIMaxAttempts = opts.Int(flagAPIMaxAttempts)
+	d.maxParallelCreates = opts.Int(flagMaxParallelCreates)
+	if raw := opts.String(flagKeepOnError); raw != "" {
+		if d.keepOnError, err = time.ParseDuration(raw); err != nil {
+			return d.flagFailure("could not parse --%v: %v", flagKeepOnError, err)
+		}
+		if d.KeepOnFailure {
+			return d.flagFailure("--%v and --%v are mutually exclusive", flagKeepOnFailure, flagKeepOnError)
+		}
+	}
+	d.RestartReset = opts.Bool(flagRestartReset)
+	d.ResizeKeepDisk = opts.Bool(flagResizeKeepDisk)
+	d.SnapshotOnRemove = opts.Bool(flagSnapshotOnRemove)
+	d.RemoveDetachOnly = opts.Bool(flagRemoveDetachOnly)
+	if d.RemoveDetachOnly && (d.SnapshotOnRemove || d.DeleteVolumes) {
+		return d.flagFailure("--%v cannot be combined with --%v or --%v", flagRemoveDetachOnly, flagSnapshotOnRemove, flagDeleteVolumes)
+	}
+	if err = d.setGoldenSnapshotFlags(opts); err != nil {
+		return err
+	}
 
 	d.placementGroup = opts.String(flagPlacementGroup)
 	if opts.Bool(flagAutoSpread) {
@@ -402,11 +1155,14 @@ func (d *Driver) setConfigFromFlagsImpl(opts drivers.DriverOptions) error {
 	if err != nil {
 		return err
 	}
+	if err = d.setAutoscalerFlags(opts); err != nil {
+		return err
+	}
 
 	d.SetSwarmConfigFromFlags(opts)
 
-	if d.AccessToken == "" {
-		return d.flagFailure("hetzner requires --%v to be set", flagAPIToken)
+	if err = d.setupTokenSource(); err != nil {
+		return err
 	}
 
 	if err = d.verifyImageFlags(); err != nil {
@@ -431,6 +1187,9 @@ func (d *Driver) setConfigFromFlagsImpl(opts drivers.DriverOptions) error {
 
 // GetSSHUsername retrieves the SSH username used to connect to the server during provisioning
 func (d *Driver) GetSSHUsername() string {
+	if d.SSHUser == "" { // not selected by image yet
+		return defaultSSHUser
+	}
 	return d.SSHUser
 }
 
@@ -441,28 +1200,107 @@ func (d *Driver) GetSSHPort() (int, error) {
 
 // PreCreateCheck validates the Driver data is in a valid state for creation; see [drivers.Driver.PreCreateCheck]
 func (d *Driver) PreCreateCheck() error {
+	// the machine name is only known now, and becomes a label value
+	if err := validateLabels(d.resourceLabels(d.keyLabels)); err != nil {
+		return err
+	}
+
+	// with a fast creation, an invalid or read-only token just fails the first lookup or the creation, respectively
+	if !d.fastCreate {
+		if err := d.verifyToken(); err != nil {
+			return err
+		}
+
+		if err := d.verifyTokenWritable(); err != nil {
+			return err
+		}
+	}
+
 	if err := d.setupExistingKey(); err != nil {
 		return err
 	}
 
+	if _, err := d.getAdditionalKeyRefs(); err != nil {
+		return err
+	}
+
+	// creating fast, the API's uniqueness error is good enough for failing
+	if d.existingServer == "" && !(d.fastCreate && d.onNameConflict == nameConflictFail) {
+		if err := d.resolveNameConflict(); err != nil {
+			return err
+		}
+	}
+
+	if d.existingServer != "" {
+		if err := d.verifyExistingServer(); err != nil {
+			return fmt.Errorf("could not verify existing server: %w", err)
+		}
+		return nil
+	}
+
 	if serverType, err := d.getType(); err != nil {
 		return fmt.Errorf("could not get type: %w", err)
 	} else if d.ImageArch != "" && serverType.Architecture != d.ImageArch {
 		log.Warnf("supplied architecture %v differs from server architecture %v", d.ImageArch, serverType.Architecture)
 	}
 
-	if _, err := d.getImage(); err != nil {
+	doneImage := d.timePhase("image resolution")
+	image, err := d.getImage()
+	if err != nil {
 		return fmt.Errorf("could not get image: %w", err)
 	}
+	d.setImageSSHUser(image)
+	doneImage()
+
+	if d.autoCheapest {
+		if err := d.selectCheapestLocation(); err != nil {
+			return fmt.Errorf("could not select cheapest location: %w", err)
+		}
+	}
 
 	if _, err := d.getLocationNullable(); err != nil {
 		return fmt.Errorf("could not get location: %w", err)
 	}
 
+	if err := d.verifyAttachVolumeLocation(); err != nil {
+		return fmt.Errorf("could not verify volume to attach: %w", err)
+	}
+
+	// rendered again on creation, including driver-generated parts, but errors in the user's parts show up early
+	if _, err := d.renderUserData(); err != nil {
+		return fmt.Errorf("could not render user data: %w", err)
+	}
+
+	if !d.fastCreate {
+		if err := d.verifyServerTypeAvailable(); err != nil {
+			return err
+		}
+	}
+
+	if d.dockerDataVolumeSize != 0 && d.Location == "" {
+		return fmt.Errorf("--%v requires a location, either given by --%v or an attached volume",
+			flagDockerDataVolumeSize, flagLocation)
+	}
+
+	// the price is logged for information, unless creating fast, where it is only needed as threshold
+	if !d.fastCreate || d.maxHourlyPrice != 0 {
+		if err := d.checkPrice(); err != nil {
+			return err
+		}
+	}
+
+	if d.dryRun {
+		return d.printPlan()
+	}
+
 	if _, err := d.getPlacementGroup(); err != nil {
 		return fmt.Errorf("could not create placement group: %w", err)
 	}
 
+	if err := d.verifyLoadBalancer(); err != nil {
+		return fmt.Errorf("could not resolve load balancer: %w", err)
+	}
+
 	if _, err := d.getPrimaryIPv4(); err != nil {
 		return fmt.Errorf("could not resolve primary IPv4: %w", err)
 	}
@@ -479,52 +1317,123 @@ func (d *Driver) PreCreateCheck() error {
 }
 
 // Create actually creates the hetzner-cloud server; see [drivers.Driver.Create]
-func (d *Driver) Create() error {
-	err := d.prepareLocalKey()
+func (d *Driver) Create() (err error) {
+	timeout := time.Duration(d.CreateTimeout) * time.Second
+	d.beginOperation(timeout)
+	defer d.endOperation()
+	// the rollback ends the operation before this runs, so its context is kept
+	ctx := d.getContext()
+	defer func() { err = timeoutError(ctx, err, flagCreateTimeout, timeout) }()
+
+	done := d.timePhase("creation slot")
+	release, err := d.acquireCreateSlot()
 	if err != nil {
 		return err
 	}
+	defer release()
+	done()
 
+	defer func() { d.logPhaseTimings(err == nil) }()
 	defer d.destroyDangling()
-	err = d.createRemoteKeys()
+
+	// runs before the rollback, which deletes the server along with the logs telling why it failed
+	sshReady := false
+	defer func() {
+		if err != nil && sshReady && !d.ignition && !d.combustion && !d.savedInitLogs {
+			d.saveCloudInitLogs()
+		}
+	}()
+
+	var srv *hcloud.ServerCreateResult
+	if d.existingServer != "" {
+		srv, err = d.adoptExistingServer()
+	} else {
+		srv, err = d.getResumableServer()
+	}
 	if err != nil {
 		return err
 	}
 
-	log.Infof("Creating Hetzner server...")
+	if srv == nil {
+		if srv, err = d.createServer(); err != nil {
+			return err
+		}
+	}
 
-	srvopts, err := d.makeCreateServerOptions()
+	log.Infof(" -> Server %s[%d]: Waiting to come up...", srv.Server.Name, srv.Server.ID)
+
+	done = d.timePhase("server startup")
+	err = d.waitForInitialStartup(*srv)
 	if err != nil {
 		return err
 	}
+	done()
 
-	srv, _, err := d.getClient().Server.Create(context.Background(), instrumented(*srvopts))
+	done = d.timePhase("network attachment")
+	err = d.configureNetworkAccess(*srv)
 	if err != nil {
-		time.Sleep(time.Duration(d.WaitOnError) * time.Second)
-		return fmt.Errorf("could not create server: %w", err)
+		return err
 	}
-
-	log.Infof(" -> Creating server %s[%d] in %s[%d]", srv.Server.Name, srv.Server.ID, srv.Action.Command, srv.Action.ID)
-	if err = d.waitForAction(srv.Action); err != nil {
-		return fmt.Errorf("could not wait for action: %w", err)
+	if d.existingServer == "" {
+		if err = d.labelPrimaryIPs(srv.Server); err != nil {
+			log.Warnf(" -> %v", err)
+		}
 	}
+	done()
+	d.updateServerDetails()
 
-	d.ServerID = srv.Server.ID
-	log.Infof(" -> Server %s[%d]: Waiting to come up...", srv.Server.Name, srv.Server.ID)
+	if d.existingServer != "" {
+		if err = d.verifySSHAccess(); err != nil {
+			return err
+		}
+	}
 
-	err = d.waitForInitialStartup(srv)
+	done = d.timePhase("load balancer targets")
+	err = d.registerLoadBalancerTargets(srv.Server)
 	if err != nil {
 		return err
 	}
+	done()
 
-	err = d.configureNetworkAccess(srv)
-	if err != nil {
-		return err
+	// libmachine retries SSH logins at a fixed pace of its own, which is either too slow or too short for some images
+	done = d.timePhase("ssh ready")
+	if err = d.waitForSSH(); err != nil {
+		return fmt.Errorf("could not reach server via SSH: %w", err)
+	}
+	sshReady = true
+	done()
+
+	if d.waitMetadata {
+		done = d.timePhase("metadata")
+		if err = d.waitForMetadata(); err != nil {
+			return err
+		}
+		done()
+	}
+
+	if d.hostKeyPinning {
+		if err = d.pinHostKey(); err != nil {
+			return err
+		}
+	}
+
+	if d.waitCloudInit {
+		done = d.timePhase("cloud-init")
+		if err = d.waitForCloudInit(); err != nil {
+			return err
+		}
+		done()
+	}
+
+	if d.writableRoot {
+		if err = d.prepareTransactionalProvisioning(); err != nil {
+			return err
+		}
 	}
 
 	log.Infof(" -> Server %s[%d] ready. Ip %s", srv.Server.Name, srv.Server.ID, d.IPAddress)
 	// Successful creation, so no keys dangle anymore
-	d.dangling = nil
+	d.dangling, d.serverDangling = nil, nil
 
 	return nil
 }
@@ -536,6 +1445,8 @@ func (d *Driver) GetSSHHostname() (string, error) {
 
 // GetURL retrieves the URL of the docker daemon on the machine; see [drivers.Driver.GetURL]
 func (d *Driver) GetURL() (string, error) {
+	d.endProvisioningTiming()
+
 	if err := drivers.MustBeRunning(d); err != nil {
 		return "", fmt.Errorf("could not execute drivers.MustBeRunning: %w", err)
 	}
@@ -545,12 +1456,17 @@ func (d *Driver) GetURL() (string, error) {
 		return "", fmt.Errorf("could not get IP: %w", err)
 	}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	port := d.DockerPort
+	if port == 0 {
+		// machines created before the port was configurable
+		port = defaultDockerPort
+	}
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(port))), nil
 }
 
 // GetState retrieves the state the machine is currently in; see [drivers.Driver.GetState]
 func (d *Driver) GetState() (state.State, error) {
-	srv, _, err := d.getClient().Server.GetByID(context.Background(), d.ServerID)
+	srv, _, err := d.getClient().Server.GetByID(d.getContext(), d.ServerID)
 	if err != nil {
 		return state.None, fmt.Errorf("could not get server by ID: %w", err)
 	}
@@ -558,61 +1474,122 @@ func (d *Driver) GetState() (state.State, error) {
 		return state.None, errors.New("server not found")
 	}
 
-	switch srv.Status {
-	case hcloud.ServerStatusInitializing:
-		return state.Starting, nil
-	case hcloud.ServerStatusRunning:
-		return state.Running, nil
+	return serverState(srv.Status), nil
+}
+
+// serverState maps the server status to the closest libmachine state; servers being migrated keep running, and ones
+// being rebuilt boot again once done
+func serverState(status hcloud.ServerStatus) state.State {
+	switch status {
+	case hcloud.ServerStatusInitializing, hcloud.ServerStatusStarting, hcloud.ServerStatusRebuilding:
+		return state.Starting
+	case hcloud.ServerStatusRunning, hcloud.ServerStatusMigrating:
+		return state.Running
+	case hcloud.ServerStatusStopping, hcloud.ServerStatusDeleting:
+		return state.Stopping
 	case hcloud.ServerStatusOff:
-		return state.Stopped, nil
+		return state.Stopped
+	case hcloud.ServerStatusUnknown:
+		return state.Error
 	}
-	return state.None, nil
+	return state.None
 }
 
-// Remove deletes the hetzner server and additional resources created during creation; see [drivers.Driver.Remove]
+// Remove deletes the hetzner server (or merely releases it, if configured) and additional resources created during
+// creation; see [drivers.Driver.Remove]
 func (d *Driver) Remove() error {
-	if err := d.destroyServer(); err != nil {
+	d.beginOperation(0)
+	defer d.endOperation()
+
+	if err := d.verifyServerOwnership(); err != nil {
 		return err
 	}
 
+	var volumeErr error
+	if d.RemoveDetachOnly || d.KeepServer {
+		if err := d.releaseServer(); err != nil {
+			return err
+		}
+		d.releaseVolumes()
+	} else {
+		d.detachVolumes()
+
+		if err := d.destroyServer(); err != nil {
+			return err
+		}
+
+		// failure to delete a volume is reported once the keys are removed, too
+		if d.DeleteVolumes {
+			volumeErr = d.deleteVolumes()
+		} else {
+			d.releaseVolumes()
+		}
+	}
+
 	// failure to remove a key is not ha hard error
 	for i, id := range d.AdditionalKeyIDs {
 		log.Infof(" -> Destroying additional key #%d (%d)", i, id)
-		key, _, softErr := d.getClient().SSHKey.GetByID(context.Background(), id)
+		key, _, softErr := d.getClient().SSHKey.GetByID(d.getContext(), id)
 		if softErr != nil {
 			log.Warnf(" ->  -> could not retrieve key %v", softErr)
+			continue
 		} else if key == nil {
 			log.Warnf(" ->  -> %d no longer exists", id)
+			continue
 		}
 
-		_, softErr = d.getClient().SSHKey.Delete(context.Background(), key)
+		users, softErr := d.otherKeyUsers(key)
 		if softErr != nil {
+			log.Warnf(" ->  -> %v", softErr)
+			continue
+		} else if len(users) != 0 {
+			log.Infof(" ->  -> keeping key, still used by servers %v", strings.Join(users, ", "))
+			continue
+		}
+
+		_, softErr = d.getClient().SSHKey.Delete(d.getContext(), key)
+		if softErr != nil && !isAPIError(softErr, hcloud.ErrorCodeNotFound) {
 			log.Warnf(" ->  -> could not remove key: %v", softErr)
 		}
 	}
 
+	for _, id := range d.SharedKeyIDs {
+		if softErr := d.releaseSharedKey(id); softErr != nil {
+			log.Warnf(" ->  -> could not release shared key: %v", softErr)
+		}
+	}
+
 	// failure to remove a server-specific key is a hard error
 	if !d.IsExistingKey && d.KeyID != 0 {
 		key, err := d.getKeyNullable()
 		if err != nil {
-			return fmt.Errorf("could not get ssh key: %w", err)
+			return errors.Join(volumeErr, fmt.Errorf("could not get ssh key: %w", err))
 		}
 		if key == nil {
 			log.Infof(" -> SSH key does not exist anymore")
-			return nil
+			return volumeErr
+		}
+
+		users, err := d.otherKeyUsers(key)
+		if err != nil {
+			return errors.Join(volumeErr, err)
+		}
+		if len(users) != 0 {
+			log.Infof(" -> Keeping SSH key %s[%d], still used by servers %v", key.Name, key.ID, strings.Join(users, ", "))
+			return volumeErr
 		}
 
 		log.Infof(" -> Destroying SSHKey %s[%d]...", key.Name, key.ID)
 
-		if _, err := d.getClient().SSHKey.Delete(context.Background(), key); err != nil {
-			return fmt.Errorf("could not delete ssh key: %w", err)
+		if _, err := d.getClient().SSHKey.Delete(d.getContext(), key); err != nil && !isAPIError(err, hcloud.ErrorCodeNotFound) {
+			return errors.Join(volumeErr, fmt.Errorf("could not delete ssh key: %w", err))
 		}
 	}
 
-	return nil
+	return volumeErr
 }
 
-// Restart instructs the hetzner cloud server to reboot; see [drivers.Driver.Restart]
+// Restart instructs the hetzner cloud server to reboot, or to reset if configured; see [drivers.Driver.Restart]
 func (d *Driver) Restart() error {
 	srv, err := d.getServerHandle()
 	if err != nil {
@@ -622,7 +1599,22 @@ func (d *Driver) Restart() error {
 		return errors.New("server not found")
 	}
 
-	act, _, err := d.getClient().Server.Reboot(context.Background(), srv)
+	if d.RestartReset {
+		act, _, err := retryLocked(d, func() (*hcloud.Action, *hcloud.Response, error) {
+			return d.getClient().Server.Reset(d.getContext(), srv)
+		})
+		if err != nil {
+			return fmt.Errorf("could not reset server: %w", err)
+		}
+
+		log.Infof(" -> Resetting server %s[%d] in %s[%d]...", srv.Name, srv.ID, act.Command, act.ID)
+
+		return d.waitForAction(act)
+	}
+
+	act, _, err := retryLocked(d, func() (*hcloud.Action, *hcloud.Response, error) {
+		return d.getClient().Server.Reboot(d.getContext(), srv)
+	})
 	if err != nil {
 		return fmt.Errorf("could not reboot server: %w", err)
 	}
@@ -639,7 +1631,9 @@ func (d *Driver) Start() error {
 		return fmt.Errorf("could not get server handle: %w", err)
 	}
 
-	act, _, err := d.getClient().Server.Poweron(context.Background(), srv)
+	act, _, err := retryLocked(d, func() (*hcloud.Action, *hcloud.Response, error) {
+		return d.getClient().Server.Poweron(d.getContext(), srv)
+	})
 	if err != nil {
 		return fmt.Errorf("could not power on server: %w", err)
 	}
@@ -649,21 +1643,39 @@ func (d *Driver) Start() error {
 	return d.waitForAction(act)
 }
 
-// Stop instructs the hetzner cloud server to shut down; see [drivers.Driver.Stop]
+// Stop instructs the hetzner cloud server to shut down gracefully, powering it off if it does not stop in time;
+// see [drivers.Driver.Stop]
 func (d *Driver) Stop() error {
 	srv, err := d.getServerHandle()
 	if err != nil {
 		return fmt.Errorf("could not get server handle: %w", err)
 	}
 
-	act, _, err := d.getClient().Server.Shutdown(context.Background(), srv)
+	act, _, err := retryLocked(d, func() (*hcloud.Action, *hcloud.Response, error) {
+		return d.getClient().Server.Shutdown(d.getContext(), srv)
+	})
 	if err != nil {
 		return fmt.Errorf("could not shutdown server: %w", err)
 	}
 
 	log.Infof(" -> Shutting down server %s[%d] in %s[%d]...", srv.Name, srv.ID, act.Command, act.ID)
 
-	return d.waitForAction(act)
+	if err = d.waitForAction(act); err != nil {
+		return err
+	}
+
+	timeout := d.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	stopped, err := d.waitForStoppedServer(time.Duration(timeout) * time.Second)
+	if err != nil || stopped {
+		return err
+	}
+
+	log.Warnf(" -> Server %s[%d] did not shut down within %d seconds, powering off...", srv.Name, srv.ID, timeout)
+	return d.Kill()
 }
 
 // Kill forcefully shuts down the hetzner cloud server; see [drivers.Driver.Kill]
@@ -673,7 +1685,9 @@ func (d *Driver) Kill() error {
 		return fmt.Errorf("could not get server handle: %w", err)
 	}
 
-	act, _, err := d.getClient().Server.Poweroff(context.Background(), srv)
+	act, _, err := retryLocked(d, func() (*hcloud.Action, *hcloud.Response, error) {
+		return d.getClient().Server.Poweroff(d.getContext(), srv)
+	})
 	if err != nil {
 		return fmt.Errorf("could not poweroff server: %w", err)
 	}
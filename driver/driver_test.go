@@ -1,14 +1,45 @@
 package driver
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"net/url"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/machine/commands/commandstest"
 	"github.com/docker/machine/libmachine/drivers"
+	rpcdriver "github.com/docker/machine/libmachine/drivers/rpc"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/docker/machine/libmachine/state"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"gopkg.in/yaml.v3"
 )
 
 var defaultFlags = map[string]interface{}{
@@ -309,3 +340,4700 @@ func assertMutualExclusion(t *testing.T, err error, flag1, flag2 string) {
 		t.Errorf("expected mutually exclusive flags to fail, but message differs: %v %v %v", flag1, flag2, errstr)
 	}
 }
+
+func TestAttachVolumes(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAttachVolume: []string{"docker", "42:/srv/data"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	if len(d.attachVolumes) != 2 {
+		t.Fatalf("expected 2 volumes, but got %v", len(d.attachVolumes))
+	}
+	if d.attachVolumes[0].idOrName != "docker" || d.attachVolumes[0].mountPoint != "" {
+		t.Errorf("unexpected volume request %v", d.attachVolumes[0])
+	}
+	if d.attachVolumes[1].idOrName != "42" || d.attachVolumes[1].mountPoint != "/srv/data" {
+		t.Errorf("unexpected volume request %v", d.attachVolumes[1])
+	}
+
+	// relative mount point
+	d = NewDriver("test")
+	err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAttachVolume: []string{"docker:srv"},
+	}))
+	if err == nil {
+		t.Fatal("expected error, but relative mount point was accepted")
+	}
+}
+
+func TestClaimVolume(t *testing.T) {
+	var queries []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/volumes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		queries = append(queries, r.URL.Query())
+		volume := func(id int, location, server string) string {
+			return fmt.Sprintf(`{"id": %d, "name": "pool-%d", "location": {"id": %d, "name": %q}, "server": %s}`,
+				id, id, id, location, server)
+		}
+		_, _ = fmt.Fprintf(w, `{"volumes": [%s], "meta": {"pagination": {"page": 1, "last_page": 1}}}`, strings.Join([]string{
+			volume(1, "nbg1", "9"),
+			volume(2, "fsn1", "null"),
+			volume(3, "nbg1", "null"),
+		}, ","))
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagVolumeSelector: "pool=ci"})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.APIEndpoint = srv.URL
+
+	for location, want := range map[string]int64{"nbg1": 3, "fsn1": 2, "": 2} {
+		d.cachedLocation = nil
+		if location != "" {
+			d.cachedLocation = &hcloud.Location{Name: location}
+		}
+		volume, err := d.claimVolume(nil)
+		if err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if volume.ID != want {
+			t.Errorf("expected unattached volume %d to be claimed in location %q, got %d", want, location, volume.ID)
+		}
+	}
+	if q := queries[0]; q.Get("label_selector") != "pool=ci" || q.Get("status") != "available" {
+		t.Errorf("expected available volumes matching the selector to be listed, got %v", q)
+	}
+
+	// the volumes given explicitly determine the location
+	d.cachedLocation = nil
+	volume, err := d.claimVolume([]*hcloud.Volume{{ID: 4, Location: &hcloud.Location{Name: "nbg1"}}})
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if volume.ID != 3 {
+		t.Errorf("expected the volume next to the explicit ones to be claimed, got %d", volume.ID)
+	}
+
+	d.cachedLocation = &hcloud.Location{Name: "hel1"}
+	if _, err := d.claimVolume(nil); err == nil || !strings.Contains(err.Error(), "no unattached volume matching 'pool=ci' found in hel1") {
+		t.Errorf("expected claiming to fail if no volume matches, got %v", err)
+	}
+}
+
+func TestDockerDataVolume(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagDockerDataVolumeSize: 5,
+	}))
+	if err == nil {
+		t.Fatal("expected error, but undersized volume was accepted")
+	}
+
+	d = NewDriver("test")
+	err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagDockerDataVolumeSize: 20,
+		flagUserData:             "#cloud-config\npackages:\n  - htop\n",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d.cachedDataVolume = &hcloud.Volume{ID: 42, LinuxDevice: "/dev/disk/by-id/scsi-0HC_Volume_42"}
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !strings.Contains(data, "/dev/disk/by-id/scsi-0HC_Volume_42") || !strings.Contains(data, dockerDataRoot) {
+		t.Errorf("expected data volume mount in user data, got %v", data)
+	}
+	if !strings.Contains(data, "htop") {
+		t.Errorf("expected user supplied data to be retained, got %v", data)
+	}
+
+	// non cloud-config user data cannot be merged
+	d.userData = "#!/bin/sh\necho hello"
+	if _, err = d.renderUserData(); err == nil {
+		t.Error("expected error, but script user data was merged")
+	}
+}
+
+func TestDeleteVolumes(t *testing.T) {
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.cachedAttachVolumes = []*hcloud.Volume{{ID: 1, Name: "data"}, {ID: 2, Name: "pool-1"}}
+	d.attachVolumes = []volumeRequest{{idOrName: "data"}, {idOrName: "pool-1"}}
+	d.cachedDataVolume = &hcloud.Volume{ID: 3, Name: "test-docker"}
+	d.trackAttachedVolumes()
+	d.AttachedVolumes = append(d.AttachedVolumes, AttachedVolume{ID: 4, Name: "test-docker-old", Created: true})
+
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/volumes/3":
+			_, _ = io.WriteString(w, `{"volume": {"id": 3, "name": "test-docker"}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/volumes/4":
+			_, _ = io.WriteString(w, `{"volume": {"id": 4, "name": "test-docker-old"}}`)
+		case r.Method == http.MethodDelete && r.URL.Path == "/volumes/3":
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `{"error": {"code": "protected", "message": "volume is protected"}}`)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	d.APIEndpoint = srv.URL
+
+	err := d.deleteVolumes()
+	if err == nil || !strings.Contains(err.Error(), "test-docker[3]") {
+		t.Errorf("expected the failed deletion to be reported, got %v", err)
+	}
+	if !slices.Equal(deleted, []string{"/volumes/3", "/volumes/4"}) {
+		t.Errorf("expected only created volumes to be deleted, despite the failure, got %v", deleted)
+	}
+}
+
+func TestSnapshotOnRemove(t *testing.T) {
+	var events []string
+	var labels map[string]string
+	failSnapshot, polls := false, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/servers/1/actions/create_image":
+			events = append(events, "create_image")
+			if failSnapshot {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = io.WriteString(w, `{"error": {"code": "forbidden", "message": "insufficient permissions"}}`)
+				return
+			}
+			var body struct {
+				Type   string            `json:"type"`
+				Labels map[string]string `json:"labels"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			labels = body.Labels
+			_, _ = io.WriteString(w, `{"image": {"id": 20, "type": "snapshot"}, "action": {"id": 5, "command": "create_image", "status": "running"}}`)
+		case r.URL.Path == "/actions/5":
+			// the snapshot takes a while
+			polls++
+			status := "running"
+			if polls > 2 {
+				status = "success"
+				events = append(events, "snapshot done")
+			}
+			_, _ = fmt.Fprintf(w, `{"action": {"id": 5, "command": "create_image", "status": %q}}`, status)
+		case r.URL.Path == "/actions/6":
+			_, _ = io.WriteString(w, `{"action": {"id": 6, "command": "delete_server", "status": "success"}}`)
+		case r.URL.Path == "/servers/1" && r.Method == http.MethodDelete:
+			events = append(events, "delete")
+			_, _ = io.WriteString(w, `{"action": {"id": 6, "command": "delete_server", "status": "running"}}`)
+		case r.URL.Path == "/servers/1":
+			_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	newDriver := func() *Driver {
+		events = nil
+		d := NewDriver("test")
+		d.AccessToken = "foo"
+		d.APIEndpoint = srv.URL
+		d.MachineName = "node-1"
+		d.ServerID = 1
+		d.SnapshotOnRemove = true
+		d.WaitInterval = 10 * time.Millisecond
+		return d
+	}
+
+	if err := newDriver().Remove(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(events, []string{"create_image", "snapshot done", "delete"}) {
+		t.Errorf("expected the server to be deleted once the snapshot is done, got %v", events)
+	}
+	if labels["docker-machine/snapshot-of"] != "node-1" || labels["docker-machine/machine"] != "node-1" {
+		t.Errorf("expected the snapshot to be labeled with the machine, got %v", labels)
+	}
+
+	// the snapshot is the point of archiving, so the server is kept without it
+	failSnapshot = true
+	if err := newDriver().Remove(); err == nil {
+		t.Error("expected the failed snapshot to fail the removal")
+	}
+	if !slices.Equal(events, []string{"create_image"}) {
+		t.Errorf("expected the server to be kept if the snapshot fails, got %v", events)
+	}
+}
+
+func TestRemoveServerGone(t *testing.T) {
+	recreated := false
+	var queries []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/servers" && r.Method == http.MethodGet:
+			queries = append(queries, r.URL.Query())
+			var servers string
+			if recreated {
+				servers = `{"id": 2, "name": "node-1", "labels": {"docker-machine/machine": "node-1"}}`
+			}
+			_, _ = fmt.Fprintf(w, `{"servers": [%s], "meta": {"pagination": {"page": 1, "last_page": 1}}}`, servers)
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error": {"code": "not_found", "message": "not found"}}`)
+		default:
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	newDriver := func() *Driver {
+		d := NewDriver("test")
+		d.AccessToken = "foo"
+		d.APIEndpoint = srv.URL
+		d.MachineName = "node-1"
+		d.ServerID = 1
+		return d
+	}
+
+	if err := newDriver().Remove(); err != nil {
+		t.Fatalf("expected removing a machine whose server is gone to succeed, got %v", err)
+	}
+	if len(queries) != 1 || queries[0].Get("name") != "node-1" || queries[0].Get("label_selector") != "docker-machine/machine=node-1" {
+		t.Errorf("expected servers carrying the machine's name and label to be looked for, got %v", queries)
+	}
+
+	// a server recreated by hand is not mistaken for the machine's, nor left behind silently
+	recreated = true
+	if err := newDriver().Remove(); err == nil || !strings.Contains(err.Error(), "node-1[2]") {
+		t.Errorf("expected the recreated server to refuse the removal, got %v", err)
+	}
+	t.Setenv(envForceRemove, "true")
+	if err := newDriver().Remove(); err != nil {
+		t.Errorf("expected %v to remove the machine anyway, got %v", envForceRemove, err)
+	}
+}
+
+func TestReleaseServer(t *testing.T) {
+	var labels map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/servers/1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPut {
+			var body struct {
+				Labels map[string]string `json:"labels"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			labels = body.Labels
+		}
+		_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1", "labels": {
+			"docker-machine/machine": "node-1", "docker-machine/store": "0123456789ab", "docker-machine-key/aa": "true",
+			"role": "web", "team": "ci", "docker-machine.io/owner": "ops"}}}`)
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.MachineName = "node-1"
+	d.ServerID = 1
+	d.ServerLabels = map[string]string{"role": "web"}
+
+	if err := d.releaseServer(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	want := map[string]string{"team": "ci", "docker-machine.io/owner": "ops"}
+	if !maps.Equal(labels, want) {
+		t.Errorf("expected only the driver's and --%v labels to be removed, got %v", flagServerLabel, labels)
+	}
+}
+
+func TestRemoveKeepsVolumes(t *testing.T) {
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	d.ServerID = 1
+	d.AttachedVolumes = []AttachedVolume{{ID: 3, Name: "node-1-docker", Created: true}}
+
+	labels := d.resourceLabels(map[string]string{d.labelName(labelAutoCreated): "true", "team": "ci"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		page := `"meta": {"pagination": {"page": 1, "last_page": 1}}`
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/actions/"):
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %v, "status": "success", "progress": 100}}`, path.Base(r.URL.Path))
+		case r.URL.Path == "/servers/1" && r.Method == http.MethodDelete:
+			_, _ = io.WriteString(w, `{"action": {"id": 1, "status": "running"}}`)
+		case r.URL.Path == "/servers/1":
+			_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1"}}`)
+		case r.URL.Path == "/volumes/3" && r.Method == http.MethodPut:
+			var body struct {
+				Labels map[string]string `json:"labels"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			labels = body.Labels
+			fallthrough
+		case r.URL.Path == "/volumes/3" && r.Method == http.MethodGet:
+			encoded, _ := json.Marshal(labels)
+			_, _ = fmt.Fprintf(w, `{"volume": {"id": 3, "name": "node-1-docker", "labels": %s}}`, encoded)
+		case r.URL.Path == "/volumes" && r.Method == http.MethodGet:
+			// gc only lists resources carrying the machine label
+			var volumes string
+			if _, ok := labels[d.labelName(labelMachine)]; ok {
+				encoded, _ := json.Marshal(labels)
+				volumes = fmt.Sprintf(`{"id": 3, "name": "node-1-docker", "labels": %s}`, encoded)
+			}
+			_, _ = fmt.Fprintf(w, `{"volumes": [%s], %s}`, volumes, page)
+		case r.Method == http.MethodGet && slices.Contains([]string{"/servers", "/ssh_keys", "/primary_ips", "/firewalls"}, r.URL.Path):
+			_, _ = fmt.Fprintf(w, `{%q: [], %s}`, r.URL.Path[1:], page)
+		default:
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	d.APIEndpoint = srv.URL
+
+	if err := d.Remove(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if _, ok := labels[d.labelName(labelStore)]; ok || labels["team"] != "ci" {
+		t.Errorf("expected only the driver's labels to be removed from the kept volume, got %v", labels)
+	}
+
+	orphans, err := d.FindOrphans(nil, "", false)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("expected the volume kept on remove not to be an orphan, got %v", orphans)
+	}
+}
+
+func TestResizeVolume(t *testing.T) {
+	var sizes []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/volumes/3":
+			_, _ = io.WriteString(w, `{"volume": {"id": 3, "name": "data", "size": 10, "linux_device": "/dev/disk/by-id/scsi-0HC_Volume_3"}}`)
+		case "/volumes/3/actions/resize":
+			var body struct {
+				Size int `json:"size"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			sizes = append(sizes, body.Size)
+			_, _ = io.WriteString(w, `{"action": {"id": 1, "command": "resize_volume", "status": "running"}}`)
+		case "/actions/1":
+			_, _ = io.WriteString(w, `{"action": {"id": 1, "command": "resize_volume", "status": "success"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	commands := filepath.Join(bin, "commands")
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte("#!/bin/sh\necho \"$*\" >> "+commands+"\n"), 0o755); err != nil {
+		t.Fatalf("could not write ssh stub: %v", err)
+	}
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.IPAddress = "127.0.0.1"
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	if err := d.InitMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err := generateEd25519Key(d.GetSSHKeyPath()); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.AttachedVolumes = []AttachedVolume{{ID: 3, Name: "data"}, {ID: 4, Name: "logs"}}
+
+	if err := d.ResizeVolume("", 20); err == nil {
+		t.Error("expected the volume to be required if several are attached")
+	}
+	if err := d.ResizeVolume("other", 20); err == nil {
+		t.Error("expected volumes not attached by the driver to be refused")
+	}
+	if err := d.ResizeVolume("data", 10); err == nil || !strings.Contains(err.Error(), "can only grow") {
+		t.Errorf("expected shrinking to be refused, got %v", err)
+	}
+	if len(sizes) != 0 {
+		t.Errorf("expected no volume to be resized, got %v", sizes)
+	}
+
+	// the only attached volume is used if none is given
+	d.AttachedVolumes = d.AttachedVolumes[:1]
+	if err := d.ResizeVolume("", 20); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(sizes, []int{20}) {
+		t.Errorf("expected the volume to be resized to 20 GB, got %v", sizes)
+	}
+	raw, err := os.ReadFile(commands)
+	if err != nil {
+		t.Fatalf("expected the filesystem to be resized via SSH, %v", err)
+	}
+	for _, command := range []string{"lsblk -no FSTYPE /dev/disk/by-id/scsi-0HC_Volume_3", "resize2fs /dev/disk/by-id/scsi-0HC_Volume_3",
+		"xfs_growfs \"$(findmnt -no TARGET /dev/disk/by-id/scsi-0HC_Volume_3)\""} {
+		if !strings.Contains(string(raw), command) {
+			t.Errorf("expected the filesystem grow command to run %v, got %s", command, raw)
+		}
+	}
+
+	// the command picks the tool by filesystem type
+	stubs := map[string]string{
+		"lsblk":      `echo "$FSTYPE"`,
+		"findmnt":    `echo /srv/data`,
+		"resize2fs":  `echo "resize2fs $*"`,
+		"xfs_growfs": `echo "xfs_growfs $*"`,
+	}
+	for name, script := range stubs {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+			t.Fatalf("could not write %v stub: %v", name, err)
+		}
+	}
+	for fstype, want := range map[string]string{
+		"ext4": "resize2fs /dev/sdb",
+		"xfs":  "xfs_growfs /srv/data",
+		"vfat": "",
+	} {
+		cmd := exec.Command("/bin/sh", "-c", growFilesystemCommand("/dev/sdb"))
+		cmd.Env = append(os.Environ(), "FSTYPE="+fstype)
+		out, err := cmd.Output()
+		if want == "" {
+			if err == nil {
+				t.Errorf("expected unsupported filesystem %v to fail, got %s", fstype, out)
+			}
+			continue
+		}
+		if err != nil || strings.TrimSpace(string(out)) != want {
+			t.Errorf("expected %v to be grown by %q, got %q, %v", fstype, want, out, err)
+		}
+	}
+}
+
+func TestDataVolumeCheckpoint(t *testing.T) {
+	var created int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/volumes/5":
+			_, _ = io.WriteString(w, `{"volume": {"id": 5, "name": "node-1-docker"}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/volumes/6":
+			_, _ = io.WriteString(w, `{"volume": {"id": 6, "name": "node-1-docker", "server": 1}}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/volumes":
+			created++
+			_, _ = io.WriteString(w, `{"volume": {"id": 7, "name": "node-1-docker"}}`)
+		default:
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	if err := d.InitMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.dockerDataVolumeSize = 10
+	d.Location, d.cachedLocation = "fsn1", &hcloud.Location{ID: 1, Name: "fsn1"}
+
+	// the volume of a previous attempt is used again
+	d.DataVolumeID = 5
+	if volume, err := d.makeDockerDataVolume(); err != nil || volume.ID != 5 || created != 0 {
+		t.Errorf("expected volume 5 to be used again, got %v, %v", volume, err)
+	}
+
+	// one in use elsewhere is replaced, recording the new one right away
+	d.DataVolumeID, d.cachedDataVolume = 6, nil
+	if volume, err := d.makeDockerDataVolume(); err != nil || volume.ID != 7 || created != 1 {
+		t.Fatalf("expected volume 7 to be created, got %v, %v", volume, err)
+	}
+	loaded, err := LoadMachine("test", d.StorePath, "node-1")
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if loaded.DataVolumeID != 7 {
+		t.Errorf("expected the created volume to be checkpointed, got %d", loaded.DataVolumeID)
+	}
+}
+
+func TestUserDataTemplate(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagUserData:         `{{ (volume "data").Device }} {{ range .Volumes }}{{ .MountPoint }}{{ end }}`,
+		flagUserDataTemplate: true,
+		flagAttachVolume:     []string{"data:/srv/data"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d.cachedAttachVolumes = []*hcloud.Volume{{ID: 42, Name: "data", LinuxDevice: "/dev/disk/by-id/scsi-0HC_Volume_42"}}
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if data != "/dev/disk/by-id/scsi-0HC_Volume_42 /srv/data" {
+		t.Errorf("unexpected rendered user data: %v", data)
+	}
+
+	// templates are opt-in
+	d.userDataTemplate = false
+	if data, _ = d.renderUserData(); data != d.userData {
+		t.Errorf("user data was rendered unexpectedly: %v", data)
+	}
+}
+
+func TestLoadBalancerDefinition(t *testing.T) {
+	file := t.TempDir() + string(os.PathSeparator) + "lb.yml"
+	err := os.WriteFile(file, []byte(`
+type: lb11
+network_zone: eu-central
+services:
+  - protocol: http
+    listen_port: 80
+    destination_port: 8080
+    health_check:
+      protocol: http
+      port: 8080
+      interval: 15s
+      http:
+        path: /health
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// name is required
+	d := NewDriver("test")
+	err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagLBCreateFromFile: file,
+	}))
+	if err == nil {
+		t.Fatal("expected error, but unnamed load balancer was accepted")
+	}
+
+	d = NewDriver("test")
+	err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagLBTarget:         "web",
+		flagLBCreateFromFile: file,
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	if len(d.lbDefinition.Services) != 1 {
+		t.Fatalf("expected 1 service, but got %v", len(d.lbDefinition.Services))
+	}
+	svc := makeLoadBalancerServiceOpts(d.lbDefinition.Services[0])
+	if *svc.ListenPort != 80 || *svc.DestinationPort != 8080 {
+		t.Errorf("unexpected ports %v -> %v", *svc.ListenPort, *svc.DestinationPort)
+	}
+	if svc.HealthCheck == nil || *svc.HealthCheck.Interval != 15*time.Second || *svc.HealthCheck.HTTP.Path != "/health" {
+		t.Errorf("unexpected health check %v", svc.HealthCheck)
+	}
+
+	var created []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		page := `"meta": {"pagination": {"page": 1, "last_page": 1}}`
+		switch {
+		case r.URL.Path == "/load_balancer_types":
+			_, _ = fmt.Fprintf(w, `{"load_balancer_types": [{"id": 1, "name": "lb11"}], %s}`, page)
+		case r.URL.Path == "/certificates" && r.Method == http.MethodPost:
+			var body struct {
+				Name        string   `json:"name"`
+				Type        string   `json:"type"`
+				DomainNames []string `json:"domain_names"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			created = append(created, fmt.Sprintf("%v %v %v", body.Name, body.Type, body.DomainNames))
+			_, _ = fmt.Fprintf(w, `{"certificate": {"id": 4, "name": %q, "type": "managed"},
+				"action": {"id": 1, "command": "create_certificate", "status": "running"}}`, body.Name)
+		case r.URL.Path == "/certificates" && r.URL.Query().Get("name") == "existing":
+			_, _ = fmt.Fprintf(w, `{"certificates": [{"id": 3, "name": "existing", "type": "managed"}], %s}`, page)
+		case r.URL.Path == "/certificates":
+			_, _ = fmt.Fprintf(w, `{"certificates": [], %s}`, page)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		name       string
+		definition string
+		certs      []int64
+		created    []string
+	}{
+		{
+			name: "managed certificate",
+			definition: `
+network_zone: eu-central
+services:
+  - protocol: https
+    listen_port: 443
+    destination_port: 8080
+    http:
+      managed_certificate:
+        domains: [example.com, www.example.com]
+`,
+			certs:   []int64{4},
+			created: []string{"web managed [example.com www.example.com]"},
+		},
+		{
+			name: "existing managed certificate",
+			definition: `
+network_zone: eu-central
+services:
+  - protocol: https
+    listen_port: 443
+    destination_port: 8080
+    http:
+      managed_certificate:
+        name: existing
+        domains: [example.com]
+`,
+			certs: []int64{3},
+		},
+	} {
+		created = nil
+		if err := os.WriteFile(file, []byte(test.definition), 0644); err != nil {
+			t.Fatal(err)
+		}
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+			flagLBTarget:         "web",
+			flagLBCreateFromFile: file,
+		})); err != nil {
+			t.Fatalf("%v: unexpected error, %v", test.name, err)
+		}
+		d.APIEndpoint = srv.URL
+
+		opts, err := d.makeLoadBalancerCreateOpts(d.lbDefinition)
+		if err != nil {
+			t.Fatalf("%v: unexpected error, %v", test.name, err)
+		}
+		if opts.Name != "web" || opts.LoadBalancerType == nil || opts.LoadBalancerType.Name != defaultLoadBalancerType ||
+			opts.NetworkZone != hcloud.NetworkZoneEUCentral || opts.Location != nil {
+			t.Errorf("%v: unexpected load balancer %v of type %v in %v/%v", test.name, opts.Name, opts.LoadBalancerType,
+				opts.NetworkZone, opts.Location)
+		}
+		if len(opts.Services) != 1 || opts.Services[0].Protocol != hcloud.LoadBalancerServiceProtocolHTTPS ||
+			opts.Services[0].HTTP == nil {
+			t.Fatalf("%v: expected a single https service, got %v", test.name, opts.Services)
+		}
+		var certs []int64
+		for _, cert := range opts.Services[0].HTTP.Certificates {
+			certs = append(certs, cert.ID)
+		}
+		if !slices.Equal(certs, test.certs) {
+			t.Errorf("%v: expected certificates %v, got %v", test.name, test.certs, certs)
+		}
+		if !slices.Equal(created, test.created) {
+			t.Errorf("%v: expected certificates %v to be created, got %v", test.name, test.created, created)
+		}
+	}
+}
+
+func TestRegisterLoadBalancerTargets(t *testing.T) {
+	status := "error"
+	var targets []int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/load_balancers":
+			_, _ = io.WriteString(w, `{"load_balancers": [{"id": 7, "name": "web"}], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		case "/load_balancers/7/actions/add_target":
+			var body struct {
+				Server struct {
+					ID int64 `json:"id"`
+				} `json:"server"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			targets = append(targets, body.Server.ID)
+			_, _ = io.WriteString(w, `{"action": {"id": 1, "command": "add_target", "status": "running"}}`)
+		case "/actions/1":
+			_, _ = fmt.Fprintf(w, `{"action": {"id": 1, "command": "add_target", "status": %q,
+				"error": {"code": "action_failed", "message": "target unhealthy"}}}`, status)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	newDriver := func() *Driver {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagLBTarget: "web"})); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		d.APIEndpoint = srv.URL
+		return d
+	}
+	server := &hcloud.Server{ID: 1, Name: "node-1"}
+
+	// the registration is tracked even if waiting for it fails, so removing the machine deregisters the target
+	d := newDriver()
+	if err := d.registerLoadBalancerTargets(server); err == nil {
+		t.Error("expected the failed registration to be reported")
+	}
+	if !slices.Equal(d.LoadBalancerIDs, []int64{7}) || !slices.Equal(targets, []int64{1}) {
+		t.Errorf("expected server 1 to be registered and tracked, got targets %v, IDs %v", targets, d.LoadBalancerIDs)
+	}
+
+	// a resumed creation does not register the server again
+	if err := d.registerLoadBalancerTargets(server); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(targets) != 1 {
+		t.Errorf("expected a tracked registration to be skipped, got targets %v", targets)
+	}
+
+	status = "success"
+	d = newDriver()
+	if err := d.registerLoadBalancerTargets(server); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(d.LoadBalancerIDs, []int64{7}) {
+		t.Errorf("expected the registration to be tracked, got %v", d.LoadBalancerIDs)
+	}
+}
+
+func TestLoadBalancerPrivateIP(t *testing.T) {
+	network := 6
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/load_balancers":
+			_, _ = fmt.Fprintf(w, `{"load_balancers": [{"id": 7, "name": "web", "private_net": [{"network": %d, "ip": "10.0.0.2"}]}],
+				"meta": {"pagination": {"page": 1, "last_page": 1}}}`, network)
+		case "/load_balancers/7/actions/add_target":
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			bodies = append(bodies, body)
+			_, _ = io.WriteString(w, `{"action": {"id": 1, "command": "add_target", "status": "success"}}`)
+		case "/actions/1":
+			_, _ = io.WriteString(w, `{"action": {"id": 1, "command": "add_target", "status": "success"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	newDriver := func() *Driver {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+			flagLBTarget:       "web",
+			flagLBUsePrivateIP: true,
+			flagNetworks:       []string{"net"},
+		})); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		d.APIEndpoint = srv.URL
+		d.cachedNetworks = []*hcloud.Network{{ID: 5, Name: "net"}}
+		return d
+	}
+	server := &hcloud.Server{ID: 1, Name: "node-1"}
+
+	d := newDriver()
+	if err := d.registerLoadBalancerTargets(server); err == nil || !strings.Contains(err.Error(), "not attached to any network") {
+		t.Errorf("expected a load balancer outside the server's networks to be refused, got %v", err)
+	}
+	if len(bodies) != 0 || len(d.LoadBalancerIDs) != 0 {
+		t.Errorf("expected the server not to be registered, got %v", bodies)
+	}
+
+	network = 5
+	d = newDriver()
+	if err := d.registerLoadBalancerTargets(server); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(bodies) != 1 || bodies[0]["use_private_ip"] != true {
+		t.Errorf("expected the server to be registered by its private IP, got %v", bodies)
+	}
+}
+
+func TestDeregisterLoadBalancerTargets(t *testing.T) {
+	var removed []string
+	deleted := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		lb := func(id int, targets string) string {
+			return fmt.Sprintf(`{"load_balancer": {"id": %d, "name": "lb-%d", "targets": [%s]}}`, id, id, targets)
+		}
+		target := `{"type": "server", "server": {"id": 1}}`
+		switch r.URL.Path {
+		case "/servers/1":
+			if r.Method == http.MethodDelete {
+				deleted = true
+				_, _ = io.WriteString(w, `{"action": {"id": 1, "command": "delete_server", "status": "success"}}`)
+				return
+			}
+			_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1"}}`)
+		case "/load_balancers/8":
+			_, _ = io.WriteString(w, lb(8, `{"type": "server", "server": {"id": 2}}`))
+		case "/load_balancers/9":
+			_, _ = io.WriteString(w, lb(9, target))
+		case "/load_balancers/10":
+			_, _ = io.WriteString(w, lb(10, target))
+		case "/load_balancers/9/actions/remove_target":
+			removed = append(removed, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `{"error": {"code": "forbidden", "message": "insufficient permissions"}}`)
+		case "/load_balancers/10/actions/remove_target":
+			removed = append(removed, r.URL.Path)
+			_, _ = io.WriteString(w, `{"action": {"id": 2, "command": "remove_target", "status": "success"}}`)
+		case "/actions/1", "/actions/2":
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %v, "status": "success"}}`, path.Base(r.URL.Path))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error": {"code": "not_found", "message": "not found"}}`)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.MachineName = "node-1"
+	d.ServerID = 1
+	// 7 is gone, the server is no target of 8 anymore, and deregistering from 9 fails
+	d.LoadBalancerIDs = []int64{7, 8, 9, 10}
+
+	if err := d.Remove(); err != nil {
+		t.Fatalf("expected failures to deregister not to fail the removal, got %v", err)
+	}
+	if !deleted {
+		t.Error("expected the server to be deleted")
+	}
+	if !slices.Equal(removed, []string{"/load_balancers/9/actions/remove_target", "/load_balancers/10/actions/remove_target"}) {
+		t.Errorf("expected the server to be deregistered from the load balancers it is a target of, got %v", removed)
+	}
+}
+
+func TestLoadBalancerSelector(t *testing.T) {
+	var selectors, registered []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		page := `"meta": {"pagination": {"page": 1, "last_page": 1}}`
+		switch {
+		case r.URL.Path == "/load_balancers" && r.URL.Query().Has("name"):
+			_, _ = fmt.Fprintf(w, `{"load_balancers": [{"id": 7, "name": "web"}], %s}`, page)
+		case r.URL.Path == "/load_balancers":
+			selectors = append(selectors, r.URL.Query().Get("label_selector"))
+			_, _ = fmt.Fprintf(w, `{"load_balancers": [{"id": 7, "name": "web"}, {"id": 8, "name": "web-2"}], %s}`, page)
+		case strings.HasSuffix(r.URL.Path, "/actions/add_target"):
+			registered = append(registered, r.URL.Path)
+			_, _ = io.WriteString(w, `{"action": {"id": 1, "command": "add_target", "status": "success"}}`)
+		case r.URL.Path == "/actions/1":
+			_, _ = io.WriteString(w, `{"action": {"id": 1, "command": "add_target", "status": "success"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagLBTarget:   "web",
+		flagLBSelector: "role=web",
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.APIEndpoint = srv.URL
+
+	if err := d.registerLoadBalancerTargets(&hcloud.Server{ID: 1, Name: "node-1"}); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(selectors, []string{"role=web"}) {
+		t.Errorf("expected the load balancers to be listed by the selector, got %v", selectors)
+	}
+	if !slices.Equal(registered, []string{"/load_balancers/7/actions/add_target", "/load_balancers/8/actions/add_target"}) {
+		t.Errorf("expected the server to be registered once at each load balancer, got %v", registered)
+	}
+	if !slices.Equal(d.LoadBalancerIDs, []int64{7, 8}) {
+		t.Errorf("expected both registrations to be tracked, got %v", d.LoadBalancerIDs)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 1; attempt <= 10; attempt++ {
+		limit := retryBaseDelay << (attempt - 1)
+		if limit > retryMaxDelay {
+			limit = retryMaxDelay
+		}
+
+		delay := retryDelay(attempt, "")
+		if delay < limit/2 || delay > limit {
+			t.Errorf("attempt %d: delay %v not within [%v, %v]", attempt, delay, limit/2, limit)
+		}
+	}
+
+	if delay := retryDelay(1, "7"); delay != 7*time.Second {
+		t.Errorf("Retry-After was not honored: %v", delay)
+	}
+
+	if !isRetryableStatus(429) || !isRetryableStatus(503) || isRetryableStatus(404) {
+		t.Error("unexpected retryable status classification")
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tc := range []struct {
+		method string
+		status int
+		err    error
+		ctx    context.Context
+		retry  bool
+	}{
+		{http.MethodGet, 503, nil, nil, true},
+		{http.MethodDelete, 502, nil, nil, true},
+		{http.MethodPost, 503, nil, nil, false},
+		{http.MethodPost, 429, nil, nil, true},
+		{http.MethodGet, 404, nil, nil, false},
+		{http.MethodPut, 0, io.ErrUnexpectedEOF, nil, true},
+		{http.MethodPost, 0, io.ErrUnexpectedEOF, nil, false},
+		{http.MethodGet, 0, context.Canceled, canceled, false},
+	} {
+		req := httptest.NewRequest(tc.method, "/servers", nil)
+		if tc.ctx != nil {
+			req = req.WithContext(tc.ctx)
+		}
+		var resp *http.Response
+		if tc.err == nil {
+			resp = &http.Response{StatusCode: tc.status}
+		}
+		if retry := shouldRetry(req, resp, tc.err); retry != tc.retry {
+			t.Errorf("%v %d %v: expected retry %v, got %v", tc.method, tc.status, tc.err, tc.retry, retry)
+		}
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	now := time.Now()
+	state := &rateLimitState{}
+	if delay := state.delay(now); delay != 0 {
+		t.Errorf("unexpected delay without budget info: %v", delay)
+	}
+
+	state.update(map[string][]string{
+		"Ratelimit-Limit":     {"3600"},
+		"Ratelimit-Remaining": {"1000"},
+		"Ratelimit-Reset":     {strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+	})
+	if delay := state.delay(now); delay != 0 {
+		t.Errorf("unexpected delay with plenty of budget: %v", delay)
+	}
+
+	state.remaining = 100
+	if delay := state.delay(now); delay <= 0 || delay > 2*time.Second {
+		t.Errorf("unexpected delay with nearly exhausted budget: %v", delay)
+	}
+}
+
+func TestWaitInterval(t *testing.T) {
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagWaitOnPolling: 3,
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.getWaitInterval() != 3*time.Second {
+		t.Errorf("expected wait-on-polling to apply, got %v", d.getWaitInterval())
+	}
+
+	d = NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagWaitOnPolling: 3,
+		flagWaitInterval:  "250ms",
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.getWaitInterval() != 250*time.Millisecond {
+		t.Errorf("expected wait interval to override, got %v", d.getWaitInterval())
+	}
+
+	d = NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagWaitInterval: "soon",
+	})); err == nil {
+		t.Error("expected invalid wait interval to fail")
+	}
+}
+
+func TestDebugSummary(t *testing.T) {
+	summary := summarizeResponse([]byte(`{"server": {}, "action": {"id": 13, "command": "create_server"},
+		"next_actions": [{"id": 14, "command": "start_server"}]}`))
+	if strings.Join(summary, ", ") != "action create_server[13], action start_server[14]" {
+		t.Errorf("unexpected summary: %v", summary)
+	}
+
+	summary = summarizeResponse([]byte(`{"error": {"code": "locked", "message": "server is locked"}}`))
+	if strings.Join(summary, ", ") != "error locked: server is locked" {
+		t.Errorf("unexpected summary: %v", summary)
+	}
+
+	transport := &debugTransport{token: "secret"}
+	if redacted := transport.redact("token secret rejected"); strings.Contains(redacted, "secret") {
+		t.Errorf("token was not redacted: %v", redacted)
+	}
+}
+
+func TestAPITokenFile(t *testing.T) {
+	file := t.TempDir() + string(os.PathSeparator) + "token"
+	if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPIToken:     "",
+		flagAPITokenFile: file,
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.AccessToken != "" {
+		t.Error("token from file must not be stored")
+	}
+	if d.getAccessToken() != "from-file" {
+		t.Errorf("expected token from file, got %v", d.getAccessToken())
+	}
+
+	d = NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPITokenFile: file,
+	}))
+	assertMutualExclusion(t, err, flagAPIToken, flagAPITokenFile)
+
+	d = NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPIToken: "",
+	})); err == nil {
+		t.Error("expected missing token to fail")
+	}
+}
+
+func TestAPITokenCmd(t *testing.T) {
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPIToken:    "",
+		flagAPITokenCmd: "echo from-cmd",
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.getAccessToken() != "from-cmd" {
+		t.Errorf("expected token from command, got %v", d.getAccessToken())
+	}
+
+	// a failing command is run once per process, not for every API client
+	runs := filepath.Join(t.TempDir(), "runs")
+	d = NewDriver("test")
+	d.APITokenCmd = "echo run >>" + runs + "; echo denied >&2; exit 1"
+	for i := 0; i < 3; i++ {
+		if token := d.getAccessToken(); token != "" {
+			t.Errorf("expected no token, got %v", token)
+		}
+	}
+	if raw, _ := os.ReadFile(runs); strings.Count(string(raw), "run") != 1 {
+		t.Errorf("expected the token command to run once, got %q", raw)
+	}
+	if d.tokenErr == nil || !strings.Contains(d.tokenErr.Error(), "denied") {
+		t.Errorf("expected the failure to be kept, got %v", d.tokenErr)
+	}
+}
+
+func TestVerifyTokenWritable(t *testing.T) {
+	var code string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/ssh_keys/0" {
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		status := map[string]int{"forbidden": http.StatusForbidden, "not_found": http.StatusNotFound}[code]
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, `{"error": {"code": %q, "message": "test"}}`, code)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	log.SetErrWriter(&out)
+	log.SetDebug(true)
+	defer func() {
+		log.SetErrWriter(os.Stderr)
+		log.SetDebug(false)
+	}()
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+
+	code = "forbidden"
+	if err := d.verifyTokenWritable(); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("expected a read-only token to be rejected, got %v", err)
+	}
+
+	// none of these prove the token writable, so the creation goes on without claiming it is
+	for _, code = range []string{"not_found", "invalid_input", "json_error"} {
+		out.Reset()
+		if err := d.verifyTokenWritable(); err != nil {
+			t.Errorf("expected %v not to fail the creation, got %v", code, err)
+		}
+		if !strings.Contains(out.String(), "permissions unknown") {
+			t.Errorf("expected %v to leave the permissions unknown, got %q", code, out.String())
+		}
+	}
+}
+
+func TestVerifyServerTypeAvailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/locations":
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, `{"error": {"code": "unauthorized", "message": "unable to authenticate"}}`)
+		case "/datacenters":
+			_, _ = io.WriteString(w, `{"datacenters": [
+				{"id": 1, "name": "nbg1-dc3", "location": {"id": 1, "name": "nbg1"},
+					"server_types": {"supported": [1, 2], "available": [1], "available_for_migration": []}},
+				{"id": 2, "name": "fsn1-dc14", "location": {"id": 2, "name": "fsn1"},
+					"server_types": {"supported": [1, 2, 3], "available": [1, 2, 3], "available_for_migration": []}}
+			], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.AccessToken = "available"
+	d.APIEndpoint = srv.URL
+	d.cachedLocation = &hcloud.Location{ID: 1, Name: "nbg1"}
+
+	for id, want := range map[int64]string{
+		1: "",
+		2: "server type cx2 is currently unavailable in nbg1",
+		3: "server type cx3 is not offered in nbg1",
+	} {
+		d.cachedType = &hcloud.ServerType{ID: id, Name: fmt.Sprintf("cx%d", id)}
+		err := d.verifyServerTypeAvailable()
+		if want == "" && err != nil {
+			t.Errorf("expected server type %d to be available, got %v", id, err)
+		} else if want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+			t.Errorf("expected %q for server type %d, got %v", want, id, err)
+		}
+	}
+
+	if err := d.verifyToken(); err == nil || !strings.Contains(err.Error(), "token is invalid") {
+		t.Errorf("expected the invalid token to be reported, got %v", err)
+	}
+}
+
+func TestAPICAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"locations": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalidFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPICAFile: invalidFile,
+	})); err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Errorf("expected the invalid CA file to be refused, got %v", err)
+	}
+
+	// the endpoint's self-signed certificate is only trusted given the CA
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.APIMaxAttempts = 1
+	if err := d.verifyToken(); err == nil {
+		t.Error("expected the untrusted certificate to be refused")
+	}
+
+	d = NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPICAFile:   caFile,
+		flagAPIEndpoint: srv.URL,
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err := d.verifyToken(); err != nil {
+		t.Errorf("expected the endpoint to be trusted with the CA, got %v", err)
+	}
+}
+
+func TestAPIApplication(t *testing.T) {
+	var agents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		agents = append(agents, r.UserAgent())
+		_, _ = io.WriteString(w, `{"locations": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+	}))
+	defer srv.Close()
+
+	if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPIApplication: "/1.0",
+	})); err == nil {
+		t.Error("expected an application without name to be refused")
+	}
+
+	for raw, want := range map[string]string{
+		"":                 defaultAPIApplication + "/1.2.3",
+		"gitlab-runner":    "gitlab-runner",
+		"rancher/2.8.1":    "rancher/2.8.1",
+		"ci/build/42-beta": "ci/build/42-beta",
+	} {
+		d := NewDriver("1.2.3")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+			flagAPIApplication: raw,
+			flagAPIEndpoint:    srv.URL,
+		})); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if name, version := d.getAPIApplication(); strings.TrimSuffix(name+"/"+version, "/") != want {
+			t.Errorf("expected %q to be parsed as %v, got %v, %v", raw, want, name, version)
+		}
+
+		agents = nil
+		if err := d.verifyToken(); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if len(agents) != 1 || !strings.HasPrefix(agents[0], want+" ") {
+			t.Errorf("expected the user agent to start with %v, got %v", want, agents)
+		}
+	}
+}
+
+func TestAPIProxy(t *testing.T) {
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPIProxy: "socks5://proxy.example.com:1080",
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	transport, ok := d.getBaseTransport().(*http.Transport)
+	if !ok {
+		t.Fatal("expected a dedicated transport for the proxy")
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://api.hetzner.cloud/v1/servers", nil)
+	if proxy, err := transport.Proxy(req); err != nil || proxy.String() != d.APIProxy {
+		t.Errorf("expected requests to use the proxy, got %v (%v)", proxy, err)
+	}
+
+	d = NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPIProxy: "ftp://proxy.example.com",
+	})); err == nil {
+		t.Error("expected unsupported proxy scheme to fail")
+	}
+}
+
+func TestActionTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"action": {"id": 1, "command": "create_image", "status": "running", "progress": 10}}`)
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.ActionTimeout = 1
+	d.WaitInterval = 10 * time.Millisecond
+
+	start := time.Now()
+	err := d.waitForAction(&hcloud.Action{ID: 1, Command: "create_image"})
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "exceeded --"+flagActionTimeout+" of 1s") {
+		t.Errorf("expected the action timeout to be named, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected waiting to stop after the action timeout, took %v", elapsed)
+	}
+}
+
+func TestRetryLocked(t *testing.T) {
+	d := NewDriver("test")
+
+	attempts := 0
+	res, _, err := retryLocked(d, func() (int, *hcloud.Response, error) {
+		attempts++
+		if attempts == 1 {
+			return 0, nil, hcloud.Error{Code: hcloud.ErrorCodeLocked, Message: "server is locked"}
+		}
+		return 42, nil, nil
+	})
+	if err != nil || res != 42 || attempts != 2 {
+		t.Errorf("expected request to succeed once unlocked, got %v after %d attempts (%v)", res, attempts, err)
+	}
+
+	attempts = 0
+	_, _, err = retryLocked(d, func() (int, *hcloud.Response, error) {
+		attempts++
+		return 0, nil, hcloud.Error{Code: hcloud.ErrorCodeForbidden}
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("expected other errors to fail right away, got %d attempts (%v)", attempts, err)
+	}
+}
+
+func TestDescribeAction(t *testing.T) {
+	action := &hcloud.Action{ID: 13, Command: "attach_volume", Resources: []*hcloud.ActionResource{
+		{ID: 42, Type: hcloud.ActionResourceTypeServer},
+		{ID: 7, Type: hcloud.ActionResourceTypeVolume},
+	}}
+	if desc := describeAction(action); desc != "action attach_volume[13] on server 42, volume 7" {
+		t.Errorf("unexpected description: %v", desc)
+	}
+}
+
+func TestCreateSlots(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	first := NewDriver("first")
+	first.AccessToken, first.maxParallelCreates = "foo", 1
+	release, err := first.acquireCreateSlot()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	second := NewDriver("second")
+	second.AccessToken, second.maxParallelCreates = "foo", 1
+	second.beginOperation(100 * time.Millisecond)
+	if _, err = second.acquireCreateSlot(); err == nil {
+		t.Error("expected the second creation to wait for a free slot")
+	}
+	second.endOperation()
+
+	release()
+	if releaseSecond, err := second.acquireCreateSlot(); err != nil {
+		t.Errorf("expected released slot to be free, got %v", err)
+	} else {
+		releaseSecond()
+	}
+}
+
+func TestFastCreate(t *testing.T) {
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagFastCreate: true,
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.getWaitInterval() != fastCreateWaitInterval {
+		t.Errorf("expected fast creation to poll faster, got %v", d.getWaitInterval())
+	}
+
+	d = NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagFastCreate:   true,
+		flagWaitInterval: "2s",
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.getWaitInterval() != 2*time.Second {
+		t.Errorf("expected explicit wait interval to take precedence, got %v", d.getWaitInterval())
+	}
+}
+
+func TestCachedLookup(t *testing.T) {
+	lookups := 0
+	lookup := func(d *Driver, key string) string {
+		value, err := cachedLookup(d, catalogLocation, key, func() (string, error) {
+			lookups++
+			return fmt.Sprintf("%v-%d", key, lookups), nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		return value
+	}
+
+	d := NewDriver("test")
+	d.AccessToken = "catalog-a"
+	other := NewDriver("test")
+	other.AccessToken = "catalog-b"
+	d.invalidateCatalog(catalogLocation)
+	other.invalidateCatalog(catalogLocation)
+
+	if first, second := lookup(d, "nbg1"), lookup(d, "nbg1"); first != second || lookups != 1 {
+		t.Errorf("expected the second lookup to be cached, got %v, %v after %d lookups", first, second, lookups)
+	}
+	if lookup(other, "nbg1"); lookups != 2 {
+		t.Errorf("expected lookups to be cached per token, got %d lookups", lookups)
+	}
+
+	if _, err := cachedLookup(d, catalogLocation, "fsn1", func() (string, error) {
+		return "", errors.New("unavailable")
+	}); err == nil {
+		t.Error("expected the failed lookup to be reported")
+	}
+	if lookup(d, "fsn1"); lookups != 3 {
+		t.Errorf("expected failed lookups not to be cached, got %d lookups", lookups)
+	}
+
+	// expired entries are looked up again
+	catalog.Lock()
+	k := catalogKey("catalog-a", catalogLocation, "nbg1")
+	catalog.entries[k] = catalogEntry{value: catalog.entries[k].value, expires: time.Now().Add(-time.Second)}
+	catalog.Unlock()
+	if lookup(d, "nbg1"); lookups != 4 {
+		t.Errorf("expected the expired entry to be looked up again, got %d lookups", lookups)
+	}
+
+	// invalidating affects the token's entries of that kind only
+	d.invalidateCatalog(catalogLocation)
+	lookup(d, "nbg1")
+	lookup(other, "nbg1")
+	if lookups != 5 {
+		t.Errorf("expected only the invalidated entry to be looked up again, got %d lookups", lookups)
+	}
+}
+
+func TestUserDataTemplateVariables(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagUserData:         `{{ .MachineName }} {{ .Labels.role }} {{ .PublicIPv4 }}`,
+		flagUserDataTemplate: true,
+		flagServerLabel:      []string{"role=worker"},
+		flagPrimary4:         "1.2.3.4",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d.MachineName = "node-1"
+	d.cachedPrimaryIPv4 = &hcloud.PrimaryIP{ID: 42, IP: net.ParseIP("1.2.3.4")}
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if data != "node-1 worker 1.2.3.4" {
+		t.Errorf("unexpected rendered user data: %v", data)
+	}
+
+	// checked with a placeholder before the primary IP is created
+	d.PrimaryIPv4, d.cachedPrimaryIPv4 = "", nil
+	if data, err = d.renderUserData(); err != nil || data != "node-1 worker "+publicIPv4Placeholder {
+		t.Errorf("expected the public IPv4 to be a placeholder, got %v, %v", data, err)
+	}
+	d.DisablePublic4 = true
+	if _, err = d.renderUserData(); err == nil || !strings.Contains(err.Error(), flagDisablePublic4) {
+		t.Errorf("expected missing public IPv4 to fail, got %v", err)
+	}
+	d.DisablePublic4 = false
+
+	for userData, expected := range map[string]bool{
+		`{{ .MachineName }}`:                 true,
+		`{{- range .Volumes }}`:              true,
+		`{{ index .Labels "a/b" }}`:          true,
+		`{{ (volume "data").Device }}`:       true,
+		`docker ps --format '{{.Names}}'`:    false,
+		`{{ v1.local_hostname }}`:            false,
+		`echo {{ .PublicIPv4 }} > /etc/motd`: true,
+	} {
+		if templateReference.MatchString(userData) != expected {
+			t.Errorf("expected %q to be detected as template %v", userData, expected)
+		}
+	}
+
+	d.userData = `{{ .Labels.missing }}`
+	if _, err = d.renderUserData(); err == nil {
+		t.Error("expected missing label to fail")
+	}
+}
+
+func TestUserDataPrimaryIPv4(t *testing.T) {
+	var created hcloud.PrimaryIPCreateOpts
+	var deleted bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/datacenters":
+			_, _ = io.WriteString(w, `{"datacenters": [
+				{"id": 1, "name": "fsn1-dc14", "location": {"id": 1, "name": "fsn1"}, "server_types": {"available": [1]}},
+				{"id": 2, "name": "hel1-dc1", "location": {"id": 3, "name": "hel1"}, "server_types": {"available": [2]}},
+				{"id": 3, "name": "hel1-dc2", "location": {"id": 3, "name": "hel1"}, "server_types": {"available": [1, 2]}}],
+				"meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/primary_ips":
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				t.Errorf("unexpected body, %v", err)
+			}
+			_, _ = io.WriteString(w, `{"primary_ip": {"id": 42, "ip": "192.0.2.42", "type": "ipv4"}}`)
+		case r.Method == http.MethodDelete && r.URL.Path == "/primary_ips/42":
+			deleted = true
+			_, _ = io.WriteString(w, `{}`)
+		default:
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagUserData:         `address: {{ .PublicIPv4 }}`,
+		flagUserDataTemplate: true,
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	d.cachedType = &hcloud.ServerType{ID: 1, Name: "cx22"}
+	if err := d.InitMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.invalidateCatalog(catalogDatacenters)
+
+	if err := d.createUserDataPrimaryIPv4(); err == nil || !strings.Contains(err.Error(), flagLocation) {
+		t.Errorf("expected the primary IP to require a location, got %v", err)
+	}
+
+	d.Location, d.cachedLocation = "hel1", &hcloud.Location{ID: 3, Name: "hel1"}
+	if err := d.createUserDataPrimaryIPv4(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if created.Datacenter != "hel1-dc2" || created.AutoDelete == nil || !*created.AutoDelete ||
+		created.Labels[d.labelName(labelMachine)] != "node-1" {
+		t.Errorf("expected a labeled primary IP deleted along with the server in hel1, got %+v", created)
+	}
+
+	d.cachedType = &hcloud.ServerType{ID: 3, Name: "ccx63"}
+	if err := d.createUserDataPrimaryIPv4(); err == nil || !strings.Contains(err.Error(), "ccx63") {
+		t.Errorf("expected the primary IP to require a datacenter hosting the type, got %v", err)
+	}
+	d.cachedType = &hcloud.ServerType{ID: 1, Name: "cx22"}
+
+	var opts hcloud.ServerCreateOpts
+	if err := d.setPublicNetIfRequired(&opts); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if opts.PublicNet == nil || opts.PublicNet.IPv4 == nil || opts.PublicNet.IPv4.ID != 42 {
+		t.Errorf("expected the server to be created with the primary IP, got %+v", opts.PublicNet)
+	}
+	if data, err := d.renderUserData(); err != nil || data != "address: 192.0.2.42" {
+		t.Errorf("expected the address to be rendered, got %v, %v", data, err)
+	}
+
+	d.destroyDangling()
+	if !deleted || d.PrimaryIPv4ID != 0 {
+		t.Errorf("expected the primary IP to be rolled back, got %v, %d", deleted, d.PrimaryIPv4ID)
+	}
+
+	// nothing is created for templates not referring to the address, or a given primary IP
+	for _, flags := range []map[string]interface{}{
+		{flagUserData: `{{ .MachineName }}`, flagUserDataTemplate: true},
+		{flagUserData: `{{ .PublicIPv4 }}`},
+		{flagUserData: `{{ .PublicIPv4 }}`, flagUserDataTemplate: true, flagPrimary4: "1.2.3.4"},
+	} {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(flags)); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if needed, err := d.needsUserDataPrimaryIPv4(); err != nil || needed {
+			t.Errorf("expected no primary IP to be created for %v, got %v, %v", flags, needed, err)
+		}
+	}
+}
+
+func TestMergeDedupe(t *testing.T) {
+	base := "#cloud-config\npackages: [curl, jq]\nruncmd:\n  - [systemctl, restart, docker]\n"
+	additional := "packages: [jq, git]\nruncmd:\n  - [systemctl, restart, docker]\n"
+
+	merged, err := mergeYAMLDocs(additional, base, true)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	var doc map[string][]interface{}
+	if err := yaml.Unmarshal([]byte(merged), &doc); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(doc["packages"]) != 3 || len(doc["runcmd"]) != 1 {
+		t.Errorf("expected duplicates to be dropped, got %v", merged)
+	}
+
+	if merged, err = mergeYAMLDocs(additional, base, false); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err := yaml.Unmarshal([]byte(merged), &doc); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(doc["packages"]) != 4 || len(doc["runcmd"]) != 2 {
+		t.Errorf("expected duplicates to be kept by default, got %v", merged)
+	}
+}
+
+func TestValidateUserData(t *testing.T) {
+	valid := "#cloud-config\npackages: [curl]\npackage_update: true\nwrite_files:\n  - path: /etc/motd\n    content: hi\n"
+	if err := validateUserData(valid); err != nil {
+		t.Errorf("unexpected error, %v", err)
+	}
+
+	invalid := "#cloud-config\npackages: curl\npackage_update: true\nwrite_files:\n  - content: hi\n"
+	err := validateUserData(invalid)
+	if err == nil || !strings.Contains(err.Error(), "line 2: packages") || !strings.Contains(err.Error(), "line 5: write_files") {
+		t.Errorf("expected line-level errors, got %v", err)
+	}
+
+	if err = validateUserData("#cloud-config\npackages: [curl\n"); err == nil {
+		t.Error("expected broken YAML to fail")
+	}
+
+	if err = validateUserData("#!/bin/sh\necho packages: curl\n"); err != nil {
+		t.Errorf("expected scripts not to be validated, got %v", err)
+	}
+}
+
+func TestFitUserData(t *testing.T) {
+	small := "#!/bin/sh\necho hi\n"
+	if data, err := fitUserData(small); err != nil || data != small {
+		t.Errorf("expected small user data to be kept, got %v (%v)", data, err)
+	}
+
+	large := "#!/bin/sh\n" + strings.Repeat("echo hi\n", userDataMaxSize/8)
+	data, err := fitUserData(large)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(data) > userDataMaxSize {
+		t.Errorf("expected user data to be compressed, got %d bytes", len(data))
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if decompressed, _ := io.ReadAll(gz); string(decompressed) != large {
+		t.Error("compressed user data does not match")
+	}
+
+	random := make([]byte, userDataMaxSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	if _, err = fitUserData(base64.StdEncoding.EncodeToString(random)); err == nil {
+		t.Error("expected incompressible user data to fail")
+	}
+}
+
+func TestIgnition(t *testing.T) {
+	const config = `{"ignition":{"version":"3.4.0"},"passwd":{"users":[{"name":"core","groups":["docker"]}]}}`
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagIgnition: config,
+		flagUserData: "#cloud-config",
+	}))
+	if err == nil {
+		t.Error("expected Ignition and user data to be mutually exclusive")
+	}
+
+	d = NewDriver("test")
+	err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagIgnition:       config,
+		flagSshUser:        defaultSSHUser,
+		flagAdditionalKeys: []string{"ssh-ed25519 BBBB additional"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.GetSSHUsername() != ignitionUser {
+		t.Errorf("expected SSH user %v, got %v", ignitionUser, d.GetSSHUsername())
+	}
+
+	d.SSHKeyPath = t.TempDir() + "/id_rsa"
+	if err = os.WriteFile(d.SSHKeyPath+".pub", []byte("ssh-ed25519 AAAA machine\n"), 0600); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	expected := `{"ignition":{"version":"3.4.0"},"passwd":{"users":[{"groups":["docker"],"name":"core",` +
+		`"sshAuthorizedKeys":["ssh-ed25519 AAAA machine","ssh-ed25519 BBBB additional"]}]}}`
+	if data != expected {
+		t.Errorf("unexpected Ignition config: %v", data)
+	}
+
+	d.userData = `{"passwd":{}}`
+	if _, err = d.renderUserData(); err == nil {
+		t.Error("expected config without version to fail")
+	}
+}
+
+func TestCombustion(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagIgnition:   `{"ignition":{"version":"3.4.0"}}`,
+		flagCombustion: "#!/bin/bash",
+	}))
+	assertMutualExclusion(t, err, flagIgnition, flagCombustion)
+
+	d = NewDriver("test")
+	err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagCombustion:     "#!/bin/bash\n# combustion: prepare\necho hello\nexit 0\n",
+		flagAdditionalKeys: []string{"ssh-ed25519 BBBB additional"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d.SSHKeyPath = t.TempDir() + "/id_rsa"
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	expected := "#!/bin/bash\n# combustion: prepare\n" + combustionNetwork + "\n" +
+		fmt.Sprintf(combustionSetup, "ssh-ed25519 BBBB additional") + "echo hello\nexit 0\n"
+	if data != expected {
+		t.Errorf("unexpected Combustion script: %v", data)
+	}
+
+	d.userData = "echo missing interpreter"
+	if _, err = d.renderUserData(); err == nil {
+		t.Error("expected script without interpreter line to fail")
+	}
+	if d.writableRoot {
+		t.Error("expected the root filesystem to stay read-only by default")
+	}
+
+	d = NewDriver("test")
+	if err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagCombustion:   "#!/bin/bash",
+		flagWritableRoot: true,
+	})); err != nil || !d.writableRoot {
+		t.Errorf("expected the root filesystem to be made writable, got %v, %v", d.writableRoot, err)
+	}
+	if err = NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagWritableRoot: true,
+	})); err == nil {
+		t.Errorf("expected --%v to require Combustion", flagWritableRoot)
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	source := t.TempDir() + "/ca.crt"
+	if err := os.WriteFile(source, []byte("certificate\n"), 0600); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	for _, arg := range []string{
+		"path=/etc/ca.crt",
+		"source=" + source + ",path=etc/ca.crt",
+		"source=" + source + ",path=/etc/ca.crt,mode=rw",
+		"source=" + source + ",path=/etc/ca.crt,owner=root",
+	} {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagWriteFile: []string{arg}})); err == nil {
+			t.Errorf("expected write file %v to fail", arg)
+		}
+	}
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagWriteFile: []string{"source=" + source + ",path=/etc/docker/certs.d/registry/ca.crt,mode=0600"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	var config struct {
+		WriteFiles []map[string]string `yaml:"write_files"`
+	}
+	if err = yaml.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	expected := map[string]string{
+		"path":        "/etc/docker/certs.d/registry/ca.crt",
+		"permissions": "0600",
+		"encoding":    "b64",
+		"content":     base64.StdEncoding.EncodeToString([]byte("certificate\n")),
+	}
+	if len(config.WriteFiles) != 1 || fmt.Sprint(config.WriteFiles[0]) != fmt.Sprint(expected) {
+		t.Errorf("unexpected write_files in user data: %v", data)
+	}
+}
+
+func TestPackages(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagPackages: []string{"curl, jq", "git"},
+		flagUserData: "#cloud-config\npackages: [htop]\npackage_update: false\n",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	var config struct {
+		Packages      []string `yaml:"packages"`
+		PackageUpdate bool     `yaml:"package_update"`
+	}
+	if err = yaml.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if strings.Join(config.Packages, " ") != "curl jq git htop" {
+		t.Errorf("unexpected packages in user data: %v", data)
+	}
+	if config.PackageUpdate {
+		t.Errorf("expected package_update of the user data to take precedence: %v", data)
+	}
+}
+
+func TestUserDataURL(t *testing.T) {
+	const userData = "#cloud-config\npackages: [htop]\n"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/user-data" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, userData)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(userData))
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagUserDataFile:     srv.URL + "/user-data",
+		flagUserDataChecksum: "sha256:" + hex.EncodeToString(sum[:]),
+		flagSshUser:          defaultSSHUser,
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		data, err := d.renderUserData()
+		if err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if data != userData {
+			t.Errorf("unexpected user data: %v", data)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected user data to be fetched once, got %d requests", requests)
+	}
+
+	d.cachedUserData = nil
+	d.userDataChecksum = hex.EncodeToString(make([]byte, sha256.Size))
+	if _, err = d.renderUserData(); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+
+	d.userDataFile = srv.URL + "/missing"
+	d.userDataChecksum = ""
+	if _, err = d.renderUserData(); err == nil {
+		t.Error("expected missing user data to fail")
+	}
+
+	d = NewDriver("test")
+	err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagUserDataFile:     "user-data.yml",
+		flagUserDataChecksum: hex.EncodeToString(sum[:]),
+	}))
+	if err == nil {
+		t.Error("expected checksum for local user data to fail")
+	}
+}
+
+func TestUserDataDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"10-base.yaml":   "#cloud-config\npackages: [curl]\ntimezone: UTC\n",
+		"20-docker.yml":  "packages: [jq]\nruncmd: [[sysctl, --system]]\n",
+		"README.md":      "not a snippet",
+		".30-hidden.cfg": "packages: [hidden]\n",
+	} {
+		if err := os.WriteFile(dir+"/"+name, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+	}
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagUserDataDir: dir,
+		flagUserData:    "#cloud-config\npackages: [git]\ntimezone: Europe/Berlin\n",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	var config struct {
+		Packages []string `yaml:"packages"`
+		Timezone string   `yaml:"timezone"`
+		Runcmd   []interface{}
+	}
+	if err = yaml.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if strings.Join(config.Packages, " ") != "curl jq git" || config.Timezone != "Europe/Berlin" || len(config.Runcmd) != 1 {
+		t.Errorf("unexpected merged user data: %v", data)
+	}
+
+	d.userData = "#!/bin/sh\necho hello"
+	if _, err = d.renderUserData(); err == nil {
+		t.Error("expected script user data to fail merging with snippets")
+	}
+}
+
+func TestDockerDaemonJSON(t *testing.T) {
+	const daemonJSON = `{"registry-mirrors": ["https://mirror.example.com"], "log-driver": "local"}`
+
+	file := t.TempDir() + "/daemon.json"
+	if err := os.WriteFile(file, []byte(daemonJSON), 0600); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	for _, arg := range []string{daemonJSON, file} {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagDockerDaemonJSON: arg})); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if len(d.writeFiles) != 1 || d.writeFiles[0].path != dockerDaemonJSONPath || string(d.writeFiles[0].content) != daemonJSON {
+			t.Errorf("unexpected write files for %v: %v", arg, d.writeFiles)
+		}
+	}
+
+	for _, arg := range []string{`{"registry-mirrors": `, `{"hosts": ["tcp://0.0.0.0:2375"]}`} {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagDockerDaemonJSON: arg})); err == nil {
+			t.Errorf("expected daemon.json %v to fail", arg)
+		}
+	}
+}
+
+func TestAuthorizedKeys(t *testing.T) {
+	const key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFJHoSeZ8SEdBPzDtTJ7ttGRVbmq0jXA7JkRLVFUEM/j operator"
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagAuthorizedKeys: []string{"ssh-ed25519 broken"}}))
+	if err == nil {
+		t.Error("expected invalid authorized key to fail")
+	}
+
+	d = NewDriver("test")
+	err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAuthorizedKeys: []string{key},
+		flagSshUser:        defaultSSHUser,
+		flagUserData:       "#cloud-config\nssh_authorized_keys: [\"ssh-rsa AAAA existing\"]\n",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	var config struct {
+		Keys []string `yaml:"ssh_authorized_keys"`
+	}
+	if err = yaml.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(config.Keys) != 2 || config.Keys[0] != key {
+		t.Errorf("unexpected authorized keys in user data: %v", data)
+	}
+
+	d.SSHUser = "deploy"
+	if data, err = d.renderUserData(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !strings.Contains(data, "name: deploy") || !strings.Contains(data, "- default") {
+		t.Errorf("expected authorized keys for the SSH user: %v", data)
+	}
+}
+
+func TestMachineMetadata(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagMachineMetadata: true,
+		flagServerLabel:     []string{"role=worker"},
+		flagUserData:        "#cloud-config\nruncmd: [[sh, -c, '. /run/machine-metadata/env']]\n",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d.MachineName = "node-1"
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	var config struct {
+		Bootcmd [][]string `yaml:"bootcmd"`
+		Runcmd  [][]string `yaml:"runcmd"`
+	}
+	if err = yaml.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(config.Bootcmd) != 1 || len(config.Runcmd) != 1 {
+		t.Fatalf("unexpected user data: %v", data)
+	}
+	script := config.Bootcmd[0][2]
+	for _, expected := range []string{"'node-1'", "role=worker\n", "docker-machine/machine=node-1", metadataServiceURL + "/instance-id"} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected %v in metadata script: %v", expected, script)
+		}
+	}
+}
+
+func TestPresets(t *testing.T) {
+	registries := filepath.Join(t.TempDir(), "registries.yaml")
+	if err := os.WriteFile(registries, []byte("mirrors:\n  docker.io:\n    endpoint: [https://mirror.example.com]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range []map[string]interface{}{
+		{flagPreset: "k8s"},
+		{flagPresetRegistries: registries},
+		{flagPreset: presetK3sAgent, flagIgnition: `{"ignition": {"version": "3.4.0"}}`},
+	} {
+		if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(args)); err == nil {
+			t.Errorf("expected %v to fail", args)
+		}
+	}
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagPreset:           presetRKE2,
+		flagPresetRegistries: registries,
+		flagPackages:         []string{"open-iscsi"},
+		flagSshUser:          defaultSSHUser,
+		flagUserData:         "#cloud-config\nruncmd: [[sh, -c, 'curl -sfL https://get.rke2.io | sh -']]\n",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	var config struct {
+		Packages   []string   `yaml:"packages"`
+		Runcmd     [][]string `yaml:"runcmd"`
+		WriteFiles []struct {
+			Path        string `yaml:"path"`
+			Permissions string `yaml:"permissions"`
+			Content     string `yaml:"content"`
+		} `yaml:"write_files"`
+	}
+	if err = yaml.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Contains(config.Packages, "tar") || !slices.Contains(config.Packages, "open-iscsi") {
+		t.Errorf("expected preset and user packages, got %v", config.Packages)
+	}
+	if len(config.Runcmd) != 4 || config.Runcmd[0][0] != "modprobe" || config.Runcmd[3][0] != "sh" {
+		t.Errorf("expected preset commands before user commands, got %v", config.Runcmd)
+	}
+
+	files := make(map[string]string)
+	for _, file := range config.WriteFiles {
+		content, _ := base64.StdEncoding.DecodeString(file.Content)
+		files[file.Path] = file.Permissions + ":" + string(content)
+	}
+	if !strings.Contains(files["/etc/sysctl.d/90-rke2.conf"], "kernel.panic = 10\n") {
+		t.Errorf("expected kernel defaults for RKE2, got %v", files)
+	}
+	if !strings.HasPrefix(files["/etc/rancher/rke2/registries.yaml"], registriesMode+":mirrors:") {
+		t.Errorf("expected registries file, got %v", files)
+	}
+	if files["/etc/modules-load.d/rke2.conf"] != defaultWriteFileMode+":overlay\nbr_netfilter\n" {
+		t.Errorf("unexpected modules, got %v", files)
+	}
+}
+
+func TestExistingKeyFingerprint(t *testing.T) {
+	for _, fp := range []string{"aa:bb", "MD5:aa:bb:cc:dd:ee:ff:00:11:22:33:44:55:66:77:88:99"} {
+		d := NewDriver("test")
+		err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagExKeyFP: fp, flagExKeyPath: "id_ed25519"}))
+		if err == nil {
+			t.Errorf("expected fingerprint %v to fail", fp)
+		}
+	}
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagExKeyFP:   "AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99",
+		flagExKeyPath: "id_ed25519",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !d.IsExistingKey || d.existingKeyFP != "aa:bb:cc:dd:ee:ff:00:11:22:33:44:55:66:77:88:99" {
+		t.Errorf("unexpected existing key fingerprint %v", d.existingKeyFP)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	path := t.TempDir() + "/id_ed25519"
+	if err = os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err = verifyLocalKey(path, &hcloud.SSHKey{PublicKey: string(ssh.MarshalAuthorizedKey(sshPub))}); err != nil {
+		t.Errorf("unexpected error, %v", err)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	otherPub, _ := ssh.NewPublicKey(other)
+	if err = verifyLocalKey(path, &hcloud.SSHKey{PublicKey: string(ssh.MarshalAuthorizedKey(otherPub))}); err == nil {
+		t.Error("expected mismatching key to fail")
+	}
+}
+
+func TestSSHKeyType(t *testing.T) {
+	for _, args := range []map[string]interface{}{
+		{flagSshKeyType: "dsa"},
+		{flagSshKeyType: sshKeyTypeEd25519, flagExKeyPath: "id_rsa"},
+	} {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(args)); err == nil {
+			t.Errorf("expected %v to fail", args)
+		}
+	}
+
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagSshKeyType: sshKeyTypeEd25519})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d.StorePath = t.TempDir()
+	d.MachineName = "node-1"
+	if err := os.MkdirAll(d.ResolveStorePath(""), 0700); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err := d.prepareLocalKey(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !strings.HasSuffix(d.GetSSHKeyPath(), "id_ed25519") {
+		t.Errorf("unexpected key path %v", d.GetSSHKeyPath())
+	}
+
+	local, err := readLocalPublicKey(d.GetSSHKeyPath())
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if local.Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("unexpected key type %v", local.Type())
+	}
+}
+
+func TestUploadKeyRace(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey := string(ssh.MarshalAuthorizedKey(sshPub))
+	fingerprint := ssh.FingerprintLegacyMD5(sshPub)
+
+	concurrent := true
+	var names []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/ssh_keys" && r.Method == http.MethodPost:
+			var body struct {
+				Name string `json:"name"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			names = append(names, body.Name)
+			if len(names) == 1 {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = io.WriteString(w, `{"error": {"code": "uniqueness_error", "message": "SSH key not unique"}}`)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"ssh_key": {"id": 10, "name": %q, "fingerprint": %q}}`, body.Name, fingerprint)
+		case r.URL.Path == "/ssh_keys" && r.URL.Query().Get("fingerprint") == fingerprint && concurrent:
+			_, _ = fmt.Fprintf(w, `{"ssh_keys": [{"id": 9, "name": "node-1", "fingerprint": %q}], "meta": {"pagination": {"page": 1, "last_page": 1}}}`,
+				fingerprint)
+		case r.URL.Path == "/ssh_keys":
+			_, _ = io.WriteString(w, `{"ssh_keys": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	newDriver := func() *Driver {
+		names = nil
+		d := NewDriver("test")
+		d.AccessToken = "foo"
+		d.APIEndpoint = srv.URL
+		return d
+	}
+
+	// a parallel creation uploaded the same key
+	d := newDriver()
+	key, created, err := d.uploadKey("node-1", pubkey, nil)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if key.ID != 9 || created || len(d.dangling) != 0 {
+		t.Errorf("expected the concurrently uploaded key to be reused, got %v (created %v)", key, created)
+	}
+
+	// another key merely carries the name
+	concurrent = false
+	d = newDriver()
+	key, created, err = d.uploadKey("node-1", pubkey, nil)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	suffixed := "node-1-" + strings.ReplaceAll(fingerprint, ":", "")[:8]
+	if key.ID != 10 || !created || !slices.Equal(names, []string{"node-1", suffixed}) {
+		t.Errorf("expected the key to be uploaded as %v, got %v (created %v) after %v", suffixed, key, created, names)
+	}
+}
+
+func TestSSHAgent(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagSSHAgent: true, flagExKeyPath: "id_rsa"}))
+	if err == nil {
+		t.Fatalf("expected %v and %v to be mutually exclusive", flagSSHAgent, flagExKeyPath)
+	}
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	sock := t.TempDir() + "/agent.sock"
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	d = NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagSSHAgent: true})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.StorePath = t.TempDir()
+	d.MachineName = "node-1"
+	if err := d.prepareLocalKey(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.GetSSHKeyPath() != "" {
+		t.Errorf("expected no key path, got %v", d.GetSSHKeyPath())
+	}
+
+	buf, err := d.getLocalPublicKey()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if string(buf) != string(ssh.MarshalAuthorizedKey(pub)) {
+		t.Errorf("unexpected public key %s", buf)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if err := d.prepareLocalKey(); err == nil {
+		t.Error("expected missing agent to fail")
+	}
+}
+
+func TestSharedKeyReferences(t *testing.T) {
+	d := NewDriver("test")
+	d.MachineName = "node-1"
+	d.keyLabels = map[string]string{"team": "infra"}
+
+	labels := d.sharedKeyLabels()
+	if labels["docker-machine/shared-key"] != "true" || labels["docker-machine-ref/node-1"] != "true" || labels["team"] != "infra" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	if _, ok := labels[d.labelName(labelMachine)]; ok {
+		t.Errorf("shared key must not carry a machine label, got %v", labels)
+	}
+
+	labels["docker-machine-ref/node-0"] = "true"
+	refs := keyReferences(&hcloud.SSHKey{Labels: labels})
+	if strings.Join(refs, ",") != "node-0,node-1" {
+		t.Errorf("unexpected references %v", refs)
+	}
+}
+
+func TestKeyServerLabels(t *testing.T) {
+	d := NewDriver("test")
+	d.MachineName = "node-1"
+
+	labels := d.machineLabels(nil)
+	d.keyServerLabels(labels, []*hcloud.SSHKey{
+		{Fingerprint: "b7:2f:30:a0:2f:6c:58:6c:21:04:58:61:ba:06:3b:2f"},
+		{Fingerprint: "00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff"},
+	})
+
+	for _, name := range []string{
+		"docker-machine-key/b72f30a02f6c586c21045861ba063b2f",
+		"docker-machine-key/00112233445566778899aabbccddeeff",
+	} {
+		if labels[name] != "true" {
+			t.Errorf("expected label %v, got %v", name, labels)
+		}
+	}
+	if labels[d.labelName(labelMachine)] != "node-1" {
+		t.Errorf("machine label lost, got %v", labels)
+	}
+}
+
+func TestAdditionalKeyRefs(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAdditionalKeys: []string{
+			"ssh-ed25519 AAAA operator",
+			"alice",
+			"4711",
+			"ssh-laptop",
+			"B7:2F:30:A0:2F:6C:58:6C:21:04:58:61:BA:06:3B:2F",
+		},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	if len(d.AdditionalKeys) != 1 || d.AdditionalKeys[0] != "ssh-ed25519 AAAA operator" {
+		t.Errorf("unexpected public keys %v", d.AdditionalKeys)
+	}
+	expected := "alice,4711,ssh-laptop,B7:2F:30:A0:2F:6C:58:6C:21:04:58:61:BA:06:3B:2F"
+	if strings.Join(d.additionalKeyRefs, ",") != expected {
+		t.Errorf("unexpected key references %v", d.additionalKeyRefs)
+	}
+}
+
+func TestKeySelector(t *testing.T) {
+	keys := map[int64]string{
+		1: `{"id": 1, "name": "alice", "fingerprint": "b7:2f:30:a0:2f:6c:58:6c:21:04:58:61:ba:06:3b:2f", "labels": {"team": "ops"}}`,
+		2: `{"id": 2, "name": "bob", "fingerprint": "00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff", "labels": {"team": "ops"}}`,
+		3: `{"id": 3, "name": "carol", "fingerprint": "ff:ee:dd:cc:bb:aa:99:88:77:66:55:44:33:22:11:00", "labels": {}}`,
+	}
+	list := func(ids ...int64) string {
+		var entries []string
+		for _, id := range ids {
+			entries = append(entries, keys[id])
+		}
+		return `{"ssh_keys": [` + strings.Join(entries, ",") + `], "meta": {"pagination": {"page": 1, "per_page": 50, "total_entries": ` +
+			strconv.Itoa(len(ids)) + `}}}`
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/ssh_keys/3":
+			_, _ = io.WriteString(w, `{"ssh_key": `+keys[3]+`}`)
+		case query.Get("label_selector") == "team=ops":
+			_, _ = io.WriteString(w, list(1, 2))
+		case query.Get("name") == "alice":
+			_, _ = io.WriteString(w, list(1))
+		case query.Get("fingerprint") == "00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff":
+			_, _ = io.WriteString(w, list(2))
+		default:
+			_, _ = io.WriteString(w, list())
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagKeySelector:    "team=ops",
+		flagAdditionalKeys: []string{"alice", "3", "00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.APIEndpoint = srv.URL
+
+	resolved, err := d.getAdditionalKeyRefs()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	var ids []string
+	for _, key := range resolved {
+		ids = append(ids, strconv.FormatInt(key.ID, 10))
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("unexpected keys %v", ids)
+	}
+
+	d.additionalKeyRefs = []string{"mallory"}
+	if _, err := d.getAdditionalKeyRefs(); err == nil {
+		t.Error("expected missing key to fail")
+	}
+}
+
+func TestReprovision(t *testing.T) {
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+
+	d := NewDriver("test")
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	if err := d.Reprovision(); err == nil || !strings.Contains(err.Error(), "docker-machine provision node-1") {
+		t.Errorf("expected missing docker-machine to fail clearly, got %v", err)
+	}
+
+	args := filepath.Join(bin, "args")
+	script := "#!/bin/sh\necho \"$@\" >" + args + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "docker-machine"), []byte(script), 0o755); err != nil {
+		t.Fatalf("could not write docker-machine stub: %v", err)
+	}
+	if err := d.Reprovision(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if raw, _ := os.ReadFile(args); strings.TrimSpace(string(raw)) != "--storage-path "+d.StorePath+" provision node-1" {
+		t.Errorf("expected docker-machine provision of the machine, got %q", raw)
+	}
+}
+
+func TestRotateSSHKey(t *testing.T) {
+	d := NewDriver("test")
+	d.UseSSHAgent = true
+	if err := d.RotateSSHKey(""); err == nil {
+		t.Error("expected rotation of agent keys to fail")
+	}
+
+	d = NewDriver("test")
+	d.KeyID, d.IsExistingKey = 42, true
+	if err := d.RotateSSHKey(""); err == nil {
+		t.Error("expected rotation of existing keys to fail")
+	}
+
+	d = NewDriver("test")
+	d.StorePath = t.TempDir()
+	d.MachineName = "node-1"
+	if err := os.MkdirAll(d.ResolveStorePath(""), 0700); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	oldPath, newPath := d.ResolveStorePath("id_rsa"), d.ResolveStorePath("id_ed25519")
+	for path, content := range map[string]string{
+		oldPath: "old", oldPath + ".pub": "old",
+		newPath + ".rotate": "new", newPath + ".rotate.pub": "new",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+	}
+
+	if err := d.installRotatedKey(oldPath, newPath+".rotate", newPath); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.GetSSHKeyPath() != newPath {
+		t.Errorf("unexpected key path %v", d.GetSSHKeyPath())
+	}
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("expected old key to be removed, got %v", err)
+	}
+	if buf, err := os.ReadFile(newPath + ".pub"); err != nil || string(buf) != "new" {
+		t.Errorf("unexpected new public key %s, %v", buf, err)
+	}
+}
+
+func TestSSHUser(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagSshUser:        "deploy",
+		flagAdditionalKeys: []string{"ssh-ed25519 AAAA operator"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	data, err := d.renderUserData()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	var config struct {
+		DisableRoot bool `yaml:"disable_root"`
+		Users       []yaml.Node
+	}
+	if err = yaml.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !config.DisableRoot || len(config.Users) != 2 {
+		t.Fatalf("unexpected user data: %v", data)
+	}
+	var user struct {
+		Name string
+		Sudo string
+		Keys []string `yaml:"ssh_authorized_keys"`
+	}
+	if err = config.Users[1].Decode(&user); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if user.Name != "deploy" || user.Sudo == "" || len(user.Keys) != 1 || user.Keys[0] != "ssh-ed25519 AAAA operator" {
+		t.Errorf("unexpected user %+v", user)
+	}
+
+	d.userData = "#!/bin/sh\necho hello\n"
+	if data, err = d.renderUserData(); err != nil || data != d.userData {
+		t.Errorf("expected script user data to remain unchanged, got %v, %v", data, err)
+	}
+}
+
+func serveHostKey(t *testing.T, signer ssh.Signer) (string, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+				conn.Close()
+			}()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestHostKeyPinning(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d := NewDriver("test")
+	d.StorePath = t.TempDir()
+	d.MachineName = "node-1"
+	if err := os.MkdirAll(d.ResolveStorePath(""), 0700); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.IPAddress, d.SSHPort = serveHostKey(t, signer)
+
+	if err := d.pinHostKey(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.HostKey != strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) {
+		t.Errorf("unexpected pinned host key %v", d.HostKey)
+	}
+	if buf, err := os.ReadFile(d.ResolveStorePath(knownHostsFile)); err != nil || !strings.Contains(string(buf), d.HostKey) {
+		t.Errorf("unexpected known hosts %s, %v", buf, err)
+	}
+	if err := d.VerifyHostKey(); err != nil {
+		t.Errorf("unexpected error, %v", err)
+	}
+
+	_, other, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	otherSigner, err := ssh.NewSignerFromKey(other)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.IPAddress, d.SSHPort = serveHostKey(t, otherSigner)
+	if err := d.VerifyHostKey(); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("expected changed host key to fail, got %v", err)
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := NewDriver("test").ConfigSchema()
+	properties := schema["properties"].(map[string]interface{})
+
+	for name, typ := range map[string]string{
+		"apiToken":             "string",
+		"serverType":           "string",
+		"sshPort":              "integer",
+		"usePrivateNetwork":    "boolean",
+		"additionalKey":        "array",
+		"disablePublicIpv4":    "boolean",
+		"volumeDeleteOnRemove": "boolean",
+	} {
+		property, ok := properties[name].(map[string]interface{})
+		if !ok {
+			t.Errorf("missing property %v", name)
+			continue
+		}
+		if property["type"] != typ {
+			t.Errorf("expected %v to be %v, got %v", name, typ, property["type"])
+		}
+	}
+
+	if properties["serverType"].(map[string]interface{})["default"] != defaultType {
+		t.Errorf("unexpected default server type %v", properties["serverType"])
+	}
+	if properties["apiToken"].(map[string]interface{})["writeOnly"] != true {
+		t.Error("expected API token to be write-only")
+	}
+	if properties["disablePublic4"].(map[string]interface{})["deprecated"] != true {
+		t.Error("expected legacy flag to be deprecated")
+	}
+}
+
+func TestCredentialDefaults(t *testing.T) {
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPIToken:        "foo",
+		flagDefaultLocation: "hel1",
+		flagDefaultNetworks: []string{"project-net"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.Location != "hel1" || len(d.Networks) != 1 || d.Networks[0] != "project-net" {
+		t.Errorf("expected credential defaults, got %v and %v", d.Location, d.Networks)
+	}
+
+	d = NewDriver("test")
+	err = d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAPIToken:        "foo",
+		flagLocation:        "fsn1",
+		flagDefaultLocation: "hel1",
+		flagNetworks:        []string{"template-net"},
+		flagDefaultNetworks: []string{"project-net"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.Location != "fsn1" || len(d.Networks) != 1 || d.Networks[0] != "template-net" {
+		t.Errorf("expected node template to take precedence, got %v and %v", d.Location, d.Networks)
+	}
+
+	properties := d.ConfigSchema()["properties"].(map[string]interface{})
+	for name, credential := range map[string]interface{}{
+		"apiToken":        credentialPrivate,
+		"defaultLocation": credentialPublic,
+		"defaultNetworks": credentialPublic,
+		"serverLocation":  nil,
+	} {
+		if property := properties[name].(map[string]interface{}); property["x-credential"] != credential {
+			t.Errorf("expected %v to be credential field %v, got %v", name, credential, property["x-credential"])
+		}
+	}
+}
+
+func TestAutoscalerNodeConfig(t *testing.T) {
+	for _, args := range []map[string]interface{}{
+		{flagNodeLabel: []string{"pool=workers"}},
+		{flagNodeGroup: "workers", flagNodeTaint: []string{"dedicated=ci"}},
+		{flagNodeGroup: "workers", flagNodeTaint: []string{"dedicated=ci:Sometimes"}},
+	} {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(args)); err == nil {
+			t.Errorf("expected %v to fail", args)
+		}
+	}
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagNodeGroup: "workers",
+		flagNodeLabel: []string{"node.kubernetes.io/pool=workers"},
+		flagNodeTaint: []string{"dedicated=ci:NoSchedule", "node.kubernetes.io/spot:PreferNoSchedule"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.ServerLabels[labelNodeGroup] != "workers" || d.ServerLabels["node.kubernetes.io/pool"] != "workers" {
+		t.Errorf("unexpected server labels %v", d.ServerLabels)
+	}
+
+	labels := map[string]string{}
+	d.autoscalerCapacityLabels(labels, &hcloud.ServerType{Cores: 2, Memory: 4, Disk: 40})
+	if labels["docker-machine/cores"] != "2" || labels["docker-machine/memory-gb"] != "4" || labels["docker-machine/disk-gb"] != "40" {
+		t.Errorf("unexpected capacity labels %v", labels)
+	}
+
+	config, err := d.AutoscalerNodeConfig()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	group := config["nodeConfigs"].(map[string]interface{})["workers"].(map[string]interface{})
+	taints := group["taints"].([]autoscalerTaint)
+	if len(taints) != 2 || taints[0] != (autoscalerTaint{Key: "dedicated", Value: "ci", Effect: "NoSchedule"}) ||
+		taints[1] != (autoscalerTaint{Key: "node.kubernetes.io/spot", Effect: "PreferNoSchedule"}) {
+		t.Errorf("unexpected taints %v", taints)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	path := t.TempDir() + "/config.yaml"
+	config := "server-type: cx22\nhetzner-server-location: fsn1\nsshPort: 2222\nuse-private-network: true\n" +
+		"networks: internal\nserver-label:\n  environment: staging\n"
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagConfigFile: path,
+		flagType:       defaultType,
+		flagLocation:   "nbg1",
+		flagSshPort:    defaultSSHPort,
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	if d.Type != "cx22" {
+		t.Errorf("expected server type from config file, got %v", d.Type)
+	}
+	if d.Location != "nbg1" {
+		t.Errorf("expected location from command line, got %v", d.Location)
+	}
+	if d.SSHPort != 2222 || !d.UsePrivateNetwork {
+		t.Errorf("unexpected SSH port %v or private network %v", d.SSHPort, d.UsePrivateNetwork)
+	}
+	if len(d.Networks) != 1 || d.Networks[0] != "internal" || d.ServerLabels["environment"] != "staging" {
+		t.Errorf("unexpected networks %v or labels %v", d.Networks, d.ServerLabels)
+	}
+
+	for _, config := range []string{"server-typo: cx22\n", "ssh-port: [22]\n", "config-file: other.yaml\n"} {
+		if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagConfigFile: path})); err == nil {
+			t.Errorf("expected %q to fail", config)
+		}
+	}
+}
+
+// TestPluginRPC drives the driver through libmachine's plugin RPC protocol, as docker-machine and its forks maintained
+// by GitLab and Rancher do, covering the gob encoding of all flag types and the JSON round trip of the machine config
+func TestPluginRPC(t *testing.T) {
+	call := func(d drivers.Driver) *rpcdriver.InternalClient {
+		server := rpc.NewServer()
+		if err := server.Register(rpcdriver.NewRPCServerDriver(d)); err != nil {
+			t.Fatal(err)
+		}
+		serverConn, clientConn := net.Pipe()
+		go server.ServeConn(serverConn)
+		client := rpc.NewClient(clientConn)
+		t.Cleanup(func() { _ = client.Close() })
+		return rpcdriver.NewInternalClient(client)
+	}
+
+	client := call(NewDriver("test"))
+	var flags []mcnflag.Flag
+	if err := client.Call(rpcdriver.GetCreateFlagsMethod, struct{}{}, &flags); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(flags) != len(NewDriver("test").GetCreateFlags()) {
+		t.Fatalf("expected all create flags, got %v", len(flags))
+	}
+
+	// docker-machine sends the defaults of all flags not given
+	values := make(map[string]interface{})
+	for _, flag := range flags {
+		switch flag := flag.(type) {
+		case *mcnflag.BoolFlag:
+			values[flag.Name] = false
+		case *mcnflag.StringSliceFlag:
+			values[flag.Name] = flag.Value
+		default:
+			values[flag.String()] = flag.Default()
+		}
+	}
+	values["swarm-master"], values["swarm-host"], values["swarm-discovery"] = false, "", ""
+	values[flagAPIToken] = "foo"
+	values[flagServerLabel] = []string{"ci=true"}
+	var opts drivers.DriverOptions = &rpcdriver.RPCFlags{Values: values}
+	if err := client.Call(rpcdriver.SetConfigFromFlagsMethod, &opts, nil); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	var raw []byte
+	if err := client.Call(rpcdriver.GetConfigRawMethod, struct{}{}, &raw); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d := NewDriver("test")
+	if err := call(d).Call(rpcdriver.SetConfigRawMethod, raw, nil); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.AccessToken != "foo" || d.ServerLabels["ci"] != "true" || d.Type != defaultType {
+		t.Errorf("unexpected config after round trip: %s", raw)
+	}
+}
+
+func TestInitMachine(t *testing.T) {
+	d := NewDriver("test")
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	d.Type = "cx22"
+	if err := d.InitMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err := d.InitMachine(); err == nil {
+		t.Error("expected existing machine to fail")
+	}
+
+	machines, err := ListMachines(d.StorePath)
+	if err != nil || len(machines) != 1 || machines[0] != "node-1" {
+		t.Fatalf("expected machine to be listed, got %v, %v", machines, err)
+	}
+	loaded, err := LoadMachine("test", d.StorePath, "node-1")
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if loaded.Type != "cx22" {
+		t.Errorf("unexpected server type %v", loaded.Type)
+	}
+
+	if err = loaded.RemoveMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if machines, _ = ListMachines(d.StorePath); len(machines) != 0 {
+		t.Errorf("expected machine to be removed, got %v", machines)
+	}
+}
+
+func TestDefaultLabels(t *testing.T) {
+	d := NewDriver("5.0.2")
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+
+	labels := d.machineLabels(map[string]string{"role": "worker"})
+	for name, value := range map[string]string{
+		"docker-machine/machine":        "node-1",
+		"docker-machine/managed-by":     "docker-machine-driver-hetzner",
+		"docker-machine/driver-version": "5.0.2",
+		"role":                          "worker",
+	} {
+		if labels[name] != value {
+			t.Errorf("expected label %v=%v, got %v", name, value, labels)
+		}
+	}
+	if created, err := strconv.ParseInt(labels["docker-machine/created"], 10, 64); err != nil || time.Since(time.Unix(created, 0)) > time.Minute {
+		t.Errorf("unexpected creation time in %v", labels)
+	}
+	if !d.ownStore(labels) || len(labels["docker-machine/store"]) != storeIDLength {
+		t.Errorf("expected own store label, got %v", labels)
+	}
+	if d.ownStore(map[string]string{}) || d.ownStore(map[string]string{"docker-machine/store": "0123456789ab"}) {
+		t.Error("expected resources without or with another store label not to be considered own")
+	}
+
+	// the ID is persisted, not derived from the path, so stores at the same path on other hosts differ
+	other := NewDriver("5.0.2")
+	other.StorePath = d.StorePath
+	if other.storeID() != d.storeID() {
+		t.Errorf("expected persisted store ID %v, got %v", d.storeID(), other.storeID())
+	}
+	other = NewDriver("5.0.2")
+	other.StorePath = t.TempDir()
+	if other.storeID() == "" || other.storeID() == d.storeID() {
+		t.Errorf("expected a new store to get another ID, got %v", other.storeID())
+	}
+
+	if _, ok := NewDriver("dev build").defaultLabels()["docker-machine/driver-version"]; ok {
+		t.Error("expected invalid version not to be labeled")
+	}
+}
+
+func TestLabelPrimaryIPs(t *testing.T) {
+	updated := make(map[string]map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var body struct {
+			Labels map[string]string `json:"labels"`
+		}
+		if r.Method != http.MethodPut || json.NewDecoder(r.Body).Decode(&body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		updated[r.URL.Path] = body.Labels
+		_, _ = io.WriteString(w, `{"primary_ip": {"id": 12}}`)
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagServerLabel: []string{"team=ops"},
+		flagPrimary4:    "11",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.APIEndpoint = srv.URL
+	d.MachineName = "node-1"
+	d.cachedPrimaryIPv4 = &hcloud.PrimaryIP{ID: 11}
+
+	err = d.labelPrimaryIPs(&hcloud.Server{PublicNet: hcloud.ServerPublicNet{
+		IPv4: hcloud.ServerPublicNetIPv4{ID: 11, IP: net.ParseIP("192.0.2.1")},
+		IPv6: hcloud.ServerPublicNetIPv6{ID: 12, IP: net.ParseIP("2001:db8::")},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(updated) != 1 {
+		t.Fatalf("expected only the created primary IP to be labeled, got %v", updated)
+	}
+	labels := updated["/primary_ips/12"]
+	if labels["team"] != "ops" || labels["docker-machine/machine"] != "node-1" || labels["docker-machine/managed-by"] != managedByDriver {
+		t.Errorf("unexpected labels %v", labels)
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	for _, label := range []string{"team=ops", "example.com/team=", "node.kubernetes.io/pool=workers-1", "a=b.c_d-e"} {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagServerLabel: []string{label}})); err != nil {
+			t.Errorf("expected %v to be valid, got %v", label, err)
+		}
+	}
+
+	for label, reason := range map[string]string{
+		"-team=ops":                       "key name",
+		"team=ops!":                       "value",
+		"Example.com/team=ops":            "key prefix",
+		"a/b/c=d":                         "key name",
+		"team=" + strings.Repeat("x", 64): "value",
+		strings.Repeat("x", 64) + "=ops":  "key name",
+		"example.com/=ops":                "key name",
+		"team=ops-":                       "value",
+	} {
+		d := NewDriver("test")
+		err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagServerLabel: []string{label}}))
+		if err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("expected %v to fail for its %v, got %v", label, reason, err)
+		}
+	}
+
+	if err := validateLabels(map[string]string{"docker-machine/machine": strings.Repeat("node", 16)}); err == nil {
+		t.Error("expected too long machine name to fail")
+	}
+}
+
+func TestServerLabelsFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "labels.yaml")
+	if err := os.WriteFile(file, []byte("team: ops\nversion: 2\nexample.com/tier: backend\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagServerLabelsFile: file,
+		flagServerLabel:      []string{"team=infra"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	for k, v := range map[string]string{"team": "infra", "version": "2", "example.com/tier": "backend"} {
+		if d.ServerLabels[k] != v {
+			t.Errorf("expected label %v=%v, got %v", k, v, d.ServerLabels)
+		}
+	}
+
+	for _, content := range []string{"team: [ops]\n", "team: ops!\n", "- team\n"} {
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagServerLabelsFile: file})); err == nil {
+			t.Errorf("expected %q to fail", content)
+		}
+	}
+}
+
+func TestGoldenSnapshot(t *testing.T) {
+	if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagGoldenSnapshot: "runner!"})); err == nil {
+		t.Error("expected invalid golden image name to fail")
+	}
+
+	status := "running"
+	var calls []string
+	var labels map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if id, ok := strings.CutPrefix(r.URL.Path, "/actions/"); ok {
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %v, "status": "success", "progress": 100}}`, id)
+			return
+		}
+		switch r.URL.Path {
+		case "/servers/1":
+			_, _ = fmt.Fprintf(w, `{"server": {"id": 1, "name": "node-1", "status": %q}}`, status)
+		case "/servers/1/actions/shutdown", "/servers/1/actions/poweron":
+			command := path.Base(r.URL.Path)
+			calls = append(calls, command)
+			status = map[string]string{"shutdown": "off", "poweron": "running"}[command]
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %d, "command": %q, "status": "success", "progress": 100}}`, len(calls), command)
+		case "/servers/1/actions/create_image":
+			calls = append(calls, "create_image:"+status)
+			var body struct {
+				Labels map[string]string `json:"labels"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			labels = body.Labels
+			_, _ = fmt.Fprintf(w, `{"image": {"id": 42}, "action": {"id": %d, "command": "create_image", "status": "success", "progress": 100}}`, len(calls))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagGoldenSnapshot: "runner-v1",
+		flagServerLabel:    []string{"team=ops"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.APIEndpoint = srv.URL
+	d.MachineName = "node-1"
+	d.ServerID = 1
+
+	image, err := d.CreateGoldenSnapshot(d.GoldenSnapshot)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if image.ID != 42 {
+		t.Errorf("expected snapshot 42, got %v", image.ID)
+	}
+	if !slices.Equal(calls, []string{"shutdown", "create_image:off", "poweron"}) {
+		t.Errorf("expected snapshot of the stopped server, got %v", calls)
+	}
+	if labels["docker-machine/golden"] != "runner-v1" || labels["docker-machine/machine"] != "node-1" || labels["team"] != "ops" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+}
+
+func TestFindPrunableSnapshots(t *testing.T) {
+	var selector string
+	d := NewDriver("test")
+	d.StorePath = t.TempDir()
+	store := d.storeID()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		selector = r.URL.Query().Get("label_selector")
+		image := func(id int, golden string, age time.Duration, protected bool) string {
+			labels := `"docker-machine/managed-by": "docker-machine-driver-hetzner", "docker-machine/store": "` + store + `"`
+			if id == 7 {
+				labels = `"docker-machine/managed-by": "docker-machine-driver-hetzner"`
+			}
+			if golden != "" {
+				labels += fmt.Sprintf(`, "docker-machine/golden": %q`, golden)
+			}
+			return fmt.Sprintf(`{"id": %d, "type": "snapshot", "created": %q, "protection": {"delete": %v}, "labels": {%s}}`,
+				id, time.Now().Add(-age).Format(time.RFC3339), protected, labels)
+		}
+		_, _ = fmt.Fprintf(w, `{"images": [%s], "meta": {"pagination": {"page": 1, "last_page": 1}}}`, strings.Join([]string{
+			image(1, "runner", 72*time.Hour, false),
+			image(2, "runner", time.Hour, false),
+			image(3, "runner", 48*time.Hour, false),
+			image(4, "runner", 96*time.Hour, true),
+			image(5, "builder", 96*time.Hour, false),
+			image(6, "", 120*time.Hour, false),
+			image(7, "", 240*time.Hour, false),
+		}, ","))
+	}))
+	defer srv.Close()
+
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+
+	if _, err := d.FindPrunableSnapshots("", 0, 0); err == nil {
+		t.Error("expected missing retention to fail")
+	}
+
+	ids := func(images []*hcloud.Image) []int64 {
+		var res []int64
+		for _, image := range images {
+			res = append(res, image.ID)
+		}
+		return res
+	}
+	for _, tc := range []struct {
+		keep   int
+		maxAge time.Duration
+		want   []int64
+	}{
+		{keep: 1, want: []int64{1, 3}},
+		{keep: 2, want: []int64{1}},
+		{maxAge: 60 * time.Hour, want: []int64{6, 5, 1}},
+		{keep: 1, maxAge: 60 * time.Hour, want: []int64{1}},
+	} {
+		images, err := d.FindPrunableSnapshots("docker-machine/golden", tc.keep, tc.maxAge)
+		if err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if got := ids(images); !slices.Equal(got, tc.want) {
+			t.Errorf("keep %d, max age %v: expected %v, got %v", tc.keep, tc.maxAge, tc.want, got)
+		}
+	}
+	if selector != "docker-machine/managed-by=docker-machine-driver-hetzner,docker-machine/golden" {
+		t.Errorf("unexpected label selector %v", selector)
+	}
+}
+
+func TestFromGolden(t *testing.T) {
+	err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagFromGolden: "runner",
+		flagImage:      "ubuntu-22.04",
+	}))
+	if err == nil {
+		t.Error("expected --hetzner-from-golden and --hetzner-image to be mutually exclusive")
+	}
+
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		query = r.URL.Query()
+		_, _ = io.WriteString(w, `{"images": [
+			{"id": 41, "type": "snapshot", "created": "2024-01-01T00:00:00Z"},
+			{"id": 43, "type": "snapshot", "created": "2024-03-01T00:00:00Z"},
+			{"id": 42, "type": "snapshot", "created": "2024-02-01T00:00:00Z"}
+		], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+	}))
+	defer srv.Close()
+
+	for selector, want := range map[string]string{
+		"runner":       "docker-machine/golden=runner",
+		"team in (ci)": "docker-machine/golden,team in (ci)",
+	} {
+		d := NewDriver("test")
+		err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+			flagFromGolden: selector,
+			flagImageArch:  "x86",
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if d.Image != "" {
+			t.Errorf("expected no default image, got %v", d.Image)
+		}
+		d.APIEndpoint = srv.URL
+
+		image, err := d.getImage()
+		if err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if image.ID != 43 || d.ImageID != 43 {
+			t.Errorf("expected newest snapshot 43 to be recorded, got %v and %v", image.ID, d.ImageID)
+		}
+		if got := query.Get("label_selector"); got != want {
+			t.Errorf("expected label selector %v, got %v", want, got)
+		}
+		if query.Get("architecture") != "x86" || query.Get("status") != "available" || query.Get("type") != "snapshot" {
+			t.Errorf("unexpected query %v", query)
+		}
+	}
+}
+
+func TestCheckPrice(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		price := func(hourly, monthly string) string {
+			return fmt.Sprintf(`"price_hourly": {"net": %q, "gross": %q}, "price_monthly": {"net": %q, "gross": %q}`,
+				hourly, hourly, monthly, monthly)
+		}
+		switch r.URL.Path {
+		case "/server_types":
+			_, _ = fmt.Fprintf(w, `{"server_types": [{"id": 1, "name": "cx22", "architecture": "x86", "prices": [
+				{"location": "fsn1", %s}, {"location": "sin", %s}]}]}`, price("0.0080", "5.00"), price("0.0120", "7.50"))
+		case "/locations":
+			_, _ = io.WriteString(w, `{"locations": [{"id": 1, "name": "fsn1"}]}`)
+		case "/pricing":
+			_, _ = fmt.Fprintf(w, `{"pricing": {"currency": "EUR", "vat_rate": "19.00",
+				"volume": {"price_per_gb_month": {"net": "0.05", "gross": "0.05"}},
+				"floating_ips": [{"type": "ipv4", "prices": []}],
+				"primary_ips": [{"type": "ipv4", "prices": [{"location": "fsn1", %s}, {"location": "sin", %s}]}]}}`,
+				price("0.0010", "0.60"), price("0.0020", "1.20"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	newDriver := func(flags map[string]interface{}) *Driver {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(flags)); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		d.APIEndpoint = srv.URL
+		return d
+	}
+
+	d := newDriver(map[string]interface{}{
+		flagType:                 "cx22",
+		flagLocation:             "fsn1",
+		flagDockerDataVolumeSize: 73,
+	})
+	estimate, err := d.estimatePrice()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if estimate.currency != "EUR" || estimate.upperBound || len(estimate.items) != 4 {
+		t.Errorf("unexpected estimate %+v", estimate)
+	}
+	if monthly := estimate.monthly(); monthly < 9.24 || monthly > 9.26 {
+		t.Errorf("expected 9.25 per month, got %v", monthly)
+	}
+	if hourly := estimate.hourly(); hourly < 0.0139 || hourly > 0.0141 {
+		t.Errorf("expected 0.014 per hour, got %v", hourly)
+	}
+
+	d = newDriver(map[string]interface{}{
+		flagType:           "cx22",
+		flagDisablePublic6: true,
+		flagMaxHourlyPrice: "0.01",
+	})
+	if err := d.checkPrice(); err == nil || !strings.Contains(err.Error(), "up to 0.0140 EUR/h") {
+		t.Errorf("expected the most expensive location to exceed the threshold, got %v", err)
+	}
+	d.maxHourlyPrice = 0.015
+	if err := d.checkPrice(); err != nil {
+		t.Errorf("unexpected error, %v", err)
+	}
+
+	if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagMaxHourlyPrice: "cheap"})); err == nil {
+		t.Error("expected invalid price to fail")
+	}
+}
+
+func TestSelectCheapestLocation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		price := func(location, hourly string) string {
+			return fmt.Sprintf(`{"location": %q, "price_hourly": {"net": %q, "gross": %q}, "price_monthly": {"net": "1", "gross": "1"}}`,
+				location, hourly, hourly)
+		}
+		datacenter := func(id int, location, zone string, available string) string {
+			return fmt.Sprintf(`{"id": %d, "name": "%s-dc1", "location": {"id": %d, "name": %q, "network_zone": %q},
+				"server_types": {"supported": [1], "available": [%s], "available_for_migration": []}}`, id, location, id, location, zone, available)
+		}
+		switch r.URL.Path {
+		case "/server_types":
+			_, _ = fmt.Fprintf(w, `{"server_types": [{"id": 1, "name": "cx22", "architecture": "x86", "prices": [%s]}]}`,
+				strings.Join([]string{price("fsn1", "0.0080"), price("hel1", "0.0070"), price("ash", "0.0060"), price("nbg1", "0.0080")}, ","))
+		case "/datacenters":
+			_, _ = fmt.Fprintf(w, `{"datacenters": [%s], "meta": {"pagination": {"page": 1, "last_page": 1}}}`, strings.Join([]string{
+				datacenter(1, "nbg1", "eu-central", "1"),
+				datacenter(2, "fsn1", "eu-central", "1"),
+				datacenter(3, "hel1", "eu-central", ""),
+				datacenter(4, "ash", "us-east", "1"),
+			}, ","))
+		case "/pricing":
+			_, _ = io.WriteString(w, `{"pricing": {"currency": "EUR", "volume": {"price_per_gb_month": {"currency": "EUR"}}}}`)
+		case "/networks":
+			_, _ = io.WriteString(w, `{"networks": [{"id": 5, "name": "net", "ip_range": "10.0.0.0/16", "subnets": [
+				{"type": "cloud", "ip_range": "10.0.0.0/24", "network_zone": "eu-central", "gateway": "10.0.0.1"}]}]}`)
+		case "/volumes":
+			_, _ = io.WriteString(w, `{"volumes": [{"id": 6, "name": "vol-in-nbg1", "location": {"id": 1, "name": "nbg1"}}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagAutoCheapest: true,
+		flagLocation:     "fsn1",
+	})); err == nil {
+		t.Error("expected --hetzner-location-auto-cheapest and --hetzner-server-location to be mutually exclusive")
+	}
+
+	for want, flags := range map[string]map[string]interface{}{
+		"ash":  {flagAPIToken: "cheapest", flagType: "cx22", flagAutoCheapest: true, flagDefaultLocation: "nbg1"},
+		"fsn1": {flagAPIToken: "cheapest", flagType: "cx22", flagAutoCheapest: true, flagNetworks: []string{"net"}},
+		"nbg1": {flagAPIToken: "cheapest", flagType: "cx22", flagAutoCheapest: true, flagVolumes: []string{"vol-in-nbg1"}},
+	} {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(flags)); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		d.APIEndpoint = srv.URL
+		if err := d.selectCheapestLocation(); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if d.Location != want {
+			t.Errorf("expected cheapest location %v, got %v", want, d.Location)
+		}
+	}
+}
+
+func TestCostReport(t *testing.T) {
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.StorePath = t.TempDir()
+	store := d.storeID()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		labels := func(machine, store string) string {
+			res := `"docker-machine/managed-by": "docker-machine-driver-hetzner", "docker-machine/store": "` + store + `"`
+			if machine != "" {
+				res += `, "docker-machine/machine": "` + machine + `"`
+			}
+			return "{" + res + "}"
+		}
+		meta := `"meta": {"pagination": {"page": 1, "last_page": 1}}`
+		switch r.URL.Path {
+		case "/pricing":
+			_, _ = io.WriteString(w, `{"pricing": {"currency": "EUR",
+				"image": {"price_per_gb_month": {"gross": "0.01"}},
+				"volume": {"price_per_gb_month": {"gross": "0.05"}},
+				"server_backup": {"percentage": "20"},
+				"floating_ips": [{"type": "ipv4", "prices": []}],
+				"primary_ips": [{"type": "ipv4", "prices": [{"location": "fsn1", "price_monthly": {"gross": "0.60"}}]}]}}`)
+		case "/servers":
+			_, _ = fmt.Fprintf(w, `{"servers": [
+				{"id": 1, "name": "node-1", "backup_window": "22-02", "labels": %s, "datacenter": {"location": {"name": "fsn1"}},
+					"server_type": {"prices": [{"location": "fsn1", "price_monthly": {"gross": "5.00"}}]}},
+				{"id": 2, "name": "node-2", "labels": %s, "datacenter": {"location": {"name": "fsn1"}},
+					"server_type": {"prices": [{"location": "fsn1", "price_monthly": {"gross": "5.00"}}]}},
+				{"id": 3, "name": "elsewhere", "labels": %s, "datacenter": {"location": {"name": "fsn1"}},
+					"server_type": {"prices": [{"location": "fsn1", "price_monthly": {"gross": "5.00"}}]}}
+			], %s}`, labels("node-1", store), labels("node-2", store), labels("other", "0123456789ab"), meta)
+		case "/primary_ips":
+			_, _ = fmt.Fprintf(w, `{"primary_ips": [{"id": 4, "name": "ip", "type": "ipv4", "ip": "192.0.2.1",
+				"datacenter": {"location": {"name": "fsn1"}}, "labels": %s}], %s}`, labels("node-1", store), meta)
+		case "/volumes":
+			_, _ = fmt.Fprintf(w, `{"volumes": [{"id": 5, "name": "data", "size": 10, "labels": %s}], %s}`, labels("node-2", store), meta)
+		case "/load_balancers":
+			_, _ = fmt.Fprintf(w, `{"load_balancers": [{"id": 6, "name": "lb", "location": {"name": "fsn1"}, "labels": %s,
+				"load_balancer_type": {"prices": [{"location": "fsn1", "price_monthly": {"gross": "6.00"}}]}}], %s}`, labels("", store), meta)
+		case "/images":
+			_, _ = fmt.Fprintf(w, `{"images": [{"id": 7, "type": "snapshot", "description": "golden", "image_size": 2.5, "labels": %s}], %s}`,
+				labels("node-1", store), meta)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	d.APIEndpoint = srv.URL
+
+	report, err := d.CostReport("docker-machine/machine", false)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if report.Currency != "EUR" || len(report.Items) != 6 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	want := map[string]float64{"node-1": 6 + 0.6 + 0.025, "node-2": 5.5, noGroup: 6}
+	for _, group := range report.Groups() {
+		if diff := group.Monthly - want[group.Name]; diff > 0.0001 || diff < -0.0001 {
+			t.Errorf("expected %v to cost %v, got %v", group.Name, want[group.Name], group.Monthly)
+		}
+	}
+	if groups := report.Groups(); groups[0].Name != "node-1" {
+		t.Errorf("expected most expensive group first, got %v", groups)
+	}
+
+	if report, err = d.CostReport("docker-machine/machine", true); err != nil || len(report.Items) != 7 {
+		t.Errorf("expected resources of all stores, got %v, %v", report.Items, err)
+	}
+}
+
+func TestServerState(t *testing.T) {
+	for status, want := range map[hcloud.ServerStatus]state.State{
+		hcloud.ServerStatusInitializing: state.Starting,
+		hcloud.ServerStatusStarting:     state.Starting,
+		hcloud.ServerStatusRunning:      state.Running,
+		hcloud.ServerStatusStopping:     state.Stopping,
+		hcloud.ServerStatusOff:          state.Stopped,
+		hcloud.ServerStatusDeleting:     state.Stopping,
+		hcloud.ServerStatusMigrating:    state.Running,
+		hcloud.ServerStatusRebuilding:   state.Starting,
+		hcloud.ServerStatusUnknown:      state.Error,
+		"something-new":                 state.None,
+	} {
+		if got := serverState(status); got != want {
+			t.Errorf("expected status %v to map to %v, got %v", status, want, got)
+		}
+	}
+}
+
+func TestStopKill(t *testing.T) {
+	status := "running"
+	stopsOnShutdown := false
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if id, ok := strings.CutPrefix(r.URL.Path, "/actions/"); ok {
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %v, "status": "success", "progress": 100}}`, id)
+			return
+		}
+		switch r.URL.Path {
+		case "/servers/1":
+			_, _ = fmt.Fprintf(w, `{"server": {"id": 1, "name": "node-1", "status": %q}}`, status)
+		case "/servers/1/actions/shutdown", "/servers/1/actions/poweroff":
+			command := path.Base(r.URL.Path)
+			calls = append(calls, command)
+			if command == "poweroff" || stopsOnShutdown {
+				status = "off"
+			}
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %d, "command": %q, "status": "running"}}`, len(calls), command)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	newDriver := func() *Driver {
+		status, calls = "running", nil
+		d := NewDriver("test")
+		d.AccessToken = "foo"
+		d.APIEndpoint = srv.URL
+		d.ServerID = 1
+		d.ShutdownTimeout = 1
+		d.WaitInterval = 10 * time.Millisecond
+		return d
+	}
+
+	// the server ignores the ACPI shutdown
+	if err := newDriver().Stop(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(calls, []string{"shutdown", "poweroff"}) {
+		t.Errorf("expected stop to power off the server once the shutdown timed out, got %v", calls)
+	}
+
+	stopsOnShutdown = true
+	if err := newDriver().Stop(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(calls, []string{"shutdown"}) {
+		t.Errorf("expected stop to shut down the server gracefully, got %v", calls)
+	}
+
+	if err := newDriver().Kill(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(calls, []string{"poweroff"}) {
+		t.Errorf("expected kill to power off the server right away, got %v", calls)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if id, ok := strings.CutPrefix(r.URL.Path, "/actions/"); ok {
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %v, "status": "success", "progress": 100}}`, id)
+			return
+		}
+		switch r.URL.Path {
+		case "/servers/1":
+			_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1", "status": "running"}}`)
+		case "/servers/1/actions/reboot", "/servers/1/actions/reset":
+			command := path.Base(r.URL.Path)
+			calls = append(calls, command)
+			// the first attempt runs into a backup locking the server
+			if len(calls) == 1 {
+				w.WriteHeader(http.StatusLocked)
+				_, _ = io.WriteString(w, `{"error": {"code": "locked", "message": "server is locked"}}`)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %d, "command": %q, "status": "running"}}`, len(calls), command)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	for _, reset := range []bool{false, true} {
+		calls = nil
+		d := NewDriver("test")
+		d.AccessToken = "foo"
+		d.APIEndpoint = srv.URL
+		d.ServerID = 1
+		d.RestartReset = reset
+
+		if err := d.Restart(); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		want := map[bool]string{false: "reboot", true: "reset"}[reset]
+		if !slices.Equal(calls, []string{want, want}) {
+			t.Errorf("expected the %v to be retried once the server is unlocked, got %v", want, calls)
+		}
+	}
+}
+
+func TestChangeServerType(t *testing.T) {
+	status, typeID := "running", 1
+	var calls []string
+	var upgradeDisk []bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if id, ok := strings.CutPrefix(r.URL.Path, "/actions/"); ok {
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %v, "status": "success", "progress": 100}}`, id)
+			return
+		}
+		switch r.URL.Path {
+		case "/server_types":
+			_, _ = io.WriteString(w, `{"server_types": [{"id": 2, "name": "cx32"}], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		case "/servers/1":
+			_, _ = fmt.Fprintf(w, `{"server": {"id": 1, "name": "node-1", "status": %q, "server_type": {"id": %d, "name": %q}}}`,
+				status, typeID, map[int]string{1: "cx22", 2: "cx32"}[typeID])
+		case "/servers/1/actions/shutdown", "/servers/1/actions/change_type", "/servers/1/actions/poweron":
+			command := path.Base(r.URL.Path)
+			calls = append(calls, command)
+			switch command {
+			case "shutdown":
+				status = "off"
+			case "change_type":
+				var body struct {
+					UpgradeDisk bool `json:"upgrade_disk"`
+				}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				upgradeDisk = append(upgradeDisk, body.UpgradeDisk)
+				typeID = 2
+			case "poweron":
+				status = "running"
+			}
+			_, _ = fmt.Fprintf(w, `{"action": {"id": %d, "command": %q, "status": "running"}}`, len(calls), command)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.ServerID = 1
+	d.WaitInterval = 10 * time.Millisecond
+
+	if err := d.ChangeServerType("cx32", false); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(calls, []string{"shutdown", "change_type", "poweron"}) {
+		t.Errorf("expected the server to be stopped for the change and started again, got %v", calls)
+	}
+	if d.Type != "cx32" {
+		t.Errorf("expected the new type to be recorded, got %v", d.Type)
+	}
+
+	calls = nil
+	if err := d.ChangeServerType("cx32", false); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("expected no change if the server already is of the type, got %v", calls)
+	}
+
+	// the disk is upgraded unless it is to be kept
+	typeID, d.cachedServer = 1, nil
+	if err := d.ChangeServerType("cx32", true); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(upgradeDisk, []bool{true, false}) {
+		t.Errorf("expected upgrade_disk to be the inverse of keepDisk, got %v", upgradeDisk)
+	}
+}
+
+func TestDockerPort(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"server": {"id": 1, "status": "running"}}`)
+	}))
+	defer srv.Close()
+
+	for port, want := range map[int]string{0: "tcp://192.0.2.1:2376", 12376: "tcp://192.0.2.1:12376"} {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagDockerPort: port})); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		d.APIEndpoint = srv.URL
+		d.ServerID = 1
+		d.IPAddress = "192.0.2.1"
+
+		url, err := d.GetURL()
+		if err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if url != want {
+			t.Errorf("expected %v, got %v", want, url)
+		}
+	}
+
+	for _, port := range []int{22, 70000, -1} {
+		err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagSshPort: 22, flagDockerPort: port}))
+		if err == nil {
+			t.Errorf("expected docker port %d to fail", port)
+		}
+	}
+}
+
+func TestRecordServerDetails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/servers/1":
+			_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1", "status": "running",
+				"datacenter": {"id": 2, "name": "fsn1-dc14"}, "server_type": {"id": 3, "name": "cx22"},
+				"image": {"id": 4, "name": "", "description": "golden runner"},
+				"public_net": {"ipv4": {"id": 11, "ip": "192.0.2.1"}, "ipv6": {"id": 12, "ip": "2001:db8::/64"},
+					"firewalls": [{"id": 9, "status": "applied"}]},
+				"private_net": [{"network": 5, "ip": "10.0.0.2"}]}}`)
+		case "/networks/5":
+			_, _ = io.WriteString(w, `{"network": {"id": 5, "name": "internal", "ip_range": "10.0.0.0/16"}}`)
+		case "/firewalls/9":
+			_, _ = io.WriteString(w, `{"firewall": {"id": 9, "name": "web"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.ServerID = 1
+	if err := d.recordServerDetails(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	if d.Datacenter != "fsn1-dc14" || d.Type != "cx22" || d.PrimaryIPv4ID != 11 || d.PrimaryIPv6ID != 12 {
+		t.Errorf("unexpected details %v, %v, %v, %v", d.Datacenter, d.Type, d.PrimaryIPv4ID, d.PrimaryIPv6ID)
+	}
+	if d.ServerImage == nil || *d.ServerImage != (ResourceRef{ID: 4, Name: "golden runner"}) {
+		t.Errorf("unexpected image %v", d.ServerImage)
+	}
+	if !slices.Equal(d.AttachedNetworks, []NetworkAttachment{{ID: 5, Name: "internal", IP: "10.0.0.2"}}) {
+		t.Errorf("unexpected networks %v", d.AttachedNetworks)
+	}
+	if !slices.Equal(d.AppliedFirewalls, []ResourceRef{{ID: 9, Name: "web"}}) {
+		t.Errorf("unexpected firewalls %v", d.AppliedFirewalls)
+	}
+}
+
+func TestSSHWait(t *testing.T) {
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.getSSHWaitTimeout() != defaultSSHWaitTimeout*time.Second || d.getSSHWaitInterval() != defaultSSHWaitInterval {
+		t.Errorf("expected default SSH wait, got %v every %v", d.getSSHWaitTimeout(), d.getSSHWaitInterval())
+	}
+
+	for _, flags := range []map[string]interface{}{
+		{flagSSHWaitInterval: "0s"},
+		{flagSSHWaitInterval: "soon"},
+		{flagSSHWaitAttempts: -1},
+		{flagSSHWaitTimeout: -1},
+	} {
+		if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(flags)); err == nil {
+			t.Errorf("expected %v to be rejected", flags)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+
+	d = NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagSSHWaitInterval: "10ms",
+		flagSSHWaitAttempts: 3,
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.IPAddress = addr.IP.String()
+	d.SSHPort = addr.Port
+
+	start := time.Now()
+	err = d.waitForSSH()
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected closed port to fail after 3 attempts, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected failing probes to give up quickly, took %v", elapsed)
+	}
+}
+
+func TestWaitMetadata(t *testing.T) {
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagWaitMetadata: true,
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !d.waitMetadata || d.getWaitMetadataTimeout() != defaultWaitMetadataTimeout {
+		t.Errorf("expected to wait for metadata up to %d seconds, got %v for %d", defaultWaitMetadataTimeout,
+			d.waitMetadata, d.getWaitMetadataTimeout())
+	}
+
+	d.ServerID = 4711
+	command := fmt.Sprintf(metadataReadyCommand, d.ServerID)
+	for _, expected := range []string{"id=4711;", metadataInstanceIDURL, "/var/lib/cloud/instances/$id/sem/config_ssh"} {
+		if !strings.Contains(command, expected) {
+			t.Errorf("expected wait command to contain %q, got %v", expected, command)
+		}
+	}
+
+	err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagWaitMetadata: true,
+		flagIgnition:     `{"ignition":{"version":"3.4.0"}}`,
+	}))
+	if err == nil {
+		t.Error("expected waiting for metadata to require cloud-init")
+	}
+
+	// the readiness check is the SSH probe, so it is bound by the metadata timeout rather than the SSH wait attempts
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+
+	d.IPAddress = addr.IP.String()
+	d.SSHPort = addr.Port
+	d.SSHWaitInterval = 10 * time.Millisecond
+	d.SSHWaitAttempts = 1
+	d.waitMetadataTimeout = 1
+	err = d.waitForMetadata()
+	if err == nil || !strings.Contains(err.Error(), "not reported initialized") || !strings.Contains(err.Error(), "within 1s") {
+		t.Errorf("expected metadata wait to time out after 1s, got %v", err)
+	}
+}
+
+func TestShortMachineName(t *testing.T) {
+	const long = "runner-abcdefgh-project-1234567-concurrent-0-0123456789abcdef-autoscaled"
+	for _, name := range []string{"node-1", "node.example.com", strings.Repeat("a", serverNameMaxLength)} {
+		if shortMachineName(name) != name {
+			t.Errorf("expected valid name %v to be kept, got %v", name, shortMachineName(name))
+		}
+	}
+
+	for _, name := range []string{long, "node-", "a..b", strings.Repeat("a", serverNameMaxLength+1)} {
+		short := shortMachineName(name)
+		if !validServerName(short) || !labelValuePattern.MatchString(short) {
+			t.Errorf("expected %v to be shortened to a valid name, got %v", name, short)
+		}
+		if short != shortMachineName(name) {
+			t.Errorf("expected %v to be shortened deterministically", name)
+		}
+	}
+	if shortMachineName(long) == shortMachineName(long+"-2") {
+		t.Error("expected names with a common prefix to be told apart")
+	}
+	if !strings.HasPrefix(shortMachineName(long), "runner-abcdefgh-project") {
+		t.Errorf("expected the shortened name to keep the prefix, got %v", shortMachineName(long))
+	}
+
+	d := NewDriver("test")
+	d.MachineName = long
+	labels := d.machineLabels(nil)
+	if err := validateLabels(labels); err != nil {
+		t.Errorf("expected valid labels for long names, got %v", err)
+	}
+	if labels[d.labelName(labelMachine)] != shortMachineName(long) {
+		t.Errorf("expected machine label %v, got %v", shortMachineName(long), labels[d.labelName(labelMachine)])
+	}
+	if d.labeledMachineName(labels) != long {
+		t.Errorf("expected labels to record %v, got %v", long, d.labeledMachineName(labels))
+	}
+
+	d.MachineName = "node-1"
+	labels = d.machineLabels(nil)
+	if len(d.machineNameLabels()) != 0 || d.labeledMachineName(labels) != "node-1" {
+		t.Errorf("expected no name mapping for valid names, got %v", labels)
+	}
+}
+
+func TestResolveNameConflict(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("name") {
+		case "node-1":
+			_, _ = io.WriteString(w, `{"servers": [{"id": 1, "name": "node-1"}]}`)
+		case "node-1-2":
+			_, _ = io.WriteString(w, `{"servers": [{"id": 2, "name": "node-1-2"}]}`)
+		default:
+			_, _ = io.WriteString(w, `{"servers": []}`)
+		}
+	}))
+	defer srv.Close()
+
+	newDriver := func(flags map[string]interface{}) *Driver {
+		d := NewDriver("test")
+		if err := d.setConfigFromFlagsImpl(makeFlags(flags)); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		d.APIEndpoint = srv.URL
+		d.MachineName = "node-1"
+		return d
+	}
+
+	d := newDriver(map[string]interface{}{})
+	if err := d.resolveNameConflict(); !errors.Is(err, ErrNameConflict) {
+		t.Errorf("expected name conflict, got %v", err)
+	}
+
+	d = newDriver(map[string]interface{}{flagOnNameConflict: nameConflictSuffix})
+	if err := d.resolveNameConflict(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.getNewServerName() != "node-1-3" {
+		t.Errorf("expected first free suffixed name node-1-3, got %v", d.getNewServerName())
+	}
+
+	d = newDriver(map[string]interface{}{flagOnNameConflict: nameConflictAdopt, flagExKeyPath: "/tmp/key"})
+	if err := d.resolveNameConflict(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.existingServer != "1" || d.getNewServerName() != "node-1" {
+		t.Errorf("expected server 1 to be adopted, got %v", d.existingServer)
+	}
+
+	d = newDriver(map[string]interface{}{flagOnNameConflict: nameConflictSuffix})
+	d.MachineName = "node-2"
+	if err := d.resolveNameConflict(); err != nil || d.getNewServerName() != "node-2" {
+		t.Errorf("expected free name to be kept, got %v, %v", d.getNewServerName(), err)
+	}
+
+	for _, flags := range []map[string]interface{}{
+		{flagOnNameConflict: nameConflictAdopt},
+		{flagOnNameConflict: "replace"},
+	} {
+		if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(flags)); err == nil {
+			t.Errorf("expected %v to be rejected", flags)
+		}
+	}
+}
+
+func TestExistingServer(t *testing.T) {
+	var labeled, deleted bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path != "/servers/1":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			deleted = true
+			_, _ = io.WriteString(w, `{"action": {"id": 1, "status": "success"}}`)
+		case r.Method == http.MethodPut:
+			labeled = true
+			fallthrough
+		default:
+			_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1", "server_type": {"id": 1, "name": "cx32"},
+				"datacenter": {"id": 1, "location": {"id": 1, "name": "hel1"}}}}`)
+		}
+	}))
+	defer srv.Close()
+
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := generateEd25519Key(key); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	newDriver := func(existing string, flags map[string]interface{}) *Driver {
+		flags[flagExistingServer] = existing
+		flags[flagExKeyPath] = key
+		d := NewDriver("test")
+		d.MachineName = "node-1"
+		d.StorePath = t.TempDir()
+		if err := d.InitMachine(); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		if err := d.setConfigFromFlagsImpl(makeFlags(flags)); err != nil {
+			t.Fatalf("unexpected error, %v", err)
+		}
+		d.AccessToken = "foo"
+		d.APIEndpoint = srv.URL
+		return d
+	}
+
+	// a server lacking its type or datacenter does not make the driver panic
+	d := newDriver("2", map[string]interface{}{})
+	d.cachedServer = &hcloud.Server{ID: 2, Name: "bare"}
+	if err := d.verifyExistingServer(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.cachedType != nil || d.cachedLocation != nil {
+		t.Errorf("expected missing details not to be recorded, got %v, %v", d.cachedType, d.cachedLocation)
+	}
+
+	d = newDriver("1", map[string]interface{}{})
+	if err := d.verifyExistingServer(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.Type != "cx32" || d.Location != "hel1" {
+		t.Errorf("expected the server details to be recorded, got %v, %v", d.Type, d.Location)
+	}
+	if _, err := d.adoptExistingServer(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	loaded, err := LoadMachine("test", d.StorePath, "node-1")
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if loaded.ServerID != 1 || !loaded.KeepServer {
+		t.Errorf("expected server 1 to be adopted and kept on remove, got %d, %v", loaded.ServerID, loaded.KeepServer)
+	}
+
+	// removing releases the adopted server instead of deleting it
+	if err := d.Remove(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !labeled || deleted {
+		t.Errorf("expected the server to be released, got labeled %v, deleted %v", labeled, deleted)
+	}
+
+	d = newDriver("1", map[string]interface{}{flagDeleteAdopted: true})
+	if _, err := d.adoptExistingServer(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.KeepServer {
+		t.Errorf("expected server 1 to be deleted on remove with --%v", flagDeleteAdopted)
+	}
+}
+
+func TestWaitCloudInit(t *testing.T) {
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	script := "#!/bin/sh\ncase \"$*\" in\n" +
+		"*'cloud-init status --wait'*) echo \"$CLOUD_INIT_STATUS\" ;;\n" +
+		"*'cloud-init status --long'*) echo \"status: $CLOUD_INIT_STATUS\" ;;\n" +
+		"*cloud-init-output.log*) echo 'runcmd output' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("could not write ssh stub: %v", err)
+	}
+
+	d := NewDriver("test")
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	d.IPAddress = "127.0.0.1"
+	d.waitCloudInitTimeout = 60
+	if err := d.InitMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err := generateEd25519Key(d.GetSSHKeyPath()); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	for _, test := range []struct {
+		status string
+		err    string
+		saved  bool
+	}{
+		{status: "0"},              // done
+		{status: "missing"},        // no cloud-init
+		{status: "2", saved: true}, // degraded
+		{status: "1", err: "cloud-init failed with status 1", saved: true},    // error
+		{status: "124", err: "did not finish within 60 seconds", saved: true}, // still running
+	} {
+		t.Setenv("CLOUD_INIT_STATUS", test.status)
+		d.savedInitLogs = false
+		_ = os.Remove(d.ResolveStorePath("cloud-init-status.log"))
+
+		err := d.waitForCloudInit()
+		if test.err == "" && err != nil {
+			t.Errorf("unexpected error for status %v, %v", test.status, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("expected %q for status %v, got %v", test.err, test.status, err)
+		}
+
+		raw, err := os.ReadFile(d.ResolveStorePath("cloud-init-status.log"))
+		if saved := err == nil; saved != test.saved {
+			t.Errorf("expected the logs for status %v to be saved: %v, got %v", test.status, test.saved, saved)
+		} else if saved && strings.TrimSpace(string(raw)) != "status: "+test.status {
+			t.Errorf("unexpected status log %q", raw)
+		}
+	}
+}
+
+func TestCreateFailureLogs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer listener.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/servers/1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1", "status": "running",
+			"public_net": {"ipv4": {"ip": "127.0.0.1"}}}}`)
+	}))
+	defer srv.Close()
+
+	// logins succeed, but the server is never reported initialized, failing the creation after SSH came up
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	script := "#!/bin/sh\ncase \"$*\" in\n" +
+		"*169.254.169.254*) exit 1 ;;\n" +
+		"*'cloud-init status'*) echo 'status: error' ;;\n" +
+		"*cloud-init-output.log*) echo 'runcmd failed' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("could not write ssh stub: %v", err)
+	}
+
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := generateEd25519Key(key); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d := NewDriver("test")
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	if err := d.InitMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagExistingServer:      "1",
+		flagExKeyPath:           key,
+		flagWaitMetadata:        true,
+		flagWaitMetadataTimeout: 1,
+		flagSSHWaitInterval:     "10ms",
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.SSHPort = listener.Addr().(*net.TCPAddr).Port
+
+	if err := d.Create(); err == nil || !strings.Contains(err.Error(), "not reported initialized") {
+		t.Fatalf("expected creation to fail waiting for the metadata, got %v", err)
+	}
+	for name, expected := range map[string]string{
+		"cloud-init-status.log": "status: error",
+		"cloud-init-output.log": "runcmd failed",
+	} {
+		raw, err := os.ReadFile(d.ResolveStorePath(name))
+		if err != nil || strings.TrimSpace(string(raw)) != expected {
+			t.Errorf("expected %v to be saved as %q, got %q, %v", name, expected, raw, err)
+		}
+	}
+}
+
+func TestResumeCreate(t *testing.T) {
+	var posts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			posts = append(posts, r.URL.Path)
+		}
+		if r.URL.Path != "/servers/1" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error": {"code": "not_found", "message": "not found"}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1", "status": "initializing"}}`)
+	}))
+	defer srv.Close()
+
+	newDriver := func(serverID int64) *Driver {
+		d := NewDriver("test")
+		d.AccessToken = "foo"
+		d.APIEndpoint = srv.URL
+		d.MachineName = "node-1"
+		d.ServerID = serverID
+		d.CreateTimeout = 1
+		d.WaitInterval = 10 * time.Millisecond
+		return d
+	}
+
+	if err := newDriver(0).ResumeCreate(); err == nil {
+		t.Error("expected resuming a machine without server to fail")
+	}
+
+	// the recorded server is waited for instead of creating a new one
+	d := newDriver(1)
+	if err := d.ResumeCreate(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the resumed creation to wait for server 1 until the timeout, got %v", err)
+	}
+	if len(posts) != 0 || d.ServerID != 1 {
+		t.Errorf("expected server %d to be resumed without creating anything, got %v", d.ServerID, posts)
+	}
+
+	// a server deleted in the meantime is created anew
+	d = newDriver(2)
+	res, err := d.getResumableServer()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if res != nil || d.ServerID != 0 {
+		t.Errorf("expected a missing server to fall back to a new creation, got %v, server %d", res, d.ServerID)
+	}
+}
+
+func TestCreateTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1", "status": "initializing"}}`)
+	}))
+	defer srv.Close()
+
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := generateEd25519Key(key); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	d := NewDriver("test")
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	if err := d.InitMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagExistingServer: "1",
+		flagExKeyPath:      key,
+		flagCreateTimeout:  1,
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.WaitInterval = 10 * time.Millisecond
+
+	err := d.Create()
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "exceeded --"+flagCreateTimeout+" of 1s") {
+		t.Errorf("expected the create timeout to be named, got %v", err)
+	}
+}
+
+func TestPhaseTimings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1", "status": "running"}}`)
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.ServerID = 1
+	d.IPAddress = "192.0.2.1"
+
+	done := d.timePhase("server startup")
+	done()
+	d.logPhaseTimings(false)
+	if d.phases != nil || !d.provisionStart.IsZero() {
+		t.Errorf("expected a failed creation not to time the provisioning, got %v, %v", d.phases, d.provisionStart)
+	}
+
+	done = d.timePhase("server startup")
+	done()
+	d.logPhaseTimings(true)
+	time.Sleep(10 * time.Millisecond)
+
+	// docker-machine asks for the daemon's URL once Docker is installed
+	if _, err := d.GetURL(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if len(d.phases) != 2 || d.phases[1].name != "docker install" || d.phases[1].duration < 10*time.Millisecond {
+		t.Errorf("expected the Docker installation to be timed, got %v", d.phases)
+	}
+	if _, err := d.GetURL(); err != nil || len(d.phases) != 2 {
+		t.Errorf("expected the Docker installation to be timed once, got %v, %v", d.phases, err)
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method != http.MethodGet:
+			t.Errorf("dry run must not modify anything, got %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path == "/placement_groups":
+			_, _ = io.WriteString(w, `{"placement_groups": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		case r.URL.Path == "/networks":
+			_, _ = io.WriteString(w, `{"networks": [{"id": 3, "name": "net1"}]}`)
+		default:
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	if err := d.InitMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{
+		flagDryRun:               true,
+		flagPlacementGroup:       "grp",
+		flagNetworks:             []string{"net1"},
+		flagDockerDataVolumeSize: 10,
+		flagLocation:             "fsn1",
+		flagUserData:             "#cloud-config\nwrite_files:\n  - path: /etc/address\n    content: {{ .PublicIPv4 }}\n",
+		flagUserDataTemplate:     true,
+	})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.cachedLocation = &hcloud.Location{ID: 1, Name: "fsn1"}
+	d.cachedImage = &hcloud.Image{ID: 5, Name: "ubuntu-24.04"}
+	d.cachedType = &hcloud.ServerType{ID: 1, Name: "cx22", Pricings: []hcloud.ServerTypeLocationPricing{{
+		Location: &hcloud.Location{Name: "fsn1"},
+		Hourly:   hcloud.Price{Gross: "0.01", Currency: "EUR"},
+		Monthly:  hcloud.Price{Gross: "4.00", Currency: "EUR"},
+	}}}
+
+	var out bytes.Buffer
+	log.SetOutWriter(&out)
+	defer log.SetOutWriter(os.Stdout)
+
+	if err := d.printPlan(); !errors.Is(err, errDryRun) {
+		t.Fatalf("expected the dry run to abort, got %v", err)
+	}
+	for _, expected := range []string{
+		"generate a new key pair and create SSH key node-1",
+		"create spread placement group grp",
+		"use network net1[3]",
+		"create Docker data volume node-1-docker (10 GB) in fsn1",
+		"create primary IPv4 node-1-ipv4 for the user data template",
+		"create server node-1 of type cx22 from image ubuntu-24.04[5] in fsn1",
+		"estimated price in fsn1: 4.00 EUR/month, 0.01 EUR/hour",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the plan to %q, got\n%v", expected, out.String())
+		}
+	}
+
+	if len(d.dangling) != 0 || d.KeyID != 0 || d.ServerID != 0 {
+		t.Errorf("expected nothing to be recorded, got %d dangling, key %d, server %d", len(d.dangling), d.KeyID, d.ServerID)
+	}
+	if _, err := os.Stat(d.GetSSHKeyPath()); !os.IsNotExist(err) {
+		t.Errorf("expected no key pair to be generated, got %v", err)
+	}
+}
+
+func TestTransientSSHError(t *testing.T) {
+	for _, msg := range []string{
+		"ssh: handshake failed: read tcp 192.0.2.1:50022->192.0.2.2:22: read: connection reset by peer",
+		"Error dialing TCP: dial tcp 192.0.2.2:22: connect: connection refused",
+		"ssh: handshake failed: EOF",
+		"ssh: connect to host 192.0.2.2 port 22: Connection refused: exit status 255",
+		"kex_exchange_identification: read: Connection reset by peer: exit status 255",
+	} {
+		if !isTransientSSHError(errors.New(msg)) {
+			t.Errorf("expected %q to be retried", msg)
+		}
+	}
+	for _, msg := range []string{
+		"exit status 1",
+		"Process exited with status 2",
+		"curl: (7) Failed to connect: connection refused: exit status 7",
+		"exit status 255",
+		"Connection to 192.0.2.2 closed by remote host.: exit status 255",
+		"write tcp 192.0.2.1:50022->192.0.2.2:22: write: broken pipe",
+		"EOF",
+	} {
+		if isTransientSSHError(errors.New(msg)) {
+			t.Errorf("expected %q not to be retried", msg)
+		}
+	}
+
+	d := NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagSSHRetries: 5})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.SSHRetries != 5 {
+		t.Errorf("expected 5 SSH retries, got %d", d.SSHRetries)
+	}
+	if err := NewDriver("test").setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagSSHRetries: -1})); err == nil {
+		t.Error("expected negative retries to be rejected")
+	}
+}
+
+func TestVersionReport(t *testing.T) {
+	report := NewDriver("1.2.3").VersionReport()
+	if report.Version != "1.2.3" || report.HcloudGo != hcloud.Version {
+		t.Errorf("unexpected versions %v, %v", report.Version, report.HcloudGo)
+	}
+	for _, capability := range report.Capabilities {
+		for _, flag := range capability.Flags {
+			if !slices.Contains(report.Flags, flag) {
+				t.Errorf("capability %v refers to unknown flag %v", capability.Name, flag)
+			}
+		}
+	}
+	if !slices.IsSorted(report.Flags) || !slices.Contains(report.Flags, flagAPIToken) {
+		t.Errorf("expected sorted create flags, got %v", report.Flags)
+	}
+}
+
+func TestImageSSHUser(t *testing.T) {
+	d := NewDriver("test")
+	for _, tc := range []struct {
+		image hcloud.Image
+		user  string
+	}{
+		{hcloud.Image{Name: "ubuntu-22.04", OSFlavor: "ubuntu"}, defaultSSHUser},
+		{hcloud.Image{Name: "debian-12", OSFlavor: "debian"}, defaultSSHUser},
+		{hcloud.Image{Description: "Flatcar Container Linux 3815.2.0", OSFlavor: "unknown"}, ignitionUser},
+		{hcloud.Image{Description: "fedora-coreos-39", OSFlavor: "unknown"}, ignitionUser},
+		{hcloud.Image{Description: "fedora-39", OSFlavor: "fedora"}, defaultSSHUser},
+		{hcloud.Image{Description: "custom", Labels: map[string]string{"docker-machine/ssh-user": "deploy"}}, "deploy"},
+		{hcloud.Image{Description: "custom", OSFlavor: "unknown"}, defaultSSHUser},
+	} {
+		if user := d.imageSSHUser(&tc.image); user != tc.user {
+			t.Errorf("expected SSH user %v for %v, got %v", tc.user, tc.image.Name+tc.image.Description, user)
+		}
+	}
+
+	flatcar := &hcloud.Image{ID: 42, Description: "flatcar-stable"}
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if d.GetSSHUsername() != defaultSSHUser {
+		t.Errorf("expected %v until the image is known, got %v", defaultSSHUser, d.GetSSHUsername())
+	}
+	d.setImageSSHUser(flatcar)
+	if d.GetSSHUsername() != ignitionUser {
+		t.Errorf("expected SSH user of the image, got %v", d.GetSSHUsername())
+	}
+
+	d = NewDriver("test")
+	if err := d.setConfigFromFlagsImpl(makeFlags(map[string]interface{}{flagSshUser: defaultSSHUser})); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.setImageSSHUser(flatcar)
+	if d.GetSSHUsername() != defaultSSHUser {
+		t.Errorf("expected given SSH user to take precedence, got %v", d.GetSSHUsername())
+	}
+}
+
+func TestVerifyServerOwnership(t *testing.T) {
+	var name string
+	var labels map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/servers/1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		encoded, _ := json.Marshal(labels)
+		_, _ = fmt.Fprintf(w, `{"server": {"id": 1, "name": %q, "labels": %s}}`, name, encoded)
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	d.ServerID = 1
+	d.ServerName = "node-1"
+	store := d.storeID()
+
+	for _, test := range []struct {
+		name     string
+		labels   map[string]string
+		mismatch string
+	}{
+		{"node-1", map[string]string{"docker-machine/machine": "node-1", "docker-machine/store": store}, ""},
+		{"node-1", nil, ""},
+		{"node-2", map[string]string{"docker-machine/machine": "node-1"}, "is named node-2 instead of node-1"},
+		{"node-1", map[string]string{"docker-machine/machine": "node-2"}, "is labeled for machine node-2"},
+		{"node-1", map[string]string{"docker-machine/machine": "node-1", "docker-machine/store": "0123456789ab"},
+			"is labeled for store 0123456789ab"},
+	} {
+		name, labels = test.name, test.labels
+		d.cachedServer = nil
+		err := d.verifyServerOwnership()
+		if test.mismatch == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %v %v, %v", test.name, test.labels, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.mismatch) || !strings.Contains(err.Error(), envForceRemove) {
+			t.Errorf("expected removal to be refused as the server %v, got %v", test.mismatch, err)
+		}
+
+		t.Setenv(envForceRemove, "true")
+		d.cachedServer = nil
+		if err := d.verifyServerOwnership(); err != nil {
+			t.Errorf("expected %v to override the check, got %v", envForceRemove, err)
+		}
+		t.Setenv(envForceRemove, "")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	var rolledBack []string
+	addDangling := func(d *Driver) {
+		rolledBack = nil
+		for _, resource := range []string{"placement group", "key", "server", "load balancer"} {
+			resource := resource
+			d.dangling = append(d.dangling, func() { rolledBack = append(rolledBack, resource) })
+		}
+	}
+
+	d := NewDriver("test")
+	addDangling(d)
+	d.destroyDangling()
+	if !slices.Equal(rolledBack, []string{"load balancer", "server", "key", "placement group"}) {
+		t.Errorf("expected all resources to be rolled back in reverse order, got %v", rolledBack)
+	}
+
+	// the partially created machine is kept for removal or resumption
+	d = NewDriver("test")
+	d.MachineName = "node-1"
+	d.StorePath = t.TempDir()
+	if err := d.InitMachine(); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	d.KeepOnFailure = true
+	d.ServerID, d.KeyID = 1, 2
+	addDangling(d)
+	d.destroyDangling()
+	if len(rolledBack) != 0 {
+		t.Errorf("expected nothing to be rolled back with --%v, got %v", flagKeepOnFailure, rolledBack)
+	}
+	loaded, err := LoadMachine("test", d.StorePath, "node-1")
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if loaded.ServerID != 1 || loaded.KeyID != 2 {
+		t.Errorf("expected the kept resources to be checkpointed, got server %d, key %d", loaded.ServerID, loaded.KeyID)
+	}
+}
+
+func TestKeepOnErrorRollback(t *testing.T) {
+	var labeled bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path != "/servers/1":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut:
+			labeled = true
+			fallthrough
+		default:
+			_, _ = io.WriteString(w, `{"server": {"id": 1, "name": "node-1"}}`)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.APIEndpoint = srv.URL
+	d.MachineName = "node-1"
+	d.ServerID = 1
+	d.keepOnError = time.Hour
+
+	var rolledBack []string
+	for _, resource := range []string{"key", "volume", "server", "load balancer"} {
+		resource := resource
+		d.dangling = append(d.dangling, func() { rolledBack = append(rolledBack, resource) })
+		if resource == "volume" || resource == "server" {
+			d.keepWithServer()
+		}
+	}
+
+	d.destroyDangling()
+	if !labeled {
+		t.Error("expected the failed server to be labeled")
+	}
+	if !slices.Equal(rolledBack, []string{"load balancer", "key"}) {
+		t.Errorf("expected all but the server and its volume to be rolled back, got %v", rolledBack)
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	d := NewDriver("test")
+	d.AccessToken = "foo"
+	d.StorePath = t.TempDir()
+	store := d.storeID()
+
+	var selectors []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		selectors = append(selectors, r.URL.Query().Get("label_selector"))
+		server := func(id int, machine, store string) string {
+			labels := fmt.Sprintf(`"docker-machine/machine": %q`, machine)
+			if store != "" {
+				labels += fmt.Sprintf(`, "docker-machine/store": %q`, store)
+			}
+			return fmt.Sprintf(`{"id": %d, "name": "%s", "labels": {%s}}`, id, machine, labels)
+		}
+		sharedKey := func(id int, fingerprint string, labels string) string {
+			return fmt.Sprintf(`{"id": %d, "name": "key-%d", "fingerprint": %q, "labels": {"docker-machine/shared-key": "true"%s}}`,
+				id, id, fingerprint, labels)
+		}
+		selector := r.URL.Query().Get("label_selector")
+		switch {
+		case r.URL.Path == "/servers" && strings.Contains(selector, "docker-machine-key/bb"):
+			_, _ = fmt.Fprintf(w, `{"servers": [%s], "meta": {"pagination": {"page": 1, "last_page": 1}}}`, server(5, "elsewhere", "0123456789ab"))
+		case r.URL.Path == "/servers" && strings.Contains(selector, "docker-machine-key/"):
+			_, _ = io.WriteString(w, `{"servers": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		case r.URL.Path == "/servers":
+			_, _ = fmt.Fprintf(w, `{"servers": [%s], "meta": {"pagination": {"page": 1, "last_page": 1}}}`, strings.Join([]string{
+				server(1, "gone", store),
+				server(2, "node-1", store),
+				server(3, "other-host", "0123456789ab"),
+				server(4, "legacy", ""),
+			}, ","))
+		case r.URL.Path == "/ssh_keys" && strings.HasPrefix(selector, "docker-machine/shared-key"):
+			_, _ = fmt.Fprintf(w, `{"ssh_keys": [%s], "meta": {"pagination": {"page": 1, "last_page": 1}}}`, strings.Join([]string{
+				sharedKey(10, "aa", fmt.Sprintf(`, "docker-machine/store": %q, "docker-machine-ref/gone": %q`, store, store)),
+				sharedKey(11, "ab", fmt.Sprintf(`, "docker-machine/store": %q, "docker-machine-ref/gone": %q, "docker-machine-ref/other-host": "0123456789ab"`, store, store)),
+				sharedKey(12, "bb", fmt.Sprintf(`, "docker-machine/store": %q, "docker-machine-ref/gone": %q`, store, store)),
+				sharedKey(13, "cc", `, "docker-machine-ref/legacy": "true"`),
+			}, ","))
+		case r.URL.Path == "/ssh_keys":
+			_, _ = io.WriteString(w, `{"ssh_keys": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		case r.URL.Path == "/primary_ips":
+			_, _ = io.WriteString(w, `{"primary_ips": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		case r.URL.Path == "/firewalls":
+			_, _ = io.WriteString(w, `{"firewalls": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		case r.URL.Path == "/volumes":
+			_, _ = io.WriteString(w, `{"volumes": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	d.APIEndpoint = srv.URL
+
+	ids := func(orphans []Orphan) []int64 {
+		var res []int64
+		for _, orphan := range orphans {
+			res = append(res, orphan.ID)
+		}
+		return res
+	}
+
+	orphans, err := d.FindOrphans([]string{"node-1"}, "", false)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(ids(orphans), []int64{1, 10}) {
+		t.Errorf("expected only the own store's orphans, and no shared keys referenced or used elsewhere, got %v", orphans)
+	}
+
+	orphans, err = d.FindOrphans([]string{"node-1"}, "env=ci", true)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	if !slices.Equal(ids(orphans), []int64{1, 4, 10, 13}) {
+		t.Errorf("expected unlabeled orphans on opt-in only, never other stores', got %v", orphans)
+	}
+	if !slices.Contains(selectors, "docker-machine/machine,env=ci") {
+		t.Errorf("expected the selector to narrow down the resources, got %v", selectors)
+	}
+}
@@ -0,0 +1,261 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// errDryRun stops docker-machine after the plan was printed, before the machine is saved or created
+var errDryRun = errors.New("dry run: no resources were created")
+
+// printPlan resolves everything the machine would reference and logs the resources that would be created or used.
+// Unlike the regular lookups, which may create missing placement groups or load balancers on the way, it only issues
+// read-only requests.
+func (d *Driver) printPlan() error {
+	log.Infof("Dry run, creating the machine would:")
+
+	if err := d.planKeys(); err != nil {
+		return err
+	}
+	if err := d.planPlacementGroup(); err != nil {
+		return err
+	}
+	if err := d.planLoadBalancer(); err != nil {
+		return err
+	}
+
+	networks, err := d.createNetworks()
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		log.Infof(" -> use network %s[%d]", network.Name, network.ID)
+	}
+
+	firewalls, err := d.createFirewalls()
+	if err != nil {
+		return err
+	}
+	for _, firewall := range firewalls {
+		log.Infof(" -> use firewall %s[%d]", firewall.Firewall.Name, firewall.Firewall.ID)
+	}
+
+	if err := d.planVolumes(); err != nil {
+		return err
+	}
+
+	for _, ip := range []func() (*hcloud.PrimaryIP, error){d.getPrimaryIPv4, d.getPrimaryIPv6} {
+		if ip, err := ip(); err != nil {
+			return fmt.Errorf("could not resolve primary IP: %w", err)
+		} else if ip != nil {
+			log.Infof(" -> use primary IP %s[%d] (%v)", ip.Name, ip.ID, ip.IP)
+		}
+	}
+	if needed, err := d.needsUserDataPrimaryIPv4(); err != nil {
+		return err
+	} else if needed {
+		log.Infof(" -> create primary IPv4 %s-ipv4 for the user data template", d.getNewServerName())
+	}
+
+	if err := d.planServer(); err != nil {
+		return err
+	}
+
+	return errDryRun
+}
+
+func (d *Driver) planKeys() error {
+	if d.KeyID != 0 {
+		key, err := d.getKey()
+		if err != nil {
+			return fmt.Errorf("could not get ssh key: %w", err)
+		}
+		log.Infof(" -> use SSH key %s[%d]", key.Name, key.ID)
+	} else if d.UseSSHAgent {
+		buf, err := getAgentPublicKey()
+		if err != nil {
+			return err
+		}
+		if err = d.planKey(d.GetMachineName(), buf); err != nil {
+			return err
+		}
+	} else if d.originalKey != "" {
+		buf, err := os.ReadFile(d.originalKey + ".pub")
+		if err != nil {
+			return fmt.Errorf("could not read ssh public key: %w", err)
+		}
+		if err = d.planKey(d.GetMachineName(), buf); err != nil {
+			return err
+		}
+	} else {
+		log.Infof(" -> generate a new key pair and create SSH key %s, labels %v", d.GetMachineName(),
+			formatLabels(d.resourceLabels(d.keyLabels)))
+	}
+
+	refs, err := d.getAdditionalKeyRefs()
+	if err != nil {
+		return err
+	}
+	for _, key := range refs {
+		log.Infof(" -> use SSH key %s[%d]", key.Name, key.ID)
+	}
+	for i, pubkey := range d.AdditionalKeys {
+		if err := d.planKey(fmt.Sprintf("%v-additional-%d", d.GetMachineName(), i), []byte(pubkey)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) planKey(name string, pubkey []byte) error {
+	key, err := d.getRemoteKeyWithSameFingerprintNullable(pubkey)
+	if err != nil {
+		return fmt.Errorf("error retrieving potentially existing key: %w", err)
+	}
+
+	if key == nil {
+		log.Infof(" -> create shared SSH key %s, labels %v", name, formatLabels(d.sharedKeyLabels()))
+	} else if key.Labels[d.labelName(labelSharedKey)] == "true" {
+		log.Infof(" -> reference shared SSH key %s[%d], used by %v", key.Name, key.ID,
+			strings.Join(keyReferences(key), ", "))
+	} else {
+		log.Infof(" -> use SSH key %s[%d]", key.Name, key.ID)
+	}
+	return nil
+}
+
+func (d *Driver) planPlacementGroup() error {
+	if d.placementGroup == "" {
+		return nil
+	}
+
+	var grp *hcloud.PlacementGroup
+	name := d.placementGroup
+	if name == autoSpreadPgName {
+		res, err := d.getClient().PlacementGroup.AllWithOpts(d.getContext(), hcloud.PlacementGroupListOpts{
+			ListOpts: hcloud.ListOpts{LabelSelector: d.labelName(labelAutoSpreadPg)},
+		})
+		if err != nil {
+			return fmt.Errorf("could not list placement groups: %w", err)
+		}
+		if len(res) != 0 {
+			grp = res[0]
+		}
+		name = "Docker-Machine auto spread"
+	} else {
+		var err error
+		if grp, _, err = d.getClient().PlacementGroup.Get(d.getContext(), name); err != nil {
+			return fmt.Errorf("could not get placement group: %w", err)
+		}
+	}
+
+	if grp != nil {
+		log.Infof(" -> use placement group %s[%d]", grp.Name, grp.ID)
+	} else {
+		log.Infof(" -> create spread placement group %s", name)
+	}
+	return nil
+}
+
+func (d *Driver) planLoadBalancer() error {
+	lb, err := d.getLoadBalancerNullable()
+	if err != nil {
+		return err
+	}
+
+	if lb != nil {
+		log.Infof(" -> add the server as target of load balancer %s[%d]", lb.Name, lb.ID)
+	} else if d.LoadBalancer != "" {
+		opts, err := d.makeLoadBalancerCreateOpts(d.lbDefinition)
+		if err != nil {
+			return err
+		}
+		log.Infof(" -> create load balancer %s of type %s, labels %v, and add the server as target",
+			opts.Name, opts.LoadBalancerType.Name, formatLabels(opts.Labels))
+	}
+
+	selected, err := d.getSelectedLoadBalancers()
+	if err != nil {
+		return err
+	}
+	for _, lb := range selected {
+		log.Infof(" -> add the server as target of load balancer %s[%d]", lb.Name, lb.ID)
+	}
+	return nil
+}
+
+func (d *Driver) planVolumes() error {
+	for _, volumeIDorName := range d.Volumes {
+		volume, _, err := d.getClient().Volume.Get(d.getContext(), volumeIDorName)
+		if err != nil {
+			return fmt.Errorf("could not get volume by ID or name: %w", err)
+		}
+		if volume == nil {
+			return fmt.Errorf("volume '%s' not found", volumeIDorName)
+		}
+		log.Infof(" -> attach volume %s[%d] (%d GB)", volume.Name, volume.ID, volume.Size)
+	}
+
+	attach, err := d.getAttachVolumes()
+	if err != nil {
+		return err
+	}
+	for _, volume := range attach {
+		log.Infof(" -> attach volume %s[%d] (%d GB)", volume.Name, volume.ID, volume.Size)
+	}
+
+	if d.dockerDataVolumeSize != 0 {
+		log.Infof(" -> create Docker data volume %s (%d GB) in %v", d.GetMachineName()+"-docker",
+			d.dockerDataVolumeSize, d.Location)
+	}
+	return nil
+}
+
+func (d *Driver) planServer() error {
+	serverType, err := d.getType()
+	if err != nil {
+		return fmt.Errorf("could not get type: %w", err)
+	}
+	image, err := d.getImage()
+	if err != nil {
+		return fmt.Errorf("could not get image: %w", err)
+	}
+	location, err := d.getLocationNullable()
+	if err != nil {
+		return fmt.Errorf("could not get location: %w", err)
+	}
+
+	locationName := "a location chosen by the API"
+	if location != nil {
+		locationName = location.Name
+	}
+
+	log.Infof(" -> create server %s of type %s from image %s[%d] in %s, labels %v", d.getNewServerName(),
+		serverType.Name, image.Name, image.ID, locationName, formatLabels(d.machineLabels(d.ServerLabels)))
+
+	for _, pricing := range serverType.Pricings {
+		if location != nil && pricing.Location.Name != location.Name {
+			continue
+		}
+		log.Infof("    estimated price in %s: %s %s/month, %s %s/hour (gross, without traffic, volumes or IPs)",
+			pricing.Location.Name, pricing.Monthly.Gross, pricing.Monthly.Currency,
+			pricing.Hourly.Gross, pricing.Hourly.Currency)
+	}
+	return nil
+}
+
+// formatLabels renders labels in a stable order, as used by label selectors
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
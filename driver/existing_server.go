@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+func (d *Driver) getExistingServer() (*hcloud.Server, error) {
+	if d.cachedServer != nil {
+		return d.cachedServer, nil
+	}
+
+	srv, _, err := d.getClient().Server.Get(d.getContext(), d.existingServer)
+	if err != nil {
+		return nil, fmt.Errorf("could not get server by ID or name: %w", err)
+	}
+	if srv == nil {
+		return nil, fmt.Errorf("server not found: %v", d.existingServer)
+	}
+
+	d.cachedServer = srv
+	return instrumented(srv), nil
+}
+
+func (d *Driver) verifyExistingServer() error {
+	srv, err := d.getExistingServer()
+	if err != nil {
+		return err
+	}
+
+	// record the actual server details instead of the creation defaults, e.g. for later rebuilds or type changes
+	if srv.ServerType != nil {
+		d.Type = srv.ServerType.Name
+		d.cachedType = srv.ServerType
+	}
+	if srv.Datacenter != nil && srv.Datacenter.Location != nil {
+		d.Location = srv.Datacenter.Location.Name
+		d.cachedLocation = srv.Datacenter.Location
+	}
+	if srv.Image != nil {
+		d.Image, d.ImageID = "", srv.Image.ID
+		d.cachedImage = srv.Image
+		d.setImageSSHUser(srv.Image)
+	}
+
+	return d.verifyLoadBalancer()
+}
+
+// adoptExistingServer records an existing server as the machine's server, instead of creating a new one. The server
+// was not created for the machine, so removing the machine leaves it running, unless
+// --hetzner-existing-server-delete-on-remove is given.
+func (d *Driver) adoptExistingServer() (*hcloud.ServerCreateResult, error) {
+	if err := d.prepareLocalKey(); err != nil {
+		return nil, err
+	}
+
+	srv, err := d.getExistingServer()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Adopting existing server %s[%d]...", srv.Name, srv.ID)
+
+	d.ServerID = srv.ID
+	d.ServerName = srv.Name
+	d.KeepServer = !d.deleteAdopted
+	d.checkpoint()
+
+	return &hcloud.ServerCreateResult{Server: srv}, nil
+}
+
+func (d *Driver) verifySSHAccess() error {
+	log.Infof(" -> Verifying SSH access to %s...", d.IPAddress)
+	if _, err := d.runRemote("true"); err != nil {
+		return fmt.Errorf("could not access server via SSH with the given key: %w", err)
+	}
+	return nil
+}
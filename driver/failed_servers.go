@@ -0,0 +1,51 @@
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const (
+	labelFailed    = "failed"
+	labelKeepUntil = "keep-until"
+)
+
+// holdFailedServer labels the server of a failed creation, so it survives garbage collection until the hold expires
+func (d *Driver) holdFailedServer() error {
+	srv, err := d.getServerHandle()
+	if err != nil {
+		return fmt.Errorf("could not get server handle: %w", err)
+	}
+
+	until := time.Now().Add(d.keepOnError)
+	labels := make(map[string]string, len(srv.Labels)+2)
+	for k, v := range srv.Labels {
+		labels[k] = v
+	}
+	labels[d.labelName(labelFailed)] = "true"
+	labels[d.labelName(labelKeepUntil)] = strconv.FormatInt(until.Unix(), 10)
+
+	if _, _, err = d.getClient().Server.Update(d.getContext(), srv, hcloud.ServerUpdateOpts{Labels: labels}); err != nil {
+		return fmt.Errorf("could not label failed server: %w", err)
+	}
+
+	log.Warnf("Keeping failed server %s[%d] for debugging until %v", srv.Name, srv.ID, until.Format(time.RFC3339))
+	return nil
+}
+
+// failedServerHold returns when the hold of a failed server expires, if the server was labeled as failed
+func (d *Driver) failedServerHold(srv *hcloud.Server) (time.Time, bool) {
+	if srv.Labels[d.labelName(labelFailed)] != "true" {
+		return time.Time{}, false
+	}
+
+	until, err := strconv.ParseInt(srv.Labels[d.labelName(labelKeepUntil)], 10, 64)
+	if err != nil {
+		return time.Time{}, true // no valid hold, so it has expired
+	}
+	return time.Unix(until, 0), true
+}
@@ -0,0 +1,6 @@
+package driver
+
+import "time"
+
+// fastCreateWaitInterval is the default polling interval for actions and SSH with --hetzner-fast-create
+const fastCreateWaitInterval = 500 * time.Millisecond
@@ -3,6 +3,9 @@ package driver
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -47,7 +50,7 @@ func (d *Driver) verifyImageFlags() error {
 		return d.flagFailure("--%v and --%v are mutually exclusive", flagImage, flagImageID)
 	} else if d.ImageID != 0 && d.ImageArch != "" {
 		return d.flagFailure("--%v and --%v are mutually exclusive", flagImageArch, flagImageID)
-	} else if d.ImageID == 0 && d.Image == "" {
+	} else if d.ImageID == 0 && d.Image == "" && d.FromGolden == "" {
 		d.Image = defaultImage
 	}
 	return nil
@@ -78,8 +81,8 @@ func (d *Driver) deprecatedBooleanFlag(opts drivers.DriverOptions, flag, depreca
 	return opts.Bool(flag)
 }
 
-// mergeYAMLDocs merges two YAML documents, merging arrays under the same key.
-func mergeYAMLDocs(doc1, doc2 string) (string, error) {
+// mergeYAMLDocs merges two YAML documents, merging arrays under the same key, optionally dropping duplicate entries.
+func mergeYAMLDocs(doc1, doc2 string, dedupe bool) (string, error) {
 	var m1, m2 map[string]interface{}
 
 	if err := yaml.Unmarshal([]byte(doc1), &m1); err != nil {
@@ -89,7 +92,11 @@ func mergeYAMLDocs(doc1, doc2 string) (string, error) {
 		return "", fmt.Errorf("failed to unmarshal second YAML: %w", err)
 	}
 
-	merged := mergeMaps(m1, m2)
+	if m1 == nil {
+		// an empty first document, i.e. nothing merged yet
+		m1 = make(map[string]interface{})
+	}
+	merged := mergeMaps(m1, m2, dedupe)
 
 	out, err := yaml.Marshal(merged)
 	if err != nil {
@@ -105,16 +112,18 @@ func mergeYAMLDocs(doc1, doc2 string) (string, error) {
 }
 
 // mergeMaps recursively merges src into dst, merging arrays under the same key.
-func mergeMaps(dst, src map[string]interface{}) map[string]interface{} {
+func mergeMaps(dst, src map[string]interface{}, dedupe bool) map[string]interface{} {
 	for k, v := range src {
 		if vMap, ok := v.(map[string]interface{}); ok {
 			if dstMap, ok := dst[k].(map[string]interface{}); ok {
-				dst[k] = mergeMaps(dstMap, vMap)
+				dst[k] = mergeMaps(dstMap, vMap, dedupe)
 			} else {
 				dst[k] = vMap
 			}
 		} else if vArr, ok := v.([]interface{}); ok {
-			if dstArr, ok := dst[k].([]interface{}); ok {
+			if dstArr, ok := dst[k].([]interface{}); ok && dedupe {
+				dst[k] = dedupeList(append(dstArr, vArr...))
+			} else if ok {
 				dst[k] = append(dstArr, vArr...)
 			} else {
 				dst[k] = vArr
@@ -126,10 +135,30 @@ func mergeMaps(dst, src map[string]interface{}) map[string]interface{} {
 	return dst
 }
 
+// dedupeList drops repeated entries from a list, keeping the first occurrence of each
+func dedupeList(list []interface{}) []interface{} {
+	res := make([]interface{}, 0, len(list))
+	for _, entry := range list {
+		if !slices.ContainsFunc(res, func(seen interface{}) bool { return reflect.DeepEqual(seen, entry) }) {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
 func (d *Driver) setUserDataFlags(opts drivers.DriverOptions) error {
 	userData := opts.String(flagUserData)
 	userDataFile := opts.String(flagUserDataFile)
 	additionalUserData := opts.String(flagAdditionalUserData)
+	d.userDataDedupe = opts.Bool(flagUserDataDedupe)
+	if dir := opts.String(flagUserDataDir); dir != "" {
+		// later operations may run from another working directory
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		d.userDataDir = abs
+	}
 
 	if opts.Bool(legacyFlagUserDataFromFile) {
 		if userDataFile != "" {
@@ -143,7 +172,7 @@ func (d *Driver) setUserDataFlags(opts drivers.DriverOptions) error {
 			if err != nil {
 				return err
 			}
-			merged, err := mergeYAMLDocs(strings.ReplaceAll(additionalUserData, `\n`, "\n"), string(content))
+			merged, err := mergeYAMLDocs(strings.ReplaceAll(additionalUserData, `\n`, "\n"), string(content), d.userDataDedupe)
 			if err != nil {
 				return fmt.Errorf("failed to merge user data YAML: %w", err)
 			}
@@ -157,21 +186,38 @@ func (d *Driver) setUserDataFlags(opts drivers.DriverOptions) error {
 
 	d.userData = userData
 	d.userDataFile = userDataFile
+	d.userDataChecksum = opts.String(flagUserDataChecksum)
 
 	if d.userData != "" && d.userDataFile != "" {
 		return d.flagFailure("--%v and --%v are mutually exclusive", flagUserData, flagUserDataFile)
 	}
+	if d.userDataChecksum != "" {
+		if !isUserDataURL(d.userDataFile) {
+			return d.flagFailure("--%v requires --%v to be an http(s) URL", flagUserDataChecksum, flagUserDataFile)
+		}
+		if _, _, err := parseUserDataChecksum(d.userDataChecksum); err != nil {
+			return d.flagFailure("invalid --%v: %v", flagUserDataChecksum, err)
+		}
+	}
 
 	return nil
 }
 
 func (d *Driver) setLabelsFromFlags(opts drivers.DriverOptions) error {
 	d.ServerLabels = make(map[string]string)
+	if path := opts.String(flagServerLabelsFile); path != "" {
+		if err := d.readLabelsFile(path); err != nil {
+			return d.flagFailure("could not use --%v: %v", flagServerLabelsFile, err)
+		}
+	}
 	for _, label := range opts.StringSlice(flagServerLabel) {
 		split := strings.SplitN(label, "=", 2)
 		if len(split) != 2 {
 			return d.flagFailure("server label %v is not in key=value format", label)
 		}
+		if err := validateLabel(split[0], split[1]); err != nil {
+			return d.flagFailure("invalid --%v: %v", flagServerLabel, err)
+		}
 		d.ServerLabels[split[0]] = split[1]
 	}
 	d.keyLabels = make(map[string]string)
@@ -180,7 +226,63 @@ func (d *Driver) setLabelsFromFlags(opts drivers.DriverOptions) error {
 		if len(split) != 2 {
 			return fmt.Errorf("key label %v is not in key=value format", label)
 		}
+		if err := validateLabel(split[0], split[1]); err != nil {
+			return d.flagFailure("invalid --%v: %v", flagKeyLabel, err)
+		}
 		d.keyLabels[split[0]] = split[1]
 	}
 	return nil
 }
+
+// readLabelsFile adds the labels of a YAML or JSON map to the server labels; scalar values are accepted as labels
+// like version: 2 would otherwise need quoting
+func (d *Driver) readLabelsFile(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var labels map[string]interface{}
+	if err = yaml.Unmarshal(buf, &labels); err != nil {
+		return fmt.Errorf("could not parse %v: %w", path, err)
+	}
+
+	for k, v := range labels {
+		var value string
+		switch v := v.(type) {
+		case nil:
+		case string:
+			value = v
+		case int, float64, bool:
+			value = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("label %v must have a scalar value", k)
+		}
+		d.ServerLabels[k] = value
+	}
+	return validateLabels(d.ServerLabels)
+}
+
+func (d *Driver) setLoadBalancerFlags(opts drivers.DriverOptions) error {
+	d.LoadBalancer = opts.String(flagLBTarget)
+	d.lbUsePrivateIP = opts.Bool(flagLBUsePrivateIP)
+	d.lbSelector = opts.String(flagLBSelector)
+
+	if d.lbUsePrivateIP && len(d.Networks) == 0 {
+		return d.flagFailure("--%v requires at least one --%v shared with the load balancer", flagLBUsePrivateIP, flagNetworks)
+	}
+
+	file := opts.String(flagLBCreateFromFile)
+	if file == "" {
+		return nil
+	}
+	if d.LoadBalancer == "" {
+		return d.flagFailure("--%v requires --%v to name the load balancer", flagLBCreateFromFile, flagLBTarget)
+	}
+
+	def, err := readLoadBalancerDefinition(file)
+	if err != nil {
+		return err
+	}
+	d.lbDefinition = def
+	return nil
+}
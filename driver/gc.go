@@ -0,0 +1,196 @@
+package driver
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// Orphan is a resource marked as belonging to a machine that does not exist locally anymore
+type Orphan struct {
+	Kind    string
+	ID      int64
+	Name    string
+	Machine string
+
+	destroy func() error
+}
+
+func (o Orphan) String() string {
+	return fmt.Sprintf("%s %s[%d] of machine %s", o.Kind, o.Name, o.ID, o.Machine)
+}
+
+// FindOrphans lists all servers, SSH keys, primary IPs, firewalls and volumes labeled with this store whose machine
+// label does not correspond to any of the given machines, as well as shared SSH keys not referenced by any of them.
+// Resources of other stores are left alone, as their machines are not known locally, and so are resources without
+// store label, e.g. created by versions before stores were labeled, unless includeUnlabeled is set. The label selector,
+// if given, narrows down the resources further. Servers are listed first, as other resources may only become deletable
+// once they are gone.
+func (d *Driver) FindOrphans(machines []string, selector string, includeUnlabeled bool) ([]Orphan, error) {
+	ctx := d.getContext()
+	client := d.getClient()
+	opts := hcloud.ListOpts{LabelSelector: d.labelName(labelMachine)}
+	sharedOpts := hcloud.ListOpts{LabelSelector: d.labelName(labelSharedKey)}
+	if selector != "" {
+		opts.LabelSelector += "," + selector
+		sharedOpts.LabelSelector += "," + selector
+	}
+	eligible := func(labels map[string]string) bool {
+		_, labeled := labels[d.labelName(labelStore)]
+		return d.ownStore(labels) || includeUnlabeled && !labeled
+	}
+
+	// resources are labeled with the shortened machine names, as the full ones may be too long for label values
+	short := make([]string, 0, len(machines))
+	for _, machine := range machines {
+		short = append(short, shortMachineName(machine))
+	}
+
+	var orphans []Orphan
+	add := func(kind string, id int64, name string, labels map[string]string, force bool, destroy func() error) {
+		if !eligible(labels) {
+			return
+		}
+		if force || !slices.Contains(short, labels[d.labelName(labelMachine)]) {
+			orphans = append(orphans, Orphan{Kind: kind, ID: id, Name: name, Machine: d.labeledMachineName(labels),
+				destroy: destroy})
+		}
+	}
+
+	servers, err := client.Server.AllWithOpts(ctx, hcloud.ServerListOpts{ListOpts: opts})
+	if err != nil {
+		return nil, fmt.Errorf("could not list servers: %w", err)
+	}
+	for _, srv := range servers {
+		srv := srv
+
+		// servers held after a failed creation survive until the hold expires, and are collected afterward even if
+		// the failed machine still exists locally
+		kind, expired := "server", false
+		if until, failed := d.failedServerHold(srv); failed {
+			if time.Now().Before(until) {
+				log.Debugf("keeping failed server %s[%d] until %v", srv.Name, srv.ID, until)
+				continue
+			}
+			kind, expired = "failed server", true
+		}
+
+		add(kind, srv.ID, srv.Name, srv.Labels, expired, func() error {
+			res, _, err := client.Server.DeleteWithResult(ctx, srv)
+			if err != nil {
+				return err
+			}
+			return d.waitForAction(res.Action)
+		})
+	}
+
+	keys, err := client.SSHKey.AllWithOpts(ctx, hcloud.SSHKeyListOpts{ListOpts: opts})
+	if err != nil {
+		return nil, fmt.Errorf("could not list ssh keys: %w", err)
+	}
+	for _, key := range keys {
+		key := key
+		add("ssh key", key.ID, key.Name, key.Labels, false, func() error {
+			_, err := client.SSHKey.Delete(ctx, key)
+			return err
+		})
+	}
+
+	// shared keys carry no machine label, but are orphaned once none of the machines referencing them exist. Keys
+	// referenced from other stores, or still used by servers whose references were lost, are kept.
+	shared, err := client.SSHKey.AllWithOpts(ctx, hcloud.SSHKeyListOpts{
+		ListOpts: sharedOpts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list shared ssh keys: %w", err)
+	}
+	for _, key := range shared {
+		key := key
+		refs := keyReferences(key)
+		if !eligible(key.Labels) || !d.ownKeyReferences(key, includeUnlabeled) ||
+			slices.ContainsFunc(refs, func(ref string) bool { return slices.Contains(short, ref) }) {
+			continue
+		}
+		users, err := d.otherKeyUsers(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(users) != 0 {
+			log.Debugf("keeping shared ssh key %s[%d], still used by servers %v", key.Name, key.ID, users)
+			continue
+		}
+		orphans = append(orphans, Orphan{Kind: "shared ssh key", ID: key.ID, Name: key.Name,
+			Machine: strings.Join(refs, ","), destroy: func() error {
+				_, err := client.SSHKey.Delete(ctx, key)
+				return err
+			}})
+	}
+
+	ips, err := client.PrimaryIP.AllWithOpts(ctx, hcloud.PrimaryIPListOpts{ListOpts: opts})
+	if err != nil {
+		return nil, fmt.Errorf("could not list primary IPs: %w", err)
+	}
+	for _, ip := range ips {
+		ip := ip
+		add("primary IP", ip.ID, ip.Name, ip.Labels, false, func() error {
+			_, err := client.PrimaryIP.Delete(ctx, ip)
+			return err
+		})
+	}
+
+	firewalls, err := client.Firewall.AllWithOpts(ctx, hcloud.FirewallListOpts{ListOpts: opts})
+	if err != nil {
+		return nil, fmt.Errorf("could not list firewalls: %w", err)
+	}
+	for _, fw := range firewalls {
+		fw := fw
+		add("firewall", fw.ID, fw.Name, fw.Labels, false, func() error {
+			_, err := client.Firewall.Delete(ctx, fw)
+			return err
+		})
+	}
+
+	volumes, err := client.Volume.AllWithOpts(ctx, hcloud.VolumeListOpts{ListOpts: opts})
+	if err != nil {
+		return nil, fmt.Errorf("could not list volumes: %w", err)
+	}
+	for _, volume := range volumes {
+		volume := volume
+		add("volume", volume.ID, volume.Name, volume.Labels, false, func() error {
+			// attachments to orphaned servers are gone by now, so refresh the volume before checking for others
+			current, _, err := client.Volume.GetByID(ctx, volume.ID)
+			if err != nil {
+				return err
+			} else if current == nil {
+				return nil
+			} else if current.Server != nil {
+				return fmt.Errorf("volume is still attached to server %d", current.Server.ID)
+			}
+			_, err = client.Volume.Delete(ctx, current)
+			return err
+		})
+	}
+
+	return orphans, nil
+}
+
+// DeleteOrphans deletes the given orphans in order, continuing after failures
+func (d *Driver) DeleteOrphans(orphans []Orphan) error {
+	failed := 0
+	for _, orphan := range orphans {
+		log.Infof(" -> Destroying %v...", orphan)
+		if err := orphan.destroy(); err != nil {
+			log.Warnf(" ->  -> could not destroy %v: %v", orphan, err)
+			failed++
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("could not destroy %d of %d orphaned resources", failed, len(orphans))
+	}
+	return nil
+}
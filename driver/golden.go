@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const labelGolden = "golden"
+
+func (d *Driver) setGoldenSnapshotFlags(opts drivers.DriverOptions) error {
+	d.FromGolden = opts.String(flagFromGolden)
+	if d.FromGolden != "" && (d.Image != "" || d.ImageID != 0) {
+		return d.flagFailure("--%v is mutually exclusive with --%v and --%v", flagFromGolden, flagImage, flagImageID)
+	}
+
+	d.GoldenSnapshot = opts.String(flagGoldenSnapshot)
+	if d.GoldenSnapshot == "" {
+		return nil
+	}
+	if err := validateLabel(d.labelName(labelGolden), d.GoldenSnapshot); err != nil {
+		return d.flagFailure("invalid --%v: %v", flagGoldenSnapshot, err)
+	}
+	return nil
+}
+
+// goldenSelector selects the golden snapshots given by --hetzner-from-golden, either by golden image name or by a
+// label selector of its own
+func (d *Driver) goldenSelector() string {
+	if labelValuePattern.MatchString(d.FromGolden) {
+		return d.labelName(labelGolden) + "=" + d.FromGolden
+	}
+	return d.labelName(labelGolden) + "," + d.FromGolden
+}
+
+// getGoldenImage finds the newest available golden snapshot for the server's architecture
+func (d *Driver) getGoldenImage(arch hcloud.Architecture) (*hcloud.Image, error) {
+	images, err := d.getClient().Image.AllWithOpts(d.getContext(), hcloud.ImageListOpts{
+		ListOpts:     hcloud.ListOpts{LabelSelector: d.goldenSelector()},
+		Type:         []hcloud.ImageType{hcloud.ImageTypeSnapshot},
+		Status:       []hcloud.ImageStatus{hcloud.ImageStatusAvailable},
+		Architecture: []hcloud.Architecture{arch},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list golden snapshots: %w", err)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no golden snapshot for %v matching %v", arch, d.goldenSelector())
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].Created.After(images[j].Created) })
+	log.Infof(" -> Using golden snapshot %s[%d], created %v", images[0].Description, images[0].ID, images[0].Created)
+	return images[0], nil
+}
+
+// CreateGoldenSnapshot powers the server down for a consistent snapshot, labeled docker-machine/golden=<label> for
+// creating machines from it later, and powers it back on if it was running
+func (d *Driver) CreateGoldenSnapshot(label string) (*hcloud.Image, error) {
+	if err := validateLabel(d.labelName(labelGolden), label); err != nil {
+		return nil, err
+	}
+
+	st, err := d.GetState()
+	if err != nil {
+		return nil, fmt.Errorf("could not get state: %w", err)
+	}
+	running := st == state.Running
+	if running {
+		if err = d.Stop(); err != nil {
+			return nil, fmt.Errorf("could not stop server: %w", err)
+		}
+	}
+
+	image, err := d.snapshotStoppedServer(label)
+	if running {
+		if startErr := d.Start(); startErr != nil {
+			err = errors.Join(err, fmt.Errorf("could not start server: %w", startErr))
+		}
+	}
+	return image, err
+}
+
+func (d *Driver) snapshotStoppedServer(label string) (*hcloud.Image, error) {
+	srv, err := d.getServerHandle()
+	if err != nil {
+		return nil, fmt.Errorf("could not get server handle: %w", err)
+	}
+
+	description := fmt.Sprintf("docker-machine %s, golden %s, %s", d.GetMachineName(), label, time.Now().UTC().Format(time.RFC3339))
+	labels := map[string]string{d.labelName(labelGolden): label}
+	// machines created from the snapshot log in as the same user
+	if labelValuePattern.MatchString(d.GetSSHUsername()) {
+		labels[d.labelName(labelSSHUser)] = d.GetSSHUsername()
+	}
+	res, _, err := d.getClient().Server.CreateImage(d.getContext(), srv, instrumented(&hcloud.ServerCreateImageOpts{
+		Type:        hcloud.ImageTypeSnapshot,
+		Description: hcloud.Ptr(description),
+		Labels:      d.resourceLabels(labels),
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("could not create snapshot: %w", err)
+	}
+
+	log.Infof(" -> Creating golden snapshot %s[%d] of server %s[%d] in %s[%d]...", description, res.Image.ID, srv.Name, srv.ID, res.Action.Command, res.Action.ID)
+
+	if err = d.waitForAction(res.Action); err != nil {
+		return nil, fmt.Errorf("could not wait for snapshot: %w", err)
+	}
+	return res.Image, nil
+}
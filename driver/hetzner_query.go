@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/machine/libmachine/log"
@@ -13,9 +16,14 @@ import (
 
 func (d *Driver) getClient() *hcloud.Client {
 	opts := []hcloud.ClientOption{
-		hcloud.WithToken(d.AccessToken),
-		hcloud.WithApplication("docker-machine-driver", d.version),
-		hcloud.WithPollBackoffFunc(hcloud.ConstantBackoff(time.Duration(d.WaitOnPolling) * time.Second)),
+		hcloud.WithToken(d.getAccessToken()),
+		hcloud.WithApplication(d.getAPIApplication()),
+		hcloud.WithPollBackoffFunc(hcloud.ConstantBackoff(d.getWaitInterval())),
+		hcloud.WithHTTPClient(&http.Client{Transport: d.getTransport()}),
+	}
+
+	if d.APIEndpoint != "" {
+		opts = append(opts, hcloud.WithEndpoint(d.APIEndpoint))
 	}
 
 	opts = d.setupClientInstrumentation(opts)
@@ -23,6 +31,50 @@ func (d *Driver) getClient() *hcloud.Client {
 	return hcloud.NewClient(opts...)
 }
 
+// getAPIApplication returns the application name and version reported to the API as part of the user agent
+func (d *Driver) getAPIApplication() (string, string) {
+	if d.APIApplication == "" {
+		return defaultAPIApplication, d.version
+	}
+
+	name, version, _ := strings.Cut(d.APIApplication, "/")
+	return name, version
+}
+
+// getTransport layers retries, debug logging and throttling, in this order, on top of the base transport, so every
+// single attempt is logged and throttled
+func (d *Driver) getTransport() http.RoundTripper {
+	var transport http.RoundTripper = &throttleTransport{next: d.getBaseTransport(), state: &d.rateLimit}
+	if d.DebugHTTP {
+		transport = &debugTransport{next: transport, token: d.getAccessToken()}
+	}
+	return &retryTransport{next: transport, maxAttempts: d.getAPIMaxAttempts()}
+}
+
+// isAPIError is like [hcloud.IsError], but also matches wrapped errors
+func isAPIError(err error, code hcloud.ErrorCode) bool {
+	var apiErr hcloud.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// getWaitInterval returns the period between polls for actions and state changes
+func (d *Driver) getWaitInterval() time.Duration {
+	if d.WaitInterval > 0 {
+		return d.WaitInterval
+	}
+	if d.fastCreate {
+		return fastCreateWaitInterval
+	}
+	return time.Duration(d.WaitOnPolling) * time.Second
+}
+
+func (d *Driver) getAPIMaxAttempts() int {
+	if d.APIMaxAttempts <= 0 { // machines created before the option existed
+		return defaultAPIMaxAttempts
+	}
+	return d.APIMaxAttempts
+}
+
 func (d *Driver) getLocationNullable() (*hcloud.Location, error) {
 	if d.cachedLocation != nil {
 		return d.cachedLocation, nil
@@ -31,7 +83,10 @@ func (d *Driver) getLocationNullable() (*hcloud.Location, error) {
 		return nil, nil
 	}
 
-	location, _, err := d.getClient().Location.GetByName(context.Background(), d.Location)
+	location, err := cachedLookup(d, catalogLocation, d.Location, func() (*hcloud.Location, error) {
+		location, _, err := d.getClient().Location.GetByName(d.getContext(), d.Location)
+		return location, err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("could not get location by name: %w", err)
 	}
@@ -47,7 +102,10 @@ func (d *Driver) getType() (*hcloud.ServerType, error) {
 		return d.cachedType, nil
 	}
 
-	stype, _, err := d.getClient().ServerType.GetByName(context.Background(), d.Type)
+	stype, err := cachedLookup(d, catalogServerType, d.Type, func() (*hcloud.ServerType, error) {
+		stype, _, err := d.getClient().ServerType.GetByName(d.getContext(), d.Type)
+		return stype, err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("could not get type by name: %w", err)
 	}
@@ -66,8 +124,21 @@ func (d *Driver) getImage() (*hcloud.Image, error) {
 	var image *hcloud.Image
 	var err error
 
-	if d.ImageID != 0 {
-		image, _, err = d.getClient().Image.GetByID(context.Background(), d.ImageID)
+	if d.ImageID == 0 && d.FromGolden != "" {
+		arch, err := d.getImageArchitectureForLookup()
+		if err != nil {
+			return nil, fmt.Errorf("could not determine image architecture: %w", err)
+		}
+		if image, err = d.getGoldenImage(arch); err != nil {
+			return nil, err
+		}
+		// record the snapshot, so the machine can be rebuilt from it even once newer ones exist
+		d.ImageID = image.ID
+	} else if d.ImageID != 0 {
+		image, err = cachedLookup(d, catalogImage, strconv.FormatInt(d.ImageID, 10), func() (*hcloud.Image, error) {
+			image, _, err := d.getClient().Image.GetByID(d.getContext(), d.ImageID)
+			return image, err
+		})
 		if err != nil {
 			return nil, fmt.Errorf("could not get image by id %v: %w", d.ImageID, err)
 		}
@@ -80,7 +151,10 @@ func (d *Driver) getImage() (*hcloud.Image, error) {
 			return nil, fmt.Errorf("could not determine image architecture: %w", err)
 		}
 
-		image, _, err = d.getClient().Image.GetByNameAndArchitecture(context.Background(), d.Image, arch)
+		image, err = cachedLookup(d, catalogImage, d.Image+"@"+string(arch), func() (*hcloud.Image, error) {
+			image, _, err := d.getClient().Image.GetByNameAndArchitecture(d.getContext(), d.Image, arch)
+			return image, err
+		})
 		if err != nil {
 			return nil, fmt.Errorf("could not get image by name %v: %w", d.Image, err)
 		}
@@ -122,7 +196,7 @@ func (d *Driver) getKeyNullable() (*hcloud.SSHKey, error) {
 		return d.cachedKey, nil
 	}
 
-	key, _, err := d.getClient().SSHKey.GetByID(context.Background(), d.KeyID)
+	key, _, err := d.getClient().SSHKey.GetByID(d.getContext(), d.KeyID)
 	if err != nil {
 		return nil, fmt.Errorf("could not get sshkey by ID: %w", err)
 	}
@@ -130,6 +204,49 @@ func (d *Driver) getKeyNullable() (*hcloud.SSHKey, error) {
 	return instrumented(key), nil
 }
 
+// getKeyByNameOrIDNullable finds an uploaded key by its name or ID
+func (d *Driver) getKeyByNameOrIDNullable(ref string) (*hcloud.SSHKey, error) {
+	key, err := cachedLookup(d, catalogSSHKey, ref, func() (*hcloud.SSHKey, error) {
+		key, _, err := d.getClient().SSHKey.Get(d.getContext(), ref)
+		return key, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get sshkey by name or ID: %w", err)
+	}
+	return instrumented(key), nil
+}
+
+// getKeyByFingerprintNullable finds an uploaded key by its MD5 fingerprint, as reported by the API, or by its SHA256
+// fingerprint, as printed by recent versions of ssh-keygen, which the API cannot filter by
+func (d *Driver) getKeyByFingerprintNullable(fp string) (*hcloud.SSHKey, error) {
+	if !strings.HasPrefix(fp, sha256FingerprintPrefix) {
+		key, err := cachedLookup(d, catalogSSHKey, fp, func() (*hcloud.SSHKey, error) {
+			key, _, err := d.getClient().SSHKey.GetByFingerprint(d.getContext(), fp)
+			return key, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not get sshkey by fingerprint: %w", err)
+		}
+		return instrumented(key), nil
+	}
+
+	keys, err := d.getClient().SSHKey.All(d.getContext())
+	if err != nil {
+		return nil, fmt.Errorf("could not list ssh keys: %w", err)
+	}
+	for _, key := range keys {
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+		if err != nil {
+			log.Debugf("could not parse public key of %v[%d]: %v", key.Name, key.ID, err)
+			continue
+		}
+		if ssh.FingerprintSHA256(publicKey) == fp {
+			return instrumented(key), nil
+		}
+	}
+	return nil, nil
+}
+
 func (d *Driver) getRemoteKeyWithSameFingerprintNullable(publicKeyBytes []byte) (*hcloud.SSHKey, error) {
 	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(publicKeyBytes)
 	if err != nil {
@@ -138,7 +255,10 @@ func (d *Driver) getRemoteKeyWithSameFingerprintNullable(publicKeyBytes []byte)
 
 	fp := ssh.FingerprintLegacyMD5(publicKey)
 
-	remoteKey, _, err := d.getClient().SSHKey.GetByFingerprint(context.Background(), fp)
+	remoteKey, err := cachedLookup(d, catalogSSHKey, fp, func() (*hcloud.SSHKey, error) {
+		remoteKey, _, err := d.getClient().SSHKey.GetByFingerprint(d.getContext(), fp)
+		return remoteKey, err
+	})
 	if err != nil {
 		return remoteKey, fmt.Errorf("could not get sshkey by fingerprint: %w", err)
 	}
@@ -165,7 +285,7 @@ func (d *Driver) getServerHandleNullable() (*hcloud.Server, error) {
 		return nil, errors.New("server ID was 0")
 	}
 
-	srv, _, err := d.getClient().Server.GetByID(context.Background(), d.ServerID)
+	srv, _, err := d.getClient().Server.GetByID(d.getContext(), d.ServerID)
 	if err != nil {
 		return nil, fmt.Errorf("could not get client by ID: %w", err)
 	}
@@ -174,52 +294,93 @@ func (d *Driver) getServerHandleNullable() (*hcloud.Server, error) {
 	return srv, nil
 }
 
+// waitContext limits waiting to the action timeout, within the running operation
+func (d *Driver) waitContext() (context.Context, context.CancelFunc) {
+	if d.ActionTimeout > 0 {
+		return context.WithTimeout(d.getContext(), time.Duration(d.ActionTimeout)*time.Second)
+	}
+	return context.WithCancel(d.getContext())
+}
+
 func (d *Driver) waitForAction(a *hcloud.Action) error {
-	progress, done := d.getClient().Action.WatchProgress(context.Background(), a)
+	ctx, cancel := d.waitContext()
+	defer cancel()
+	progress, done := d.getClient().Action.WatchProgress(ctx, a)
 
-	running := true
 	var ret error
-
-	for running {
+	for running := true; running; {
 		select {
-		case <-done:
-			ret = <-done
+		case ret = <-done:
 			running = false
-		case <-progress:
-			log.Debugf(" -> %s[%d]: %d %%", a.Command, a.ID, <-progress)
+		case p, ok := <-progress:
+			if !ok {
+				progress = nil
+				continue
+			}
+			log.Debugf(" -> %s[%d]: %d %%", a.Command, a.ID, p)
 		}
 	}
 
+	var actionErr hcloud.ActionError
 	if ret == nil {
 		log.Debugf(" -> finished %s[%d]", a.Command, a.ID)
+	} else if errors.Is(ret, context.DeadlineExceeded) {
+		ret = fmt.Errorf("timed out waiting for %s: %w", describeAction(a), ret)
+		// the operation's own deadline is named by the operation
+		if d.getContext().Err() == nil {
+			ret = timeoutError(ctx, ret, flagActionTimeout, time.Duration(d.ActionTimeout)*time.Second)
+		}
+	} else if errors.As(ret, &actionErr) {
+		ret = fmt.Errorf("%s failed: %w", describeAction(a), ret)
 	}
 
 	return ret
 }
 
 func (d *Driver) waitForMultipleActions(step string, a []*hcloud.Action) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := d.waitContext()
 	defer cancel()
 	progress, watchErr := d.getClient().Action.WatchOverallProgress(ctx, a)
 
-	running := true
 	var ret error
-
-	for running {
+	for running := true; running; {
 		select {
-		case <-watchErr:
-			ret = errors.Join(ret, <-watchErr)
-			cancel()
-		case <-progress:
-			log.Debugf(" -> %s: %d %%", step, <-progress)
-		default:
-			running = false
+		case err, ok := <-watchErr:
+			if !ok {
+				running = false
+				continue
+			}
+			ret = errors.Join(ret, err)
+		case p, ok := <-progress:
+			if !ok {
+				progress = nil
+				continue
+			}
+			log.Debugf(" -> %s: %d %%", step, p)
 		}
 	}
 
 	if ret == nil {
 		log.Debugf(" -> finished %s", step)
+		return nil
 	}
 
-	return ret
+	described := make([]string, 0, len(a))
+	for _, action := range a {
+		described = append(described, describeAction(action))
+	}
+	return fmt.Errorf("%s, waiting for %s: %w", step, strings.Join(described, ", "), ret)
+}
+
+// describeAction names the action along with the resources it affects, for error messages users can act upon
+func describeAction(a *hcloud.Action) string {
+	if len(a.Resources) == 0 {
+		return fmt.Sprintf("action %s[%d]", a.Command, a.ID)
+	}
+
+	resources := make([]string, 0, len(a.Resources))
+	for _, res := range a.Resources {
+		resources = append(resources, fmt.Sprintf("%s %d", res.Type, res.ID))
+	}
+	return fmt.Sprintf("action %s[%d] on %s", a.Command, a.ID, strings.Join(resources, ", "))
 }
@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	knownHostsFile   = "known_hosts"
+	hostKeyTimeout   = 10 * time.Second
+	hostKeyProbeUser = "docker-machine"
+)
+
+// errHostKeyReceived aborts the handshake once the host key is known, as authenticating is not needed for it
+var errHostKeyReceived = errors.New("host key received")
+
+func (d *Driver) hostKeyAddress() (string, error) {
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(d.SSHPort)), nil
+}
+
+// fetchHostKey returns the key the SSH server presents for one of the given algorithms, or the client's preferred one if
+// none are given; the connection is closed before authenticating
+func (d *Driver) fetchHostKey(algorithms []string) (ssh.PublicKey, error) {
+	addr, err := d.hostKeyAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: hostKeyTimeout}
+	conn, err := dialer.DialContext(d.getContext(), "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %v: %w", addr, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(hostKeyTimeout))
+
+	var key ssh.PublicKey
+	_, _, _, err = ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:              hostKeyProbeUser,
+		HostKeyAlgorithms: algorithms,
+		HostKeyCallback: func(_ string, _ net.Addr, received ssh.PublicKey) error {
+			key = received
+			return errHostKeyReceived
+		},
+	})
+	if key == nil {
+		return nil, fmt.Errorf("could not get host key of %v: %w", addr, err)
+	}
+	return key, nil
+}
+
+// pinHostKey trusts the host key presented on the first connection after creating or rebuilding the server, and
+// writes it to a known_hosts file in the machine's store directory for use with other SSH clients
+func (d *Driver) pinHostKey() error {
+	key, err := d.fetchHostKey(nil)
+	if err != nil {
+		return err
+	}
+	addr, err := d.hostKeyAddress()
+	if err != nil {
+		return err
+	}
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	if err = os.WriteFile(d.ResolveStorePath(knownHostsFile), []byte(line+"\n"), 0600); err != nil {
+		return fmt.Errorf("could not write known hosts: %w", err)
+	}
+
+	d.HostKey = string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(key)))
+	log.Infof(" -> Pinned SSH host key %v", ssh.FingerprintSHA256(key))
+	return nil
+}
+
+// VerifyHostKey ensures the server still presents the pinned host key before the driver connects to it
+func (d *Driver) VerifyHostKey() error {
+	if d.HostKey == "" {
+		return nil
+	}
+
+	pinned, _, _, _, err := ssh.ParseAuthorizedKey([]byte(d.HostKey))
+	if err != nil {
+		return fmt.Errorf("could not parse pinned host key: %w", err)
+	}
+	// servers offer a key per type, so ask for the pinned one
+	algorithms := []string{pinned.Type()}
+	if pinned.Type() == ssh.KeyAlgoRSA {
+		algorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	key, err := d.fetchHostKey(algorithms)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(key.Marshal(), pinned.Marshal()) {
+		return fmt.Errorf("host key of %v changed from %v to %v, refusing to connect", d.GetMachineName(),
+			ssh.FingerprintSHA256(pinned), ssh.FingerprintSHA256(key))
+	}
+	return nil
+}
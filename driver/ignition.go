@@ -0,0 +1,161 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+// ignitionUser is the unprivileged user with sudo rights that Flatcar and Fedora CoreOS provide
+const ignitionUser = "core"
+
+// setIgnitionFlags passes an Ignition config as user data, switching to the SSH user these images provide
+func (d *Driver) setIgnitionFlags(opts drivers.DriverOptions) error {
+	set, err := d.setBootConfigFlags(opts, flagIgnition, flagIgnitionFile)
+	if err != nil || !set {
+		return err
+	}
+
+	d.ignition = true
+	if d.SSHUser == "" || d.SSHUser == defaultSSHUser {
+		d.SSHUser = ignitionUser
+	}
+	return nil
+}
+
+// setBootConfigFlags passes a config given inline or by file as user data, for images which are configured by a
+// first boot mechanism instead of cloud-init. Hence, none of the cloud-config based features can be combined with it.
+func (d *Driver) setBootConfigFlags(opts drivers.DriverOptions, flagInline, flagFile string) (bool, error) {
+	inline := opts.String(flagInline)
+	file := opts.String(flagFile)
+	if inline == "" && file == "" {
+		return false, nil
+	}
+
+	if inline != "" && file != "" {
+		return false, d.flagFailure("--%v and --%v are mutually exclusive", flagInline, flagFile)
+	}
+	if d.userData != "" || d.userDataFile != "" || d.userDataDir != "" || opts.String(flagAdditionalUserData) != "" {
+		return false, d.flagFailure("--%v and --%v cannot be combined with cloud-init user data", flagInline, flagFile)
+	}
+	if d.waitCloudInit {
+		return false, d.flagFailure("--%v requires cloud-init, which --%v does not use", flagWaitCloudInit, flagInline)
+	}
+	if d.waitMetadata {
+		return false, d.flagFailure("--%v requires cloud-init, which --%v does not use", flagWaitMetadata, flagInline)
+	}
+	if d.dockerDataVolumeSize != 0 {
+		return false, d.flagFailure("--%v is set up by cloud-init, which --%v does not use", flagDockerDataVolumeSize, flagInline)
+	}
+	if len(d.writeFiles) != 0 {
+		return false, d.flagFailure("--%v and --%v are written by cloud-init, which --%v does not use",
+			flagWriteFile, flagDockerDaemonJSON, flagInline)
+	}
+	if len(d.packages) != 0 {
+		return false, d.flagFailure("--%v are installed by cloud-init, which --%v does not use", flagPackages, flagInline)
+	}
+	if d.machineMetadata {
+		return false, d.flagFailure("--%v is written by cloud-init, which --%v does not use", flagMachineMetadata, flagInline)
+	}
+	if d.preset != "" {
+		return false, d.flagFailure("--%v is applied by cloud-init, which --%v does not use", flagPreset, flagInline)
+	}
+
+	d.userData = inline
+	d.userDataFile = file
+	if file != "" && !isUserDataURL(file) {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return false, err
+		}
+		d.userDataFile = abs
+	}
+	return true, nil
+}
+
+// composeIgnition adds the machine's public keys to the SSH user of the Ignition config, so provisioning does not
+// depend on the image picking up the keys from the metadata service
+func (d *Driver) composeIgnition(userData string) (string, error) {
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(userData), &config); err != nil {
+		return "", fmt.Errorf("invalid Ignition config: %w", err)
+	}
+
+	meta, _ := config["ignition"].(map[string]interface{})
+	if version, _ := meta["version"].(string); version == "" {
+		return "", fmt.Errorf("invalid Ignition config: ignition.version is missing")
+	}
+
+	keys, err := d.getBootConfigKeys()
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return userData, nil
+	}
+
+	passwd, _ := config["passwd"].(map[string]interface{})
+	if passwd == nil {
+		passwd = make(map[string]interface{})
+		config["passwd"] = passwd
+	}
+	users, _ := passwd["users"].([]interface{})
+
+	var user map[string]interface{}
+	for _, candidate := range users {
+		if candidate, ok := candidate.(map[string]interface{}); ok && candidate["name"] == d.GetSSHUsername() {
+			user = candidate
+			break
+		}
+	}
+	if user == nil {
+		user = map[string]interface{}{"name": d.GetSSHUsername()}
+		passwd["users"] = append(users, user)
+	}
+
+	authorized, _ := user["sshAuthorizedKeys"].([]interface{})
+	for _, key := range keys {
+		if !slices.Contains(authorized, interface{}(key)) {
+			authorized = append(authorized, key)
+		}
+	}
+	user["sshAuthorizedKeys"] = authorized
+
+	out, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal Ignition config: %w", err)
+	}
+	return string(out), nil
+}
+
+// getBootConfigKeys returns the machine's, additional and authorized public keys; the machine key only exists after it
+// was generated or copied on creation and is thus absent while validating beforehand
+func (d *Driver) getBootConfigKeys() ([]string, error) {
+	var keys []string
+
+	buf, err := d.getLocalPublicKey()
+	if err == nil {
+		keys = append(keys, strings.TrimSpace(string(buf)))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, key := range d.AdditionalKeys {
+		keys = append(keys, strings.TrimSpace(key))
+	}
+	refs, err := d.getAdditionalKeyRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range refs {
+		keys = append(keys, strings.TrimSpace(key.PublicKey))
+	}
+	keys = append(keys, d.authorizedKeys...)
+	return keys, nil
+}
@@ -0,0 +1,171 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	mcnssh "github.com/docker/machine/libmachine/ssh"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"golang.org/x/crypto/ssh"
+)
+
+// RotateSSHKey replaces the machine's generated key pair by a new one of the given type, or of the current type if
+// empty. The new public key is authorized on the server and verified to work before the old one is revoked, both on
+// the server and in the project.
+func (d *Driver) RotateSSHKey(keyType string) error {
+	if d.UseSSHAgent {
+		return fmt.Errorf("keys held by ssh-agent are not managed by the driver, replace the key in the agent instead")
+	}
+	if d.IsExistingKey || d.KeyID == 0 {
+		return fmt.Errorf("only keys generated for the machine can be rotated, but %v uses an existing key", d.GetMachineName())
+	}
+
+	oldPath := d.GetSSHKeyPath()
+	oldPub, err := os.ReadFile(oldPath + ".pub")
+	if err != nil {
+		return fmt.Errorf("could not read ssh public key: %w", err)
+	}
+	oldKey, err := d.getKeyNullable()
+	if err != nil {
+		return fmt.Errorf("could not get ssh key: %w", err)
+	}
+
+	if keyType == "" {
+		keyType = sshKeyTypeRSA
+		if strings.HasSuffix(oldPath, "id_"+sshKeyTypeEd25519) {
+			keyType = sshKeyTypeEd25519
+		}
+	}
+	newPath := d.ResolveStorePath("id_" + keyType)
+	tmpPath := newPath + ".rotate"
+
+	log.Infof(" -> Generating new %v key pair...", keyType)
+	_ = os.Remove(tmpPath)
+	_ = os.Remove(tmpPath + ".pub")
+	switch keyType {
+	case sshKeyTypeRSA:
+		err = mcnssh.GenerateSSHKey(tmpPath)
+	case sshKeyTypeEd25519:
+		err = generateEd25519Key(tmpPath)
+	default:
+		return fmt.Errorf("key type must be %v or %v, got %v", sshKeyTypeRSA, sshKeyTypeEd25519, keyType)
+	}
+	if err != nil {
+		return fmt.Errorf("could not generate ssh key: %w", err)
+	}
+	newPub, err := os.ReadFile(tmpPath + ".pub")
+	if err != nil {
+		return fmt.Errorf("could not read ssh public key: %w", err)
+	}
+
+	authorizedKeys := "~" + d.GetSSHUsername() + "/.ssh/authorized_keys"
+	log.Infof(" -> Authorizing new key on the server...")
+	if _, err = d.runRemote(fmt.Sprintf("printf '%%s\\n' %s >>%s", shellQuote(strings.TrimSpace(string(newPub))), authorizedKeys)); err != nil {
+		return fmt.Errorf("could not authorize new key: %w", err)
+	}
+
+	d.SSHKeyPath = tmpPath
+	if _, err = d.runRemote("true"); err != nil {
+		d.SSHKeyPath = oldPath
+		return fmt.Errorf("could not connect using the new key, the old one remains in use: %w", err)
+	}
+
+	log.Infof(" -> Uploading new SSH key...")
+	key, _, err := d.uploadKey(d.GetMachineName(), string(newPub), d.resourceLabels(d.keyLabels))
+	if err != nil {
+		d.SSHKeyPath = oldPath
+		return err
+	}
+
+	// from here on, the new key is in use, so it is recorded right away and failures only leave the old key behind
+	if err = d.installRotatedKey(oldPath, tmpPath, newPath); err != nil {
+		return err
+	}
+	d.KeyID, d.cachedKey = key.ID, key
+	if err = d.SaveMachine(); err != nil {
+		return fmt.Errorf("could not record the new key, the old one remains authorized: %w", err)
+	}
+
+	if err = d.updateServerKeyLabels(oldKey, key); err != nil {
+		log.Warnf(" -> %v", err)
+	}
+
+	log.Infof(" -> Revoking old key on the server...")
+	if err = d.revokeAuthorizedKey(authorizedKeys, oldPub); err != nil {
+		log.Warnf(" -> %v, remove it from %v by hand", err, authorizedKeys)
+	}
+
+	if oldKey == nil {
+		return nil
+	}
+	if users, err := d.otherKeyUsers(oldKey); err != nil {
+		log.Warnf(" -> Keeping old SSH key %s[%d]: %v", oldKey.Name, oldKey.ID, err)
+		return nil
+	} else if len(users) != 0 {
+		log.Warnf(" -> Keeping old SSH key %s[%d], still used by servers %v", oldKey.Name, oldKey.ID, strings.Join(users, ", "))
+		return nil
+	}
+	log.Infof(" -> Destroying old SSH key %s[%d]...", oldKey.Name, oldKey.ID)
+	if _, err = d.getClient().SSHKey.Delete(d.getContext(), oldKey); err != nil && !isAPIError(err, hcloud.ErrorCodeNotFound) {
+		log.Warnf(" -> Could not delete old ssh key %s[%d], remove it by hand: %v", oldKey.Name, oldKey.ID, err)
+		return nil
+	}
+	d.invalidateCatalog(catalogSSHKey)
+	return nil
+}
+
+// installRotatedKey moves the new key pair to its final location, replacing the old one
+func (d *Driver) installRotatedKey(oldPath, tmpPath, newPath string) error {
+	for _, suffix := range []string{"", ".pub"} {
+		if err := os.Remove(oldPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove old ssh key: %w", err)
+		}
+		if err := os.Rename(tmpPath+suffix, newPath+suffix); err != nil {
+			return fmt.Errorf("could not store new ssh key: %w", err)
+		}
+	}
+	d.SSHKeyPath = newPath
+	return nil
+}
+
+// revokeAuthorizedKey removes all lines containing the old key's blob, regardless of the comment they were added with
+func (d *Driver) revokeAuthorizedKey(authorizedKeys string, pub []byte) error {
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey(pub)
+	if err != nil {
+		return fmt.Errorf("could not parse old ssh public key: %w", err)
+	}
+	blob := strings.Fields(string(ssh.MarshalAuthorizedKey(parsed)))[1]
+
+	command := fmt.Sprintf("keys=$(grep -vF %[1]s %[2]s || true) && printf '%%s\\n' \"$keys\" >%[2]s", shellQuote(blob), authorizedKeys)
+	if _, err = d.runRemote(command); err != nil {
+		return fmt.Errorf("could not revoke old key: %w", err)
+	}
+	return nil
+}
+
+// updateServerKeyLabels replaces the old key's label on the server, so the new key is protected from removal instead
+func (d *Driver) updateServerKeyLabels(oldKey, newKey *hcloud.SSHKey) error {
+	srv, err := d.getServerHandle()
+	if err != nil {
+		return fmt.Errorf("could not get server handle: %w", err)
+	}
+
+	labels := make(map[string]string, len(srv.Labels)+1)
+	for k, v := range srv.Labels {
+		labels[k] = v
+	}
+	if oldKey != nil {
+		delete(labels, d.keyLabelName(oldKey))
+	}
+	labels[d.keyLabelName(newKey)] = "true"
+
+	if _, _, err = retryLocked(d, func() (*hcloud.Server, *hcloud.Response, error) {
+		return d.getClient().Server.Update(d.getContext(), srv, hcloud.ServerUpdateOpts{Labels: labels})
+	}); err != nil {
+		return fmt.Errorf("could not update server labels: %w", err)
+	}
+	return nil
+}
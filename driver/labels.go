@@ -1,7 +1,197 @@
 package driver
 
-const labelNamespace = "docker-machine"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	labelNamespace     = "docker-machine"
+	labelMachine       = "machine"
+	labelManagedBy     = "managed-by"
+	labelDriverVersion = "driver-version"
+	labelStore         = "store"
+	labelCreated       = "created"
+
+	managedByDriver = "docker-machine-driver-hetzner"
+	storeIDLength   = 12
+	storeIDFile     = "hetzner-store-id"
+
+	labelPrefixMaxLength = 253
+)
+
+var (
+	// labelValuePattern is what the API accepts as label value, as well as name part of the key if not empty
+	labelValuePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9._-]{0,61}[a-zA-Z0-9])?)?$`)
+	// labelPrefixPattern is what the API accepts as optional prefix of a label key, i.e. a DNS subdomain
+	labelPrefixPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`)
+)
+
+// validateLabel checks a label against the API's rules up front, as the API rejects invalid labels only once the
+// resource is created, possibly after others were already
+func validateLabel(key, value string) error {
+	prefix, name, prefixed := strings.Cut(key, "/")
+	if !prefixed {
+		prefix, name = "", key
+	}
+	if prefixed && (len(prefix) > labelPrefixMaxLength || !labelPrefixPattern.MatchString(prefix)) {
+		return fmt.Errorf("label %v: key prefix %v must be a lower-case DNS subdomain of at most %d characters", key,
+			prefix, labelPrefixMaxLength)
+	}
+	if name == "" || !labelValuePattern.MatchString(name) {
+		return fmt.Errorf("label %v: key name %v must have 1 to 63 characters, beginning and ending with a letter or "+
+			"digit, with only letters, digits, dashes, underscores and dots in between", key, name)
+	}
+	if !labelValuePattern.MatchString(value) {
+		return fmt.Errorf("label %v: value %v must have at most 63 characters, beginning and ending with a letter or "+
+			"digit, with only letters, digits, dashes, underscores and dots in between", key, value)
+	}
+	return nil
+}
+
+// validateLabels checks all labels, in order of their keys for a deterministic error
+func validateLabels(labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := validateLabel(k, labels[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 func (d *Driver) labelName(name string) string {
 	return labelNamespace + "/" + name
 }
+
+// defaultLabels mark a resource as created by the driver, and by which version and store, regardless of the machine;
+// the creation time is given in seconds since the epoch, as label values must not contain colons
+func (d *Driver) defaultLabels() map[string]string {
+	labels := map[string]string{
+		d.labelName(labelManagedBy): managedByDriver,
+		d.labelName(labelCreated):   strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	if d.version != "" && labelValuePattern.MatchString(d.version) {
+		labels[d.labelName(labelDriverVersion)] = d.version
+	}
+	if store := d.storeID(); store != "" {
+		labels[d.labelName(labelStore)] = store
+	}
+	return labels
+}
+
+// storeID identifies the docker-machine store the machine lives in, so stores sharing a project can tell their
+// resources apart. The ID is random and persisted in the store directory on first use, as paths like the default
+// ~/.docker/machine are the same on many hosts. Without a writable store, resources are not labeled with a store,
+// which no store considers its own.
+func (d *Driver) storeID() string {
+	if d.BaseDriver == nil || d.StorePath == "" {
+		return ""
+	}
+	if d.cachedStoreID != "" {
+		return d.cachedStoreID
+	}
+
+	path := filepath.Join(d.StorePath, storeIDFile)
+	if id, err := readStoreID(path); err == nil {
+		d.cachedStoreID = id
+		return id
+	}
+
+	buf := make([]byte, storeIDLength/2)
+	if _, err := rand.Read(buf); err != nil {
+		log.Warnf("could not generate store ID: %v", err)
+		return ""
+	}
+	id := hex.EncodeToString(buf)
+	// parallel creations in a new store race for the file, and all use the winner's ID
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		if id, err = readStoreID(path); err != nil {
+			log.Warnf("could not read store ID: %v", err)
+			return ""
+		}
+	} else if err != nil {
+		log.Warnf("could not persist store ID: %v", err)
+		return ""
+	} else {
+		_, err = f.WriteString(id + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Warnf("could not persist store ID: %v", err)
+			return ""
+		}
+	}
+
+	d.cachedStoreID = id
+	return id
+}
+
+func readStoreID(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(string(raw))
+	if len(id) != storeIDLength || !labelValuePattern.MatchString(id) {
+		return "", fmt.Errorf("invalid store ID in %v", path)
+	}
+	return id, nil
+}
+
+// ownStore tells whether labels of a resource belong to this store. Resources without store label, e.g. created
+// before stores were labeled, or by other tools, are never considered own.
+func (d *Driver) ownStore(labels map[string]string) bool {
+	store, ok := labels[d.labelName(labelStore)]
+	id := d.storeID()
+	return ok && id != "" && store == id
+}
+
+// sharedLabels returns a copy of the given labels with the default ones, for resources shared by machines
+func (d *Driver) sharedLabels(labels map[string]string) map[string]string {
+	res := d.defaultLabels()
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
+}
+
+// machineLabels returns a copy of the given labels, marking the resource as belonging to the machine
+func (d *Driver) machineLabels(labels map[string]string) map[string]string {
+	res := d.defaultLabels()
+	res[d.labelName(labelMachine)] = d.shortName()
+	for k, v := range d.machineNameLabels() {
+		res[k] = v
+	}
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
+}
+
+// resourceLabels returns the labels of a resource created for the machine, i.e. the server labels, overridden by the
+// given ones, marked as belonging to the machine
+func (d *Driver) resourceLabels(labels map[string]string) map[string]string {
+	res := d.machineLabels(d.ServerLabels)
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
+}
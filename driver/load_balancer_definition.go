@@ -0,0 +1,280 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultLoadBalancerType = "lb11"
+
+// lbDefinition describes a load balancer to be created, as read from --hetzner-lb-create-from-file
+type lbDefinition struct {
+	Type            string            `yaml:"type"`
+	Location        string            `yaml:"location"`
+	NetworkZone     string            `yaml:"network_zone"`
+	Algorithm       string            `yaml:"algorithm"`
+	Network         string            `yaml:"network"`
+	PublicInterface *bool             `yaml:"public_interface"`
+	Labels          map[string]string `yaml:"labels"`
+	Services        []lbServiceDef    `yaml:"services"`
+}
+
+type lbServiceDef struct {
+	Protocol        string            `yaml:"protocol"`
+	ListenPort      *int              `yaml:"listen_port"`
+	DestinationPort *int              `yaml:"destination_port"`
+	Proxyprotocol   *bool             `yaml:"proxyprotocol"`
+	HTTP            *lbServiceHTTPDef `yaml:"http"`
+	HealthCheck     *lbHealthCheckDef `yaml:"health_check"`
+}
+
+type lbServiceHTTPDef struct {
+	CookieName         *string           `yaml:"cookie_name"`
+	CookieLifetime     *time.Duration    `yaml:"cookie_lifetime"`
+	RedirectHTTP       *bool             `yaml:"redirect_http"`
+	StickySessions     *bool             `yaml:"sticky_sessions"`
+	Certificates       []string          `yaml:"certificates"`
+	ManagedCertificate *lbManagedCertDef `yaml:"managed_certificate"`
+}
+
+// lbManagedCertDef describes a Hetzner managed certificate, which is created unless one of the same name exists
+type lbManagedCertDef struct {
+	Name    string   `yaml:"name"`
+	Domains []string `yaml:"domains"`
+}
+
+type lbHealthCheckDef struct {
+	Protocol string                `yaml:"protocol"`
+	Port     *int                  `yaml:"port"`
+	Interval *time.Duration        `yaml:"interval"`
+	Timeout  *time.Duration        `yaml:"timeout"`
+	Retries  *int                  `yaml:"retries"`
+	HTTP     *lbHealthCheckHTTPDef `yaml:"http"`
+}
+
+type lbHealthCheckHTTPDef struct {
+	Domain      *string  `yaml:"domain"`
+	Path        *string  `yaml:"path"`
+	Response    *string  `yaml:"response"`
+	StatusCodes []string `yaml:"status_codes"`
+	TLS         *bool    `yaml:"tls"`
+}
+
+func readLoadBalancerDefinition(file string) (*lbDefinition, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read load balancer definition: %w", err)
+	}
+
+	var def lbDefinition
+	if err := yaml.Unmarshal(raw, &def); err != nil {
+		return nil, fmt.Errorf("could not parse load balancer definition: %w", err)
+	}
+
+	if err := validateLabels(def.Labels); err != nil {
+		return nil, fmt.Errorf("load balancer definition: %w", err)
+	}
+	if def.Location != "" && def.NetworkZone != "" {
+		return nil, fmt.Errorf("load balancer definition: location and network_zone are mutually exclusive")
+	}
+	for i, svc := range def.Services {
+		switch hcloud.LoadBalancerServiceProtocol(svc.Protocol) {
+		case hcloud.LoadBalancerServiceProtocolTCP, hcloud.LoadBalancerServiceProtocolHTTP, hcloud.LoadBalancerServiceProtocolHTTPS:
+		default:
+			return nil, fmt.Errorf("load balancer definition: unknown protocol '%s' for service #%d", svc.Protocol, i)
+		}
+
+		if svc.HTTP == nil || (len(svc.HTTP.Certificates) == 0 && svc.HTTP.ManagedCertificate == nil) {
+			continue
+		}
+		if hcloud.LoadBalancerServiceProtocol(svc.Protocol) != hcloud.LoadBalancerServiceProtocolHTTPS {
+			return nil, fmt.Errorf("load balancer definition: certificates require https for service #%d", i)
+		}
+		if mc := svc.HTTP.ManagedCertificate; mc != nil && len(mc.Domains) == 0 {
+			return nil, fmt.Errorf("load balancer definition: managed certificate for service #%d lacks domains", i)
+		}
+	}
+
+	return &def, nil
+}
+
+func (d *Driver) makeLoadBalancerCreateOpts(def *lbDefinition) (hcloud.LoadBalancerCreateOpts, error) {
+	opts := hcloud.LoadBalancerCreateOpts{
+		Name:            d.LoadBalancer,
+		NetworkZone:     hcloud.NetworkZone(def.NetworkZone),
+		PublicInterface: def.PublicInterface,
+		Labels:          d.sharedLabels(map[string]string{d.labelName(labelAutoCreated): "true"}),
+	}
+	for k, v := range def.Labels {
+		opts.Labels[k] = v
+	}
+
+	lbType := def.Type
+	if lbType == "" {
+		lbType = defaultLoadBalancerType
+	}
+	t, _, err := d.getClient().LoadBalancerType.Get(d.getContext(), lbType)
+	if err != nil {
+		return opts, fmt.Errorf("could not get load balancer type: %w", err)
+	}
+	if t == nil {
+		return opts, fmt.Errorf("unknown load balancer type: %v", lbType)
+	}
+	opts.LoadBalancerType = t
+
+	if def.Algorithm != "" {
+		opts.Algorithm = &hcloud.LoadBalancerAlgorithm{Type: hcloud.LoadBalancerAlgorithmType(def.Algorithm)}
+	}
+
+	// default to the server's location, unless explicitly specified otherwise
+	if def.Location != "" {
+		location, _, err := d.getClient().Location.Get(d.getContext(), def.Location)
+		if err != nil {
+			return opts, fmt.Errorf("could not get location: %w", err)
+		}
+		if location == nil {
+			return opts, fmt.Errorf("unknown location: %v", def.Location)
+		}
+		opts.Location = location
+	} else if def.NetworkZone == "" {
+		location, err := d.getLocationNullable()
+		if err != nil {
+			return opts, fmt.Errorf("could not get location: %w", err)
+		}
+		if location == nil {
+			return opts, fmt.Errorf("load balancer definition lacks location or network_zone and no server location was given")
+		}
+		opts.Location = location
+	}
+
+	if def.Network != "" {
+		network, _, err := d.getClient().Network.Get(d.getContext(), def.Network)
+		if err != nil {
+			return opts, fmt.Errorf("could not get network by ID or name: %w", err)
+		}
+		if network == nil {
+			return opts, fmt.Errorf("network '%s' not found", def.Network)
+		}
+		opts.Network = network
+	}
+
+	for _, svc := range def.Services {
+		svcOpts := makeLoadBalancerServiceOpts(svc)
+		if svc.HTTP != nil {
+			if svcOpts.HTTP.Certificates, err = d.getServiceCertificates(svc.HTTP); err != nil {
+				return opts, err
+			}
+		}
+		opts.Services = append(opts.Services, svcOpts)
+	}
+
+	return opts, nil
+}
+
+func (d *Driver) getServiceCertificates(def *lbServiceHTTPDef) ([]*hcloud.Certificate, error) {
+	var certs []*hcloud.Certificate
+	for _, idOrName := range def.Certificates {
+		cert, _, err := d.getClient().Certificate.Get(d.getContext(), idOrName)
+		if err != nil {
+			return nil, fmt.Errorf("could not get certificate by ID or name: %w", err)
+		}
+		if cert == nil {
+			return nil, fmt.Errorf("certificate '%s' not found", idOrName)
+		}
+		certs = append(certs, cert)
+	}
+
+	if def.ManagedCertificate != nil {
+		cert, err := d.getManagedCertificate(def.ManagedCertificate)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return instrumented(certs), nil
+}
+
+func (d *Driver) getManagedCertificate(def *lbManagedCertDef) (*hcloud.Certificate, error) {
+	name := def.Name
+	if name == "" {
+		name = d.LoadBalancer
+	}
+
+	cert, _, err := d.getClient().Certificate.GetByName(d.getContext(), name)
+	if err != nil {
+		return nil, fmt.Errorf("could not get certificate by name: %w", err)
+	}
+	if cert != nil {
+		log.Infof("Using existing certificate %s[%d]", cert.Name, cert.ID)
+		return cert, nil
+	}
+
+	log.Infof("Creating managed certificate %v for %v...", name, def.Domains)
+	res, _, err := d.getClient().Certificate.CreateCertificate(d.getContext(), instrumented(hcloud.CertificateCreateOpts{
+		Name:        name,
+		Type:        hcloud.CertificateTypeManaged,
+		DomainNames: def.Domains,
+		Labels:      d.sharedLabels(map[string]string{d.labelName(labelAutoCreated): "true"}),
+	}))
+
+	if res.Certificate != nil {
+		d.dangling = append(d.dangling, func() {
+			_, err := d.getClient().Certificate.Delete(d.getContext(), res.Certificate)
+			if err != nil {
+				log.Errorf("could not delete certificate: %v", err)
+			}
+		})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create managed certificate: %w", err)
+	}
+
+	// issuance continues in the background, as it depends on DNS pointing at the load balancer
+	log.Infof(" -> Created certificate %s[%d], issuance pending", res.Certificate.Name, res.Certificate.ID)
+	return res.Certificate, nil
+}
+
+func makeLoadBalancerServiceOpts(svc lbServiceDef) hcloud.LoadBalancerCreateOptsService {
+	opts := hcloud.LoadBalancerCreateOptsService{
+		Protocol:        hcloud.LoadBalancerServiceProtocol(svc.Protocol),
+		ListenPort:      svc.ListenPort,
+		DestinationPort: svc.DestinationPort,
+		Proxyprotocol:   svc.Proxyprotocol,
+	}
+
+	if svc.HTTP != nil {
+		opts.HTTP = &hcloud.LoadBalancerCreateOptsServiceHTTP{
+			CookieName:     svc.HTTP.CookieName,
+			CookieLifetime: svc.HTTP.CookieLifetime,
+			RedirectHTTP:   svc.HTTP.RedirectHTTP,
+			StickySessions: svc.HTTP.StickySessions,
+		}
+	}
+
+	if hc := svc.HealthCheck; hc != nil {
+		opts.HealthCheck = &hcloud.LoadBalancerCreateOptsServiceHealthCheck{
+			Protocol: hcloud.LoadBalancerServiceProtocol(hc.Protocol),
+			Port:     hc.Port,
+			Interval: hc.Interval,
+			Timeout:  hc.Timeout,
+			Retries:  hc.Retries,
+		}
+		if hc.HTTP != nil {
+			opts.HealthCheck.HTTP = &hcloud.LoadBalancerCreateOptsServiceHealthCheckHTTP{
+				Domain:      hc.HTTP.Domain,
+				Path:        hc.HTTP.Path,
+				Response:    hc.HTTP.Response,
+				StatusCodes: hc.HTTP.StatusCodes,
+				TLS:         hc.HTTP.TLS,
+			}
+		}
+	}
+
+	return opts
+}
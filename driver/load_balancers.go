@@ -0,0 +1,206 @@
+package driver
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+func (d *Driver) getLoadBalancerNullable() (*hcloud.LoadBalancer, error) {
+	if d.LoadBalancer == "" {
+		return nil, nil
+	} else if d.cachedLoadBalancer != nil {
+		return d.cachedLoadBalancer, nil
+	}
+
+	lb, _, err := d.getClient().LoadBalancer.Get(d.getContext(), d.LoadBalancer)
+	if err != nil {
+		return nil, fmt.Errorf("could not get load balancer by ID or name: %w", err)
+	}
+
+	d.cachedLoadBalancer = lb
+	return instrumented(lb), nil
+}
+
+// verifyLoadBalancer checks the load balancer either exists or can be created from the given definition
+func (d *Driver) verifyLoadBalancer() error {
+	lb, err := d.getLoadBalancerNullable()
+	if err != nil {
+		return err
+	}
+	if lb == nil && d.LoadBalancer != "" && d.lbDefinition == nil {
+		return fmt.Errorf("load balancer '%s' not found", d.LoadBalancer)
+	}
+	return nil
+}
+
+func (d *Driver) getLoadBalancer() (*hcloud.LoadBalancer, error) {
+	lb, err := d.getLoadBalancerNullable()
+	if err != nil || lb != nil || d.LoadBalancer == "" {
+		return lb, err
+	}
+
+	if d.lbDefinition == nil {
+		return nil, fmt.Errorf("load balancer '%s' not found", d.LoadBalancer)
+	}
+
+	return d.makeLoadBalancer()
+}
+
+// makeLoadBalancer creates the load balancer from its definition and appends it to the dangling resource list
+func (d *Driver) makeLoadBalancer() (*hcloud.LoadBalancer, error) {
+	opts, err := d.makeLoadBalancerCreateOpts(d.lbDefinition)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Creating load balancer %v...", opts.Name)
+	res, _, err := d.getClient().LoadBalancer.Create(d.getContext(), instrumented(opts))
+
+	if res.LoadBalancer != nil {
+		d.dangling = append(d.dangling, func() {
+			_, err := d.getClient().LoadBalancer.Delete(d.getContext(), res.LoadBalancer)
+			if err != nil {
+				log.Errorf("could not delete load balancer: %v", err)
+			}
+		})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create load balancer: %w", err)
+	}
+
+	if err = d.waitForAction(res.Action); err != nil {
+		return nil, fmt.Errorf("could not wait for load balancer creation: %w", err)
+	}
+
+	log.Infof(" -> Created load balancer %s[%d]", res.LoadBalancer.Name, res.LoadBalancer.ID)
+	d.cachedLoadBalancer = res.LoadBalancer
+	return instrumented(res.LoadBalancer), nil
+}
+
+// getSelectedLoadBalancers lists all load balancers matching the load balancer selector
+func (d *Driver) getSelectedLoadBalancers() ([]*hcloud.LoadBalancer, error) {
+	if d.lbSelector == "" {
+		return nil, nil
+	}
+
+	lbs, err := d.getClient().LoadBalancer.AllWithOpts(d.getContext(), hcloud.LoadBalancerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: d.lbSelector},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list load balancers: %w", err)
+	}
+	if len(lbs) == 0 {
+		log.Warnf("no load balancer matches %v", d.lbSelector)
+	}
+	return instrumented(lbs), nil
+}
+
+func (d *Driver) registerLoadBalancerTargets(srv *hcloud.Server) error {
+	var lbs []*hcloud.LoadBalancer
+
+	lb, err := d.getLoadBalancer()
+	if err != nil {
+		return err
+	}
+	if lb != nil {
+		lbs = append(lbs, lb)
+	}
+
+	selected, err := d.getSelectedLoadBalancers()
+	if err != nil {
+		return err
+	}
+	for _, candidate := range selected {
+		if lb == nil || candidate.ID != lb.ID {
+			lbs = append(lbs, candidate)
+		}
+	}
+
+	for _, lb := range lbs {
+		if err = d.registerLoadBalancerTarget(lb, srv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) registerLoadBalancerTarget(lb *hcloud.LoadBalancer, srv *hcloud.Server) error {
+	if slices.Contains(d.LoadBalancerIDs, lb.ID) {
+		log.Debugf(" -> Server %s[%d] already registered at load balancer %s[%d]", srv.Name, srv.ID, lb.Name, lb.ID)
+		return nil
+	}
+
+	if d.lbUsePrivateIP && !d.sharesNetworkWith(lb) {
+		return fmt.Errorf("load balancer %s[%d] is not attached to any network of the server", lb.Name, lb.ID)
+	}
+
+	act, _, err := d.getClient().LoadBalancer.AddServerTarget(d.getContext(), lb, instrumented(hcloud.LoadBalancerAddServerTargetOpts{
+		Server:       srv,
+		UsePrivateIP: hcloud.Ptr(d.lbUsePrivateIP),
+	}))
+	if err != nil {
+		return fmt.Errorf("could not add server as load balancer target: %w", err)
+	}
+
+	log.Infof(" -> Registering server %s[%d] at load balancer %s[%d] in %s[%d]...", srv.Name, srv.ID, lb.Name, lb.ID, act.Command, act.ID)
+	// track the registration right away, so the target gets removed even if the action fails later on
+	d.LoadBalancerIDs = append(d.LoadBalancerIDs, lb.ID)
+	return d.waitForAction(act)
+}
+
+func (d *Driver) sharesNetworkWith(lb *hcloud.LoadBalancer) bool {
+	for _, network := range d.cachedNetworks {
+		for _, lbNet := range lb.PrivateNet {
+			if lbNet.Network != nil && lbNet.Network.ID == network.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *Driver) deregisterLoadBalancerTarget(lb *hcloud.LoadBalancer, srv *hcloud.Server) error {
+	registered := false
+	for _, target := range lb.Targets {
+		if target.Type == hcloud.LoadBalancerTargetTypeServer && target.Server.Server.ID == srv.ID {
+			registered = true
+			break
+		}
+	}
+	if !registered {
+		log.Infof(" -> Server is no target of load balancer %s[%d] anymore", lb.Name, lb.ID)
+		return nil
+	}
+
+	act, _, err := d.getClient().LoadBalancer.RemoveServerTarget(d.getContext(), lb, srv)
+	if err != nil {
+		return fmt.Errorf("could not remove server from load balancer targets: %w", err)
+	}
+
+	log.Infof(" -> Deregistering server from load balancer %s[%d] in %s[%d]...", lb.Name, lb.ID, act.Command, act.ID)
+	return d.waitForAction(act)
+}
+
+// deregisterLoadBalancerTargets removes the server from all load balancers it was registered at by the driver
+func (d *Driver) deregisterLoadBalancerTargets(srv *hcloud.Server) {
+	// failure to deregister from a load balancer is not a hard error, as deleting the server removes the target
+	for _, id := range d.LoadBalancerIDs {
+		lb, _, err := d.getClient().LoadBalancer.GetByID(d.getContext(), id)
+		if err != nil {
+			log.Warnf(" -> could not get load balancer %d: %v", id, err)
+			continue
+		}
+		if lb == nil {
+			log.Infof(" -> Load balancer %d does not exist anymore", id)
+			continue
+		}
+
+		if err = d.deregisterLoadBalancerTarget(lb, srv); err != nil {
+			log.Warnf(" -> could not deregister from load balancer %s[%d]: %v", lb.Name, lb.ID, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	machineMetadataDir = "/run/machine-metadata"
+	metadataServiceURL = "http://169.254.169.254/hetzner/v1/metadata"
+)
+
+// machineMetadataScript writes the machine's metadata to files below machineMetadataDir. Name and labels are known
+// up front, while server ID, location and private IPs are only assigned during creation and thus queried from the
+// metadata service. As /run does not survive reboots, it runs as bootcmd on every boot.
+const machineMetadataScript = `set -e
+mkdir -p %[1]s
+printf '%%s\n' %[2]s >%[1]s/name
+printf '%%s' %[3]s >%[1]s/labels
+curl -fsS --retry 5 %[4]s/instance-id >%[1]s/server-id
+curl -fsS --retry 5 %[4]s/availability-zone | sed 's/-dc[0-9]*$//' >%[1]s/location
+curl -fsS --retry 5 %[4]s/private-networks | sed -n 's/^- ip: *//p' >%[1]s/private-ips
+{
+  echo MACHINE_NAME=%[2]s
+  echo SERVER_ID=$(cat %[1]s/server-id)
+  echo LOCATION=$(cat %[1]s/location)
+  echo PRIVATE_IPS=\"$(cat %[1]s/private-ips | tr '\n' ' ' | sed 's/ $//')\"
+} >%[1]s/env
+`
+
+// machineMetadataCloudConfig exposes the machine's metadata to scripts in the user data, e.g. for registering nodes
+func (d *Driver) machineMetadataCloudConfig() map[string]interface{} {
+	labels := d.machineLabels(d.ServerLabels)
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	for _, k := range keys {
+		buf.WriteString(k + "=" + labels[k] + "\n")
+	}
+
+	script := fmt.Sprintf(machineMetadataScript, machineMetadataDir, shellQuote(d.GetMachineName()),
+		shellQuote(buf.String()), metadataServiceURL)
+	return map[string]interface{}{
+		"bootcmd": []interface{}{
+			[]interface{}{"sh", "-c", script},
+		},
+	}
+}
@@ -0,0 +1,105 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	serverNameMaxLength = 63
+	nameHashLength      = 8
+
+	// labelMachineName holds the full machine name in numbered parts if it had to be shortened, as label values are
+	// limited to 63 characters as well
+	labelMachineName = "machine-name"
+)
+
+var (
+	hostnamePartPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	invalidNameChars    = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+)
+
+// validServerName tells whether the API accepts the name for a server, i.e. it is a hostname of at most 63 characters
+func validServerName(name string) bool {
+	if name == "" || len(name) > serverNameMaxLength {
+		return false
+	}
+	for _, part := range strings.Split(name, ".") {
+		if !hostnamePartPattern.MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// shortMachineName maps the machine name to a valid server name, which is also a valid label value. Names which are
+// too long or no valid hostname, like those generated by the GitLab runner's autoscaler, are truncated and suffixed
+// with a hash of the full name, so they remain unique and map to the same server name on every invocation.
+func shortMachineName(name string) string {
+	if validServerName(name) {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:nameHashLength]
+	prefix := invalidNameChars.ReplaceAllString(name, "-")
+	if len(prefix) > serverNameMaxLength-nameHashLength-1 {
+		prefix = prefix[:serverNameMaxLength-nameHashLength-1]
+	}
+	prefix = strings.Trim(prefix, "-")
+	if prefix == "" {
+		return hash
+	}
+	return prefix + "-" + hash
+}
+
+// shortName is the name of the machine's server and the value of the machine label on its resources
+func (d *Driver) shortName() string {
+	return shortMachineName(d.GetMachineName())
+}
+
+// machineNameLabels records the full machine name if it was shortened, split into parts which are valid label values
+func (d *Driver) machineNameLabels() map[string]string {
+	name := d.GetMachineName()
+	if shortMachineName(name) == name {
+		return nil
+	}
+
+	labels := make(map[string]string)
+	for i := 1; name != ""; i++ {
+		end := min(len(name), serverNameMaxLength)
+		// parts must begin and end with a letter or digit
+		for end > 0 && !(isAlphanumeric(name[end-1]) && (end == len(name) || isAlphanumeric(name[end]))) {
+			end--
+		}
+		if end == 0 {
+			return nil
+		}
+		labels[d.labelName(labelMachineName+"-"+strconv.Itoa(i))] = name[:end]
+		name = name[end:]
+	}
+	return labels
+}
+
+// labeledMachineName returns the full machine name recorded in labels, or the machine label if it was not shortened
+func (d *Driver) labeledMachineName(labels map[string]string) string {
+	var parts []string
+	for i := 1; ; i++ {
+		part, ok := labels[d.labelName(labelMachineName+"-"+strconv.Itoa(i))]
+		if !ok {
+			break
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return labels[d.labelName(labelMachine)]
+	}
+	return strings.Join(parts, "")
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
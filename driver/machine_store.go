@@ -0,0 +1,138 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	mcnversion "github.com/docker/machine/libmachine/version"
+)
+
+const machineConfigFile = "config.json"
+
+// LoadMachine reads the driver state of an existing docker-machine host from the given store path, allowing
+// operations outside the plugin lifecycle
+func LoadMachine(version, storePath, machineName string) (*Driver, error) {
+	d := NewDriver(version)
+
+	raw, err := os.ReadFile(machineConfigPath(storePath, machineName))
+	if err != nil {
+		return nil, fmt.Errorf("could not read machine config: %w", err)
+	}
+
+	var host struct {
+		DriverName string
+		Driver     json.RawMessage
+	}
+	if err := json.Unmarshal(raw, &host); err != nil {
+		return nil, fmt.Errorf("could not parse machine config: %w", err)
+	}
+	if host.DriverName != d.DriverName() {
+		return nil, fmt.Errorf("machine %v uses driver %v, not %v", machineName, host.DriverName, d.DriverName())
+	}
+	if err := json.Unmarshal(host.Driver, d); err != nil {
+		return nil, fmt.Errorf("could not parse driver config: %w", err)
+	}
+
+	return d, nil
+}
+
+// SaveMachine writes the driver state back to the machine config, leaving all other host settings untouched
+func (d *Driver) SaveMachine() error {
+	path := machineConfigPath(d.StorePath, d.MachineName)
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read machine config: %w", err)
+	}
+
+	var host map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &host); err != nil {
+		return fmt.Errorf("could not parse machine config: %w", err)
+	}
+
+	if host["Driver"], err = json.Marshal(d); err != nil {
+		return fmt.Errorf("could not encode driver config: %w", err)
+	}
+
+	out, err := json.MarshalIndent(host, "", "    ")
+	if err != nil {
+		return fmt.Errorf("could not encode machine config: %w", err)
+	}
+
+	return os.WriteFile(path, out, 0600)
+}
+
+// InitMachine writes the config of a machine created without docker-machine, in docker-machine's format; as docker is
+// not provisioned then, the host options are left empty
+func (d *Driver) InitMachine() error {
+	path := machineConfigPath(d.StorePath, d.MachineName)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("machine %v already exists", d.MachineName)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("could not create machine directory: %w", err)
+	}
+
+	host := map[string]interface{}{
+		"ConfigVersion": mcnversion.ConfigVersion,
+		"Driver":        d,
+		"DriverName":    d.DriverName(),
+		"HostOptions": map[string]interface{}{
+			"Driver":        d.DriverName(),
+			"EngineOptions": map[string]interface{}{},
+			"SwarmOptions":  map[string]interface{}{},
+			"AuthOptions":   map[string]interface{}{},
+		},
+		"Name": d.MachineName,
+	}
+	out, err := json.MarshalIndent(host, "", "    ")
+	if err != nil {
+		return fmt.Errorf("could not encode machine config: %w", err)
+	}
+	return os.WriteFile(path, out, 0600)
+}
+
+// RemoveMachine deletes the machine's directory in the store, including its config and keys
+func (d *Driver) RemoveMachine() error {
+	if err := os.RemoveAll(filepath.Dir(machineConfigPath(d.StorePath, d.MachineName))); err != nil {
+		return fmt.Errorf("could not remove machine directory: %w", err)
+	}
+	return nil
+}
+
+func machineConfigPath(storePath, machineName string) string {
+	return filepath.Join(storePath, "machines", machineName, machineConfigFile)
+}
+
+// ListMachines returns the names of all docker-machine hosts in the given store path using this driver
+func ListMachines(storePath string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(storePath, "machines"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not list machines: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		raw, err := os.ReadFile(machineConfigPath(storePath, entry.Name()))
+		if err != nil {
+			continue // not a (complete) machine
+		}
+
+		var host struct{ DriverName string }
+		if err := json.Unmarshal(raw, &host); err != nil {
+			return nil, fmt.Errorf("could not parse machine config of %v: %w", entry.Name(), err)
+		}
+		if host.DriverName == "hetzner" {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
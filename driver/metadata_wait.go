@@ -0,0 +1,42 @@
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	metadataInstanceIDURL      = "http://169.254.169.254/hetzner/v1/metadata/instance-id"
+	defaultWaitMetadataTimeout = 300
+)
+
+// metadataReadyCommand succeeds once the metadata endpoint serves the server's ID, cloud-init picked up that instance
+// and applied its SSH configuration, or if cloud-init is not installed
+const metadataReadyCommand = "[ -d /var/lib/cloud ] || exit 0; id=%d; " +
+	"served=$(curl -fsm 2 " + metadataInstanceIDURL + " 2>/dev/null || wget -qT 2 -O- " + metadataInstanceIDURL + " 2>/dev/null); " +
+	"[ \"$served\" = \"$id\" ] && [ \"$(cat /var/lib/cloud/data/instance-id 2>/dev/null)\" = \"$id\" ] && " +
+	"[ -e /var/lib/cloud/instances/$id/sem/config_ssh ]"
+
+func (d *Driver) getWaitMetadataTimeout() int {
+	if d.waitMetadataTimeout <= 0 {
+		return defaultWaitMetadataTimeout
+	}
+	return d.waitMetadataTimeout
+}
+
+// waitForMetadata blocks until the instance metadata endpoint reports the server and cloud-init has configured SSH
+// for it. sshd may accept logins before, e.g. with keys or host keys left in a snapshot, which cloud-init replaces
+// right after, breaking provisioning or a pinned host key. The readiness check is the SSH probe itself, so like for
+// waitForSSH it must pass on several consecutive logins, which all happen after cloud-init configured SSH.
+func (d *Driver) waitForMetadata() error {
+	log.Infof(" -> Waiting for the metadata endpoint to report the server initialized...")
+
+	timeout := time.Duration(d.getWaitMetadataTimeout()) * time.Second
+	if err := d.waitForSSHCommand(fmt.Sprintf(metadataReadyCommand, d.ServerID), timeout, 0); err != nil {
+		return fmt.Errorf("server was not reported initialized, see %v and /var/lib/cloud/data/instance-id on the server: %w",
+			metadataInstanceIDURL, err)
+	}
+	return nil
+}
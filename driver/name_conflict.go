@@ -0,0 +1,101 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	nameConflictFail   = "fail"
+	nameConflictAdopt  = "adopt"
+	nameConflictSuffix = "suffix"
+
+	maxNameSuffix = 100
+)
+
+// ErrNameConflict is returned if a server with the machine's server name exists already; as errors only pass the
+// plugin boundary as text, its message is what docker-machine and its callers see
+var ErrNameConflict = errors.New("server name conflict")
+
+func (d *Driver) setNameConflictFlags(opts drivers.DriverOptions) error {
+	d.onNameConflict = opts.String(flagOnNameConflict)
+	switch d.onNameConflict {
+	case "":
+		d.onNameConflict = nameConflictFail
+	case nameConflictFail, nameConflictSuffix:
+	case nameConflictAdopt:
+		if d.originalKey == "" {
+			return d.flagFailure("--%v=%v requires --%v, as no key can be added to an existing server",
+				flagOnNameConflict, nameConflictAdopt, flagExKeyPath)
+		}
+	default:
+		return d.flagFailure("--%v must be one of %v, %v or %v, got %v", flagOnNameConflict, nameConflictFail,
+			nameConflictAdopt, nameConflictSuffix, d.onNameConflict)
+	}
+	return nil
+}
+
+// getNewServerName returns the name to create the server with, as resolved for name conflicts
+func (d *Driver) getNewServerName() string {
+	if d.newServerName != "" {
+		return d.newServerName
+	}
+	return d.shortName()
+}
+
+func (d *Driver) nameConflictError(name string, id int64) error {
+	return fmt.Errorf("%w: server %s[%d] exists already; set --%v to %v or %v it", ErrNameConflict, name, id,
+		flagOnNameConflict, nameConflictAdopt, nameConflictSuffix)
+}
+
+// resolveNameConflict checks whether the server name is taken, and if so fails, adopts that server or picks the first
+// free name suffixed by -2, -3 and so on, as given by --hetzner-on-name-conflict
+func (d *Driver) resolveNameConflict() error {
+	name := d.shortName()
+	srv, _, err := d.getClient().Server.GetByName(d.getContext(), name)
+	if err != nil {
+		return fmt.Errorf("could not look up server %v: %w", name, err)
+	}
+	if srv == nil {
+		return nil
+	}
+
+	switch d.onNameConflict {
+	case nameConflictAdopt:
+		if d.dryRun {
+			log.Infof(" -> would adopt existing server %s[%d]", srv.Name, srv.ID)
+			return nil
+		}
+		log.Infof("Server %s[%d] exists already, adopting it", srv.Name, srv.ID)
+		d.existingServer = strconv.FormatInt(srv.ID, 10)
+		d.cachedServer = srv
+		return nil
+	case nameConflictSuffix:
+		for i := 2; i <= maxNameSuffix; i++ {
+			suffix := "-" + strconv.Itoa(i)
+			candidate := name
+			if len(candidate)+len(suffix) > serverNameMaxLength {
+				candidate = strings.TrimRight(candidate[:serverNameMaxLength-len(suffix)], "-.")
+			}
+			candidate += suffix
+
+			other, _, err := d.getClient().Server.GetByName(d.getContext(), candidate)
+			if err != nil {
+				return fmt.Errorf("could not look up server %v: %w", candidate, err)
+			}
+			if other == nil {
+				log.Infof("Server %s[%d] exists already, naming the server %v", srv.Name, srv.ID, candidate)
+				d.newServerName = candidate
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: servers %v-2 to %v-%d exist already", ErrNameConflict, name, name, maxNameSuffix)
+	default:
+		return d.nameConflictError(srv.Name, srv.ID)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"time"
+	"slices"
 
 	"github.com/docker/machine/libmachine/log"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
@@ -12,10 +12,10 @@ import (
 
 func (d *Driver) getPrimaryIPv4() (*hcloud.PrimaryIP, error) {
 	raw := d.PrimaryIPv4
-	if raw == "" {
-		return nil, nil
-	} else if d.cachedPrimaryIPv4 != nil {
+	if d.cachedPrimaryIPv4 != nil { // also the one created for the user data
 		return d.cachedPrimaryIPv4, nil
+	} else if raw == "" {
+		return nil, nil
 	}
 
 	ip, err := d.resolvePrimaryIP(raw)
@@ -46,7 +46,7 @@ func (d *Driver) resolvePrimaryIP(raw string) (*hcloud.PrimaryIP, error) {
 		getter = client.Get
 	}
 
-	ip, _, err := getter(context.Background(), raw)
+	ip, _, err := getter(d.getContext(), raw)
 
 	if err != nil {
 		return nil, fmt.Errorf("could not get primary IP: %w", err)
@@ -59,6 +59,93 @@ func (d *Driver) resolvePrimaryIP(raw string) (*hcloud.PrimaryIP, error) {
 	return nil, fmt.Errorf("primary IP not found: %v", raw)
 }
 
+// needsUserDataPrimaryIPv4 tells whether the user data template refers to the public IPv4, which is only known before
+// the server is created for an existing primary IP
+func (d *Driver) needsUserDataPrimaryIPv4() (bool, error) {
+	if !d.userDataTemplate || d.PrimaryIPv4 != "" || d.DisablePublic4 {
+		return false, nil
+	}
+	userData, err := d.getMergedUserData()
+	if err != nil {
+		return false, err
+	}
+	return publicIPv4Reference.MatchString(userData), nil
+}
+
+// createUserDataPrimaryIPv4 creates the server's primary IPv4 up front if the user data template refers to it. It is
+// deleted along with the server, like the one the API would have created.
+func (d *Driver) createUserDataPrimaryIPv4() error {
+	if needed, err := d.needsUserDataPrimaryIPv4(); err != nil || !needed {
+		return err
+	}
+
+	location, err := d.getLocationNullable()
+	if err != nil {
+		return fmt.Errorf("could not get location: %w", err)
+	}
+	if location == nil {
+		return fmt.Errorf("the user data refers to the public IPv4, which requires --%v to create the primary IP in "+
+			"the server's location up front", flagLocation)
+	}
+
+	serverType, err := d.getType()
+	if err != nil {
+		return err
+	}
+
+	datacenters, err := cachedLookup(d, catalogDatacenters, "", func() ([]*hcloud.Datacenter, error) {
+		return d.getClient().Datacenter.All(d.getContext())
+	})
+	if err != nil {
+		return fmt.Errorf("could not list datacenters: %w", err)
+	}
+	// the server is placed along with its primary IP, so the datacenter has to host the type
+	idx := slices.IndexFunc(datacenters, func(dc *hcloud.Datacenter) bool {
+		return dc.Location != nil && dc.Location.ID == location.ID &&
+			containsServerType(dc.ServerTypes.Available, serverType)
+	})
+	if idx < 0 {
+		return fmt.Errorf("server type %v is currently unavailable in %v, no datacenter to create the primary IP in",
+			serverType.Name, location.Name)
+	}
+
+	log.Infof(" -> Creating primary IPv4 for the user data...")
+	res, _, err := d.getClient().PrimaryIP.Create(d.getContext(), instrumented(hcloud.PrimaryIPCreateOpts{
+		Name:         d.getNewServerName() + "-ipv4",
+		Type:         hcloud.PrimaryIPTypeIPv4,
+		AssigneeType: "server",
+		AutoDelete:   hcloud.Ptr(true),
+		Datacenter:   datacenters[idx].Name,
+		Labels:       d.resourceLabels(nil),
+	}))
+	if err != nil {
+		return fmt.Errorf("could not create primary IPv4: %w", err)
+	}
+	if res.Action != nil {
+		if err = d.waitForAction(res.Action); err != nil {
+			return fmt.Errorf("could not wait for primary IPv4: %w", err)
+		}
+	}
+
+	d.cachedPrimaryIPv4 = res.PrimaryIP
+	d.PrimaryIPv4ID = res.PrimaryIP.ID
+	d.checkpoint()
+
+	d.dangling = append(d.dangling, func() {
+		// gone already if the server was deleted, as it is deleted along with it
+		_, err := d.getClient().PrimaryIP.Delete(d.getContext(), res.PrimaryIP)
+		if err != nil && !isAPIError(err, hcloud.ErrorCodeNotFound) {
+			log.Errorf("could not delete primary IPv4: %v", err)
+			return
+		}
+		d.cachedPrimaryIPv4, d.PrimaryIPv4ID = nil, 0
+		d.checkpoint()
+	})
+	// assigned to the server
+	d.keepWithServer()
+	return nil
+}
+
 func (d *Driver) setPublicNetIfRequired(srvopts *hcloud.ServerCreateOpts) error {
 	pip4, err := d.getPrimaryIPv4()
 	if err != nil {
@@ -80,12 +167,42 @@ func (d *Driver) setPublicNetIfRequired(srvopts *hcloud.ServerCreateOpts) error
 	return nil
 }
 
+// labelPrimaryIPs labels the primary IPs the API created along with the server, which it does without any labels;
+// primary IPs given by flags are left as they are
+func (d *Driver) labelPrimaryIPs(srv *hcloud.Server) error {
+	given4, err := d.getPrimaryIPv4()
+	if err != nil {
+		return err
+	}
+	given6, err := d.getPrimaryIPv6()
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	if ip := srv.PublicNet.IPv4; !ip.IsUnspecified() && ip.ID != 0 && (given4 == nil || given4.ID != ip.ID) {
+		ids = append(ids, ip.ID)
+	}
+	if ip := srv.PublicNet.IPv6; !ip.IsUnspecified() && ip.ID != 0 && (given6 == nil || given6.ID != ip.ID) {
+		ids = append(ids, ip.ID)
+	}
+
+	labels := d.resourceLabels(nil)
+	for _, id := range ids {
+		if _, _, err := d.getClient().PrimaryIP.Update(d.getContext(), &hcloud.PrimaryIP{ID: id},
+			instrumented(hcloud.PrimaryIPUpdateOpts{Labels: &labels})); err != nil {
+			return fmt.Errorf("could not label primary IP %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
 func (d *Driver) configureNetworkAccess(srv hcloud.ServerCreateResult) error {
 	if d.UsePrivateNetwork {
 		for {
 			// we need to wait until network is attached
 			log.Infof("Wait until private network attached ...")
-			server, _, err := d.getClient().Server.GetByID(context.Background(), srv.Server.ID)
+			server, _, err := d.getClient().Server.GetByID(d.getContext(), srv.Server.ID)
 			if err != nil {
 				return fmt.Errorf("could not get newly created server [%d]: %w", srv.Server.ID, err)
 			}
@@ -93,7 +210,9 @@ func (d *Driver) configureNetworkAccess(srv hcloud.ServerCreateResult) error {
 				d.IPAddress = server.PrivateNet[0].IP.String()
 				break
 			}
-			time.Sleep(time.Duration(d.WaitOnPolling) * time.Second)
+			if err := d.sleep(); err != nil {
+				return fmt.Errorf("stopped waiting for private network: %w", err)
+			}
 		}
 	} else if d.DisablePublic4 {
 		log.Infof("Using public IPv6 network ...")
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// getContext returns the context of the running operation, which is canceled on interrupts or once the operation's
+// deadline has passed; outside of operations, a background context is used
+func (d *Driver) getContext() context.Context {
+	if d.operation == nil {
+		return context.Background()
+	}
+	return d.operation
+}
+
+// beginOperation starts a cancellable operation, optionally limited to the given duration. Interrupts and
+// terminations cancel in-flight requests and waits, rather than killing the process, so cleanup can still happen.
+func (d *Driver) beginOperation(timeout time.Duration) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	cancel := stop
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancel = func() {
+			cancelTimeout()
+			stop()
+		}
+	}
+
+	d.operation, d.endOperationFunc = ctx, cancel
+}
+
+// endOperation ends the running operation, if any; further interrupts terminate the process as usual again
+func (d *Driver) endOperation() {
+	if d.endOperationFunc != nil {
+		d.endOperationFunc()
+	}
+	d.operation, d.endOperationFunc = nil, nil
+}
+
+// timeoutError names the flag and timeout of an operation which failed for having run past its deadline, as the
+// request or wait that got canceled only reports the context's error
+func timeoutError(ctx context.Context, err error, flag string, timeout time.Duration) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("exceeded --%v of %v: %w", flag, timeout, err)
+}
+
+// sleep waits for the polling interval, returning early if the operation was canceled
+func (d *Driver) sleep() error {
+	ctx := d.getContext()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d.getWaitInterval()):
+		return nil
+	}
+}
@@ -1,7 +1,6 @@
 package driver
 
 import (
-	"context"
 	"fmt"
 
 	"github.com/docker/machine/libmachine/log"
@@ -15,7 +14,7 @@ const (
 )
 
 func (d *Driver) getAutoPlacementGroup() (*hcloud.PlacementGroup, error) {
-	res, err := d.getClient().PlacementGroup.AllWithOpts(context.Background(), hcloud.PlacementGroupListOpts{
+	res, err := d.getClient().PlacementGroup.AllWithOpts(d.getContext(), hcloud.PlacementGroupListOpts{
 		ListOpts: hcloud.ListOpts{LabelSelector: d.labelName(labelAutoSpreadPg)},
 	})
 
@@ -36,19 +35,21 @@ func (d *Driver) getAutoPlacementGroup() (*hcloud.PlacementGroup, error) {
 }
 
 func (d *Driver) makePlacementGroup(name string, labels map[string]string) (*hcloud.PlacementGroup, error) {
-	grp, _, err := d.getClient().PlacementGroup.Create(context.Background(), instrumented(hcloud.PlacementGroupCreateOpts{
+	grp, _, err := d.getClient().PlacementGroup.Create(d.getContext(), instrumented(hcloud.PlacementGroupCreateOpts{
 		Name:   name,
-		Labels: labels,
+		Labels: d.sharedLabels(labels),
 		Type:   "spread",
 	}))
 
 	if grp.PlacementGroup != nil {
 		d.dangling = append(d.dangling, func() {
-			_, err := d.getClient().PlacementGroup.Delete(context.Background(), grp.PlacementGroup)
+			_, err := d.getClient().PlacementGroup.Delete(d.getContext(), grp.PlacementGroup)
 			if err != nil {
 				log.Errorf("could not delete placement group: %v", err)
 			}
 		})
+		// holds the server
+		d.keepWithServer()
 	}
 
 	if err != nil {
@@ -72,7 +73,7 @@ func (d *Driver) getPlacementGroup() (*hcloud.PlacementGroup, error) {
 		return grp, err
 	} else {
 		client := d.getClient().PlacementGroup
-		grp, _, err := client.Get(context.Background(), name)
+		grp, _, err := client.Get(d.getContext(), name)
 		if err != nil {
 			return nil, fmt.Errorf("could not get placement group: %w", err)
 		}
@@ -0,0 +1,82 @@
+package driver
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// verifyToken issues a cheap request to tell an invalid API token apart from any later lookup failures
+func (d *Driver) verifyToken() error {
+	_, _, err := d.getClient().Location.List(d.getContext(), hcloud.LocationListOpts{
+		ListOpts: hcloud.ListOpts{PerPage: 1},
+	})
+	if isAPIError(err, hcloud.ErrorCodeUnauthorized) {
+		return fmt.Errorf("the API token is invalid or lacks access to the project: %w", err)
+	} else if err != nil {
+		return fmt.Errorf("could not reach the API: %w", err)
+	}
+	return nil
+}
+
+// verifyTokenWritable tells read-only tokens apart before anything is created, as these would otherwise only fail
+// midway through the creation. Updating a key that cannot exist is rejected with a permission error for read-only
+// tokens; any other outcome does not prove the token writable, so it merely leaves the permissions unknown and the
+// creation goes on.
+func (d *Driver) verifyTokenWritable() error {
+	_, _, err := d.getClient().SSHKey.Update(d.getContext(), &hcloud.SSHKey{ID: 0}, hcloud.SSHKeyUpdateOpts{})
+	if isAPIError(err, hcloud.ErrorCodeForbidden) {
+		return fmt.Errorf("the API token is read-only, creating machines requires a read & write token: %w", err)
+	} else if err != nil {
+		log.Debugf("API token permissions unknown, a read-only token fails the creation later on: %v", err)
+	}
+	return nil
+}
+
+// verifyServerTypeAvailable ensures the server type can currently be ordered in the chosen location; without a
+// location, the API picks one offering the type
+func (d *Driver) verifyServerTypeAvailable() error {
+	location, err := d.getLocationNullable()
+	if err != nil || location == nil {
+		return err
+	}
+
+	serverType, err := d.getType()
+	if err != nil {
+		return err
+	}
+
+	datacenters, err := cachedLookup(d, catalogDatacenters, "", func() ([]*hcloud.Datacenter, error) {
+		return d.getClient().Datacenter.All(d.getContext())
+	})
+	if err != nil {
+		return fmt.Errorf("could not list datacenters: %w", err)
+	}
+
+	supported := false
+	for _, dc := range datacenters {
+		if dc.Location == nil || dc.Location.ID != location.ID {
+			continue
+		}
+		if containsServerType(dc.ServerTypes.Available, serverType) {
+			return nil
+		}
+		supported = supported || containsServerType(dc.ServerTypes.Supported, serverType)
+	}
+
+	if supported {
+		return fmt.Errorf("server type %v is currently unavailable in %v, try again later or use another location",
+			serverType.Name, location.Name)
+	}
+	return fmt.Errorf("server type %v is not offered in %v", serverType.Name, location.Name)
+}
+
+func containsServerType(types []*hcloud.ServerType, serverType *hcloud.ServerType) bool {
+	for _, t := range types {
+		if t.ID == serverType.ID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	labelPreset      = "preset"
+	presetK3sServer  = "k3s-server"
+	presetK3sAgent   = "k3s-agent"
+	presetRKE2       = "rke2"
+	registriesMode   = "0600"
+	presetModulesDir = "/etc/modules-load.d"
+	presetSysctlDir  = "/etc/sysctl.d"
+)
+
+// preset prepares the host for a Kubernetes distribution, which is installed afterwards, e.g. by Rancher
+type preset struct {
+	packages []string
+	modules  []string
+	sysctls  map[string]string
+	// registries is where the distribution reads its private registry configuration from
+	registries string
+}
+
+// kubernetesSysctls are required for pod networking by all presets
+var kubernetesSysctls = map[string]string{
+	"net.ipv4.ip_forward":                 "1",
+	"net.ipv6.conf.all.forwarding":        "1",
+	"net.bridge.bridge-nf-call-iptables":  "1",
+	"net.bridge.bridge-nf-call-ip6tables": "1",
+	"fs.inotify.max_user_instances":       "8192",
+	"fs.inotify.max_user_watches":         "524288",
+}
+
+// kernelDefaultsSysctls are the values the kubelet insists on with protect-kernel-defaults, as enabled by RKE2's CIS
+// profile
+var kernelDefaultsSysctls = map[string]string{
+	"vm.overcommit_memory": "1",
+	"vm.panic_on_oom":      "0",
+	"kernel.panic":         "10",
+	"kernel.panic_on_oops": "1",
+}
+
+var presets = map[string]preset{
+	presetK3sServer: {
+		packages:   []string{"curl"},
+		modules:    []string{"overlay", "br_netfilter"},
+		sysctls:    kubernetesSysctls,
+		registries: "/etc/rancher/k3s/registries.yaml",
+	},
+	presetK3sAgent: {
+		packages:   []string{"curl"},
+		modules:    []string{"overlay", "br_netfilter"},
+		sysctls:    kubernetesSysctls,
+		registries: "/etc/rancher/k3s/registries.yaml",
+	},
+	presetRKE2: {
+		packages:   []string{"curl", "tar"},
+		modules:    []string{"overlay", "br_netfilter"},
+		sysctls:    mergeSysctls(kubernetesSysctls, kernelDefaultsSysctls),
+		registries: "/etc/rancher/rke2/registries.yaml",
+	},
+}
+
+func mergeSysctls(sysctls ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, s := range sysctls {
+		for k, v := range s {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d *Driver) setPresetFlags(opts drivers.DriverOptions) error {
+	d.preset = opts.String(flagPreset)
+	d.presetRegistries = nil
+	if d.preset != "" {
+		if _, ok := presets[d.preset]; !ok {
+			return d.flagFailure("--%v must be one of %v, got %v", flagPreset, strings.Join(presetNames(), ", "), d.preset)
+		}
+	}
+
+	path := opts.String(flagPresetRegistries)
+	if path == "" {
+		return nil
+	}
+	if d.preset == "" {
+		return d.flagFailure("--%v requires --%v", flagPresetRegistries, flagPreset)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return d.flagFailure("could not read --%v: %v", flagPresetRegistries, err)
+	}
+	var registries map[string]interface{}
+	if err = yaml.Unmarshal(content, &registries); err != nil {
+		return d.flagFailure("--%v is not a valid YAML document: %v", flagPresetRegistries, err)
+	}
+	d.presetRegistries = content
+	return nil
+}
+
+// presetCloudConfig loads the kernel modules and applies the sysctls right away, as well as on every boot, before the
+// distribution is installed
+func (d *Driver) presetCloudConfig() map[string]interface{} {
+	p := presets[d.preset]
+
+	keys := make([]string, 0, len(p.sysctls))
+	for k := range p.sysctls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sysctls strings.Builder
+	for _, k := range keys {
+		sysctls.WriteString(k + " = " + p.sysctls[k] + "\n")
+	}
+
+	files := []writeFile{
+		{path: fmt.Sprintf("%v/%v.conf", presetModulesDir, d.preset), mode: defaultWriteFileMode, content: []byte(strings.Join(p.modules, "\n") + "\n")},
+		{path: fmt.Sprintf("%v/90-%v.conf", presetSysctlDir, d.preset), mode: defaultWriteFileMode, content: []byte(sysctls.String())},
+	}
+	if d.presetRegistries != nil {
+		files = append(files, writeFile{path: p.registries, mode: registriesMode, content: d.presetRegistries})
+	}
+
+	runcmd := make([]interface{}, 0, len(p.modules)+1)
+	for _, module := range p.modules {
+		runcmd = append(runcmd, []interface{}{"modprobe", module})
+	}
+	runcmd = append(runcmd, []interface{}{"sysctl", "--system"})
+
+	fragment := writeFilesCloudConfig(files)
+	for k, v := range packagesCloudConfig(p.packages) {
+		fragment[k] = v
+	}
+	fragment["runcmd"] = runcmd
+	return fragment
+}
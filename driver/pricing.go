@@ -0,0 +1,310 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// hoursPerMonth converts monthly prices without hourly counterpart, like those of volumes, for comparing against
+// hourly ones; servers and primary IPs are billed hourly, up to their monthly price, which is reached after about as
+// many hours
+const hoursPerMonth = 730
+
+// priceItem is the gross price of a resource the driver creates, in the project's currency
+type priceItem struct {
+	name    string
+	hourly  float64
+	monthly float64
+}
+
+// priceEstimate sums up the resources created for the machine
+type priceEstimate struct {
+	currency string
+	items    []priceItem
+	// upperBound is set if the location is left to the API, so the most expensive location is assumed
+	upperBound bool
+}
+
+func (e priceEstimate) hourly() float64 {
+	var sum float64
+	for _, item := range e.items {
+		sum += item.hourly
+	}
+	return sum
+}
+
+func (e priceEstimate) monthly() float64 {
+	var sum float64
+	for _, item := range e.items {
+		sum += item.monthly
+	}
+	return sum
+}
+
+// parsePrice parses the decimal strings of the pricing API, treating missing prices as free
+func parsePrice(raw string) (float64, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(raw, 64)
+}
+
+// locationPrice picks the price for the location, or the highest one if the location is not known yet
+func locationPrice(location string, prices []hcloud.ServerTypeLocationPricing) (hourly, monthly float64, err error) {
+	found := false
+	for _, p := range prices {
+		if location != "" && (p.Location == nil || p.Location.Name != location) {
+			continue
+		}
+		h, err := parsePrice(p.Hourly.Gross)
+		if err != nil {
+			return 0, 0, err
+		}
+		m, err := parsePrice(p.Monthly.Gross)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !found || h > hourly {
+			hourly, monthly = h, m
+		}
+		found = true
+	}
+	if !found {
+		return 0, 0, fmt.Errorf("no price for location %v", location)
+	}
+	return hourly, monthly, nil
+}
+
+func primaryIPPrice(location string, pricing hcloud.Pricing, ipType string) (hourly, monthly float64, err error) {
+	for _, p := range pricing.PrimaryIPs {
+		if p.Type != ipType {
+			continue
+		}
+		for _, lp := range p.Pricings {
+			if location != "" && lp.Location != location {
+				continue
+			}
+			h, err := parsePrice(lp.Hourly.Gross)
+			if err != nil {
+				return 0, 0, err
+			}
+			m, err := parsePrice(lp.Monthly.Gross)
+			if err != nil {
+				return 0, 0, err
+			}
+			if h > hourly {
+				hourly, monthly = h, m
+			}
+		}
+	}
+	return hourly, monthly, nil
+}
+
+// createdPrimaryIPTypes lists the primary IPs created along with the server, as opposed to given ones
+func (d *Driver) createdPrimaryIPTypes() []string {
+	var types []string
+	if !d.DisablePublic4 && d.PrimaryIPv4 == "" {
+		types = append(types, "ipv4")
+	}
+	if !d.DisablePublic6 && d.PrimaryIPv6 == "" {
+		types = append(types, "ipv6")
+	}
+	return types
+}
+
+// estimatePrice prices the server, the primary IPs and the Docker data volume the driver would create; resources
+// given by flags, like existing primary IPs or attached volumes, are billed already
+func (d *Driver) estimatePrice() (priceEstimate, error) {
+	serverType, err := d.getType()
+	if err != nil {
+		return priceEstimate{}, fmt.Errorf("could not get type: %w", err)
+	}
+	location, err := d.getLocationNullable()
+	if err != nil {
+		return priceEstimate{}, fmt.Errorf("could not get location: %w", err)
+	}
+	locationName := ""
+	if location != nil {
+		locationName = location.Name
+	}
+
+	pricing, _, err := d.getClient().Pricing.Get(d.getContext())
+	if err != nil {
+		return priceEstimate{}, fmt.Errorf("could not get pricing: %w", err)
+	}
+	estimate := priceEstimate{currency: pricing.Volume.PerGBMonthly.Currency, upperBound: location == nil}
+
+	hourly, monthly, err := locationPrice(locationName, serverType.Pricings)
+	if err != nil {
+		return priceEstimate{}, fmt.Errorf("could not price server type %v: %w", serverType.Name, err)
+	}
+	estimate.items = append(estimate.items, priceItem{name: "server type " + serverType.Name, hourly: hourly, monthly: monthly})
+
+	for _, ipType := range d.createdPrimaryIPTypes() {
+		hourly, monthly, err := primaryIPPrice(locationName, pricing, ipType)
+		if err != nil {
+			return priceEstimate{}, fmt.Errorf("could not price primary %v: %w", ipType, err)
+		}
+		estimate.items = append(estimate.items, priceItem{name: "primary " + ipType, hourly: hourly, monthly: monthly})
+	}
+
+	if d.dockerDataVolumeSize != 0 {
+		perGB, err := parsePrice(pricing.Volume.PerGBMonthly.Gross)
+		if err != nil {
+			return priceEstimate{}, fmt.Errorf("could not price volume: %w", err)
+		}
+		monthly := perGB * float64(d.dockerDataVolumeSize)
+		estimate.items = append(estimate.items, priceItem{name: fmt.Sprintf("%d GB volume", d.dockerDataVolumeSize),
+			hourly: monthly / hoursPerMonth, monthly: monthly})
+	}
+	return estimate, nil
+}
+
+// checkPrice logs the estimated price of the machine, and fails if it exceeds --hetzner-max-hourly-price
+func (d *Driver) checkPrice() error {
+	estimate, err := d.estimatePrice()
+	if err != nil {
+		return err
+	}
+
+	var parts []string
+	for _, item := range estimate.items {
+		parts = append(parts, fmt.Sprintf("%v %.4f/h", item.name, item.hourly))
+	}
+	bound := ""
+	if estimate.upperBound {
+		bound = "up to "
+	}
+	log.Infof("Estimated price %s%.4f %s/h, %.2f %s/month (%s)", bound, estimate.hourly(), estimate.currency,
+		estimate.monthly(), estimate.currency, strings.Join(parts, ", "))
+
+	if d.maxHourlyPrice > 0 && estimate.hourly() > d.maxHourlyPrice {
+		return fmt.Errorf("estimated price of %s%.4f %s/h exceeds --%v of %v", bound, estimate.hourly(),
+			estimate.currency, flagMaxHourlyPrice, d.maxHourlyPrice)
+	}
+	return nil
+}
+
+// locationConstraints collects the locations and network zones the server is bound to by the resources it uses; empty
+// ones do not restrict the choice
+func (d *Driver) locationConstraints() (locations map[string]string, zones map[hcloud.NetworkZone]string, err error) {
+	locations = make(map[string]string)
+	zones = make(map[hcloud.NetworkZone]string)
+
+	volumes, err := d.getVolumes()
+	if err != nil {
+		return nil, nil, err
+	}
+	attach, err := d.getAttachVolumes()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, volume := range append(volumes, attach...) {
+		locations[volume.Location.Name] = "volume " + volume.Name
+	}
+
+	for _, ip := range []func() (*hcloud.PrimaryIP, error){d.getPrimaryIPv4, d.getPrimaryIPv6} {
+		ip, err := ip()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not resolve primary IP: %w", err)
+		}
+		if ip != nil && ip.Datacenter != nil && ip.Datacenter.Location != nil {
+			locations[ip.Datacenter.Location.Name] = "primary IP " + ip.Name
+		}
+	}
+
+	networks, err := d.createNetworks()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, network := range networks {
+		for _, subnet := range network.Subnets {
+			zones[subnet.NetworkZone] = "network " + network.Name
+		}
+	}
+	return locations, zones, nil
+}
+
+// selectCheapestLocation picks the location where the server type is available and, along with the primary IPs to
+// create, cheapest per hour, among those allowed by attached volumes, given primary IPs and networks' zones
+func (d *Driver) selectCheapestLocation() error {
+	serverType, err := d.getType()
+	if err != nil {
+		return fmt.Errorf("could not get type: %w", err)
+	}
+	locations, zones, err := d.locationConstraints()
+	if err != nil {
+		return err
+	}
+	if len(locations) > 1 {
+		return fmt.Errorf("--%v: the server is bound to multiple locations, %v", flagAutoCheapest, locations)
+	}
+	if len(zones) > 1 {
+		return fmt.Errorf("--%v: the server is bound to multiple network zones, %v", flagAutoCheapest, zones)
+	}
+
+	datacenters, err := cachedLookup(d, catalogDatacenters, "", func() ([]*hcloud.Datacenter, error) {
+		return d.getClient().Datacenter.All(d.getContext())
+	})
+	if err != nil {
+		return fmt.Errorf("could not list datacenters: %w", err)
+	}
+	pricing, _, err := d.getClient().Pricing.Get(d.getContext())
+	if err != nil {
+		return fmt.Errorf("could not get pricing: %w", err)
+	}
+
+	type candidate struct {
+		location *hcloud.Location
+		hourly   float64
+	}
+	var candidates []candidate
+	seen := make(map[int64]bool)
+	for _, dc := range datacenters {
+		location := dc.Location
+		if location == nil || seen[location.ID] || !containsServerType(dc.ServerTypes.Available, serverType) {
+			continue
+		}
+		if _, ok := locations[location.Name]; len(locations) != 0 && !ok {
+			continue
+		}
+		if _, ok := zones[location.NetworkZone]; len(zones) != 0 && !ok {
+			continue
+		}
+		seen[location.ID] = true
+
+		hourly, _, err := locationPrice(location.Name, serverType.Pricings)
+		if err != nil {
+			log.Debugf("skipping location %v: %v", location.Name, err)
+			continue
+		}
+		for _, ipType := range d.createdPrimaryIPTypes() {
+			ipHourly, _, err := primaryIPPrice(location.Name, pricing, ipType)
+			if err != nil {
+				return fmt.Errorf("could not price primary %v: %w", ipType, err)
+			}
+			hourly += ipHourly
+		}
+		candidates = append(candidates, candidate{location: location, hourly: hourly})
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("server type %v is currently not available in any eligible location", serverType.Name)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].hourly != candidates[j].hourly {
+			return candidates[i].hourly < candidates[j].hourly
+		}
+		return candidates[i].location.Name < candidates[j].location.Name
+	})
+	cheapest := candidates[0]
+	log.Infof("Using cheapest location %v at %.4f %s/h", cheapest.location.Name, cheapest.hourly, pricing.Volume.PerGBMonthly.Currency)
+	d.Location = cheapest.location.Name
+	d.cachedLocation = cheapest.location
+	return nil
+}
@@ -0,0 +1,75 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// FindPrunableSnapshots lists the snapshots created by the driver from this store and matching the label selector,
+// which are neither among the newest keep ones of their golden image, nor younger than maxAge if given. Snapshots
+// without golden label, like those taken on removal, are retained as one group; protected snapshots are never listed.
+func (d *Driver) FindPrunableSnapshots(selector string, keep int, maxAge time.Duration) ([]*hcloud.Image, error) {
+	if keep <= 0 && maxAge <= 0 {
+		return nil, errors.New("a retention count or age is required")
+	}
+
+	labelSelector := d.labelName(labelManagedBy) + "=" + managedByDriver
+	if selector != "" {
+		labelSelector += "," + selector
+	}
+	images, err := d.getClient().Image.AllWithOpts(d.getContext(), hcloud.ImageListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: labelSelector},
+		Type:     []hcloud.ImageType{hcloud.ImageTypeSnapshot},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list snapshots: %w", err)
+	}
+
+	groups := make(map[string][]*hcloud.Image)
+	for _, image := range images {
+		if !d.ownStore(image.Labels) {
+			continue
+		}
+		golden := image.Labels[d.labelName(labelGolden)]
+		groups[golden] = append(groups[golden], image)
+	}
+
+	var prunable []*hcloud.Image
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i].Created.After(group[j].Created) })
+		for i, image := range group {
+			if i < keep || maxAge > 0 && time.Since(image.Created) < maxAge {
+				continue
+			}
+			if image.Protection.Delete {
+				log.Debugf("keeping protected snapshot %s[%d]", image.Description, image.ID)
+				continue
+			}
+			prunable = append(prunable, image)
+		}
+	}
+	sort.Slice(prunable, func(i, j int) bool { return prunable[i].Created.Before(prunable[j].Created) })
+	return prunable, nil
+}
+
+// DeleteSnapshots deletes the given snapshots in order, continuing after failures
+func (d *Driver) DeleteSnapshots(images []*hcloud.Image) error {
+	failed := 0
+	for _, image := range images {
+		log.Infof(" -> Deleting snapshot %s[%d]...", image.Description, image.ID)
+		if _, err := d.getClient().Image.Delete(d.getContext(), image); err != nil {
+			log.Warnf(" ->  -> could not delete snapshot %s[%d]: %v", image.Description, image.ID, err)
+			failed++
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("could not delete %d of %d snapshots", failed, len(images))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// Rebuild reimages the existing server, keeping its ID and IP addresses. The original image is used unless an image
+// ID or name is given, which then replaces the stored image. Docker needs to be provisioned again afterward, see
+// [Driver.Reprovision].
+func (d *Driver) Rebuild(idOrName string) error {
+	srv, err := d.getServerHandle()
+	if err != nil {
+		return fmt.Errorf("could not get server handle: %w", err)
+	}
+
+	if idOrName != "" {
+		if id, err := strconv.ParseInt(idOrName, 10, 64); err == nil {
+			d.ImageID, d.Image = id, ""
+		} else {
+			d.ImageID, d.Image = 0, idOrName
+		}
+		d.cachedImage = nil
+	}
+
+	image, err := d.getImage()
+	if err != nil {
+		return err
+	}
+
+	act, _, err := d.getClient().Server.Rebuild(d.getContext(), srv, hcloud.ServerRebuildOpts{Image: image})
+	if err != nil {
+		return fmt.Errorf("could not rebuild server: %w", err)
+	}
+
+	log.Infof(" -> Rebuilding server %s[%d] from image %s[%d] in %s[%d]...", srv.Name, srv.ID, image.Name, image.ID, act.Command, act.ID)
+
+	if err = d.waitForAction(act); err != nil {
+		return fmt.Errorf("could not wait for server rebuild: %w", err)
+	}
+
+	if err = d.waitForRunningServer(); err != nil {
+		return err
+	}
+
+	log.Infof(" -> Waiting for SSH on %s...", d.IPAddress)
+	if err = d.waitForSSH(); err != nil {
+		return fmt.Errorf("could not reach server after rebuild: %w", err)
+	}
+
+	d.updateServerDetails()
+
+	// rebuilding generates new host keys
+	if d.HostKey != "" {
+		if err = d.pinHostKey(); err != nil {
+			return err
+		}
+	}
+
+	log.Infof(" -> Server %s[%d] rebuilt", srv.Name, srv.ID)
+	return nil
+}
+
+// Reprovision installs and configures docker on the server by running 'docker-machine provision', which also
+// generates new certificates for it. Provisioning is part of docker-machine rather than the driver, so it fails if
+// docker-machine is not found.
+func (d *Driver) Reprovision() error {
+	machine, err := exec.LookPath("docker-machine")
+	if err != nil {
+		return fmt.Errorf("docker is not set up on the rebuilt server, as docker-machine was not found; "+
+			"run 'docker-machine provision %v': %w", d.GetMachineName(), err)
+	}
+
+	log.Infof(" -> Provisioning docker on %s...", d.IPAddress)
+	cmd := exec.CommandContext(d.getContext(), machine, "--storage-path", d.StorePath, "provision", d.GetMachineName())
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("could not provision docker, run 'docker-machine provision %v' to retry: %w",
+			d.GetMachineName(), err)
+	}
+	return nil
+}
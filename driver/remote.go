@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const sshRetryDelay = 2 * time.Second
+
+// transientSSHErrors are messages of the native and external SSH clients for connections refused or dropped before the
+// session started, as happens while sshd restarts during the first boot. Connections dropped later are not retried, as
+// the command may have run already.
+var transientSSHErrors = []string{
+	"error dialing tcp",
+	"ssh: connect to host",
+	"connect: connection refused",
+	"kex_exchange_identification",
+	"connection timed out during banner exchange",
+	"handshake failed",
+	"connection closed by",
+}
+
+// runRemote executes the given shell command on the machine via SSH, elevating privileges for non-root users. Commands
+// failing before they ran, i.e. to connect or to establish the session, are retried up to --hetzner-ssh-retries times.
+func (d *Driver) runRemote(command string) (string, error) {
+	if d.GetSSHUsername() != defaultSSHUser {
+		command = "sudo sh -c " + shellQuote(command)
+	}
+
+	if err := d.VerifyHostKey(); err != nil {
+		return "", err
+	}
+
+	log.Debugf("running remote command: %v", command)
+	for attempt := 0; ; attempt++ {
+		out, err := drivers.RunSSHCommandFromDriver(d, command)
+		if err == nil {
+			return out, nil
+		}
+		if attempt >= d.SSHRetries || !isTransientSSHError(err) {
+			return out, fmt.Errorf("could not run remote command: %w", err)
+		}
+
+		delay := time.Duration(attempt+1) * sshRetryDelay
+		log.Warnf(" -> SSH connection failed, retrying in %v: %v", delay, err)
+		ctx := d.getContext()
+		select {
+		case <-ctx.Done():
+			return out, errors.Join(fmt.Errorf("could not run remote command: %w", err), ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isTransientSSHError tells failures to connect or to establish the session apart from failing or interrupted
+// commands. The external client exits with 255 for both connection failures and commands doing so, so only the
+// messages it prints tell them apart.
+func isTransientSSHError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "exited with status") || strings.Contains(msg, "exit status") && !strings.Contains(msg, "exit status 255") {
+		return false
+	}
+	for _, transient := range transientSSHErrors {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote wraps the given string in single quotes for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"strings"
+
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+const (
+	schemaDialect     = "https://json-schema.org/draft/2020-12/schema"
+	deprecationPrefix = "DEPRECATED"
+	credentialPrivate = "private"
+	credentialPublic  = "public"
+)
+
+// sensitiveFlags must not be displayed or logged by UIs rendering the schema
+var sensitiveFlags = []string{flagAPIToken}
+
+// credentialFlags belong to the Hetzner project rather than the machine, so Rancher keeps them in cloud credentials
+// instead of node templates; private ones are stored as secrets
+var credentialFlags = map[string]string{
+	flagAPIToken:        credentialPrivate,
+	flagDefaultLocation: credentialPublic,
+	flagDefaultNetworks: credentialPublic,
+}
+
+// ConfigSchema describes all create flags as JSON schema of a machine config, whose fields are named like Rancher
+// derives them from the flags, i.e. camel-cased without the driver's prefix
+func (d *Driver) ConfigSchema() map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, flag := range d.GetCreateFlags() {
+		var property map[string]interface{}
+		var usage, envVar string
+		switch flag := flag.(type) {
+		case mcnflag.StringFlag:
+			property = map[string]interface{}{"type": "string", "default": flag.Value}
+			usage, envVar = flag.Usage, flag.EnvVar
+		case mcnflag.IntFlag:
+			property = map[string]interface{}{"type": "integer", "default": flag.Value}
+			usage, envVar = flag.Usage, flag.EnvVar
+		case mcnflag.BoolFlag:
+			property = map[string]interface{}{"type": "boolean", "default": false}
+			usage, envVar = flag.Usage, flag.EnvVar
+		case mcnflag.StringSliceFlag:
+			value := flag.Value
+			if value == nil {
+				value = []string{}
+			}
+			property = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "default": value}
+			usage, envVar = flag.Usage, flag.EnvVar
+		default:
+			continue
+		}
+
+		property["description"] = usage
+		property["x-flag"] = "--" + flag.String()
+		property["x-env"] = envVar
+		if strings.HasPrefix(usage, deprecationPrefix) {
+			property["deprecated"] = true
+		}
+		for _, sensitive := range sensitiveFlags {
+			if flag.String() == sensitive {
+				property["writeOnly"] = true
+			}
+		}
+		if credential, ok := credentialFlags[flag.String()]; ok {
+			property["x-credential"] = credential
+		}
+		properties[configFieldName(flag.String())] = property
+	}
+
+	return map[string]interface{}{
+		"$schema":              schemaDialect,
+		"title":                d.DriverName() + "Config",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// configFieldName converts a flag name like hetzner-server-type to serverType
+func configFieldName(flag string) string {
+	parts := strings.Split(strings.TrimPrefix(flag, "hetzner-"), "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
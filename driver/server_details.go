@@ -0,0 +1,95 @@
+package driver
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// ResourceRef names a Hetzner resource the server uses, as shown by docker-machine inspect
+type ResourceRef struct {
+	ID   int64
+	Name string
+}
+
+// NetworkAttachment is a private network the server is attached to
+type NetworkAttachment struct {
+	ID   int64
+	Name string
+	IP   string
+}
+
+// recordServerDetails stores where the server runs and what it is attached to in the machine config, so tools only
+// reading it, like docker-machine inspect or Rancher, see the full context; they are informational only, and may be
+// outdated by changes made outside the driver
+func (d *Driver) recordServerDetails() error {
+	srv, _, err := d.getClient().Server.GetByID(d.getContext(), d.ServerID)
+	if err != nil {
+		return fmt.Errorf("could not get server by ID: %w", err)
+	}
+	if srv == nil {
+		return fmt.Errorf("server does not exist: %v", d.ServerID)
+	}
+
+	d.ServerName = srv.Name
+	if srv.Datacenter != nil {
+		d.Datacenter = srv.Datacenter.Name
+	}
+	if srv.ServerType != nil {
+		d.Type = srv.ServerType.Name
+	}
+	d.ServerImage = nil
+	if srv.Image != nil {
+		name := srv.Image.Name
+		if name == "" {
+			name = srv.Image.Description
+		}
+		d.ServerImage = &ResourceRef{ID: srv.Image.ID, Name: name}
+	}
+	d.PrimaryIPv4ID = srv.PublicNet.IPv4.ID
+	d.PrimaryIPv6ID = srv.PublicNet.IPv6.ID
+
+	names := make(map[int64]string)
+	for _, network := range d.cachedNetworks {
+		names[network.ID] = network.Name
+	}
+	d.AttachedNetworks = nil
+	for _, attachment := range srv.PrivateNet {
+		if attachment.Network == nil {
+			continue
+		}
+		name, ok := names[attachment.Network.ID]
+		if !ok {
+			network, _, err := d.getClient().Network.GetByID(d.getContext(), attachment.Network.ID)
+			if err != nil {
+				return fmt.Errorf("could not get network by ID: %w", err)
+			} else if network != nil {
+				name = network.Name
+			}
+		}
+		d.AttachedNetworks = append(d.AttachedNetworks, NetworkAttachment{ID: attachment.Network.ID, Name: name,
+			IP: attachment.IP.String()})
+	}
+
+	d.AppliedFirewalls = nil
+	for _, status := range srv.PublicNet.Firewalls {
+		firewall, _, err := d.getClient().Firewall.GetByID(d.getContext(), status.Firewall.ID)
+		if err != nil {
+			return fmt.Errorf("could not get firewall by ID: %w", err)
+		}
+		ref := ResourceRef{ID: status.Firewall.ID}
+		if firewall != nil {
+			ref.Name = firewall.Name
+		}
+		d.AppliedFirewalls = append(d.AppliedFirewalls, ref)
+	}
+	return nil
+}
+
+// updateServerDetails records the server details after changing the server, warning only, as the change itself
+// succeeded
+func (d *Driver) updateServerDetails() {
+	if err := d.recordServerDetails(); err != nil {
+		log.Warnf(" -> could not record server details: %v", err)
+	}
+}
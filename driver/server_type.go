@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// ChangeServerType changes the server type of an existing machine, powering it off for the change if necessary; the
+// server is powered on again afterward if it was running before. Unless keepDisk is set, the disk is upgraded to the
+// size of the new type, which is a one-way operation
+func (d *Driver) ChangeServerType(typeName string, keepDisk bool) error {
+	if typeName == "" {
+		return fmt.Errorf("a server type is required")
+	}
+
+	srv, err := d.getServerHandle()
+	if err != nil {
+		return fmt.Errorf("could not get server handle: %w", err)
+	}
+
+	stype, err := cachedLookup(d, catalogServerType, typeName, func() (*hcloud.ServerType, error) {
+		stype, _, err := d.getClient().ServerType.GetByName(d.getContext(), typeName)
+		return stype, err
+	})
+	if err != nil {
+		return fmt.Errorf("could not get type by name: %w", err)
+	}
+	if stype == nil {
+		return fmt.Errorf("unknown server type: %v", typeName)
+	}
+
+	if srv.ServerType != nil && srv.ServerType.ID == stype.ID {
+		log.Infof(" -> Server %s[%d] already is of type %s", srv.Name, srv.ID, stype.Name)
+		d.Type = stype.Name
+		return nil
+	}
+
+	srvstate, err := d.GetState()
+	if err != nil {
+		return fmt.Errorf("could not get state: %w", err)
+	}
+
+	wasRunning := srvstate != state.Stopped
+	if wasRunning {
+		if err = d.Stop(); err != nil {
+			return fmt.Errorf("could not stop server for type change: %w", err)
+		}
+	}
+
+	act, _, err := d.getClient().Server.ChangeType(d.getContext(), srv, hcloud.ServerChangeTypeOpts{
+		ServerType:  stype,
+		UpgradeDisk: !keepDisk,
+	})
+	if err != nil {
+		return fmt.Errorf("could not change server type: %w", err)
+	}
+
+	log.Infof(" -> Changing type of server %s[%d] to %s (keep disk: %v) in %s[%d]...", srv.Name, srv.ID, stype.Name, keepDisk, act.Command, act.ID)
+
+	if err = d.waitForAction(act); err != nil {
+		return fmt.Errorf("could not wait for server type change: %w", err)
+	}
+
+	d.Type = stype.Name
+	d.cachedType = stype
+	d.cachedServer = nil
+	d.updateServerDetails()
+
+	if !wasRunning {
+		return nil
+	}
+
+	if err = d.Start(); err != nil {
+		return err
+	}
+	return d.waitForRunningServer()
+}
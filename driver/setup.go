@@ -1,11 +1,12 @@
 package driver
 
 import (
-	"context"
 	"fmt"
 	"os"
+	"slices"
 	"time"
 
+	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/state"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 )
@@ -26,12 +27,36 @@ func (d *Driver) waitForRunningServer() error {
 		if d.WaitForRunningTimeout > 0 && int(elapsed_time) > d.WaitForRunningTimeout {
 			return fmt.Errorf("server exceeded wait-for-running-timeout")
 		}
-
-		time.Sleep(time.Duration(d.WaitOnPolling) * time.Second)
+		if err := d.sleep(); err != nil {
+			return fmt.Errorf("stopped waiting for server: %w", err)
+		}
 	}
 	return nil
 }
 
+// waitForStoppedServer polls the server state until it is stopped, returning false if the timeout was exceeded
+func (d *Driver) waitForStoppedServer(timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		srvstate, err := d.GetState()
+		if err != nil {
+			return false, fmt.Errorf("could not get state: %w", err)
+		}
+
+		if srvstate == state.Stopped {
+			return true, nil
+		}
+
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+
+		if err := d.sleep(); err != nil {
+			return false, err
+		}
+	}
+}
+
 func (d *Driver) waitForInitialStartup(srv hcloud.ServerCreateResult) error {
 	if len(srv.NextActions) != 0 {
 		if err := d.waitForMultipleActions("server.NextActions", srv.NextActions); err != nil {
@@ -48,18 +73,15 @@ func (d *Driver) makeCreateServerOptions() (*hcloud.ServerCreateOpts, error) {
 		return nil, err
 	}
 
-	userData, err := d.getUserData()
-	if err != nil {
-		return nil, err
-	}
-
 	srvopts := hcloud.ServerCreateOpts{
-		Name:           d.GetMachineName(),
-		UserData:       userData,
-		Labels:         d.ServerLabels,
+		Name:           d.getNewServerName(),
+		Labels:         d.machineLabels(d.ServerLabels),
 		PlacementGroup: pgrp,
 	}
 
+	if err = d.createUserDataPrimaryIPv4(); err != nil {
+		return nil, err
+	}
 	err = d.setPublicNetIfRequired(&srvopts)
 	if err != nil {
 		return nil, err
@@ -83,12 +105,21 @@ func (d *Driver) makeCreateServerOptions() (*hcloud.ServerCreateOpts, error) {
 	}
 	srvopts.Volumes = volumes
 
+	// may depend on created resources, such as the Docker data volume
+	if srvopts.UserData, err = d.renderUserData(); err != nil {
+		return nil, err
+	}
+
 	if srvopts.Location, err = d.getLocationNullable(); err != nil {
 		return nil, fmt.Errorf("could not get location: %w", err)
 	}
 	if srvopts.ServerType, err = d.getType(); err != nil {
 		return nil, fmt.Errorf("could not get type: %w", err)
 	}
+	d.autoscalerCapacityLabels(srvopts.Labels, srvopts.ServerType)
+	if d.preset != "" {
+		srvopts.Labels[d.labelName(labelPreset)] = d.preset
+	}
 	if srvopts.Image, err = d.getImage(); err != nil {
 		return nil, fmt.Errorf("could not get image: %w", err)
 	}
@@ -96,7 +127,14 @@ func (d *Driver) makeCreateServerOptions() (*hcloud.ServerCreateOpts, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not get ssh key: %w", err)
 	}
-	srvopts.SSHKeys = append(d.cachedAdditionalKeys, key)
+	srvopts.SSHKeys = []*hcloud.SSHKey{key}
+	for _, additional := range d.cachedAdditionalKeys {
+		// the same key may be given both as public key and by reference, which the API rejects
+		if !slices.ContainsFunc(srvopts.SSHKeys, func(k *hcloud.SSHKey) bool { return k.ID == additional.ID }) {
+			srvopts.SSHKeys = append(srvopts.SSHKeys, additional)
+		}
+	}
+	d.keyServerLabels(srvopts.Labels, srvopts.SSHKeys)
 	return &srvopts, nil
 }
 
@@ -105,6 +143,9 @@ func (d *Driver) getUserData() (string, error) {
 	if file == "" {
 		return d.userData, nil
 	}
+	if isUserDataURL(file) {
+		return d.fetchUserData(file)
+	}
 
 	readUserData, err := os.ReadFile(file)
 	if err != nil {
@@ -116,7 +157,7 @@ func (d *Driver) getUserData() (string, error) {
 func (d *Driver) createNetworks() ([]*hcloud.Network, error) {
 	networks := []*hcloud.Network{}
 	for _, networkIDorName := range d.Networks {
-		network, _, err := d.getClient().Network.Get(context.Background(), networkIDorName)
+		network, _, err := d.getClient().Network.Get(d.getContext(), networkIDorName)
 		if err != nil {
 			return nil, fmt.Errorf("could not get network by ID or name: %w", err)
 		}
@@ -125,13 +166,14 @@ func (d *Driver) createNetworks() ([]*hcloud.Network, error) {
 		}
 		networks = append(networks, network)
 	}
+	d.cachedNetworks = networks
 	return instrumented(networks), nil
 }
 
 func (d *Driver) createFirewalls() ([]*hcloud.ServerCreateFirewall, error) {
 	firewalls := []*hcloud.ServerCreateFirewall{}
 	for _, firewallIDorName := range d.Firewalls {
-		firewall, _, err := d.getClient().Firewall.Get(context.Background(), firewallIDorName)
+		firewall, _, err := d.getClient().Firewall.Get(d.getContext(), firewallIDorName)
 		if err != nil {
 			return nil, fmt.Errorf("could not get firewall by ID or name: %w", err)
 		}
@@ -143,10 +185,11 @@ func (d *Driver) createFirewalls() ([]*hcloud.ServerCreateFirewall, error) {
 	return instrumented(firewalls), nil
 }
 
-func (d *Driver) createVolumes() ([]*hcloud.Volume, error) {
+// getVolumes resolves the volumes given by --hetzner-volumes
+func (d *Driver) getVolumes() ([]*hcloud.Volume, error) {
 	volumes := []*hcloud.Volume{}
 	for _, volumeIDorName := range d.Volumes {
-		volume, _, err := d.getClient().Volume.Get(context.Background(), volumeIDorName)
+		volume, _, err := d.getClient().Volume.Get(d.getContext(), volumeIDorName)
 		if err != nil {
 			return nil, fmt.Errorf("could not get volume by ID or name: %w", err)
 		}
@@ -155,5 +198,105 @@ func (d *Driver) createVolumes() ([]*hcloud.Volume, error) {
 		}
 		volumes = append(volumes, volume)
 	}
+	return volumes, nil
+}
+
+func (d *Driver) createVolumes() ([]*hcloud.Volume, error) {
+	volumes, err := d.getVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	attach, err := d.getAttachVolumes()
+	if err != nil {
+		return nil, err
+	}
+	volumes = append(volumes, attach...)
+
+	dataVolume, err := d.makeDockerDataVolume()
+	if err != nil {
+		return nil, err
+	}
+	if dataVolume != nil {
+		volumes = append(volumes, dataVolume)
+	}
 	return instrumented(volumes), nil
 }
+
+func (d *Driver) createServer() (*hcloud.ServerCreateResult, error) {
+	err := d.prepareLocalKey()
+	if err != nil {
+		return nil, err
+	}
+
+	done := d.timePhase("key upload")
+	err = d.createRemoteKeys()
+	if err != nil {
+		return nil, err
+	}
+	// record the keys, so a resumed creation uses them again and removing the machine deletes them
+	d.checkpoint()
+	done()
+
+	log.Infof("Creating Hetzner server...")
+	if name := d.shortName(); name != d.GetMachineName() {
+		log.Infof(" -> Machine name is no valid server name, using %v", name)
+	}
+
+	done = d.timePhase("server options")
+	srvopts, err := d.makeCreateServerOptions()
+	if err != nil {
+		return nil, err
+	}
+	done()
+
+	done = d.timePhase("server create request")
+	srv, _, err := d.getClient().Server.Create(d.getContext(), instrumented(*srvopts))
+	if isAPIError(err, hcloud.ErrorCodeResourceLimitExceeded) {
+		// the API does not expose project limits, so these cannot be checked up front
+		return nil, fmt.Errorf("project resource limit reached, request a limit increase or remove unused resources: %w", err)
+	} else if isAPIError(err, hcloud.ErrorCodeUniquenessError) {
+		return nil, fmt.Errorf("%w: server %v was created concurrently: %w", ErrNameConflict, srvopts.Name, err)
+	} else if err != nil {
+		time.Sleep(time.Duration(d.WaitOnError) * time.Second)
+		return nil, fmt.Errorf("could not create server: %w", err)
+	}
+	done()
+
+	log.Infof(" -> Creating server %s[%d] in %s[%d]", srv.Server.Name, srv.Server.ID, srv.Action.Command, srv.Action.ID)
+
+	// record the server right away, so it can be cleaned up or resumed even if waiting fails
+	d.ServerID = srv.Server.ID
+	d.ServerName = srv.Server.Name
+	d.PrimaryIPv4ID, d.PrimaryIPv6ID = srv.Server.PublicNet.IPv4.ID, srv.Server.PublicNet.IPv6.ID
+	d.trackAttachedVolumes()
+	d.checkpoint()
+
+	d.dangling = append(d.dangling, func() {
+		res, _, err := d.getClient().Server.DeleteWithResult(d.getContext(), srv.Server)
+		if err == nil {
+			err = d.waitForAction(res.Action)
+		}
+		if err != nil {
+			log.Error(fmt.Errorf("could not delete server: %w", err))
+			return
+		}
+
+		d.ServerID, d.ServerName = 0, ""
+		d.AttachedVolumes, d.LoadBalancerIDs = nil, nil
+		d.checkpoint()
+	})
+	d.keepWithServer()
+
+	// the server only starts once it was created, so waiting for that in the startup suffices
+	if d.fastCreate && len(srv.NextActions) != 0 {
+		return &srv, nil
+	}
+
+	done = d.timePhase("server create action")
+	if err = d.waitForAction(srv.Action); err != nil {
+		return nil, fmt.Errorf("could not wait for action: %w", err)
+	}
+	done()
+	return &srv, nil
+}
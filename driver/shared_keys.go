@@ -0,0 +1,191 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const (
+	labelSharedKey = "shared-key"
+	// labelRefNamespace holds one label per machine referencing a shared key, named after the machine, as label values
+	// are too short to hold a list of machines
+	labelRefNamespace = labelNamespace + "-ref"
+	// labelKeyNamespace holds one label per SSH key a server was created with, named after the key's fingerprint
+	labelKeyNamespace = labelNamespace + "-key"
+)
+
+func (d *Driver) refLabelName(machine string) string {
+	return labelRefNamespace + "/" + machine
+}
+
+// refLabelValue names the store of the referencing machine, as machines of different stores may share a name
+func (d *Driver) refLabelValue() string {
+	if store := d.storeID(); store != "" {
+		return store
+	}
+	return "true"
+}
+
+// keyLabelName derives a label name from the key's MD5 fingerprint, whose colons are not allowed in labels
+func (d *Driver) keyLabelName(key *hcloud.SSHKey) string {
+	return labelKeyNamespace + "/" + strings.ReplaceAll(key.Fingerprint, ":", "")
+}
+
+// keyServerLabels marks the server as using the given keys, so their removal can be prevented while it exists
+func (d *Driver) keyServerLabels(labels map[string]string, keys []*hcloud.SSHKey) {
+	for _, key := range keys {
+		labels[d.keyLabelName(key)] = "true"
+	}
+}
+
+// otherKeyUsers returns the servers of other machines created with the key. Servers created by versions not labeling
+// keys are not taken into account.
+func (d *Driver) otherKeyUsers(key *hcloud.SSHKey) ([]string, error) {
+	servers, err := d.getClient().Server.AllWithOpts(d.getContext(), hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: d.labelName(labelMachine) + "," + d.keyLabelName(key)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list servers using ssh key %s[%d]: %w", key.Name, key.ID, err)
+	}
+
+	var users []string
+	for _, srv := range servers {
+		if srv.ID != d.ServerID && srv.Labels[d.labelName(labelMachine)] != d.shortName() {
+			users = append(users, srv.Name)
+		}
+	}
+	return users, nil
+}
+
+// sharedKeyLabels marks a key as shared and referenced by the machine; the machine label is omitted, so garbage
+// collection does not consider the key orphaned once the uploading machine is gone
+func (d *Driver) sharedKeyLabels() map[string]string {
+	labels := d.sharedLabels(d.keyLabels)
+	labels[d.labelName(labelSharedKey)] = "true"
+	labels[d.refLabelName(d.shortName())] = d.refLabelValue()
+	return labels
+}
+
+// ownKeyReferences tells whether all references of a shared key are by machines of this store; references made by
+// versions not recording the store are only accepted if includeUnlabeled is set
+func (d *Driver) ownKeyReferences(key *hcloud.SSHKey, includeUnlabeled bool) bool {
+	for k, v := range key.Labels {
+		if !strings.HasPrefix(k, labelRefNamespace+"/") {
+			continue
+		}
+		if v == "" || v != d.storeID() && !(includeUnlabeled && v == "true") {
+			return false
+		}
+	}
+	return true
+}
+
+// keyReferences returns the machines referencing a shared key
+func keyReferences(key *hcloud.SSHKey) []string {
+	var refs []string
+	for k := range key.Labels {
+		if machine, ok := strings.CutPrefix(k, labelRefNamespace+"/"); ok {
+			refs = append(refs, machine)
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// acquireSharedKey uploads a public key used by several machines only once, and references it otherwise. Keys not
+// uploaded as shared key, e.g. by hand, are used as they are and never deleted. Returns whether the key is shared.
+func (d *Driver) acquireSharedKey(name, pubkey string) (*hcloud.SSHKey, bool, error) {
+	key, err := d.getRemoteKeyWithSameFingerprintNullable([]byte(pubkey))
+	if err != nil {
+		return nil, false, fmt.Errorf("error retrieving potentially existing key: %w", err)
+	}
+
+	if key == nil {
+		log.Infof(" -> Uploading shared SSH key %v...", name)
+		var created bool
+		key, created, err = d.uploadKey(name, pubkey, d.sharedKeyLabels())
+		if err != nil {
+			return nil, false, err
+		}
+		if created {
+			return key, true, nil
+		}
+	}
+
+	if key.Labels[d.labelName(labelSharedKey)] != "true" {
+		log.Infof(" -> Using existing SSH key %s[%d]", key.Name, key.ID)
+		return key, false, nil
+	}
+
+	log.Infof(" -> Referencing shared SSH key %s[%d]", key.Name, key.ID)
+	if err := d.updateKeyReference(key, true); err != nil {
+		return nil, false, err
+	}
+	d.dangling = append(d.dangling, func() {
+		if err := d.releaseSharedKey(key.ID); err != nil {
+			log.Error(err)
+		}
+	})
+	return key, true, nil
+}
+
+// updateKeyReference adds or removes the machine's reference label. The API offers no atomic label updates, so
+// machines created or removed at the same time may lose each other's references.
+func (d *Driver) updateKeyReference(key *hcloud.SSHKey, add bool) error {
+	labels := make(map[string]string, len(key.Labels)+1)
+	for k, v := range key.Labels {
+		labels[k] = v
+	}
+	if add {
+		labels[d.refLabelName(d.shortName())] = d.refLabelValue()
+	} else {
+		delete(labels, d.refLabelName(d.shortName()))
+	}
+
+	updated, _, err := d.getClient().SSHKey.Update(d.getContext(), key, instrumented(hcloud.SSHKeyUpdateOpts{Labels: labels}))
+	if err != nil {
+		return fmt.Errorf("could not update references of ssh key %s[%d]: %w", key.Name, key.ID, err)
+	}
+	if updated != nil {
+		*key = *updated
+	}
+	d.invalidateCatalog(catalogSSHKey)
+	return nil
+}
+
+// releaseSharedKey removes the machine's reference from a shared key, deleting the key if no references remain
+func (d *Driver) releaseSharedKey(id int64) error {
+	key, _, err := d.getClient().SSHKey.GetByID(d.getContext(), id)
+	if err != nil {
+		return fmt.Errorf("could not get shared ssh key %d: %w", id, err)
+	} else if key == nil {
+		log.Infof(" -> Shared SSH key %d does not exist anymore", id)
+		return nil
+	}
+
+	if err := d.updateKeyReference(key, false); err != nil {
+		return err
+	}
+	if refs := keyReferences(key); len(refs) != 0 {
+		log.Infof(" -> Keeping shared SSH key %s[%d], still used by %v", key.Name, key.ID, strings.Join(refs, ", "))
+		return nil
+	}
+	// references may have been lost to concurrent updates
+	if users, err := d.otherKeyUsers(key); err != nil {
+		return err
+	} else if len(users) != 0 {
+		log.Infof(" -> Keeping shared SSH key %s[%d], still used by servers %v", key.Name, key.ID, strings.Join(users, ", "))
+		return nil
+	}
+
+	log.Infof(" -> Destroying shared SSH key %s[%d]...", key.Name, key.ID)
+	if _, err := d.getClient().SSHKey.Delete(d.getContext(), key); err != nil && !isAPIError(err, hcloud.ErrorCodeNotFound) {
+		return fmt.Errorf("could not delete shared ssh key: %w", err)
+	}
+	d.invalidateCatalog(catalogSSHKey)
+	return nil
+}
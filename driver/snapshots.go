@@ -0,0 +1,33 @@
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const labelSnapshotOf = "snapshot-of"
+
+func (d *Driver) snapshotServer(srv *hcloud.Server) error {
+	description := fmt.Sprintf("docker-machine %s, removed %s", d.GetMachineName(), time.Now().UTC().Format(time.RFC3339))
+
+	res, _, err := d.getClient().Server.CreateImage(d.getContext(), srv, instrumented(&hcloud.ServerCreateImageOpts{
+		Type:        hcloud.ImageTypeSnapshot,
+		Description: hcloud.Ptr(description),
+		Labels: d.resourceLabels(map[string]string{
+			d.labelName(labelSnapshotOf): d.shortName(),
+		}),
+	}))
+	if err != nil {
+		return fmt.Errorf("could not create snapshot: %w", err)
+	}
+
+	log.Infof(" -> Creating snapshot %s[%d] of server %s[%d] in %s[%d]...", description, res.Image.ID, srv.Name, srv.ID, res.Action.Command, res.Action.ID)
+
+	if err = d.waitForAction(res.Action); err != nil {
+		return fmt.Errorf("could not wait for snapshot: %w", err)
+	}
+	return nil
+}
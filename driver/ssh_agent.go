@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// GetSSHKeyPath returns no key when using the SSH agent, so docker-machine's SSH client falls back to the agent
+func (d *Driver) GetSSHKeyPath() string {
+	if d.UseSSHAgent {
+		return ""
+	}
+	return d.BaseDriver.GetSSHKeyPath()
+}
+
+// getAgentPublicKey returns the first key held by the SSH agent, which is the one offered first when connecting
+func getAgentPublicKey() ([]byte, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, fmt.Errorf("--%v requires a running ssh-agent, but SSH_AUTH_SOCK is not set", flagSSHAgent)
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to ssh-agent: %w", err)
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, fmt.Errorf("could not list keys of ssh-agent: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("ssh-agent holds no keys, add one using ssh-add")
+	}
+	return ssh.MarshalAuthorizedKey(keys[0]), nil
+}
+
+// getLocalPublicKey returns the public key of the machine, as read from the key pair in the store or the SSH agent
+func (d *Driver) getLocalPublicKey() ([]byte, error) {
+	if d.UseSSHAgent {
+		return getAgentPublicKey()
+	}
+
+	buf, err := os.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		return nil, fmt.Errorf("could not read ssh public key: %w", err)
+	}
+	return buf, nil
+}
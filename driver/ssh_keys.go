@@ -1,21 +1,167 @@
 package driver
 
 import (
-	"context"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnutils"
 	mcnssh "github.com/docker/machine/libmachine/ssh"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"golang.org/x/crypto/ssh"
 )
 
+const (
+	sshKeyTypeRSA     = "rsa"
+	sshKeyTypeEd25519 = "ed25519"
+
+	sha256FingerprintPrefix = "SHA256:"
+)
+
+var md5Fingerprint = regexp.MustCompile(`^(?i)[0-9a-f]{2}(:[0-9a-f]{2}){15}$`)
+
+// setAuthorizedKeysFromFlags validates the keys to authorize via user data, as cloud-init silently skips broken ones
+func (d *Driver) setAuthorizedKeysFromFlags(raw []string) error {
+	d.authorizedKeys = nil
+	for _, key := range raw {
+		key = strings.TrimSpace(key)
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+			return d.flagFailure("could not parse --%v %v: %v", flagAuthorizedKeys, key, err)
+		}
+		d.authorizedKeys = append(d.authorizedKeys, key)
+	}
+	return nil
+}
+
+// publicKeyPrefixes are the algorithm names public keys in authorized_keys format start with
+var publicKeyPrefixes = []string{"ssh-", "ecdsa-", "sk-"}
+
+// isPublicKey tells public keys in authorized_keys format apart from key names, which may start like key algorithms,
+// but hardly contain the space separating algorithm and key
+func isPublicKey(arg string) bool {
+	arg = strings.TrimSpace(arg)
+	for _, prefix := range publicKeyPrefixes {
+		if strings.HasPrefix(arg, prefix) && strings.ContainsAny(arg, " \t") {
+			return true
+		}
+	}
+	return false
+}
+
+// setAdditionalKeysFromFlags tells public keys to upload apart from references to keys already in the project
+func (d *Driver) setAdditionalKeysFromFlags(raw []string) {
+	d.AdditionalKeys, d.additionalKeyRefs = nil, nil
+	for _, arg := range raw {
+		if isPublicKey(arg) {
+			d.AdditionalKeys = append(d.AdditionalKeys, arg)
+		} else {
+			d.additionalKeyRefs = append(d.additionalKeyRefs, arg)
+		}
+	}
+}
+
+// getAdditionalKeyRefs resolves the existing keys to attach, i.e. those matching the key selector and those given by
+// name, ID or fingerprint
+func (d *Driver) getAdditionalKeyRefs() ([]*hcloud.SSHKey, error) {
+	keys, err := d.getSelectedKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range d.additionalKeyRefs {
+		var key *hcloud.SSHKey
+		if md5Fingerprint.MatchString(ref) {
+			key, err = d.getKeyByFingerprintNullable(strings.ToLower(ref))
+		} else if strings.HasPrefix(ref, sha256FingerprintPrefix) {
+			key, err = d.getKeyByFingerprintNullable(ref)
+		} else {
+			key, err = d.getKeyByNameOrIDNullable(ref)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, fmt.Errorf("additional key %v does not exist", ref)
+		}
+		if !slices.ContainsFunc(keys, func(k *hcloud.SSHKey) bool { return k.ID == key.ID }) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// getSelectedKeys lists all keys matching the key selector, so keys can be granted or revoked centrally via labels
+func (d *Driver) getSelectedKeys() ([]*hcloud.SSHKey, error) {
+	if d.keySelector == "" {
+		return nil, nil
+	}
+
+	keys, err := d.getClient().SSHKey.AllWithOpts(d.getContext(), hcloud.SSHKeyListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: d.keySelector},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list ssh keys: %w", err)
+	}
+	if len(keys) == 0 {
+		log.Warnf("no ssh key matches %v", d.keySelector)
+	}
+	return instrumented(keys), nil
+}
+
+// setExistingKeyFingerprintFromFlags accepts MD5 fingerprints in colon-separated hex, as shown by the API, and SHA256
+// ones in ssh-keygen's format
+func (d *Driver) setExistingKeyFingerprintFromFlags(fp string) error {
+	if fp == "" {
+		return nil
+	}
+
+	if d.KeyID != 0 {
+		return d.flagFailure("--%v and --%v are mutually exclusive", flagExKeyID, flagExKeyFP)
+	}
+	if d.originalKey == "" {
+		return d.flagFailure("--%v requires --%v, as the private key is needed to connect", flagExKeyFP, flagExKeyPath)
+	}
+	if !strings.HasPrefix(fp, sha256FingerprintPrefix) && !md5Fingerprint.MatchString(fp) {
+		return d.flagFailure("--%v must be an MD5 fingerprint like aa:bb:..., or a SHA256 fingerprint like %v...",
+			flagExKeyFP, sha256FingerprintPrefix)
+	}
+
+	d.existingKeyFP = fp
+	if md5Fingerprint.MatchString(fp) {
+		// as reported by the API
+		d.existingKeyFP = strings.ToLower(fp)
+	}
+	d.IsExistingKey = true
+	return nil
+}
+
 func (d *Driver) setupExistingKey() error {
 	if !d.IsExistingKey {
 		return nil
 	}
 
+	if d.existingKeyFP != "" {
+		key, err := d.getKeyByFingerprintNullable(d.existingKeyFP)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return fmt.Errorf("no ssh key with fingerprint %v found", d.existingKeyFP)
+		}
+		if err = verifyLocalKey(d.originalKey, key); err != nil {
+			return err
+		}
+		d.KeyID = key.ID
+		d.cachedKey = key
+	}
+
 	_, err := d.getKey()
 	if err != nil {
 		return fmt.Errorf("could not get key: %w", err)
@@ -24,6 +170,50 @@ func (d *Driver) setupExistingKey() error {
 	return nil
 }
 
+// verifyLocalKey ensures the local key pair belongs to the uploaded key, as the server would be unreachable otherwise.
+// The public key is derived from the private key where possible, falling back to the .pub file for encrypted keys.
+func verifyLocalKey(path string, key *hcloud.SSHKey) error {
+	remote, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+	if err != nil {
+		return fmt.Errorf("could not parse public key of %v[%d]: %w", key.Name, key.ID, err)
+	}
+
+	local, err := readLocalPublicKey(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(local.Marshal(), remote.Marshal()) {
+		return fmt.Errorf("local key %v does not match ssh key %v[%d] (%v)", path, key.Name, key.ID, ssh.FingerprintSHA256(local))
+	}
+	return nil
+}
+
+func readLocalPublicKey(path string) (ssh.PublicKey, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ssh key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(buf)
+	if err == nil {
+		return signer.PublicKey(), nil
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && missing.PublicKey != nil {
+		return missing.PublicKey, nil
+	}
+
+	buf, err = os.ReadFile(path + ".pub")
+	if err != nil {
+		return nil, fmt.Errorf("could not read ssh public key: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ssh public key: %w", err)
+	}
+	return pub, nil
+}
+
 func (d *Driver) copySSHKeyPair(src string) error {
 	if err := mcnutils.CopyFile(src, d.GetSSHKeyPath()); err != nil {
 		return fmt.Errorf("could not copy ssh key: %w", err)
@@ -44,46 +234,60 @@ func (d *Driver) createRemoteKeys() error {
 	if d.KeyID == 0 {
 		log.Infof("Creating SSH key...")
 
-		buf, err := os.ReadFile(d.GetSSHKeyPath() + ".pub")
-		if err != nil {
-			return fmt.Errorf("could not read ssh public key: %w", err)
-		}
-
-		key, err := d.getRemoteKeyWithSameFingerprintNullable(buf)
+		buf, err := d.getLocalPublicKey()
 		if err != nil {
-			return fmt.Errorf("error retrieving potentially existing key: %w", err)
+			return err
 		}
-		if key == nil {
-			log.Infof("SSH key not found in Hetzner. Uploading...")
 
-			key, err = d.makeKey(d.GetMachineName(), string(buf), d.keyLabels)
+		if d.originalKey != "" || d.UseSSHAgent {
+			// keys not generated for this machine are likely used by others as well
+			key, shared, err := d.acquireSharedKey(d.GetMachineName(), string(buf))
 			if err != nil {
 				return err
 			}
-		} else {
 			d.IsExistingKey = true
-			log.Debugf("SSH key found in Hetzner. ID: %d", key.ID)
-		}
+			if shared && !slices.Contains(d.SharedKeyIDs, key.ID) {
+				d.SharedKeyIDs = append(d.SharedKeyIDs, key.ID)
+			}
+			d.KeyID = key.ID
+		} else {
+			key, err := d.getRemoteKeyWithSameFingerprintNullable(buf)
+			if err != nil {
+				return fmt.Errorf("error retrieving potentially existing key: %w", err)
+			}
+			if key == nil {
+				log.Infof("SSH key not found in Hetzner. Uploading...")
 
-		d.KeyID = key.ID
+				var created bool
+				key, created, err = d.uploadKey(d.GetMachineName(), string(buf), d.resourceLabels(d.keyLabels))
+				if err != nil {
+					return err
+				}
+				d.IsExistingKey = !created
+			} else {
+				d.IsExistingKey = true
+				log.Debugf("SSH key found in Hetzner. ID: %d", key.ID)
+			}
+
+			d.KeyID = key.ID
+		}
+	}
+	refs, err := d.getAdditionalKeyRefs()
+	if err != nil {
+		return err
+	}
+	for _, key := range refs {
+		log.Infof("Using existing key (%v) %v", key.ID, key.Name)
+		d.cachedAdditionalKeys = append(d.cachedAdditionalKeys, key)
 	}
 	for i, pubkey := range d.AdditionalKeys {
-		key, err := d.getRemoteKeyWithSameFingerprintNullable([]byte(pubkey))
+		key, shared, err := d.acquireSharedKey(fmt.Sprintf("%v-additional-%d", d.GetMachineName(), i), pubkey)
 		if err != nil {
-			return fmt.Errorf("error checking for existing key for %v: %w", pubkey, err)
+			return fmt.Errorf("error acquiring key for %v: %w", pubkey, err)
 		}
-		if key == nil {
-			log.Infof("Creating new key for %v...", pubkey)
-			key, err = d.makeKey(fmt.Sprintf("%v-additional-%d", d.GetMachineName(), i), pubkey, d.keyLabels)
-
-			if err != nil {
-				return fmt.Errorf("error creating new key for %v: %w", pubkey, err)
-			}
-
-			log.Infof(" -> Created %v", key.ID)
-			d.AdditionalKeyIDs = append(d.AdditionalKeyIDs, key.ID)
-		} else {
-			log.Infof("Using existing key (%v) %v", key.ID, key.Name)
+		// a resumed creation acquires the keys again
+		if shared && !slices.Contains(d.SharedKeyIDs, key.ID) {
+			d.SharedKeyIDs = append(d.SharedKeyIDs, key.ID)
 		}
 
 		d.cachedAdditionalKeys = append(d.cachedAdditionalKeys, key)
@@ -92,11 +296,21 @@ func (d *Driver) createRemoteKeys() error {
 }
 
 func (d *Driver) prepareLocalKey() error {
-	if d.originalKey != "" {
+	if d.UseSSHAgent {
+		// nothing to store, but fail early if the agent is unavailable
+		_, err := getAgentPublicKey()
+		return err
+	} else if d.originalKey != "" {
 		log.Debugf("Copying SSH key...")
 		if err := d.copySSHKeyPair(d.originalKey); err != nil {
 			return fmt.Errorf("could not copy ssh key pair: %w", err)
 		}
+	} else if d.sshKeyType == sshKeyTypeEd25519 {
+		log.Debugf("Generating ed25519 SSH key...")
+		d.SSHKeyPath = d.ResolveStorePath("id_ed25519")
+		if err := generateEd25519Key(d.SSHKeyPath); err != nil {
+			return fmt.Errorf("could not generate ssh key: %w", err)
+		}
 	} else {
 		log.Debugf("Generating SSH key...")
 		if err := mcnssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
@@ -106,6 +320,77 @@ func (d *Driver) prepareLocalKey() error {
 	return nil
 }
 
+func (d *Driver) setSSHKeyTypeFromFlags(keyType string) error {
+	switch keyType {
+	case "", sshKeyTypeRSA:
+		d.sshKeyType = sshKeyTypeRSA
+	case sshKeyTypeEd25519:
+		if d.originalKey != "" {
+			return d.flagFailure("--%v only applies to generated keys, not to --%v", flagSshKeyType, flagExKeyPath)
+		}
+		d.sshKeyType = keyType
+	default:
+		return d.flagFailure("--%v must be %v or %v, got %v", flagSshKeyType, sshKeyTypeRSA, sshKeyTypeEd25519, keyType)
+	}
+	return nil
+}
+
+// generateEd25519Key writes a key pair in OpenSSH's format, along the lines of libmachine's RSA key generation; an
+// existing key pair, e.g. of a resumed creation, is kept
+func generateEd25519Key(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return err
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(sshPub), 0600)
+}
+
+// uploadKey creates a new key for the machine, unless a parallel creation uploaded the same public key in the meantime,
+// in which case the winner's key is reused. If merely the name is taken by another key, a name derived from the key's
+// fingerprint is used instead.
+func (d *Driver) uploadKey(name string, pubkey string, labels map[string]string) (*hcloud.SSHKey, bool, error) {
+	key, err := d.makeKey(name, pubkey, labels)
+	if !isAPIError(err, hcloud.ErrorCodeUniquenessError) {
+		return key, err == nil, err
+	}
+
+	d.invalidateCatalog(catalogSSHKey)
+	existing, ferr := d.getRemoteKeyWithSameFingerprintNullable([]byte(pubkey))
+	if ferr != nil {
+		return nil, false, fmt.Errorf("could not look up conflicting key: %w", ferr)
+	}
+	if existing != nil {
+		log.Infof(" -> Key was uploaded concurrently, reusing %s[%d]", existing.Name, existing.ID)
+		return existing, false, nil
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pubkey))
+	if err != nil {
+		return nil, false, fmt.Errorf("could not parse ssh public key: %w", err)
+	}
+	suffix := strings.ReplaceAll(ssh.FingerprintLegacyMD5(publicKey), ":", "")[:8]
+
+	log.Infof(" -> Key name %v is taken, retrying as %v-%v", name, name, suffix)
+	key, err = d.makeKey(name+"-"+suffix, pubkey, labels)
+	return key, err == nil, err
+}
+
 // Creates a new key for the machine and appends it to the dangling key list
 func (d *Driver) makeKey(name string, pubkey string, labels map[string]string) (*hcloud.SSHKey, error) {
 	keyopts := hcloud.SSHKeyCreateOpts{
@@ -114,17 +399,26 @@ func (d *Driver) makeKey(name string, pubkey string, labels map[string]string) (
 		Labels:    labels,
 	}
 
-	key, _, err := d.getClient().SSHKey.Create(context.Background(), instrumented(keyopts))
+	key, _, err := d.getClient().SSHKey.Create(d.getContext(), instrumented(keyopts))
 	if err != nil {
 		return nil, fmt.Errorf("could not create ssh key: %w", err)
 	} else if key == nil {
 		return nil, fmt.Errorf("key upload did not return an error, but key was nil")
 	}
 
+	// lookups by fingerprint may have cached the key's absence
+	d.invalidateCatalog(catalogSSHKey)
+
 	d.dangling = append(d.dangling, func() {
-		_, err := d.getClient().SSHKey.Delete(context.Background(), key)
+		_, err := d.getClient().SSHKey.Delete(d.getContext(), key)
 		if err != nil {
 			log.Error(fmt.Errorf("could not delete ssh key: %w", err))
+			return
+		}
+		d.invalidateCatalog(catalogSSHKey)
+		if d.KeyID == key.ID {
+			d.KeyID = 0
+			d.checkpoint()
 		}
 	})
 
@@ -0,0 +1,53 @@
+package driver
+
+import (
+	"regexp"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// labelSSHUser names the SSH user of a snapshot, e.g. of golden snapshots, overriding the image family's default
+const labelSSHUser = "ssh-user"
+
+// imageSSHUsers maps image families to the SSH user their images provide, matched in order against the name,
+// description and OS flavor of the image. Images of other families are assumed to use root, like those provided by
+// Hetzner.
+var imageSSHUsers = []struct {
+	family *regexp.Regexp
+	user   string
+}{
+	{regexp.MustCompile(`(?i)\bflatcar\b`), ignitionUser},
+	{regexp.MustCompile(`(?i)\b(fedora-coreos|fcos)\b`), ignitionUser},
+	{regexp.MustCompile(`(?i)\b(rancheros|burmilla)\b`), "rancher"},
+	{regexp.MustCompile(`(?i)\b(ubuntu|debian|centos|rocky|alma|fedora|opensuse|microos)\b`), defaultSSHUser},
+}
+
+// imageSSHUser returns the SSH user the image provides, by its label or family
+func (d *Driver) imageSSHUser(image *hcloud.Image) string {
+	if user := image.Labels[d.labelName(labelSSHUser)]; user != "" {
+		return user
+	}
+	text := image.Name + " " + image.Description + " " + image.OSFlavor
+	for _, mapping := range imageSSHUsers {
+		if mapping.family.MatchString(text) {
+			return mapping.user
+		}
+	}
+	return defaultSSHUser
+}
+
+// setImageSSHUser selects the SSH user of the image, unless --hetzner-ssh-user is given
+func (d *Driver) setImageSSHUser(image *hcloud.Image) {
+	if d.SSHUser != "" || image == nil {
+		return
+	}
+	d.SSHUser = d.imageSSHUser(image)
+	if d.SSHUser != defaultSSHUser {
+		name := image.Name
+		if name == "" {
+			name = image.Description
+		}
+		log.Infof(" -> Using SSH user %v of image %v[%d]", d.SSHUser, name, image.ID)
+	}
+}
@@ -0,0 +1,123 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	defaultSSHWaitTimeout  = 180
+	defaultSSHWaitInterval = time.Second
+	sshDialTimeout         = 3 * time.Second
+
+	// sshStableProbes is the number of consecutive successful probes needed, so provisioning by docker-machine, which
+	// the driver cannot retry, does not start while sshd is about to be restarted during the first boot
+	sshStableProbes = 3
+)
+
+func (d *Driver) setSSHWaitFlags(opts drivers.DriverOptions) error {
+	d.SSHWaitTimeout = opts.Int(flagSSHWaitTimeout)
+	if d.SSHWaitTimeout < 0 {
+		return d.flagFailure("--%v must not be negative, got %d", flagSSHWaitTimeout, d.SSHWaitTimeout)
+	}
+	if raw := opts.String(flagSSHWaitInterval); raw != "" {
+		var err error
+		if d.SSHWaitInterval, err = time.ParseDuration(raw); err != nil || d.SSHWaitInterval <= 0 {
+			return d.flagFailure("--%v must be a positive duration, got %v", flagSSHWaitInterval, raw)
+		}
+	}
+	d.SSHWaitAttempts = opts.Int(flagSSHWaitAttempts)
+	if d.SSHWaitAttempts < 0 {
+		return d.flagFailure("--%v must not be negative, got %d", flagSSHWaitAttempts, d.SSHWaitAttempts)
+	}
+	return nil
+}
+
+func (d *Driver) getSSHWaitTimeout() time.Duration {
+	if d.SSHWaitTimeout <= 0 { // machines created before the option existed
+		return defaultSSHWaitTimeout * time.Second
+	}
+	return time.Duration(d.SSHWaitTimeout) * time.Second
+}
+
+func (d *Driver) getSSHWaitInterval() time.Duration {
+	if d.SSHWaitInterval > 0 {
+		return d.SSHWaitInterval
+	}
+	if d.fastCreate {
+		return fastCreateWaitInterval
+	}
+	return defaultSSHWaitInterval
+}
+
+// waitForSSH probes the server until it accepts SSH logins on several consecutive attempts, giving up after
+// --hetzner-ssh-wait-timeout or --hetzner-ssh-wait-attempts. Each attempt connects to the SSH port via plain TCP first,
+// which notices sshd coming up much sooner than a full login, and only logs in once the port is open.
+func (d *Driver) waitForSSH() error {
+	return d.waitForSSHCommand("exit 0", d.getSSHWaitTimeout(), d.SSHWaitAttempts)
+}
+
+// waitForSSHCommand probes the server like waitForSSH, but requires the command to succeed on each attempt, giving up
+// after the timeout or the given number of failed attempts, if any
+func (d *Driver) waitForSSHCommand(command string, timeout time.Duration, attempts int) error {
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(d.SSHPort))
+
+	interval := d.getSSHWaitInterval()
+	deadline := time.Now().Add(timeout)
+	successes := 0
+	for attempt := 1; ; attempt++ {
+		if err = d.probeSSH(addr, command); err == nil {
+			successes++
+			if successes >= sshStableProbes {
+				log.Debugf(" -> SSH on %v ready after %d attempts", addr, attempt)
+				return nil
+			}
+		} else {
+			if successes != 0 {
+				log.Debugf(" -> SSH on %v dropped again after %d successful probes", addr, successes)
+			}
+			successes = 0
+			log.Debugf(" -> SSH on %v not ready yet (attempt %d): %v", addr, attempt, err)
+
+			if attempts > 0 && attempt >= attempts {
+				return fmt.Errorf("SSH on %v not ready after %d attempts: %w", addr, attempt, err)
+			}
+		}
+		if time.Now().Add(interval).After(deadline) {
+			if err == nil {
+				return fmt.Errorf("SSH on %v not stable within %v", addr, timeout)
+			}
+			return fmt.Errorf("SSH on %v not ready within %v: %w", addr, timeout, err)
+		}
+
+		ctx := d.getContext()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (d *Driver) probeSSH(addr, command string) error {
+	dialer := net.Dialer{Timeout: sshDialTimeout}
+	conn, err := dialer.DialContext(d.getContext(), "tcp", addr)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+
+	if _, err = drivers.RunSSHCommandFromDriver(d, command); err != nil {
+		return fmt.Errorf("could not log in: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package driver
+
+import (
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+type phaseTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// timePhase starts timing a phase of the creation; the returned function ends it
+func (d *Driver) timePhase(name string) func() {
+	start := time.Now()
+	return func() {
+		d.phases = append(d.phases, phaseTiming{name: name, duration: time.Since(start)})
+	}
+}
+
+// logPhaseTimings logs how long each phase of the creation took, to pinpoint slow provisioning. If the creation
+// succeeded, docker-machine goes on to install Docker, which is timed until endProvisioningTiming.
+func (d *Driver) logPhaseTimings(provisioning bool) {
+	if len(d.phases) == 0 {
+		return
+	}
+
+	var total time.Duration
+	log.Infof("Creation phases:")
+	for _, phase := range d.phases {
+		log.Infof(" -> %-22s %v", phase.name, phase.duration.Round(time.Millisecond))
+		total += phase.duration
+	}
+	log.Infof(" -> %-22s %v", "total", total.Round(time.Millisecond))
+
+	if provisioning {
+		d.provisionStart = time.Now()
+		return
+	}
+	d.phases = nil
+}
+
+// endProvisioningTiming logs how long docker-machine took to install Docker after the creation, along with the total.
+// docker-machine asks for the URL of the daemon right after that, to configure TLS for it, which is the only hint the
+// driver gets of its progress, so the duration includes detecting the OS and installing its base packages.
+func (d *Driver) endProvisioningTiming() {
+	if d.provisionStart.IsZero() {
+		return
+	}
+	phase := phaseTiming{name: "docker install", duration: time.Since(d.provisionStart)}
+	d.phases = append(d.phases, phase)
+	d.provisionStart = time.Time{}
+
+	var total time.Duration
+	for _, phase := range d.phases {
+		total += phase.duration
+	}
+	log.Infof(" -> %-22s %v", phase.name, phase.duration.Round(time.Millisecond))
+	log.Infof(" -> %-22s %v", "total with docker", total.Round(time.Millisecond))
+}
@@ -0,0 +1,318 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"gopkg.in/yaml.v3"
+)
+
+const cloudConfigHeader = "#cloud-config"
+
+// userDataSnippetExts are the extensions of files considered cloud-config snippets in --hetzner-user-data-dir
+var userDataSnippetExts = []string{".yaml", ".yml", ".cfg"}
+
+func isCloudConfig(userData string) bool {
+	return strings.HasPrefix(strings.TrimSpace(userData), cloudConfigHeader)
+}
+
+// templateReference finds expressions referring to the template data in user data, to hint at a missing
+// --hetzner-user-data-template
+var templateReference = regexp.MustCompile(`\{\{-?\s*((range|index)\s+)?\(?(\.(MachineName|Labels|PublicIPv4|Volumes)\b|volume\s)`)
+
+// publicIPv4Reference finds references to the public IPv4 in user data templates
+var publicIPv4Reference = regexp.MustCompile(`\.PublicIPv4\b`)
+
+// publicIPv4Placeholder is rendered for the public IPv4 while checking the user data before the primary IP is
+// created, having the maximum length of an address
+const publicIPv4Placeholder = "255.255.255.255"
+
+// userDataTemplateData is passed to the user data when rendered as template
+type userDataTemplateData struct {
+	MachineName string
+	Labels      map[string]string
+	Volumes     []AttachedVolume
+
+	publicIPv4   *hcloud.PrimaryIP
+	noPublicIPv4 bool
+}
+
+// PublicIPv4 is the address of the given primary IP, or of the one created up front for templates referring to it
+func (t userDataTemplateData) PublicIPv4() (string, error) {
+	switch {
+	case t.publicIPv4 != nil:
+		return t.publicIPv4.IP.String(), nil
+	case t.noPublicIPv4:
+		return "", fmt.Errorf("the server has no public IPv4, as --%v is given", flagDisablePublic4)
+	default:
+		return publicIPv4Placeholder, nil
+	}
+}
+
+func (d *Driver) executeUserDataTemplate(userData string) (string, error) {
+	publicIPv4, err := d.getPrimaryIPv4()
+	if err != nil {
+		return "", fmt.Errorf("could not resolve primary IPv4: %w", err)
+	}
+
+	data := userDataTemplateData{
+		MachineName: d.GetMachineName(),
+		Labels:      d.machineLabels(d.ServerLabels),
+		Volumes:     d.getPendingVolumes(),
+		publicIPv4:  publicIPv4,
+
+		noPublicIPv4: d.DisablePublic4 && publicIPv4 == nil,
+	}
+
+	tmpl, err := template.New("user-data").Option("missingkey=error").Funcs(template.FuncMap{
+		"volume": func(name string) (AttachedVolume, error) {
+			for _, volume := range data.Volumes {
+				if volume.Name == name {
+					return volume, nil
+				}
+			}
+			return AttachedVolume{}, fmt.Errorf("volume %v is not attached", name)
+		},
+	}).Parse(userData)
+	if err != nil {
+		return "", fmt.Errorf("could not parse user data template: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("could not render user data template: %w", err)
+	}
+	return out.String(), nil
+}
+
+// getGeneratedCloudConfig collects the cloud-config fragments generated by the driver itself
+func (d *Driver) getGeneratedCloudConfig(userData string) ([]map[string]interface{}, error) {
+	var fragments []map[string]interface{}
+
+	if volume := d.cachedDataVolume; volume != nil {
+		fragments = append(fragments, dockerDataVolumeCloudConfig(volume.LinuxDevice))
+	}
+	if len(d.writeFiles) != 0 {
+		fragments = append(fragments, writeFilesCloudConfig(d.writeFiles))
+	}
+	if len(d.packages) != 0 {
+		fragments = append(fragments, packagesCloudConfig(d.packages))
+	}
+	if d.machineMetadata {
+		fragments = append(fragments, d.machineMetadataCloudConfig())
+	}
+	if d.preset != "" {
+		fragments = append(fragments, d.presetCloudConfig())
+	}
+	// first boot configs other than cloud-init receive the keys along with the machine's
+	if d.ignition || d.combustion {
+		return fragments, nil
+	}
+
+	if d.GetSSHUsername() != defaultSSHUser && (strings.TrimSpace(userData) == "" || isCloudConfig(userData)) {
+		keys, err := d.getBootConfigKeys()
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, sshUserCloudConfig(d.GetSSHUsername(), keys))
+	} else if d.GetSSHUsername() != defaultSSHUser && len(d.authorizedKeys) == 0 {
+		log.Warnf("User data is no cloud-config, so the SSH user %v is expected to exist in the image", d.GetSSHUsername())
+	} else if len(d.authorizedKeys) != 0 {
+		fragments = append(fragments, authorizedKeysCloudConfig(d.GetSSHUsername(), d.authorizedKeys))
+	}
+
+	return fragments, nil
+}
+
+// splitPackages accepts the packages both as repeated flags and as comma-separated list
+func splitPackages(raw []string) []string {
+	var packages []string
+	for _, arg := range raw {
+		for _, pkg := range strings.Split(arg, ",") {
+			if pkg = strings.TrimSpace(pkg); pkg != "" {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+	return packages
+}
+
+// packagesCloudConfig installs the given packages, refreshing the package index first, as images may ship with
+// outdated ones; package_update given by the user data takes precedence
+func packagesCloudConfig(packages []string) map[string]interface{} {
+	list := make([]interface{}, 0, len(packages))
+	for _, pkg := range packages {
+		list = append(list, pkg)
+	}
+	return map[string]interface{}{
+		"packages":       list,
+		"package_update": true,
+	}
+}
+
+// mergeUserDataDir merges the cloud-config snippets of the user data directory in lexical order, followed by the
+// given user data, so later documents take precedence
+func (d *Driver) mergeUserDataDir(userData string) (string, error) {
+	entries, err := os.ReadDir(d.userDataDir)
+	if err != nil {
+		return "", fmt.Errorf("could not read user data directory: %w", err)
+	}
+
+	merged := ""
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !slices.Contains(userDataSnippetExts, filepath.Ext(entry.Name())) {
+			continue
+		}
+
+		buf, err := os.ReadFile(filepath.Join(d.userDataDir, entry.Name()))
+		if err != nil {
+			return "", fmt.Errorf("could not read user data snippet: %w", err)
+		}
+		if merged, err = mergeYAMLDocs(merged, string(buf), d.userDataDedupe); err != nil {
+			return "", fmt.Errorf("could not merge user data snippet %v: %w", entry.Name(), err)
+		}
+	}
+
+	if strings.TrimSpace(userData) != "" {
+		if !isCloudConfig(userData) {
+			return "", fmt.Errorf("user data must be a cloud-config document to be merged with --%v", flagUserDataDir)
+		}
+		if merged, err = mergeYAMLDocs(merged, userData, d.userDataDedupe); err != nil {
+			return "", fmt.Errorf("could not merge user data: %w", err)
+		}
+	}
+	return merged, nil
+}
+
+// authorizedKeysCloudConfig authorizes the keys for root, which the images provided by Hetzner use as default user,
+// or adds them to the entry of another SSH user, keeping the default user
+func authorizedKeysCloudConfig(user string, keys []string) map[string]interface{} {
+	list := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key)
+	}
+
+	if user == defaultSSHUser {
+		return map[string]interface{}{"ssh_authorized_keys": list}
+	}
+	return map[string]interface{}{
+		"users": []interface{}{
+			"default",
+			map[string]interface{}{"name": user, "ssh_authorized_keys": list},
+		},
+	}
+}
+
+// sshUserCloudConfig creates the unprivileged SSH user with passwordless sudo, as provisioning needs root privileges,
+// and disables SSH logins as root; keys passed via the API are only authorized for root by the images provided by
+// Hetzner, so they are authorized for the SSH user as well
+func sshUserCloudConfig(user string, keys []string) map[string]interface{} {
+	list := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key)
+	}
+
+	return map[string]interface{}{
+		"disable_root": true,
+		"users": []interface{}{
+			"default",
+			map[string]interface{}{
+				"name":                user,
+				"shell":               "/bin/bash",
+				"sudo":                "ALL=(ALL) NOPASSWD:ALL",
+				"lock_passwd":         true,
+				"ssh_authorized_keys": list,
+			},
+		},
+	}
+}
+
+// renderUserData retrieves the user supplied user data, merging any driver-generated cloud-config into it, and
+// validates the result, compressing it if too large
+func (d *Driver) renderUserData() (string, error) {
+	userData, err := d.composeUserData()
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case d.ignition:
+		userData, err = d.composeIgnition(userData)
+	case d.combustion:
+		userData, err = d.composeCombustion(userData)
+	default:
+		if err = validateUserData(userData); err != nil {
+			return "", err
+		}
+		return fitUserData(userData)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// neither Ignition nor Combustion decode compressed user data
+	if len(userData) > userDataMaxSize {
+		return "", fmt.Errorf("the first boot config has %d bytes, exceeding the limit of %d bytes", len(userData), userDataMaxSize)
+	}
+	return userData, nil
+}
+
+// getMergedUserData retrieves the user supplied user data along with the snippets of --hetzner-user-data-dir
+func (d *Driver) getMergedUserData() (string, error) {
+	userData, err := d.getUserData()
+	if err != nil || d.userDataDir == "" {
+		return userData, err
+	}
+	return d.mergeUserDataDir(userData)
+}
+
+func (d *Driver) composeUserData() (string, error) {
+	userData, err := d.getMergedUserData()
+	if err != nil {
+		return "", err
+	}
+
+	if !d.userDataTemplate && templateReference.MatchString(userData) {
+		log.Warnf("The user data seems to contain template expressions of the driver, which are only rendered given --%v",
+			flagUserDataTemplate)
+	}
+	if d.userDataTemplate {
+		if userData, err = d.executeUserDataTemplate(userData); err != nil {
+			return "", err
+		}
+	}
+
+	fragments, err := d.getGeneratedCloudConfig(userData)
+	if err != nil {
+		return "", err
+	}
+	if len(fragments) == 0 {
+		return userData, nil
+	}
+
+	if strings.TrimSpace(userData) != "" && !isCloudConfig(userData) {
+		return "", fmt.Errorf("user data must be a cloud-config document to merge driver-generated configuration")
+	}
+
+	for _, fragment := range fragments {
+		out, err := yaml.Marshal(fragment)
+		if err != nil {
+			return "", fmt.Errorf("could not marshal generated cloud-config: %w", err)
+		}
+
+		userData, err = mergeYAMLDocs(string(out), userData, d.userDataDedupe)
+		if err != nil {
+			return "", fmt.Errorf("could not merge generated cloud-config: %w", err)
+		}
+	}
+
+	return userData, nil
+}
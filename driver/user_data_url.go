@@ -0,0 +1,101 @@
+package driver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	// userDataFetchTimeout bounds fetching user data from a URL, which should be served by a nearby server
+	userDataFetchTimeout = 30 * time.Second
+	// userDataFetchMaxSize is generous, as user data may exceed the API's limit before being compressed
+	userDataFetchMaxSize = 1024 * 1024
+)
+
+func isUserDataURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// parseUserDataChecksum accepts checksums in <algorithm>:<hex> format, defaulting to sha256 if no algorithm is given
+func parseUserDataChecksum(checksum string) (func() hash.Hash, []byte, error) {
+	algorithm, sum, found := strings.Cut(checksum, ":")
+	if !found {
+		algorithm, sum = "sha256", checksum
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(algorithm) {
+	case "sha256":
+		newHash = sha256.New
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %v, use sha256 or sha512", algorithm)
+	}
+
+	raw, err := hex.DecodeString(sum)
+	if err != nil || len(raw) != newHash().Size() {
+		return nil, nil, fmt.Errorf("checksum %v is not a valid %v checksum", checksum, algorithm)
+	}
+	return newHash, raw, nil
+}
+
+// fetchUserData downloads the user data once per process, so the validation before creation and the creation itself
+// see the same contents
+func (d *Driver) fetchUserData(url string) (string, error) {
+	if d.cachedUserData != nil {
+		return *d.cachedUserData, nil
+	}
+
+	log.Infof(" -> Fetching user data from %v", url)
+
+	client := http.Client{Timeout: userDataFetchTimeout}
+	req, err := http.NewRequestWithContext(d.getContext(), http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not fetch user data: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not fetch user data: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not fetch user data: %v", resp.Status)
+	}
+
+	// reading one byte more than allowed detects oversized documents without loading them completely
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, userDataFetchMaxSize+1))
+	if err != nil {
+		return "", fmt.Errorf("could not fetch user data: %w", err)
+	}
+	if len(buf) > userDataFetchMaxSize {
+		return "", fmt.Errorf("user data at %v exceeds %d bytes", url, userDataFetchMaxSize)
+	}
+
+	if d.userDataChecksum != "" {
+		newHash, expected, err := parseUserDataChecksum(d.userDataChecksum)
+		if err != nil {
+			return "", err
+		}
+		h := newHash()
+		h.Write(buf)
+		if actual := h.Sum(nil); !bytes.Equal(actual, expected) {
+			return "", fmt.Errorf("checksum mismatch for user data at %v: expected %x, got %x", url, expected, actual)
+		}
+	}
+
+	userData := string(buf)
+	d.cachedUserData = &userData
+	return userData, nil
+}
@@ -0,0 +1,96 @@
+package driver
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const libmachineModule = "github.com/docker/machine"
+
+// Capability is a feature of the driver along with the create flags enabling it, for telling in advance whether a
+// build supports what a setup relies on
+type Capability struct {
+	Name  string
+	Flags []string
+}
+
+// capabilities lists the features of the driver; each flag must be a create flag
+var capabilities = []Capability{
+	{"arm", []string{flagImageArch}},
+	{"primary-ips", []string{flagPrimary4, flagPrimary6, flagDisablePublic4, flagDisablePublic6}},
+	{"firewalls", []string{flagFirewalls}},
+	{"networks", []string{flagNetworks, flagUsePrivateNetwork, flagDefaultNetworks}},
+	{"volumes", []string{flagVolumes, flagAttachVolume, flagDockerDataVolumeSize}},
+	{"load-balancers", []string{flagLBTarget, flagLBCreateFromFile}},
+	{"placement-groups", []string{flagPlacementGroup, flagAutoSpread}},
+	{"cloud-init", []string{flagUserData, flagWaitCloudInit, flagWaitMetadata}},
+	{"ignition", []string{flagIgnition, flagIgnitionFile}},
+	{"combustion", []string{flagCombustion, flagCombustionFile, flagWritableRoot}},
+	{"existing-servers", []string{flagExistingServer, flagDeleteAdopted, flagOnNameConflict}},
+	{"golden-snapshots", []string{flagGoldenSnapshot, flagFromGolden}},
+	{"pricing", []string{flagMaxHourlyPrice, flagAutoCheapest}},
+	{"node-groups", []string{flagNodeGroup}},
+	{"host-key-pinning", []string{flagPinHostKey}},
+	{"ssh-agent", []string{flagSSHAgent}},
+	{"ed25519-keys", []string{flagSshKeyType}},
+	{"dry-run", []string{flagDryRun}},
+	{"fast-create", []string{flagFastCreate}},
+}
+
+// VersionReport describes the build of the driver, for bug reports and compatibility checks
+type VersionReport struct {
+	Version      string
+	Revision     string `json:",omitempty"`
+	BuildTime    string `json:",omitempty"`
+	Modified     bool   `json:",omitempty"`
+	GoVersion    string
+	Platform     string
+	HcloudGo     string
+	Libmachine   string `json:",omitempty"`
+	Capabilities []Capability
+	Flags        []string
+}
+
+// VersionReport gathers the driver's version, the versions it was built with, its capabilities and create flags
+func (d *Driver) VersionReport() VersionReport {
+	report := VersionReport{
+		Version:      d.version,
+		GoVersion:    runtime.Version(),
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+		HcloudGo:     hcloud.Version,
+		Capabilities: capabilities,
+	}
+	if report.Version == "" {
+		report.Version = "(development build)"
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == libmachineModule {
+				report.Libmachine = dep.Version
+				if dep.Replace != nil {
+					report.Libmachine = dep.Replace.Path + " " + dep.Replace.Version
+				}
+			}
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				report.Revision = setting.Value
+			case "vcs.time":
+				report.BuildTime = setting.Value
+			case "vcs.modified":
+				report.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	for _, flag := range d.GetCreateFlags() {
+		report.Flags = append(report.Flags, flag.String())
+	}
+	sort.Strings(report.Flags)
+	return report
+}
@@ -0,0 +1,444 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const (
+	dockerDataRoot          = "/var/lib/docker"
+	dockerDataVolumeFormat  = "ext4"
+	minDockerDataVolumeSize = 10
+)
+
+// AttachedVolume records a volume attached by the driver, along with the mount point requested for it, the Linux
+// device it is available as and whether the driver created it
+type AttachedVolume struct {
+	ID         int64
+	Name       string
+	MountPoint string
+	Device     string
+	Created    bool `json:",omitempty"`
+}
+
+// volumeRequest is a parsed --hetzner-attach-volume argument in <id-or-name>[:<mount-point>] format
+type volumeRequest struct {
+	idOrName   string
+	mountPoint string
+}
+
+func (d *Driver) setAttachVolumesFromFlags(raw []string) error {
+	d.attachVolumes = nil
+	for _, arg := range raw {
+		split := strings.SplitN(arg, ":", 2)
+		req := volumeRequest{idOrName: split[0]}
+		if req.idOrName == "" {
+			return d.flagFailure("volume %v does not specify an ID or name", arg)
+		}
+		if len(split) == 2 {
+			req.mountPoint = split[1]
+			if !path.IsAbs(req.mountPoint) {
+				return d.flagFailure("mount point for volume %v must be an absolute path", arg)
+			}
+		}
+		d.attachVolumes = append(d.attachVolumes, req)
+	}
+	return nil
+}
+
+func (d *Driver) setDockerDataVolumeFromFlags(size int) error {
+	if size != 0 && size < minDockerDataVolumeSize {
+		return d.flagFailure("--%v must be at least %d (GB)", flagDockerDataVolumeSize, minDockerDataVolumeSize)
+	}
+	d.dockerDataVolumeSize = size
+	return nil
+}
+
+func (d *Driver) getAttachVolumes() ([]*hcloud.Volume, error) {
+	if d.cachedAttachVolumes != nil || (len(d.attachVolumes) == 0 && d.volumeSelector == "") {
+		return d.cachedAttachVolumes, nil
+	}
+
+	volumes := make([]*hcloud.Volume, 0, len(d.attachVolumes))
+	for _, req := range d.attachVolumes {
+		volume, _, err := d.getClient().Volume.Get(d.getContext(), req.idOrName)
+		if err != nil {
+			return nil, fmt.Errorf("could not get volume by ID or name: %w", err)
+		}
+		if volume == nil {
+			return nil, fmt.Errorf("volume '%s' not found", req.idOrName)
+		}
+		if volume.Server != nil {
+			return nil, fmt.Errorf("volume '%s' is already attached to server %d", req.idOrName, volume.Server.ID)
+		}
+		volumes = append(volumes, volume)
+	}
+
+	if d.volumeSelector != "" {
+		volume, err := d.claimVolume(volumes)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, volume)
+		d.attachVolumes = append(d.attachVolumes, volumeRequest{idOrName: volume.Name})
+	}
+
+	d.cachedAttachVolumes = volumes
+	return instrumented(volumes), nil
+}
+
+// claimVolume picks the first unattached volume matching the volume selector, located next to the explicitly given
+// volumes or in the requested location, if any
+func (d *Driver) claimVolume(explicit []*hcloud.Volume) (*hcloud.Volume, error) {
+	var locationName string
+	if len(explicit) != 0 {
+		locationName = explicit[0].Location.Name
+	} else if location, err := d.getLocationNullable(); err != nil {
+		return nil, fmt.Errorf("could not get location: %w", err)
+	} else if location != nil {
+		locationName = location.Name
+	}
+
+	candidates, err := d.getClient().Volume.AllWithOpts(d.getContext(), hcloud.VolumeListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: d.volumeSelector},
+		Status:   []hcloud.VolumeStatus{hcloud.VolumeStatusAvailable},
+		Sort:     []string{"id:asc"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list volumes: %w", err)
+	}
+
+	for _, volume := range candidates {
+		if volume.Server != nil {
+			continue
+		}
+		if locationName != "" && volume.Location.Name != locationName {
+			continue
+		}
+
+		log.Infof("Claiming volume %s[%d] matching %v", volume.Name, volume.ID, d.volumeSelector)
+		return instrumented(volume), nil
+	}
+
+	if locationName != "" {
+		return nil, fmt.Errorf("no unattached volume matching '%s' found in %v", d.volumeSelector, locationName)
+	}
+	return nil, fmt.Errorf("no unattached volume matching '%s' found", d.volumeSelector)
+}
+
+// verifyAttachVolumeLocation ensures the server will be created next to the volumes; if no location was given, the
+// server is pinned to the volumes' location
+func (d *Driver) verifyAttachVolumeLocation() error {
+	volumes, err := d.getAttachVolumes()
+	if err != nil {
+		return err
+	}
+
+	location, err := d.getLocationNullable()
+	if err != nil {
+		return fmt.Errorf("could not get location: %w", err)
+	}
+
+	for _, volume := range volumes {
+		if location == nil {
+			log.Infof("Using location %v of volume %v", volume.Location.Name, volume.Name)
+			d.Location = volume.Location.Name
+			d.cachedLocation = volume.Location
+			location = volume.Location
+			continue
+		}
+
+		if location.Name != volume.Location.Name {
+			return fmt.Errorf("volume '%s' is located in %v, but server is to be created in %v",
+				volume.Name, volume.Location.Name, location.Name)
+		}
+	}
+	return nil
+}
+
+// getPendingVolumes lists the volumes to be passed on server creation, as they will be tracked in the driver state
+func (d *Driver) getPendingVolumes() []AttachedVolume {
+	var pending []AttachedVolume
+	for i, volume := range d.cachedAttachVolumes {
+		pending = append(pending, AttachedVolume{
+			ID:         volume.ID,
+			Name:       volume.Name,
+			MountPoint: d.attachVolumes[i].mountPoint,
+			Device:     volume.LinuxDevice,
+		})
+	}
+
+	if volume := d.cachedDataVolume; volume != nil {
+		pending = append(pending, AttachedVolume{
+			ID:         volume.ID,
+			Name:       volume.Name,
+			MountPoint: dockerDataRoot,
+			Device:     volume.LinuxDevice,
+			Created:    true,
+		})
+	}
+	return pending
+}
+
+// trackAttachedVolumes records the volumes passed on server creation in the driver state
+func (d *Driver) trackAttachedVolumes() {
+	d.AttachedVolumes = append(d.AttachedVolumes, d.getPendingVolumes()...)
+}
+
+func (d *Driver) detachVolume(attached AttachedVolume) error {
+	volume, _, err := d.getClient().Volume.GetByID(d.getContext(), attached.ID)
+	if err != nil {
+		return fmt.Errorf("could not get volume by ID: %w", err)
+	}
+	if volume == nil {
+		log.Infof(" -> Volume %s[%d] does not exist anymore", attached.Name, attached.ID)
+		return nil
+	}
+	if volume.Server == nil || volume.Server.ID != d.ServerID {
+		log.Infof(" -> Volume %s[%d] is not attached to the server anymore", volume.Name, volume.ID)
+		return nil
+	}
+
+	log.Infof(" -> Detaching volume %s[%d]...", volume.Name, volume.ID)
+	act, _, err := retryLocked(d, func() (*hcloud.Action, *hcloud.Response, error) {
+		return d.getClient().Volume.Detach(d.getContext(), volume)
+	})
+	if err != nil {
+		return fmt.Errorf("could not detach volume: %w", err)
+	}
+
+	return d.waitForAction(act)
+}
+
+func (d *Driver) detachVolumes() {
+	// failure to detach a volume is not a hard error, as deleting the server will detach it anyway
+	for _, attached := range d.AttachedVolumes {
+		if softErr := d.detachVolume(attached); softErr != nil {
+			log.Warnf(" -> could not detach volume %s[%d]: %v", attached.Name, attached.ID, softErr)
+		}
+	}
+}
+
+// deleteVolumes deletes the volumes created by the driver; volumes attached by ID, name or selector belong to the user
+// or a pool and are kept. All volumes are tried, and the errors returned together.
+func (d *Driver) deleteVolumes() error {
+	var errs []error
+	for _, attached := range d.getTrackedVolumes() {
+		if !attached.Created {
+			log.Infof(" -> Keeping volume %s[%d], as it was not created by the driver", attached.Name, attached.ID)
+			continue
+		}
+		if err := d.deleteVolume(attached); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// getTrackedVolumes lists the volumes attached by the driver, along with the data volume of a creation which failed
+// before the server was created, as it is not attached
+func (d *Driver) getTrackedVolumes() []AttachedVolume {
+	volumes := d.AttachedVolumes
+	if d.DataVolumeID != 0 && !slices.ContainsFunc(volumes, func(v AttachedVolume) bool { return v.ID == d.DataVolumeID }) {
+		volumes = append(slices.Clip(volumes), AttachedVolume{ID: d.DataVolumeID, Name: d.GetMachineName() + "-docker", Created: true})
+	}
+	return volumes
+}
+
+// releaseVolumes strips the driver's labels from the volumes created by the driver, so the volumes kept on remove are
+// not collected as orphans
+func (d *Driver) releaseVolumes() {
+	// failure to release a volume is not a hard error, as the volume is kept either way
+	for _, attached := range d.getTrackedVolumes() {
+		if !attached.Created {
+			continue
+		}
+		if softErr := d.releaseVolume(attached); softErr != nil {
+			log.Warnf(" -> could not release volume %s[%d]: %v", attached.Name, attached.ID, softErr)
+		}
+	}
+}
+
+func (d *Driver) releaseVolume(attached AttachedVolume) error {
+	volume, _, err := d.getClient().Volume.GetByID(d.getContext(), attached.ID)
+	if err != nil {
+		return fmt.Errorf("could not get volume by ID: %w", err)
+	}
+	if volume == nil {
+		log.Infof(" -> Volume %s[%d] does not exist anymore", attached.Name, attached.ID)
+		return nil
+	}
+
+	log.Infof(" -> Keeping volume %s[%d]...", volume.Name, volume.ID)
+	if _, _, err = d.getClient().Volume.Update(d.getContext(), volume, hcloud.VolumeUpdateOpts{
+		Labels: d.withoutDriverLabels(volume.Labels),
+	}); err != nil {
+		return fmt.Errorf("could not remove volume labels: %w", err)
+	}
+	return nil
+}
+
+func (d *Driver) deleteVolume(attached AttachedVolume) error {
+	volume, _, err := d.getClient().Volume.GetByID(d.getContext(), attached.ID)
+	if err != nil {
+		return fmt.Errorf("could not get volume %s[%d]: %w", attached.Name, attached.ID, err)
+	}
+	if volume == nil {
+		log.Infof(" -> Volume %s[%d] does not exist anymore", attached.Name, attached.ID)
+		return nil
+	}
+	if volume.Server != nil {
+		return fmt.Errorf("volume %s[%d] is still attached to server %d", volume.Name, volume.ID, volume.Server.ID)
+	}
+
+	log.Infof(" -> Destroying volume %s[%d]...", volume.Name, volume.ID)
+	if _, err := d.getClient().Volume.Delete(d.getContext(), volume); err != nil && !isAPIError(err, hcloud.ErrorCodeNotFound) {
+		return fmt.Errorf("could not delete volume %s[%d]: %w", volume.Name, volume.ID, err)
+	}
+	return nil
+}
+
+func (d *Driver) makeDockerDataVolume() (*hcloud.Volume, error) {
+	if d.dockerDataVolumeSize == 0 {
+		return nil, nil
+	} else if d.cachedDataVolume != nil {
+		return d.cachedDataVolume, nil
+	}
+
+	// a previous, failed creation may have created the volume already
+	if d.DataVolumeID != 0 {
+		volume, _, err := d.getClient().Volume.GetByID(d.getContext(), d.DataVolumeID)
+		if err != nil {
+			return nil, fmt.Errorf("could not get volume by ID: %w", err)
+		}
+		if volume != nil && volume.Server == nil {
+			log.Infof("Using Docker data volume %s[%d] from previous attempt", volume.Name, volume.ID)
+			d.cachedDataVolume = volume
+			return instrumented(volume), nil
+		}
+		log.Warnf("Docker data volume %d from previous attempt is gone or in use, creating a new one", d.DataVolumeID)
+		d.DataVolumeID = 0
+	}
+
+	location, err := d.getLocationNullable()
+	if err != nil {
+		return nil, fmt.Errorf("could not get location: %w", err)
+	}
+
+	log.Infof("Creating Docker data volume...")
+	res, _, err := d.getClient().Volume.Create(d.getContext(), instrumented(hcloud.VolumeCreateOpts{
+		Name:     d.GetMachineName() + "-docker",
+		Size:     d.dockerDataVolumeSize,
+		Location: location,
+		Format:   hcloud.Ptr(dockerDataVolumeFormat),
+		Labels:   d.resourceLabels(map[string]string{d.labelName(labelAutoCreated): "true"}),
+	}))
+
+	if res.Volume != nil {
+		// record the volume, so a resumed creation uses it again
+		d.DataVolumeID = res.Volume.ID
+		d.checkpoint()
+
+		d.dangling = append(d.dangling, func() {
+			_, err := d.getClient().Volume.Delete(d.getContext(), res.Volume)
+			if err != nil {
+				log.Errorf("could not delete volume: %v", err)
+				return
+			}
+			d.DataVolumeID = 0
+			d.checkpoint()
+		})
+		// attached to the server
+		d.keepWithServer()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create volume: %w", err)
+	}
+
+	if res.Action != nil {
+		if err = d.waitForAction(res.Action); err != nil {
+			return nil, fmt.Errorf("could not wait for volume creation: %w", err)
+		}
+	}
+
+	log.Infof(" -> Created volume %s[%d] at %v", res.Volume.Name, res.Volume.ID, res.Volume.LinuxDevice)
+	d.cachedDataVolume = res.Volume
+	return instrumented(res.Volume), nil
+}
+
+// dockerDataVolumeCloudConfig mounts the given device as the Docker data-root; cloud-init adds it to fstab, and as the
+// mounts module runs before SSH becomes available, it is in place before the engine is installed
+func dockerDataVolumeCloudConfig(device string) map[string]interface{} {
+	return map[string]interface{}{
+		"mounts": []interface{}{
+			[]interface{}{device, dockerDataRoot, dockerDataVolumeFormat, "defaults,discard,nofail", "0", "2"},
+		},
+	}
+}
+
+func (d *Driver) getAttachedVolume(idOrName string) (AttachedVolume, error) {
+	if idOrName == "" {
+		if len(d.AttachedVolumes) != 1 {
+			return AttachedVolume{}, fmt.Errorf("machine has %d attached volumes, please specify one", len(d.AttachedVolumes))
+		}
+		return d.AttachedVolumes[0], nil
+	}
+
+	for _, attached := range d.AttachedVolumes {
+		if attached.Name == idOrName || strconv.FormatInt(attached.ID, 10) == idOrName {
+			return attached, nil
+		}
+	}
+	return AttachedVolume{}, fmt.Errorf("volume '%s' is not attached by the driver", idOrName)
+}
+
+// ResizeVolume grows a volume attached by the driver to the given size (in GB) and resizes its filesystem via SSH; an
+// empty idOrName selects the only attached volume
+func (d *Driver) ResizeVolume(idOrName string, size int) error {
+	attached, err := d.getAttachedVolume(idOrName)
+	if err != nil {
+		return err
+	}
+
+	volume, _, err := d.getClient().Volume.GetByID(d.getContext(), attached.ID)
+	if err != nil {
+		return fmt.Errorf("could not get volume by ID: %w", err)
+	}
+	if volume == nil {
+		return fmt.Errorf("volume %s[%d] does not exist anymore", attached.Name, attached.ID)
+	}
+	if size <= volume.Size {
+		return fmt.Errorf("volumes can only grow, but %s[%d] already has %d GB", volume.Name, volume.ID, volume.Size)
+	}
+
+	log.Infof(" -> Resizing volume %s[%d] from %d to %d GB...", volume.Name, volume.ID, volume.Size, size)
+	act, _, err := d.getClient().Volume.Resize(d.getContext(), volume, size)
+	if err != nil {
+		return fmt.Errorf("could not resize volume: %w", err)
+	}
+	if err = d.waitForAction(act); err != nil {
+		return fmt.Errorf("could not wait for volume resize: %w", err)
+	}
+
+	log.Infof(" -> Resizing filesystem on %v...", volume.LinuxDevice)
+	if _, err = d.runRemote(growFilesystemCommand(volume.LinuxDevice)); err != nil {
+		return fmt.Errorf("could not resize filesystem: %w", err)
+	}
+	return nil
+}
+
+func growFilesystemCommand(device string) string {
+	return fmt.Sprintf(`case "$(lsblk -no FSTYPE %[1]s)" in `+
+		`ext*) resize2fs %[1]s ;; `+
+		`xfs) xfs_growfs "$(findmnt -no TARGET %[1]s)" ;; `+
+		`*) echo "unsupported filesystem on %[1]s" >&2; exit 1 ;; `+
+		`esac`, device)
+}
@@ -0,0 +1,113 @@
+package driver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultWriteFileMode = "0644"
+	dockerDaemonJSONPath = "/etc/docker/daemon.json"
+)
+
+// dockerManagedDaemonOptions are passed as dockerd flags by docker-machine's provisioning, which dockerd refuses to
+// start with if also given in daemon.json
+var dockerManagedDaemonOptions = []string{"hosts", "labels", "storage-driver", "tls", "tlscacert", "tlscert", "tlskey", "tlsverify"}
+
+// writeFile is a parsed --hetzner-write-file argument in source=<local>,path=<remote>[,mode=<octal>] format, holding
+// the file's contents as read on creation
+type writeFile struct {
+	path    string
+	mode    string
+	content []byte
+}
+
+func (d *Driver) setWriteFilesFromFlags(raw []string) error {
+	d.writeFiles = nil
+	for _, arg := range raw {
+		file := writeFile{mode: defaultWriteFileMode}
+		var source string
+		for _, field := range strings.Split(arg, ",") {
+			split := strings.SplitN(field, "=", 2)
+			if len(split) != 2 {
+				return d.flagFailure("write file %v is not in source=...,path=...,mode=... format", arg)
+			}
+
+			switch split[0] {
+			case "source":
+				source = split[1]
+			case "path":
+				file.path = split[1]
+			case "mode":
+				file.mode = split[1]
+			default:
+				return d.flagFailure("write file %v has unknown field %v", arg, split[0])
+			}
+		}
+
+		if source == "" || file.path == "" {
+			return d.flagFailure("write file %v requires both source and path", arg)
+		}
+		if !path.IsAbs(file.path) {
+			return d.flagFailure("path of write file %v must be absolute", arg)
+		}
+		if mode, err := strconv.ParseUint(file.mode, 8, 32); err != nil || mode > 0o7777 {
+			return d.flagFailure("mode of write file %v must be octal, like %v", arg, defaultWriteFileMode)
+		}
+
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return d.flagFailure("could not read write file %v: %v", arg, err)
+		}
+		file.content = content
+
+		d.writeFiles = append(d.writeFiles, file)
+	}
+	return nil
+}
+
+// setDockerDaemonJSONFromFlags accepts the daemon.json inline, if given as JSON object, or as path to read it from
+func (d *Driver) setDockerDaemonJSONFromFlags(raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	content := []byte(raw)
+	if !strings.HasPrefix(strings.TrimSpace(raw), "{") {
+		var err error
+		if content, err = os.ReadFile(raw); err != nil {
+			return d.flagFailure("could not read --%v: %v", flagDockerDaemonJSON, err)
+		}
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return d.flagFailure("--%v is not a valid JSON object: %v", flagDockerDaemonJSON, err)
+	}
+	for _, option := range dockerManagedDaemonOptions {
+		if _, ok := config[option]; ok {
+			return d.flagFailure("--%v must not set %v, which is managed by docker-machine", flagDockerDaemonJSON, option)
+		}
+	}
+
+	d.writeFiles = append(d.writeFiles, writeFile{path: dockerDaemonJSONPath, mode: defaultWriteFileMode, content: content})
+	return nil
+}
+
+// writeFilesCloudConfig passes the files base64 encoded, so binary contents and trailing whitespace survive YAML
+func writeFilesCloudConfig(files []writeFile) map[string]interface{} {
+	entries := make([]interface{}, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, map[string]interface{}{
+			"path":        file.path,
+			"permissions": file.mode,
+			"encoding":    "b64",
+			"content":     base64.StdEncoding.EncodeToString(file.content),
+		})
+	}
+	return map[string]interface{}{"write_files": entries}
+}
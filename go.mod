@@ -6,6 +6,8 @@ require (
 	github.com/docker/machine v0.16.2
 	github.com/hetznercloud/hcloud-go/v2 v2.5.1
 	golang.org/x/crypto v0.16.0
+	golang.org/x/sys v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 replace github.com/codegangsta/cli v1.22.14 => github.com/urfave/cli v1.22.14
@@ -26,9 +28,7 @@ require (
 	github.com/prometheus/procfs v0.11.1 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	golang.org/x/net v0.19.0 // indirect
-	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/term v0.15.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
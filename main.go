@@ -14,10 +14,22 @@ var version string
 
 func main() {
 	versionFlag := flag.Bool("v", false, "prints current docker-machine-driver-hetzner version")
+	reportFlag := flag.Bool("version", false, "prints the version, build info, capabilities and create flags")
 	flag.Parse()
 	if *versionFlag {
 		fmt.Printf("Version: %s\n", version)
 		os.Exit(0)
 	}
+	if *reportFlag {
+		printVersionReport(driver.NewDriver(version).VersionReport())
+		os.Exit(0)
+	}
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Arg(0), flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	plugin.RegisterDriver(driver.NewDriver(version))
 }